@@ -0,0 +1,110 @@
+// admin - консольный клиент AdminAPI сервера из cmd/server.
+//
+//	ADMIN_TOKEN=secret go run ./cmd/admin -maintenance on -reason "обновление БД"
+//	ADMIN_TOKEN=secret go run ./cmd/admin -maintenance off
+//	ADMIN_TOKEN=secret go run ./cmd/admin
+//	ADMIN_TOKEN=secret go run ./cmd/admin -tenants
+//	JWT_SECRET=secret go run ./cmd/admin -jwt
+//	go run ./cmd/admin -tls-ca certs/ca.crt -tls-cert certs/client.crt -tls-key certs/client.key
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/auth"
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+	adminpb "github.com/easyp-tech/course-grpc/pkg/api/admin/v1"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:5001", "адрес сервера")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "токен AdminAPI, по умолчанию из $ADMIN_TOKEN")
+	mode := flag.String("maintenance", "", "on или off переключают режим обслуживания, пустое значение - только показать его")
+	reason := flag.String("reason", "", "причина включения режима обслуживания")
+	tenants := flag.Bool("tenants", false, "показать счетчики вызовов по tenant вместо режима обслуживания")
+	tenantPrefix := flag.String("tenant-prefix", "", "с -tenants: только tenant с этим префиксом")
+	useJWT := flag.Bool("jwt", false, "вместо -token подписать JWT с ролью admin секретом -jwt-secret, для сервера с auth provider: jwt")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "секрет HS256 подписи JWT, по умолчанию из $JWT_SECRET")
+	jwtIssuer := flag.String("jwt-issuer", "course-grpc", "claim iss токена, как auth.jwt.issuer сервера")
+	jwtAudience := flag.String("jwt-audience", "", "claim aud токена, как auth.jwt.audience сервера")
+	var tlsOpts tlsconfig.ClientOptions
+	flag.StringVar(&tlsOpts.CAFile, "tls-ca", "", "CA для проверки сертификата сервера; включает TLS")
+	flag.StringVar(&tlsOpts.CertFile, "tls-cert", "", "сертификат клиента для mTLS, для сервера с auth provider: mtls; включает TLS")
+	flag.StringVar(&tlsOpts.KeyFile, "tls-key", "", "закрытый ключ клиента для mTLS")
+	flag.StringVar(&tlsOpts.ServerName, "tls-server-name", "", "имя, на которое должен быть выписан сертификат сервера, вместо хоста из -addr; включает TLS")
+	flag.Parse()
+
+	// без TLS флагов соединение без шифрования
+	creds := insecure.NewCredentials()
+	if tlsOpts.Enabled() {
+		tlsCfg, err := tlsconfig.Client(tlsOpts)
+		if err != nil {
+			log.Fatalf("failed to load TLS config: %v", err)
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	c := adminpb.NewAdminAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if *useJWT {
+		if *jwtSecret == "" {
+			log.Fatal("-jwt needs -jwt-secret or $JWT_SECRET")
+		}
+		// токен живет минуту: его хватает на один вызов и его не жалко потерять
+		jwt, err := auth.IssueJWT([]byte(*jwtSecret), "admin", []string{"admin"}, *jwtIssuer, *jwtAudience, time.Minute)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*token = jwt
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, auth.Key, auth.Credentials(*token))
+
+	if *tenants {
+		resp, err := c.ListTenants(ctx, &adminpb.ListTenantsRequest{Prefix: *tenantPrefix})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, t := range resp.GetTenants() {
+			name := t.GetTenant()
+			if name == "" {
+				name = "(без tenant)"
+			}
+			log.Printf("tenant %s: calls=%d failed=%d throttled=%d quota_exceeded=%d in_flight=%d last_call=%s",
+				name, t.GetCalls(), t.GetFailed(), t.GetThrottled(), t.GetQuotaExceeded(), t.GetInFlight(),
+				t.GetLastCall().AsTime().Format(time.RFC3339))
+		}
+		return
+	}
+
+	var st *adminpb.MaintenanceStatus
+	switch *mode {
+	case "":
+		st, err = c.GetMaintenance(ctx, &adminpb.GetMaintenanceRequest{})
+	case "on", "off":
+		st, err = c.SetMaintenance(ctx, &adminpb.SetMaintenanceRequest{Enabled: *mode == "on", Reason: *reason})
+	default:
+		log.Fatalf("-maintenance должен быть on или off, получено %q", *mode)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("maintenance: enabled=%t reason=%q since=%s in_flight=%d",
+		st.GetEnabled(), st.GetReason(), st.GetSince().AsTime().Format(time.RFC3339), st.GetInFlight())
+}
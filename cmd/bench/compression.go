@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/easyp-tech/course-grpc/internal/compression"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/chunk"
+)
+
+// compressionRun is what one encoding cost.
+type compressionRun struct {
+	encoding string
+	elapsed  time.Duration
+	// clientCPU is negative where the CPU time of the process is not known,
+	// serverCPU when the server metrics are not scraped.
+	clientCPU time.Duration
+	serverCPU time.Duration
+	payload   int64
+	wire      int64
+}
+
+// runCompression uploads the same payload through EchoService.UploadFile
+// once per encoding, so the runs differ in compression alone, and prints
+// them side by side.
+func runCompression(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("compression", flag.ContinueOnError)
+	size := fs.Int("size", 16<<20, "bytes uploaded per round, at most 64 MiB")
+	chunkSize := fs.Int("chunk", 64<<10, "bytes per message")
+	payload := fs.String("payload", "json", "payload: json (compresses well) or random (does not compress)")
+	rounds := fs.Int("rounds", 3, "uploads per encoding")
+	encodings := fs.String("encodings", strings.Join([]string{compression.Identity, compression.Gzip, compression.Zstd}, ","), "comma-separated encodings to compare")
+	serverMetrics := fs.String("server-metrics", "", "Prometheus endpoint of the server, e.g. http://localhost:9001/metrics, to report its CPU too")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if *size < 1 || *chunkSize < 1 || *rounds < 1 {
+		return 2, errors.New("-size, -chunk and -rounds must be positive")
+	}
+
+	data, err := benchPayload(*payload, *size)
+	if err != nil {
+		return 2, err
+	}
+	chunks := chunk.Split(data, *chunkSize)
+	client := stream.NewEchoServiceClient(conn)
+
+	var runs []compressionRun
+	for _, enc := range strings.Split(*encodings, ",") {
+		opts, err := compression.CallOptions(enc)
+		if err != nil {
+			return 2, err
+		}
+		// one upload outside the measurement warms up the connection and the
+		// compressor pools on both sides
+		if err := upload(ctx, client, chunks, opts); err != nil {
+			return 1, fmt.Errorf("%s: %w", enc, err)
+		}
+
+		run := compressionRun{encoding: enc, serverCPU: -1}
+		serverBefore, err := scrapeCPU(ctx, *serverMetrics)
+		if err != nil {
+			return 1, err
+		}
+		wireBefore := wire.snapshot()
+		cpuBefore := cpuTime()
+		start := time.Now()
+		for i := 0; i < *rounds; i++ {
+			if err := upload(ctx, client, chunks, opts); err != nil {
+				return 1, fmt.Errorf("%s: %w", enc, err)
+			}
+		}
+		run.elapsed = time.Since(start)
+		if cpuBefore >= 0 {
+			run.clientCPU = cpuTime() - cpuBefore
+		} else {
+			run.clientCPU = -1
+		}
+		sent := wire.snapshot().sub(wireBefore)
+		run.payload, run.wire = sent.payload, sent.wire
+		serverAfter, err := scrapeCPU(ctx, *serverMetrics)
+		if err != nil {
+			return 1, err
+		}
+		if serverBefore >= 0 {
+			run.serverCPU = serverAfter - serverBefore
+		}
+
+		log.Printf("[BENCH] %s: %d x %d bytes in %v", enc, *rounds, len(data), run.elapsed.Round(time.Millisecond))
+		runs = append(runs, run)
+	}
+
+	printCompression(os.Stdout, runs)
+	return 0, nil
+}
+
+// upload sends chunks as one file and waits for the server to confirm it.
+func upload(ctx context.Context, client stream.EchoServiceClient, chunks []chunk.Chunk, opts []grpc.CallOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s, err := client.UploadFile(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	// acks of the server are read while sending, so it never blocks on them
+	done := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := s.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			if resp.GetComplete() {
+				done <- nil
+				return
+			}
+		}
+	}()
+
+	for _, ch := range chunks {
+		err := s.Send(&stream.FileChunk{
+			Name:      "bench.bin",
+			TotalSize: ch.TotalSize,
+			Offset:    ch.Offset,
+			Data:      ch.Data,
+			Last:      ch.Last,
+			Sha256:    ch.Checksum,
+		})
+		if err == io.EOF {
+			// the server ended the stream, its status comes with Recv
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// benchPayload returns size bytes of the kind.
+func benchPayload(kind string, size int) ([]byte, error) {
+	r := rand.New(rand.NewPCG(1, 2))
+	switch kind {
+	case "random":
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(r.IntN(256))
+		}
+		return data, nil
+	case "json":
+		// orders as a REST API would return them: repeated keys, varied values
+		var b strings.Builder
+		for id := 1; b.Len() < size; id++ {
+			fmt.Fprintf(&b, `{"id":"order-%d","customer":"customer-%d","amount":%d.%02d,"currency":"RUB","status":"created"}`+"\n",
+				id, r.IntN(1000), r.IntN(100000), r.IntN(100))
+		}
+		return []byte(b.String()[:size]), nil
+	default:
+		return nil, fmt.Errorf("unknown payload %q, want json or random", kind)
+	}
+}
+
+// scrapeCPU reads process_cpu_seconds_total from a Prometheus endpoint. It
+// returns -1 without an endpoint.
+func scrapeCPU(ctx context.Context, url string) (time.Duration, error) {
+	if url == "" {
+		return -1, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("scrape server metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		value, ok := strings.CutPrefix(sc.Text(), "process_cpu_seconds_total ")
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse process_cpu_seconds_total: %w", err)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s has no process_cpu_seconds_total", url)
+}
+
+func printCompression(w io.Writer, runs []compressionRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "encoding\tMiB/s\tclient CPU\tserver CPU\tpayload\ton wire\tratio")
+	for _, r := range runs {
+		serverCPU := "n/a"
+		if r.serverCPU >= 0 {
+			serverCPU = r.serverCPU.Round(time.Millisecond).String()
+		}
+		clientCPU := "n/a"
+		if r.clientCPU >= 0 {
+			clientCPU = r.clientCPU.Round(time.Millisecond).String()
+		}
+		ratio := 0.0
+		if r.wire > 0 {
+			ratio = float64(r.payload) / float64(r.wire)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%s\t%s\t%.1f MiB\t%.1f MiB\t%.2fx\n",
+			r.encoding, float64(r.payload)/(1<<20)/r.elapsed.Seconds(), clientCPU, serverCPU,
+			float64(r.payload)/(1<<20), float64(r.wire)/(1<<20), ratio)
+	}
+	tw.Flush()
+}
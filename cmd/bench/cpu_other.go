@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// cpuTime is not available here; -1 reports the CPU as n/a.
+func cpuTime() time.Duration {
+	return -1
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/exportbatch"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// exportRun is what one way of compressing an export cost.
+type exportRun struct {
+	mode    string
+	elapsed time.Duration
+	// clientCPU is negative where the CPU time of the process is not known
+	clientCPU time.Duration
+	orders    int
+	// payload is the encoded size of the exported orders, wire what the
+	// responses took on the wire
+	payload int64
+	wire    int64
+}
+
+// runExport imports -orders orders and exports all orders of the server
+// once per mode: one order per message with every message compressed by
+// gRPC, and batches of -batch orders compressed as one payload by the
+// handler (package exportbatch). Batches trade latency for a ratio no
+// single small message reaches.
+func runExport(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	n := fs.Int("orders", 5000, "orders to import before exporting, 0 to export the existing ones")
+	batchSize := fs.Int("batch", exportbatch.DefaultSize, "orders per batch, at most 1000")
+	rounds := fs.Int("rounds", 3, "exports per mode")
+	encodings := fs.String("encodings", strings.Join([]string{compression.Identity, compression.Gzip, compression.Zstd}, ","), "comma-separated encodings to compare, per message and per batch")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if *n < 0 || *batchSize < 1 || *batchSize > 1000 || *rounds < 1 {
+		return 2, errors.New("-orders must not be negative, -batch between 1 and 1000, -rounds positive")
+	}
+
+	client := pbv2.NewEchoAPIClient(conn)
+	if err := importOrders(ctx, client, *n); err != nil {
+		return 1, err
+	}
+
+	var runs []exportRun
+	for _, batched := range []bool{false, true} {
+		for _, enc := range strings.Split(*encodings, ",") {
+			req := &pbv2.ExportOrdersRequest{}
+			var opts []grpc.CallOption
+			mode := "message " + enc
+			if batched {
+				codec, err := exportbatch.ParseCodec(enc)
+				if err != nil {
+					return 2, err
+				}
+				req.PayloadCodec, req.BatchSize = codec, uint32(*batchSize)
+				mode = fmt.Sprintf("batch %s x%d", enc, *batchSize)
+			} else {
+				var err error
+				if opts, err = compression.CallOptions(enc); err != nil {
+					return 2, err
+				}
+			}
+			// one export outside the measurement warms up both sides
+			if _, _, err := export(ctx, client, req, opts); err != nil {
+				return 1, fmt.Errorf("%s: %w", mode, err)
+			}
+
+			run := exportRun{mode: mode, clientCPU: -1}
+			wireBefore := wire.snapshot()
+			cpuBefore := cpuTime()
+			start := time.Now()
+			for i := 0; i < *rounds; i++ {
+				orders, size, err := export(ctx, client, req, opts)
+				if err != nil {
+					return 1, fmt.Errorf("%s: %w", mode, err)
+				}
+				run.orders += orders
+				run.payload += size
+			}
+			run.elapsed = time.Since(start)
+			if cpuBefore >= 0 {
+				run.clientCPU = cpuTime() - cpuBefore
+			}
+			run.wire = wire.snapshot().sub(wireBefore).inWire
+
+			log.Printf("[BENCH] %s: %d x %d orders in %v", mode, *rounds, run.orders / *rounds, run.elapsed.Round(time.Millisecond))
+			runs = append(runs, run)
+		}
+	}
+
+	printExport(os.Stdout, runs)
+	return 0, nil
+}
+
+// importOrders creates n orders in one ImportOrders call.
+func importOrders(ctx context.Context, client pbv2.EchoAPIClient, n int) error {
+	if n == 0 {
+		return nil
+	}
+	s, err := client.ImportOrders(ctx)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	for i := 0; i < n; i++ {
+		err := s.Send(&pbv2.ImportOrdersRequest{
+			Ref:  fmt.Sprintf("bench-%d", i),
+			Item: &pbv2.OrderItem{ProductId: uuid.NewString(), Count: uint32(1 + i%10)},
+		})
+		if err == io.EOF {
+			// the server ended the stream, its status comes with CloseAndRecv
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+	}
+	resp, err := s.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	log.Printf("[BENCH] imported %d orders, %d failed", resp.GetImported(), resp.GetFailed())
+	return nil
+}
+
+// export reads a whole export and returns the number of orders and their
+// encoded size.
+func export(ctx context.Context, client pbv2.EchoAPIClient, req *pbv2.ExportOrdersRequest, opts []grpc.CallOption) (int, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s, err := client.ExportOrders(ctx, req, opts...)
+	if err != nil {
+		return 0, 0, err
+	}
+	batches := exportbatch.NewReader(64 << 20)
+	var count int
+	var size int64
+	for {
+		resp, err := s.Recv()
+		if errors.Is(err, io.EOF) {
+			return count, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		orders, err := batches.Orders(resp)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, o := range orders {
+			size += int64(proto.Size(o))
+		}
+		count += len(orders)
+	}
+}
+
+func printExport(w io.Writer, runs []exportRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "mode\torders/s\tclient CPU\torders\ton wire\tratio")
+	for _, r := range runs {
+		clientCPU := "n/a"
+		if r.clientCPU >= 0 {
+			clientCPU = r.clientCPU.Round(time.Millisecond).String()
+		}
+		ratio := 0.0
+		if r.wire > 0 {
+			ratio = float64(r.payload) / float64(r.wire)
+		}
+		fmt.Fprintf(tw, "%s\t%.0f\t%s\t%.2f MiB\t%.2f MiB\t%.2fx\n",
+			r.mode, float64(r.orders)/r.elapsed.Seconds(), clientCPU,
+			float64(r.payload)/(1<<20), float64(r.wire)/(1<<20), ratio)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/easyp-tech/course-grpc/internal/latency"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// runLatency sends n small echoes from concurrency workers sharing one
+// connection and prints the percentiles. A single worker waits for every
+// reply before the next request, so Nagle's algorithm has nothing to hold
+// back; with several workers small writes overlap and the setting of
+// TCP_NODELAY on either side shows in the tail.
+func runLatency(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("latency", flag.ContinueOnError)
+	n := fs.Int("n", 2000, "echoes to measure")
+	size := fs.Int("size", 16, "message size in bytes")
+	concurrency := fs.Int("concurrency", 1, "workers sending at the same time")
+	warmup := fs.Int("warmup", 100, "echoes sent before measuring, to open the connection and warm up both sides")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if *n < 1 || *concurrency < 1 || *size < 0 || *warmup < 0 {
+		return 2, fmt.Errorf("-n and -concurrency must be positive, -size and -warmup non-negative")
+	}
+
+	client := pbv2.NewEchoAPIClient(conn)
+	req := &pbv2.EchoRequest{Message: strings.Repeat("x", *size)}
+	for i := 0; i < *warmup; i++ {
+		if _, err := client.Echo(ctx, req); err != nil {
+			return 1, fmt.Errorf("warmup: %w", err)
+		}
+	}
+
+	name := fmt.Sprintf("Echo %dB x%d", *size, *concurrency)
+	summary := latency.NewSummary()
+	jobs := make(chan struct{}, *n)
+	for i := 0; i < *n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	var lastErr error
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				callStart := time.Now()
+				_, err := client.Echo(ctx, req)
+				summary.Record(name, time.Since(callStart), err)
+				if err != nil {
+					mu.Lock()
+					failed, lastErr = failed+1, err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	summary.Log()
+	log.Printf("[BENCH] %s: %.0f echoes/s over %v", name, float64(*n)/elapsed.Seconds(), elapsed.Round(time.Millisecond))
+	if err := ctx.Err(); err != nil {
+		return 1, err
+	}
+	if failed > 0 {
+		return 1, fmt.Errorf("%d of %d echoes failed, last: %w", failed, *n, lastErr)
+	}
+	return 0, nil
+}
@@ -0,0 +1,106 @@
+// bench runs small load workloads against the course servers and prints
+// side-by-side numbers, to see what a server or client setting changes.
+//
+//	go run ./cmd/bench -addr localhost:5001 latency
+//	go run ./cmd/bench -addr localhost:5001 -tcp-nodelay=false latency -concurrency 8
+//	go run ./cmd/bench -addr localhost:5001 compression -server-metrics http://localhost:9001/metrics
+//	go run ./cmd/bench -addr localhost:5001 restart -pid $(pgrep -n server)
+//	go run ./cmd/bench -addr localhost:5001 priority -low 16 -high 2
+//	go run ./cmd/bench -addr localhost:5001 export -orders 5000 -batch 100
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/easyp-tech/course-grpc/internal/sockopt"
+)
+
+// command is one workload; it returns the exit code of the tool.
+type command struct {
+	usage string
+	run   func(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error)
+}
+
+var commands = map[string]command{
+	"latency": {
+		usage: "latency [-n N] [-size B] [-concurrency C] [-warmup N]\n" +
+			"                             latency of small unary echoes over one connection",
+		run: runLatency,
+	},
+	"compression": {
+		usage: "compression [-size B] [-chunk B] [-payload json|random] [-rounds N] [-encodings list] [-server-metrics url]\n" +
+			"                             throughput, CPU and bytes on the wire of one upload per encoding",
+		run: runCompression,
+	},
+	"restart": {
+		usage: "restart [-pid P] [-duration D] [-after D] [-concurrency C]\n" +
+			"                             echoes without a pause while the server restarts on SIGUSR2",
+		run: runRestart,
+	},
+	"priority": {
+		usage: "priority [-duration D] [-delay D] [-low N] [-high N]\n" +
+			"                             latency per x-priority while low priority echoes saturate the server",
+		run: runPriority,
+	},
+	"export": {
+		usage: "export [-orders N] [-batch N] [-rounds N] [-encodings list]\n" +
+			"                             bytes on the wire of ExportOrders, per-message gRPC compression against compressed batches",
+		run: runExport,
+	},
+}
+
+var commandOrder = []string{"latency", "compression", "restart", "priority", "export"}
+
+func main() {
+	addr := flag.String("addr", "localhost:5001", "server address")
+	sockOpts := sockopt.Default
+	flag.BoolVar(&sockOpts.NoDelay, "tcp-nodelay", true, "set TCP_NODELAY on the connection, false turns Nagle's algorithm on")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bench [flags] <command> [args]\n\ncommands:\n")
+		for _, name := range commandOrder {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(sockOpts.Dialer()),
+		grpc.WithStatsHandler(wire),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+	log.Printf("Client sockets: %s", sockOpts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	code, err := cmd.run(ctx, conn, flag.Args()[1:])
+	if err != nil {
+		log.Printf("%s: %v", flag.Arg(0), err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	conn.Close()
+	os.Exit(code)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/admission"
+	"github.com/easyp-tech/course-grpc/internal/latency"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// runPriority saturates a server with admission control: -low workers send
+// low priority slow echoes without a pause and -high workers send high
+// priority ones, for -duration. The summary shows the latency and the
+// rejections of either priority; with the server's weighted scheduling the
+// high priority calls wait far less although they are the minority.
+func runPriority(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("priority", flag.ContinueOnError)
+	duration := fs.Duration("duration", 10*time.Second, "how long to send echoes")
+	delay := fs.Duration("delay", 50*time.Millisecond, "how long the server handles every echo")
+	low := fs.Int("low", 16, "workers sending low priority echoes")
+	high := fs.Int("high", 2, "workers sending high priority echoes")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if *duration <= 0 || *delay < 0 || *low < 0 || *high < 0 || *low+*high == 0 {
+		return 2, fmt.Errorf("-duration must be positive, -delay, -low and -high not negative, and some workers needed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	client := pbv2.NewEchoAPIClient(conn)
+	req := &pbv2.SlowEchoRequest{Message: "priority echo", Delay: durationpb.New(*delay)}
+	summary := latency.NewSummary()
+
+	var mu sync.Mutex
+	calls := make(map[admission.Priority]int)
+	failed := make(map[admission.Priority]int)
+
+	var wg sync.WaitGroup
+	start := func(p admission.Priority, workers int) {
+		callCtx := admission.NewOutgoingContext(ctx, p)
+		name := "SlowEcho " + p.String()
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					callStart := time.Now()
+					_, err := client.SlowEcho(callCtx, req)
+					if ctx.Err() != nil {
+						// the end of -duration, not a failure
+						return
+					}
+					summary.Record(name, time.Since(callStart), err)
+					mu.Lock()
+					calls[p]++
+					if err != nil {
+						failed[p]++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	start(admission.High, *high)
+	start(admission.Low, *low)
+	wg.Wait()
+
+	summary.Log()
+	for _, p := range []admission.Priority{admission.High, admission.Low} {
+		log.Printf("[PRIORITY] %s: %d echoes, %d rejected", p, calls[p], failed[p])
+	}
+	return 0, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/handoff"
+	"github.com/easyp-tech/course-grpc/internal/latency"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// runRestart sends echoes without a pause for -duration and asks the
+// server to restart (package handoff) after -after. The echoes are counted
+// per instance from the x-server-id trailer, so the summary shows the old
+// and the new process; a restart without downtime fails none of them.
+func runRestart(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("restart", flag.ContinueOnError)
+	pid := fs.Int("pid", 0, "server process to send SIGUSR2, 0 to send it by hand")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send echoes")
+	after := fs.Duration("after", 3*time.Second, "when to ask the server to restart")
+	concurrency := fs.Int("concurrency", 4, "workers sending at the same time")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if *duration <= 0 || *concurrency < 1 || *after < 0 || *after >= *duration {
+		return 2, fmt.Errorf("-duration and -concurrency must be positive, -after between 0 and -duration")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	client := pbv2.NewEchoAPIClient(conn)
+	req := &pbv2.EchoRequest{Message: "restart echo"}
+	name := fmt.Sprintf("Echo x%d", *concurrency)
+	summary := latency.NewSummary()
+
+	var mu sync.Mutex
+	var calls, failed int
+	var lastErr error
+	// instances in the order they first answered
+	var instances []string
+	answered := make(map[string]int)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*after):
+		}
+		if *pid == 0 {
+			log.Printf("[RESTART] send SIGUSR2 to the server now")
+			return
+		}
+		if err := handoff.Signal(*pid); err != nil {
+			log.Printf("[RESTART] signal %d: %v", *pid, err)
+			return
+		}
+		log.Printf("[RESTART] sent SIGUSR2 to %d", *pid)
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var trailer metadata.MD
+				callStart := time.Now()
+				_, err := client.Echo(ctx, req, grpc.Trailer(&trailer))
+				if ctx.Err() != nil {
+					// the end of -duration, not a failure
+					return
+				}
+				summary.Record(name, time.Since(callStart), err)
+
+				mu.Lock()
+				calls++
+				if err != nil {
+					failed, lastErr = failed+1, err
+				}
+				if ids := trailer.Get(servertiming.ServerIDKey); len(ids) > 0 {
+					if answered[ids[0]] == 0 {
+						instances = append(instances, ids[0])
+					}
+					answered[ids[0]]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	summary.Log()
+	for _, id := range instances {
+		log.Printf("[RESTART] %s answered %d echoes", id, answered[id])
+	}
+	log.Printf("[RESTART] %d echoes, %d failed", calls, failed)
+	if failed > 0 {
+		return 1, fmt.Errorf("%d of %d echoes failed during the restart, last: %w", failed, calls, lastErr)
+	}
+	return 0, nil
+}
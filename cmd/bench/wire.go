@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/stats"
+)
+
+// wire counts the messages sent and received on the connection of the tool.
+var wire = &wireCounter{}
+
+// wireCounter is a stats.Handler adding up the size of every sent and
+// received message before compression and on the wire, including the
+// 5-byte gRPC header.
+type wireCounter struct {
+	payload atomic.Int64
+	wire    atomic.Int64
+	// the same for received messages
+	inPayload atomic.Int64
+	inWire    atomic.Int64
+}
+
+// wireSizes is a snapshot of the counters.
+type wireSizes struct {
+	payload, wire     int64
+	inPayload, inWire int64
+}
+
+func (s wireSizes) sub(before wireSizes) wireSizes {
+	return wireSizes{
+		payload:   s.payload - before.payload,
+		wire:      s.wire - before.wire,
+		inPayload: s.inPayload - before.inPayload,
+		inWire:    s.inWire - before.inWire,
+	}
+}
+
+func (c *wireCounter) snapshot() wireSizes {
+	return wireSizes{
+		payload:   c.payload.Load(),
+		wire:      c.wire.Load(),
+		inPayload: c.inPayload.Load(),
+		inWire:    c.inWire.Load(),
+	}
+}
+
+func (c *wireCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *wireCounter) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch p := s.(type) {
+	case *stats.OutPayload:
+		c.payload.Add(int64(p.Length))
+		c.wire.Add(int64(p.WireLength))
+	case *stats.InPayload:
+		c.inPayload.Add(int64(p.Length))
+		c.inWire.Add(int64(p.WireLength))
+	}
+}
+
+func (c *wireCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *wireCounter) HandleConn(context.Context, stats.ConnStats) {}
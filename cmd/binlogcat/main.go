@@ -0,0 +1,185 @@
+// binlogcat prints gRPC binary logs written with -binlog by the course
+// servers and clients, or by grpc-go itself with GRPC_BINARY_LOG_FILTER.
+//
+//	go run ./cmd/binlogcat server.binlog
+//
+// Payloads of the course services are decoded and printed as JSON, other
+// payloads are shown as their size.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/easyp-tech/course-grpc/internal/binlog"
+	// registered so their payloads can be decoded
+	_ "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	_ "github.com/easyp-tech/course-grpc/pkg/api/v1"
+)
+
+func main() {
+	call := flag.Uint64("call", 0, "print only the entries of this call id")
+	noPayload := flag.Bool("no-payload", false, "do not decode message payloads")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range flag.Args() {
+		if err := printFile(os.Stdout, path, *call, !*noPayload); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+func printFile(w io.Writer, path string, call uint64, payloads bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := &printer{w: w, payloads: payloads, methods: make(map[uint64]string)}
+	r := binlog.NewReader(f)
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if call != 0 && e.GetCallId() != call {
+			continue
+		}
+		p.print(e)
+	}
+}
+
+type printer struct {
+	w        io.Writer
+	payloads bool
+	// methods maps call ids to the method from their client header, the
+	// message entries do not carry it
+	methods map[uint64]string
+}
+
+func (p *printer) print(e *binlogpb.GrpcLogEntry) {
+	ts := e.GetTimestamp().AsTime().Local().Format(time.RFC3339Nano)
+	logger := strings.TrimPrefix(e.GetLogger().String(), "LOGGER_")
+	event := strings.TrimPrefix(e.GetType().String(), "EVENT_TYPE_")
+	method := p.methods[e.GetCallId()]
+	if h := e.GetClientHeader(); h != nil {
+		method = h.GetMethodName()
+		p.methods[e.GetCallId()] = method
+	}
+
+	fmt.Fprintf(p.w, "%s call=%d seq=%d %s %s %s", ts, e.GetCallId(), e.GetSequenceIdWithinCall(), logger, event, method)
+	if e.GetPeer() != nil {
+		fmt.Fprintf(p.w, " peer=%s", binlog.FormatAddress(e.GetPeer()))
+	}
+	fmt.Fprintln(p.w)
+
+	switch pl := e.GetPayload().(type) {
+	case *binlogpb.GrpcLogEntry_ClientHeader:
+		if a := pl.ClientHeader.GetAuthority(); a != "" {
+			fmt.Fprintf(p.w, "    authority: %s\n", a)
+		}
+		if t := pl.ClientHeader.GetTimeout(); t != nil {
+			fmt.Fprintf(p.w, "    timeout: %v\n", t.AsDuration())
+		}
+		p.printMetadata(pl.ClientHeader.GetMetadata())
+
+	case *binlogpb.GrpcLogEntry_ServerHeader:
+		p.printMetadata(pl.ServerHeader.GetMetadata())
+
+	case *binlogpb.GrpcLogEntry_Message:
+		p.printMessage(method, e.GetType() == binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, pl.Message, e.GetPayloadTruncated())
+
+	case *binlogpb.GrpcLogEntry_Trailer:
+		fmt.Fprintf(p.w, "    status: %s", codes.Code(pl.Trailer.GetStatusCode()))
+		if msg := pl.Trailer.GetStatusMessage(); msg != "" {
+			fmt.Fprintf(p.w, " %q", msg)
+		}
+		if len(pl.Trailer.GetStatusDetails()) > 0 {
+			fmt.Fprintf(p.w, " (%d bytes of details)", len(pl.Trailer.GetStatusDetails()))
+		}
+		fmt.Fprintln(p.w)
+		p.printMetadata(pl.Trailer.GetMetadata())
+	}
+}
+
+func (p *printer) printMetadata(md *binlogpb.Metadata) {
+	for _, e := range md.GetEntry() {
+		if strings.HasSuffix(e.GetKey(), "-bin") {
+			fmt.Fprintf(p.w, "    %s: (%d bytes)\n", e.GetKey(), len(e.GetValue()))
+			continue
+		}
+		fmt.Fprintf(p.w, "    %s: %s\n", e.GetKey(), e.GetValue())
+	}
+}
+
+func (p *printer) printMessage(method string, fromClient bool, msg *binlogpb.Message, truncated bool) {
+	if truncated {
+		fmt.Fprintf(p.w, "    %d bytes, truncated to %d\n", msg.GetLength(), len(msg.GetData()))
+		return
+	}
+	if !p.payloads {
+		fmt.Fprintf(p.w, "    %d bytes\n", msg.GetLength())
+		return
+	}
+
+	m, err := newMessage(method, fromClient)
+	if err == nil {
+		err = proto.Unmarshal(msg.GetData(), m)
+	}
+	if err != nil {
+		fmt.Fprintf(p.w, "    %d bytes (%v)\n", msg.GetLength(), err)
+		return
+	}
+	fmt.Fprintf(p.w, "    %s\n", protojson.MarshalOptions{}.Format(m))
+}
+
+// newMessage creates the request or response message of a full method name
+// like /api.v1.EchoAPI/HelloWorld.
+func newMessage(method string, request bool) (proto.Message, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown method %q", method)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+
+	msgDesc := md.Output()
+	if request {
+		msgDesc = md.Input()
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(msgDesc.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
@@ -2,19 +2,83 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver/dns"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/easyp-tech/course-grpc/internal/attempts"
+	"github.com/easyp-tech/course-grpc/internal/binlog"
+	"github.com/easyp-tech/course-grpc/internal/cache"
+	"github.com/easyp-tech/course-grpc/internal/callctx"
+	"github.com/easyp-tech/course-grpc/internal/canary"
+	"github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/connstate"
+	"github.com/easyp-tech/course-grpc/internal/deprecation"
+	"github.com/easyp-tech/course-grpc/internal/dryrun"
+	"github.com/easyp-tech/course-grpc/internal/encryption"
+	"github.com/easyp-tech/course-grpc/internal/exportbatch"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/headers"
+	"github.com/easyp-tech/course-grpc/internal/i18n"
+	"github.com/easyp-tech/course-grpc/internal/idempotency"
+	"github.com/easyp-tech/course-grpc/internal/latency"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/internal/mirror"
+	"github.com/easyp-tech/course-grpc/internal/pathcheck"
+	"github.com/easyp-tech/course-grpc/internal/proxydial"
+	"github.com/easyp-tech/course-grpc/internal/quota"
+	"github.com/easyp-tech/course-grpc/internal/retry"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	"github.com/easyp-tech/course-grpc/internal/shutdownreport"
+	"github.com/easyp-tech/course-grpc/internal/signing"
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	"github.com/easyp-tech/course-grpc/internal/wiresize"
+	callctxpb "github.com/easyp-tech/course-grpc/pkg/api/callctx/v1"
+	nextpb "github.com/easyp-tech/course-grpc/pkg/api/next/v2"
 	pb "github.com/easyp-tech/course-grpc/pkg/api/v1"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/client"
+	"github.com/easyp-tech/course-grpc/pkg/inprocess"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
 )
 
+// сколько ждем завершения текущих вызовов после Ctrl+C
+const shutdownTimeout = 5 * time.Second
+
+// до скольких байт может распаковаться одна пачка ExportOrders
+const exportBatchMaxSize = 64 << 20
+
+// время вызовов по методам, сводка печатается при завершении клиента
+var latencies = latency.NewSummary()
+
+// повторы вызовов по методам и видам, сводка печатается при завершении
+var retries = attempts.NewSummary()
+
+// quietStat отключает строки об успешных вызовах в interceptorStat, когда
+// вместо них периодически печатается сводка latencies
+var quietStat bool
+
 func interceptorStat(
 	ctx context.Context,
 	method string,
@@ -25,12 +89,12 @@ func interceptorStat(
 ) error {
 	// Pre-processing
 	start := time.Now()
-	log.Printf("[INTERCEPTOR STAT] Calling: %s", method)
+	logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] Calling: %s", method)
 
-	// Добавляем кастомные заголовки
+	// Добавляем кастомные заголовки. user-agent через метаданные передать нельзя:
+	// это зарезервированный заголовок, его задаем через grpc.WithUserAgent
 	ctx = metadata.AppendToOutgoingContext(ctx,
-		"client-timestamp", time.Now().Format(time.RFC3339),
-		"user-agent", "my-grpc-client/1.0",
+		"client-timestamp", time.Now().Format(time.RFC3339Nano),
 	)
 
 	// Вызов сервера
@@ -38,54 +102,411 @@ func interceptorStat(
 
 	// Post-processing
 	duration := time.Since(start)
+	latencies.Record(method, duration, err)
 	if err != nil {
 		if st, ok := status.FromError(err); ok {
-			log.Printf("[INTERCEPTOR STAT] %s failed after %v: code=%s, message=%s",
+			logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] %s failed after %v: code=%s, message=%s",
 				method, duration, st.Code(), st.Message())
 		} else {
-			log.Printf("[INTERCEPTOR STAT] %s failed after %v: %v", method, duration, err)
+			logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] %s failed after %v: %v", method, duration, err)
 		}
-	} else {
-		log.Printf("[INTERCEPTOR STAT] %s completed in %v", method, duration)
+	} else if !quietStat {
+		logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] %s completed in %v", method, duration)
 	}
 
 	return err
 }
 
 func main() {
-	conn, err := grpc.NewClient(
-		"127.0.0.1:5001",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithChainUnaryInterceptor(interceptorStat),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:                10 * time.Second,
-			Timeout:             3 * time.Second,
-			PermitWithoutStream: true,
-		}),
+	compressionName := flag.String("compression", compression.Identity, "сжатие запросов: identity, gzip или zstd")
+	slowDelay := flag.Duration("slow-delay", 0, "задержка обработки в вызове SlowEcho, 0 - не вызывать его")
+	slowTimeout := flag.Duration("slow-timeout", time.Second, "таймаут вызова SlowEcho")
+	chainHops := flag.Int("chain", -1, "вызвать ChainedEcho через столько звеньев после первого сервера, -1 - не вызывать")
+	chainDelay := flag.Duration("chain-delay", 0, "сколько каждое звено ChainedEcho работает перед вызовом следующего")
+	chainTimeout := flag.Duration("chain-timeout", time.Second, "таймаут вызова ChainedEcho на всю цепочку")
+	broadcast := flag.Bool("broadcast", false, "вызвать BroadcastEcho и напечатать ответ или ошибку каждого сервера из -broadcast-targets сервера")
+	broadcastTimeout := flag.Duration("broadcast-timeout", 500*time.Millisecond, "сколько BroadcastEcho ждет каждый сервер, 0 - до дедлайна вызова")
+	slowIgnoreCancel := flag.Bool("slow-ignore-cancel", false, "сервер не прерывает SlowEcho при отмене вызова")
+	oversize := flag.Int("oversize", 0, "отправить Echo с сообщением такого размера в байтах и напечатать ошибку сервера, 0 - не отправлять")
+	wireSoftLimit := flag.Int("wire-soft-limit", wiresize.DefaultSoftLimit, "предупреждать о сообщениях больше стольких байт до сжатия, задолго до лимитов размера; 0 - не предупреждать")
+	importItems := flag.Int("import", 0, "импортировать через ImportOrders столько позиций, часть из них заведомо ошибочные, и напечатать результат, 0 - не импортировать")
+	export := flag.Bool("export", false, "выгрузить все заказы через ExportOrders")
+	exportCodec := flag.String("export-codec", "", "выгружать заказы сжатыми пачками по 100: identity, gzip или zstd; пустая строка - по одному заказу в сообщении")
+	exportBreak := flag.Int("export-break", 0, "оборвать выгрузку после стольких заказов и продолжить ее с cursor последнего, 0 - не обрывать")
+	syncOrders := flag.Bool("sync", false, "создать заказ и изменить его через SyncOrders, в том числе с конфликтами версий")
+	quotaOrders := flag.Int("quota", 0, "создать столько заказов по одному через CreateOrders, при исчерпании квоты ждать до конца ее окна, 0 - не создавать")
+	quotaWait := flag.Duration("quota-wait", time.Minute, "дольше этого не ждать восстановления квоты, а завершиться с ошибкой")
+	dryRun := flag.Bool("dry-run", false, "проверить заказы пробными вызовами CreateOrders (dry_run) и api.v1 CreateOrder (x-dry-run) и напечатать, какими они были бы, ничего не создавая")
+	profile := flag.Bool("profile", false, "сохранить профиль следующей ревизии (api/next/v2) на сервере, который знает только api.v2.Profile, и проверить, что новые поля вернулись")
+	cancelOrder := flag.Bool("cancel", false, "создать заказ, отменить его через CancelOrder и отменить еще раз, чтобы получить FailedPrecondition с ErrorInfo")
+	syncWatch := flag.Duration("sync-watch", 0, "после своих изменений столько ждать и печатать изменения заказов другими клиентами")
+	echoMetadata := flag.Bool("echo-metadata", false, "вызвать EchoWithMetadata и напечатать заголовки, которые получил сервер")
+	signingKey := flag.String("signing-key", os.Getenv("SIGNING_KEY"), "ключ HMAC подписи запросов (по умолчанию из $SIGNING_KEY), пустой - запросы не подписываются")
+	encryptionKey := flag.String("encryption-key", os.Getenv("ENCRYPTION_KEY"), "ключ AES-GCM шифрования сообщений (по умолчанию из $ENCRYPTION_KEY), пустой - без шифрования")
+	reportPath := flag.String("shutdown-report", shutdownreport.Stdout, `куда при завершении записать отчет в JSON: время работы, число вызовов по методам и кодам, сколько стримов закрыто при остановке и сколько оборвано, длительность этапов остановки; "-" - stdout, пустая строка - не писать`)
+	binlogPath := flag.String("binlog", "", "файл бинарного лога gRPC (читается cmd/binlogcat), пустая строка отключает его")
+	useTLS := flag.Bool("tls", false, "подключаться по TLS")
+	var tlsOpts tlsconfig.ClientOptions
+	flag.StringVar(&tlsOpts.CAFile, "tls-ca", "", "CA для проверки сертификата сервера, например самоподписанного в лабе; включает -tls")
+	flag.StringVar(&tlsOpts.CertFile, "tls-cert", "", "сертификат клиента для mTLS; включает -tls")
+	flag.StringVar(&tlsOpts.KeyFile, "tls-key", "", "закрытый ключ клиента для mTLS")
+	flag.StringVar(&tlsOpts.ServerName, "tls-server-name", "", "имя, на которое должен быть выписан сертификат сервера, вместо хоста из -target; включает -tls")
+	flag.BoolVar(&tlsOpts.InsecureSkipVerify, "tls-insecure-skip-verify", false, "принимать любой сертификат сервера, только для лабы; включает -tls")
+	inProcess := flag.Bool("in-process", false, "запустить сервисы курса внутри клиента (pkg/inprocess) и вызывать их через соединение в памяти вместо -target")
+	proxyURL := flag.String("proxy", "", `подключаться через прокси: "http://[user:password@]host:port" (HTTP CONNECT) или "socks5://[user:password@]host:port"; пустая строка - напрямую или через $HTTPS_PROXY средствами grpc-go`)
+	target := flag.String("target", "127.0.0.1:5001", `адрес сервера в формате gRPC, например "dns:///localhost:5001" или "dns://127.0.0.1:5353/echo.cluster:6001"`)
+	lb := flag.String("lb", "pick_first", "балансировка между адресами сервера: pick_first или round_robin")
+	resolveEvery := flag.Duration("resolve-every", 0, "перезапрашивать адреса у DNS с этим интервалом, 0 - только при обрыве соединения")
+	minConnectTimeout := flag.Duration("min-connect-timeout", 20*time.Second, "минимальное время на одну попытку соединения")
+	backoffBase := flag.Duration("backoff-base", time.Second, "пауза после первой неудачной попытки соединения")
+	backoffMax := flag.Duration("backoff-max", 120*time.Second, "максимальная пауза между попытками соединения")
+	pingPath := flag.String("ping-path", "", "адрес прокси (nginx, HAProxy) перед сервером: проверить здоровье сервера напрямую по -target и через прокси, сравнить задержки и сказать, какое звено сломано, вместо обычных вызовов")
+	pingCount := flag.Int("ping-count", 5, "число проверок на каждом пути для -ping-path")
+	pingInterval := flag.Duration("ping-interval", 200*time.Millisecond, "пауза между проверками для -ping-path")
+	metricsAddr := flag.String("metrics-addr", "", "адрес эндпоинта /metrics для Prometheus с числом повторов вызовов, пустая строка отключает его")
+	latencyEvery := flag.Duration("latency-every", 0, "печатать сводку задержек и кодов ответа по методам с этим интервалом вместо строки на каждый успешный вызов, 0 - только при завершении")
+	tenant := flag.String("tenant", "", "арендатор в контексте вызова, который уходит в бинарном заголовке x-call-context-bin")
+	features := flag.String("features", "", "флаги функциональности через запятую для контекста вызова")
+	canaryTarget := flag.String("canary-target", "", "адрес canary сервера host:port: часть вызовов уходит на него вместо -target (тоже host:port) с заголовком x-canary, в сводке - ошибки по адресам; пустая строка - без canary")
+	canaryPercent := flag.Float64("canary-percent", 10, "какой процент вызовов отправлять на -canary-target")
+	mirrorTarget := flag.String("mirror-target", "", "теневой сервер, например с новой версией API: копии части unary вызовов уходят и туда, ответы отбрасываются, ошибки и расхождения кодов пишутся в лог; пустая строка - без копий")
+	mirrorPercent := flag.Float64("mirror-percent", 10, "какой процент вызовов копировать на -mirror-target")
+	mirrorTimeout := flag.Duration("mirror-timeout", 5*time.Second, "таймаут копии вызова на -mirror-target, он не зависит от дедлайна основного вызова")
+	var extraHeaders headers.Flag
+	lang := flag.String("lang", "", `предпочитаемые языки сообщений об ошибках в формате Accept-Language, например "ru, en;q=0.5"`)
+	flag.Var(&extraHeaders, "H", `дополнительный заголовок "key: value" для каждого вызова, можно указывать несколько раз; значения ключей *-bin в base64`)
+	flag.Parse()
+	runReport := shutdownreport.New("client")
+	// GOAWAY, неотвеченные keepalive пинги и закрытые соединения - в лог
+	connstate.LogTransportEvents()
+	// язык передается обычным заголовком, сервер выбирает по нему текст ошибок
+	if *lang != "" {
+		if err := extraHeaders.Set(i18n.Key + ": " + *lang); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// опции, которые применяются к каждому вызову
+	callOpts, err := compression.CallOptions(*compressionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// сообщения шифруются на клиенте и расшифровываются только сервером
+	if *encryptionKey != "" {
+		if err := encryption.Register(*encryptionKey); err != nil {
+			log.Fatal(err)
+		}
+		callOpts = append(callOpts, encryption.CallOption())
+	}
+
+	// собирает время обработки и id сервера из трейлеров ответов
+	timings := servertiming.NewCollector()
+	// размеры запросов и ответов по методам для сводки при завершении
+	wireSizes := wiresize.NewAccounting(*wireSoftLimit)
+
+	// соединение собирает pkg/client: tracectx и requestid первыми, чтобы их
+	// id попадали в логи остальных интерсепторов, повторы по RetryInfo, и
+	// задержка, которую сервер попросил у одного вызова, держит все вызовы к
+	// нему, а не только повторы этого вызова. interceptorStat снаружи retry,
+	// чтобы учитывать время всех повторов, у повторов один request id
+	clientOpts := []client.Option{
+		// предупреждение об устаревшем API печатается один раз на метод
+		client.WithCallInterceptor(deprecation.UnaryClientInterceptor()),
+		client.WithCallInterceptor(interceptorStat),
+		client.WithRetryHook(retries.OnRetry),
+		client.WithMetadata(extraHeaders.MD()),
+		client.WithInterceptor(timings.UnaryClientInterceptor(), nil),
+		// после retry: каждая попытка retry - отдельный вызов gRPC, а попытки
+		// внутри него считает retries.StatsHandler
+		client.WithInterceptor(retries.UnaryClientInterceptor(), retries.StreamClientInterceptor()),
+	}
+	// контекст вызова - protobuf сообщение в бинарном заголовке
+	if *tenant != "" || *features != "" {
+		cc := &callctxpb.CallContext{Tenant: *tenant}
+		if *features != "" {
+			cc.Features = strings.Split(*features, ",")
+		}
+		callCtx, err := callctx.UnaryClientInterceptor(cc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientOpts = append(clientOpts, client.WithInterceptor(callCtx, nil))
+	}
+	// подпись после retry: каждая попытка подписывается со свежим временем
+	if *signingKey != "" {
+		clientOpts = append(clientOpts, client.WithInterceptor(signing.UnaryClientInterceptor([]byte(*signingKey)), nil))
+	}
+	// прокси: свой dialer отключает прокси из $HTTPS_PROXY, которым grpc-go
+	// пользуется по умолчанию
+	var proxyDialOpts []grpc.DialOption
+	if *proxyURL != "" {
+		dial, err := proxydial.Dialer(*proxyURL, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxyDialOpts = append(proxyDialOpts, grpc.WithContextDialer(dial))
+		log.Printf("Connecting through proxy %s", proxydial.Redact(*proxyURL))
+	}
+
+	// зеркало последним: копия получает все заголовки и подпись основного
+	// вызова; каждая попытка retry - отдельный вызов, поэтому повторенный
+	// вызов может скопироваться несколько раз, а сама копия не повторяется
+	var shadow *mirror.Mirror
+	if *mirrorTarget != "" {
+		if *mirrorPercent < 0 || *mirrorPercent > 100 {
+			log.Fatalf("-mirror-percent must be between 0 and 100, got %v", *mirrorPercent)
+		}
+		shadowConn, err := client.Dial(*mirrorTarget, client.WithRetries(1), client.WithDialOptions(proxyDialOpts...))
+		if err != nil {
+			log.Fatalf("did not connect to the mirror: %v", err)
+		}
+		defer shadowConn.Close()
+		shadow = mirror.New(shadowConn, *mirrorPercent, *mirrorTimeout)
+		clientOpts = append(clientOpts, client.WithInterceptor(shadow.UnaryClientInterceptor(), nil))
+	}
+
+	// без TLS флагов соединение без шифрования
+	if *useTLS || tlsOpts.Enabled() {
+		tlsCfg, err := tlsconfig.Client(tlsOpts)
+		if err != nil {
+			log.Fatalf("failed to load TLS config: %v", err)
+		}
+		clientOpts = append(clientOpts, client.WithTLS(tlsCfg))
+		log.Printf("TLS enabled (mTLS: %t)", tlsOpts.CertFile != "")
+		if tlsOpts.InsecureSkipVerify {
+			log.Printf("WARNING: the server certificate is not verified")
+		}
+	}
+
+	// остальное pkg/client не задает: keepalive и лимит ответов у него свои
+	dialOpts := []grpc.DialOption{
+		// логируем размер сообщений до и после сжатия
+		grpc.WithStatsHandler(wiresize.NewLogger("client")),
+		grpc.WithStatsHandler(wireSizes),
+		grpc.WithStatsHandler(runReport),
+		grpc.WithStatsHandler(retries.StatsHandler()),
 		grpc.WithDefaultCallOptions(
-			grpc.MaxCallRecvMsgSize(16*1024*1024),
 			grpc.MaxCallSendMsgSize(8*1024*1024),
 			grpc.WaitForReady(false),
 		),
-		grpc.WithReadBufferSize(64*1024),
-		grpc.WithWriteBufferSize(64*1024),
-	)
+		grpc.WithReadBufferSize(64 * 1024),
+		grpc.WithWriteBufferSize(64 * 1024),
+		// адреса, которые вернул резолвер, печатаются при каждом изменении
+		grpc.WithResolvers(connstate.LoggingResolver(dns.NewBuilder(), *resolveEvery)),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, *lb)),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  *backoffBase,
+				Multiplier: backoff.DefaultConfig.Multiplier,
+				Jitter:     backoff.DefaultConfig.Jitter,
+				MaxDelay:   *backoffMax,
+			},
+			MinConnectTimeout: *minConnectTimeout,
+		}),
+	}
+	dialOpts = append(dialOpts, proxyDialOpts...)
+	// DNS по умолчанию опрашивается не чаще раза в 30 секунд
+	if *resolveEvery > 0 {
+		dns.SetMinResolutionInterval(*resolveEvery)
+	}
+	// бинарный лог: заголовки, сообщения и статусы всех вызовов
+	var binlogSink *binlog.FileSink
+	if *binlogPath != "" {
+		binlogSink, err = binlog.Create(*binlogPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(binlog.NewHandler(binlogSink)))
+	}
+
+	// canary: оба адреса в одном канале, вызовы между ними делит балансировщик
+	// canary_split вместо -lb
+	dialTarget := *target
+	var canaryStats *canary.Stats
+	if *canaryTarget != "" {
+		if *canaryPercent < 0 || *canaryPercent > 100 {
+			log.Fatalf("-canary-percent must be between 0 and 100, got %v", *canaryPercent)
+		}
+		if strings.Contains(*target, "://") || strings.Contains(*canaryTarget, "://") || *target == *canaryTarget {
+			log.Fatalf("-target and -canary-target must be two different host:port addresses")
+		}
+		canaryStats = canary.Register(*canaryPercent)
+		r, canaryDial := canary.Resolver(*target, *canaryTarget)
+		dialTarget = canaryDial
+		dialOpts = append(dialOpts, grpc.WithResolvers(r), grpc.WithDefaultServiceConfig(canary.ServiceConfig))
+	}
+
+	// сервер в памяти этого же процесса: те же вызовы без сети и без порта
+	if *inProcess {
+		if *useTLS || tlsOpts.Enabled() || *canaryTarget != "" || *proxyURL != "" {
+			log.Fatal("-in-process can not be combined with TLS, -canary-target or -proxy")
+		}
+		embedded, err := inprocess.New()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer embedded.Close()
+		dialTarget = inprocess.Target
+		dialOpts = append(dialOpts, embedded.DialOption())
+		log.Printf("Calling the course services in process")
+	}
+	// через прокси имя сервера разрешает прокси: в закрытой сети локальный
+	// DNS часто не знает внешних имен; dns:/// адреса по-прежнему разрешаются
+	// здесь
+	if *proxyURL != "" && !strings.Contains(dialTarget, "://") {
+		dialTarget = "passthrough:///" + dialTarget
+	}
+	clientOpts = append(clientOpts, client.WithDialOptions(dialOpts...))
+	conn, err := client.Dial(dialTarget, clientOpts...)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 
+	if *pingPath != "" {
+		code, err := runPingPath(conn, *target, *pingPath, *pingCount, *pingInterval, clientOpts)
+		if err != nil {
+			log.Print(err)
+		}
+		conn.Close()
+		os.Exit(code)
+	}
+
 	c := pb.NewEchoAPIClient(conn)
+	cV2 := pbv2.NewEchoAPIClient(conn)
+
+	// вызовы выполняются как компонент группы: Ctrl+C отменяет их контекст,
+	// а группа дожидается завершения
+	g := graceful.New(shutdownTimeout)
+	g.OnShutdown(runReport.Shutdown)
+	if *metricsAddr != "" {
+		g.AddHTTPServer("metrics", metrics.NewServer(*metricsAddr))
+	}
+	// лог закрывается последним, после завершения вызовов
+	if binlogSink != nil {
+		g.Add("binary log", nil, func(context.Context) error { return binlogSink.Close() })
+	}
+	if *latencyEvery > 0 {
+		quietStat = true
+		g.AddContext("latency digest", func(ctx context.Context) error {
+			return latencies.Run(ctx, *latencyEvery)
+		})
+	}
+	g.AddContext("calls", func(ctx context.Context) error {
+		if err := run(ctx, c, cV2, callOpts); err != nil {
+			return err
+		}
+		if *echoMetadata {
+			if err := runEchoWithMetadata(ctx, cV2, callOpts); err != nil {
+				return err
+			}
+		}
+		if *oversize > 0 {
+			if err := runOversize(ctx, cV2, *oversize, callOpts); err != nil {
+				return err
+			}
+		}
+		if *importItems > 0 {
+			if err := runImportOrders(ctx, cV2, *importItems, callOpts); err != nil {
+				return err
+			}
+		}
+		if *export {
+			if err := runExportOrders(ctx, cV2, *exportCodec, *exportBreak, callOpts); err != nil {
+				return err
+			}
+		}
+		if *syncOrders {
+			if err := runSyncOrders(ctx, cV2, *syncWatch, callOpts); err != nil {
+				return err
+			}
+		}
+		if *cancelOrder {
+			if err := runCancelOrder(ctx, cV2, callOpts); err != nil {
+				return err
+			}
+		}
+		if *quotaOrders > 0 {
+			if err := runQuota(ctx, cV2, *quotaOrders, *quotaWait, callOpts); err != nil {
+				return err
+			}
+		}
+		if *dryRun {
+			if err := runDryRun(ctx, c, cV2, callOpts); err != nil {
+				return err
+			}
+		}
+		if *profile {
+			if err := runProfile(ctx, conn, callOpts); err != nil {
+				return err
+			}
+		}
+		if *broadcast {
+			if err := runBroadcastEcho(ctx, cV2, *broadcastTimeout, callOpts); err != nil {
+				return err
+			}
+		}
+		if *chainHops >= 0 {
+			if err := runChainedEcho(ctx, cV2, *chainHops, *chainDelay, *chainTimeout, callOpts); err != nil {
+				return err
+			}
+		}
+		if *slowDelay == 0 {
+			return nil
+		}
+		return runSlowEcho(ctx, cV2, *slowDelay, *slowTimeout, *slowIgnoreCancel, callOpts)
+	})
+	err = g.Run(context.Background())
+	// копии последних вызовов еще могут идти
+	if shadow != nil {
+		shadow.Wait()
+	}
+
+	// сводки печатаются и при ошибке: повторы важнее всего, когда вызов так
+	// и не удался
+	timings.Log()
+	if canaryStats != nil {
+		canaryStats.Log()
+	}
+	latencies.Log()
+	retries.Log()
+	wireSizes.Log()
+	if err := runReport.Write(*reportPath, g.Phases(), err); err != nil {
+		log.Print(err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+func run(ctx context.Context, c pb.EchoAPIClient, cV2 pbv2.EchoAPIClient, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*2)
 	defer cancel()
 
-	// Отправляем первый запрос
-	respHelloWorld, err := c.HelloWorld(ctx, &pb.EchoRequest{Message: "ping123456789"})
+	// все вызовы - спаны одного трейса
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	// Отправляем первый запрос; сервер кеширует ответ, заголовок x-cache
+	// говорит, взят ли он из кеша
+	var header metadata.MD
+	respHelloWorld, err := c.HelloWorld(ctx, &pb.EchoRequest{Message: "ping123456789"}, append(callOpts, grpc.Header(&header))...)
+	if err != nil {
+		return fmt.Errorf("could not greet: %w", err)
+	}
+	logger.Printf("Response Hello World: %s (cache: %s)", respHelloWorld.Message, strings.Join(header.Get(cache.StatusKey), ","))
+
+	// тот же вызов во второй версии API
+	respEcho, err := cV2.Echo(ctx, &pbv2.EchoRequest{Message: "ping123456789", Repeat: 2}, callOpts...)
 	if err != nil {
-		log.Fatalf("could not greet: %v", err)
+		return fmt.Errorf("could not echo: %w", err)
 	}
-	log.Printf("Response Hello World: %s", respHelloWorld.Message)
+	logger.Printf("Response Echo v2: %s (server time %s, request id %s)",
+		respEcho.GetMessage(), respEcho.GetServerTime().AsTime().Format(time.RFC3339Nano), respEcho.GetRequestId())
+
+	// ошибка с текстом на языке из -lang, сообщение статуса всегда английское
+	_, err = cV2.EchoWithError(ctx, &pbv2.EchoRequest{Message: "ping123456789"}, callOpts...)
+	logger.Printf("EchoWithError v2: %s (status message %q)", i18n.Display(err), status.Convert(err).Message())
 
 	// create request 1
 	createOrder1 := &pb.CreateOrder{
@@ -113,20 +534,579 @@ func main() {
 		//UserId:      &userID,
 	}
 
-	resp, err := c.CreateOrder(ctx, createOrderRequest)
+	// без ключа retry не повторит CreateOrder: заказ мог быть создан
+	resp, err := c.CreateOrder(idempotency.WithKey(ctx), createOrderRequest, callOpts...)
 	if err != nil {
 		st, ok := status.FromError(err)
 		if !ok {
-			log.Fatalf("status.FromError: %v", err)
+			return fmt.Errorf("status.FromError: %w", err)
 		}
-		log.Printf("Code: %s", st.Code().String())
+		logger.Printf("Code: %s", st.Code().String())
+		// пользователю показываем текст на его языке, если сервер его прислал
+		logger.Printf("Error: %s", i18n.Display(err))
 
 		for _, d := range st.Details() {
 			switch t := d.(type) {
 			case *pb.CustomError:
-				log.Printf("Reason: %v", t.Reason)
+				logger.Printf("Reason: %v", t.Reason)
+			case *errdetails.RequestInfo:
+				logger.Printf("Request ID: %s", t.RequestId)
+			case *errdetails.LocalizedMessage:
+				logger.Printf("Localized (%s): %s", t.Locale, t.Message)
 			}
 		}
 	}
-	log.Printf("resp: %v", resp)
+	logger.Printf("resp: %v", resp)
+
+	return nil
+}
+
+// runSlowEcho вызывает SlowEcho с задержкой delay и таймаутом timeout. При
+// timeout < delay вызов завершается DeadlineExceeded, а сервер прерывает
+// обработку (или нет, с ignoreCancel); Ctrl+C отменяет вызов с Canceled.
+func runSlowEcho(
+	ctx context.Context, cV2 pbv2.EchoAPIClient, delay, timeout time.Duration, ignoreCancel bool, callOpts []grpc.CallOption,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	start := time.Now()
+	resp, err := cV2.SlowEcho(ctx, &pbv2.SlowEchoRequest{
+		Message:            "slow ping",
+		Delay:              durationpb.New(delay),
+		IgnoreCancellation: ignoreCancel,
+	}, callOpts...)
+	if err != nil {
+		// ожидаемый результат демонстрации, а не ошибка клиента
+		logger.Printf("SlowEcho failed after %v: %s", time.Since(start).Round(time.Millisecond), status.Code(err))
+		return nil
+	}
+	logger.Printf("SlowEcho answered after %v: %s", time.Since(start).Round(time.Millisecond), resp.GetMessage())
+	return nil
+}
+
+// runChainedEcho вызывает ChainedEcho через hops звеньев с таймаутом timeout
+// на всю цепочку. Каждое звено тратит delay, поэтому при timeout меньше
+// суммы задержек дедлайн кончается посреди цепочки: звено, которому не
+// хватает времени на вызов следующего, отвечает DeadlineExceeded и
+// называет себя. Из двух заголовков запроса дальше первого звена уходит
+// только accept-language: x-debug-token нет в списке -chain-forward сервера.
+func runChainedEcho(
+	ctx context.Context, cV2 pbv2.EchoAPIClient, hops int, delay, timeout time.Duration, callOpts []grpc.CallOption,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	ctx = metadata.AppendToOutgoingContext(ctx, "accept-language", "ru", "x-debug-token", "secret")
+	logger := logctx.Logger(ctx)
+
+	start := time.Now()
+	resp, err := cV2.ChainedEcho(ctx, &pbv2.ChainedEchoRequest{
+		Message:  "chained ping",
+		Hops:     uint32(hops),
+		HopDelay: durationpb.New(delay),
+	}, callOpts...)
+	if err != nil {
+		// ожидаемый результат демонстрации, а не ошибка клиента
+		st := status.Convert(err)
+		logger.Printf("[CHAIN] failed after %v: %s: %s", time.Since(start).Round(time.Millisecond), st.Code(), st.Message())
+		return nil
+	}
+	for _, hop := range resp.GetHops() {
+		remaining := "no deadline"
+		if hop.GetRemaining() != nil {
+			remaining = hop.GetRemaining().AsDuration().Round(time.Millisecond).String() + " left"
+		}
+		logger.Printf("[CHAIN] hop %d on %s: %s, forwarded %v", hop.GetHop(), hop.GetServerId(), remaining, hop.GetForwardedMetadata())
+	}
+	logger.Printf("[CHAIN] answered after %v: %s", time.Since(start).Round(time.Millisecond), resp.GetMessage())
+	return nil
+}
+
+// runBroadcastEcho вызывает BroadcastEcho: сервер вызывает Echo своих
+// -broadcast-targets одновременно, каждый не дольше timeout. Неответивший
+// сервер не проваливает вызов, а попадает в ответ со своей ошибкой.
+func runBroadcastEcho(ctx context.Context, cV2 pbv2.EchoAPIClient, timeout time.Duration, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	logger := logctx.Logger(ctx)
+
+	resp, err := cV2.BroadcastEcho(ctx, &pbv2.BroadcastEchoRequest{
+		Message:       "broadcast ping",
+		TargetTimeout: durationpb.New(timeout),
+	}, callOpts...)
+	if err != nil {
+		return fmt.Errorf("BroadcastEcho: %w", err)
+	}
+	for _, r := range resp.GetResults() {
+		latency := r.GetLatency().AsDuration().Round(time.Millisecond)
+		if r.GetError() != nil {
+			st := status.FromProto(r.GetError())
+			logger.Printf("[BROADCAST] %s: %s after %v: %s", r.GetTarget(), st.Code(), latency, st.Message())
+			continue
+		}
+		logger.Printf("[BROADCAST] %s: %q after %v", r.GetTarget(), r.GetResponse().GetMessage(), latency)
+	}
+	logger.Printf("BroadcastEcho: %d succeeded, %d failed", resp.GetSucceeded(), resp.GetFailed())
+	return nil
+}
+
+// runOversize отправляет Echo с сообщением size байт. Сообщение больше
+// -max-recv-msg-size сервера отклоняется с ResourceExhausted, причину и
+// лимит клиент берет из детали ErrorInfo.
+func runOversize(ctx context.Context, cV2 pbv2.EchoAPIClient, size int, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	_, err := cV2.Echo(ctx, &pbv2.EchoRequest{Message: strings.Repeat("x", size)}, callOpts...)
+	if err == nil {
+		logger.Printf("Oversized Echo of %d bytes accepted", size)
+		return nil
+	}
+
+	st := status.Convert(err)
+	logger.Printf("Oversized Echo of %d bytes: %s: %s", size, st.Code(), st.Message())
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			logger.Printf("    reason %s (%s), limit %s bytes", info.GetReason(), info.GetDomain(), info.GetMetadata()["limit"])
+		}
+	}
+	return nil
+}
+
+// runImportOrders импортирует n позиций одним клиентским стримом. Каждая
+// пятая позиция - с неверным product_id, каждая седьмая - с количеством
+// больше 10: сервер отклоняет только их, остальные импортируются, а причины
+// отказов приходят в результатах по позициям.
+func runImportOrders(ctx context.Context, cV2 pbv2.EchoAPIClient, n int, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	stream, err := cV2.ImportOrders(ctx, callOpts...)
+	if err != nil {
+		return fmt.Errorf("could not import orders: %w", err)
+	}
+	for i := 1; i <= n; i++ {
+		item := &pbv2.OrderItem{ProductId: uuid.NewString(), Count: 1}
+		if i%5 == 0 {
+			item.ProductId = "product-" + strconv.Itoa(i)
+		}
+		if i%7 == 0 {
+			item.Count = 11
+		}
+		if err := stream.Send(&pbv2.ImportOrdersRequest{Ref: "line-" + strconv.Itoa(i), Item: item}); err != nil {
+			// причину обрыва вернет CloseAndRecv
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("could not import orders: %w", err)
+	}
+
+	logger.Printf("ImportOrders: imported %d, failed %d", resp.GetImported(), resp.GetFailed())
+	for _, r := range resp.GetResults() {
+		if r.GetError() == nil {
+			continue
+		}
+		st := status.FromProto(r.GetError())
+		logger.Printf("    #%d %s: %s: %s", r.GetIndex(), r.GetRef(), st.Code(), st.Message())
+		for _, d := range st.Details() {
+			switch d := d.(type) {
+			case *errdetails.BadRequest:
+				for _, v := range d.GetFieldViolations() {
+					logger.Printf("        %s: %s", v.GetField(), v.GetDescription())
+				}
+			case *pbv2.CustomError:
+				logger.Printf("        %s: %s", d.GetField(), d.GetReason())
+			}
+		}
+	}
+	return nil
+}
+
+// runExportOrders выгружает заказы. С breakAfter > 0 клиент отменяет вызов
+// после breakAfter заказов, как при обрыве соединения, и продолжает
+// выгрузку новым вызовом с cursor последнего полученного заказа: заказы не
+// теряются и не повторяются.
+func runExportOrders(ctx context.Context, cV2 pbv2.EchoAPIClient, codecName string, breakAfter int, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// пачки распаковывает exportbatch.Reader, дальше заказы те же
+	var codec pbv2.PayloadCodec
+	if codecName != "" {
+		var err error
+		if codec, err = exportbatch.ParseCodec(codecName); err != nil {
+			return err
+		}
+	}
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	var (
+		cursor string
+		total  int
+	)
+	for attempt := 1; ; attempt++ {
+		callCtx, callCancel := context.WithCancel(ctx)
+		stream, err := cV2.ExportOrders(callCtx, &pbv2.ExportOrdersRequest{Cursor: cursor, PayloadCodec: codec}, callOpts...)
+		if err != nil {
+			callCancel()
+			return fmt.Errorf("could not export orders: %w", err)
+		}
+
+		var received int
+		batches := exportbatch.NewReader(exportBatchMaxSize)
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				callCancel()
+				logger.Printf("ExportOrders: %d orders in %d calls", total, attempt)
+				return nil
+			}
+			if err != nil {
+				callCancel()
+				return fmt.Errorf("could not export orders: %w", err)
+			}
+
+			orders, err := batches.Orders(resp)
+			if err != nil {
+				callCancel()
+				return fmt.Errorf("could not export orders: %w", err)
+			}
+			for _, o := range orders {
+				logger.Printf("    %s %s x%d %s %s", o.GetId(), o.GetProductId(), o.GetCount(), o.GetStatus(), o.GetCreatedAt().AsTime().Format(time.RFC3339))
+			}
+			cursor = resp.GetCursor()
+			total += len(orders)
+			received += len(orders)
+			// с пачками выгрузка обрывается на первой пачке, в которой
+			// набралось breakAfter заказов
+			if attempt == 1 && breakAfter > 0 && received >= breakAfter {
+				break
+			}
+		}
+		callCancel()
+		logger.Printf("ExportOrders: interrupted after %d orders, resuming from cursor %s", received, cursor)
+	}
+}
+
+// runSyncOrders создает заказ и отправляет в SyncOrders изменения, которые
+// показывают все исходы: обычное изменение, два изменения старой версии
+// (раньше и позже победившего, last-writer-wins) и изменение несуществующего
+// заказа. Ответы и чужие изменения печатаются по мере прихода; с watch > 0
+// стрим остается открытым еще watch, чтобы увидеть изменения других клиентов,
+// запущенных параллельно.
+func runSyncOrders(ctx context.Context, cV2 pbv2.EchoAPIClient, watch time.Duration, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second+watch)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	created, err := cV2.CreateOrders(idempotency.WithKey(ctx), &pbv2.CreateOrdersRequest{
+		Items:       []*pbv2.OrderItem{{ProductId: uuid.NewString(), Count: 1}},
+		Customer:    &pbv2.CreateOrdersRequest_UserId{UserId: uuid.NewString()},
+		PaymentType: pbv2.PaymentType_PAYMENT_TYPE_CASH,
+	}, callOpts...)
+	if err != nil {
+		return fmt.Errorf("could not create order: %w", err)
+	}
+	id := created.GetOrderIds()[0]
+
+	stream, err := cV2.SyncOrders(ctx, callOpts...)
+	if err != nil {
+		return fmt.Errorf("could not sync orders: %w", err)
+	}
+
+	now := time.Now()
+	changes := []*pbv2.SyncOrdersRequest{
+		// версия 1 текущая: применится и станет версией 2
+		{OrderId: id, BaseVersion: 1, Status: pbv2.OrderStatus_ORDER_STATUS_PAID, Count: 1, ChangedAt: timestamppb.New(now)},
+		// версия 1 устарела, а изменение сделано раньше примененного: отказ
+		{OrderId: id, BaseVersion: 1, Status: pbv2.OrderStatus_ORDER_STATUS_CANCELLED, Count: 1, ChangedAt: timestamppb.New(now.Add(-time.Minute))},
+		// версия 1 устарела, но изменение сделано позже: применится
+		{OrderId: id, BaseVersion: 1, Status: pbv2.OrderStatus_ORDER_STATUS_PAID, Count: 2, ChangedAt: timestamppb.New(now.Add(time.Second))},
+		{OrderId: uuid.NewString(), BaseVersion: 1, Status: pbv2.OrderStatus_ORDER_STATUS_PAID, Count: 1, ChangedAt: timestamppb.New(now)},
+	}
+	return streams.RunBidi(ctx, stream,
+		func(ctx context.Context, send func(*pbv2.SyncOrdersRequest) error) error {
+			for _, c := range changes {
+				logger.Printf("SyncOrders > %s v%d %s x%d", c.GetOrderId(), c.GetBaseVersion(), c.GetStatus(), c.GetCount())
+				if err := send(c); err != nil {
+					return err
+				}
+			}
+			return streams.Pause(ctx, watch)
+		},
+		func(resp *pbv2.SyncOrdersResponse) error {
+			o := resp.GetOrder()
+			switch {
+			case resp.GetError() != nil:
+				st := status.FromProto(resp.GetError())
+				logger.Printf("SyncOrders < %s: %s: %s", resp.GetEvent(), st.Code(), st.Message())
+			case resp.GetConflict():
+				logger.Printf("SyncOrders < %s after conflict: %s v%d %s x%d", resp.GetEvent(), o.GetId(), o.GetVersion(), o.GetStatus(), o.GetCount())
+			default:
+				logger.Printf("SyncOrders < %s: %s v%d %s x%d", resp.GetEvent(), o.GetId(), o.GetVersion(), o.GetStatus(), o.GetCount())
+			}
+			return nil
+		})
+}
+
+// runCancelOrder создает заказ и отменяет его дважды. Первая отмена
+// проходит, и клиенты SyncOrders получают SYNC_EVENT_CANCELLED; вторая
+// отклоняется с FailedPrecondition: отменить можно только новый заказ, а
+// статус заказа приходит в детали ErrorInfo.
+func runCancelOrder(ctx context.Context, cV2 pbv2.EchoAPIClient, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	created, err := cV2.CreateOrders(idempotency.WithKey(ctx), &pbv2.CreateOrdersRequest{
+		Items:       []*pbv2.OrderItem{{ProductId: uuid.NewString(), Count: 1}},
+		Customer:    &pbv2.CreateOrdersRequest_UserId{UserId: uuid.NewString()},
+		PaymentType: pbv2.PaymentType_PAYMENT_TYPE_CASH,
+	}, callOpts...)
+	if err != nil {
+		return fmt.Errorf("could not create order: %w", err)
+	}
+	id := created.GetOrderIds()[0]
+
+	for range 2 {
+		resp, err := cV2.CancelOrder(ctx, &pbv2.CancelOrderRequest{OrderId: id}, callOpts...)
+		if err == nil {
+			o := resp.GetOrder()
+			logger.Printf("CancelOrder: %s v%d %s", o.GetId(), o.GetVersion(), o.GetStatus())
+			continue
+		}
+		st := status.Convert(err)
+		if st.Code() != codes.FailedPrecondition {
+			return fmt.Errorf("could not cancel order: %w", err)
+		}
+		logger.Printf("CancelOrder: %s: %s", st.Code(), i18n.Display(err))
+		for _, d := range st.Details() {
+			if info, ok := d.(*errdetails.ErrorInfo); ok {
+				md := info.GetMetadata()
+				logger.Printf("    reason %s (%s), order %s is %s, cannot become %s",
+					info.GetReason(), info.GetDomain(), md["order_id"], md["status"], md["target"])
+			}
+		}
+	}
+	return nil
+}
+
+// runPingPath проверяет здоровье сервера напрямую через conn и через прокси
+// proxyAddr и печатает, какое звено сломано. Код выхода 1, если сервер
+// недоступен через прокси.
+func runPingPath(conn *grpc.ClientConn, target, proxyAddr string, count int, interval time.Duration, opts []client.Option) (int, error) {
+	proxyConn, err := client.Dial(proxyAddr, opts...)
+	if err != nil {
+		return 1, fmt.Errorf("could not connect to proxy: %w", err)
+	}
+	defer proxyConn.Close()
+
+	ctx := context.Background()
+	// пути проверяются по очереди, чтобы не мешать друг другу
+	direct := pathcheck.Ping(ctx, pathcheck.Direct, target, conn, count, interval, 2*time.Second)
+	proxied := pathcheck.Ping(ctx, pathcheck.Proxy, proxyAddr, proxyConn, count, interval, 2*time.Second)
+	log.Printf("[PING PATH] %s", direct)
+	log.Printf("[PING PATH] %s", proxied)
+
+	verdict, ok := pathcheck.Diagnose(direct, proxied)
+	log.Printf("[PING PATH] %s", verdict)
+	if !ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// runQuota создает n заказов по одному. Когда квота пользователя на
+// CreateOrders исчерпана, сервер отвечает ResourceExhausted с QuotaFailure и
+// RetryInfo до конца окна квоты; интерсептор retry так долго не ждет, и
+// runQuota сама ждет указанное время (не дольше maxWait) и повторяет вызов с
+// тем же ключом идемпотентности.
+func runQuota(ctx context.Context, cV2 pbv2.EchoAPIClient, n int, maxWait time.Duration, callOpts []grpc.CallOption) error {
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	for i := 1; i <= n; i++ {
+		req := &pbv2.CreateOrdersRequest{
+			Items:       []*pbv2.OrderItem{{ProductId: uuid.NewString(), Count: 1}},
+			Customer:    &pbv2.CreateOrdersRequest_UserId{UserId: uuid.NewString()},
+			PaymentType: pbv2.PaymentType_PAYMENT_TYPE_CASH,
+		}
+		callCtx := idempotency.WithKey(ctx)
+		for {
+			resp, err := createOrder(callCtx, cV2, req, callOpts)
+			if err == nil {
+				logger.Printf("Quota: order %d of %d created: %s", i, n, resp.GetOrderIds()[0])
+				break
+			}
+			violations := quota.Violations(err)
+			delay, ok := retry.Delay(err)
+			if len(violations) == 0 || !ok {
+				return fmt.Errorf("could not create order %d: %w", i, err)
+			}
+			for _, v := range violations {
+				logger.Printf("Quota: %s exceeded %s (%d), %s", v.GetSubject(), v.GetQuotaId(), v.GetQuotaValue(), v.GetDescription())
+			}
+			if delay > maxWait {
+				return fmt.Errorf("quota resets in %v, longer than -quota-wait %v: %w", delay, maxWait, err)
+			}
+			logger.Printf("Quota: waiting %v until the quota resets", delay.Round(time.Millisecond))
+			if err := streams.Pause(ctx, delay); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runDryRun проверяет заказы пробными вызовами: сервер выполняет все
+// проверки и отвечает, какими были бы заказы, но ничего не сохраняет.
+// Второй заказ в CreateOrders нарушает лимит количества, и пробный вызов
+// отклоняется так же, как настоящий. Старый api.v1 не знает поля dry_run,
+// для него пробный вызов задается заголовком x-dry-run, и клиент проверяет,
+// что сервер его подтвердил, а не создал заказ.
+func runDryRun(ctx context.Context, c pb.EchoAPIClient, cV2 pbv2.EchoAPIClient, callOpts []grpc.CallOption) error {
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	req := &pbv2.CreateOrdersRequest{
+		Items:       []*pbv2.OrderItem{{ProductId: uuid.NewString(), Count: 2}},
+		Customer:    &pbv2.CreateOrdersRequest_UserId{UserId: uuid.NewString()},
+		PaymentType: pbv2.PaymentType_PAYMENT_TYPE_CASH,
+		DryRun:      true,
+	}
+	resp, err := createOrder(ctx, cV2, req, callOpts)
+	if err != nil {
+		return fmt.Errorf("could not dry-run CreateOrders: %w", err)
+	}
+	for _, o := range resp.GetOrders() {
+		logger.Printf("Dry run: CreateOrders would create %s x%d, %s, version %d", o.GetProductId(), o.GetCount(), o.GetStatus(), o.GetVersion())
+	}
+
+	req.Items = append(req.Items, &pbv2.OrderItem{ProductId: uuid.NewString(), Count: 11})
+	if _, err := createOrder(ctx, cV2, req, callOpts); err != nil {
+		logger.Printf("Dry run: CreateOrders would be rejected: %s: %s", status.Code(err), i18n.Display(err))
+	} else {
+		return errors.New("dry run of CreateOrders with count 11 was not rejected")
+	}
+
+	callCtx, cancel := context.WithTimeout(dryrun.NewOutgoingContext(ctx), 2*time.Second)
+	defer cancel()
+	var header metadata.MD
+	userID := uuid.NewString()
+	_, err = c.CreateOrder(callCtx, &pb.CreateOrdersRequest{
+		CreateOrder: []*pb.CreateOrder{{ProductId: uuid.NewString(), Count: 1}},
+		UserId:      &userID,
+		PaymentType: &pb.CreateOrdersRequest_Credit{Credit: true},
+	}, append(callOpts, grpc.Header(&header))...)
+	if err != nil {
+		return fmt.Errorf("could not dry-run api.v1 CreateOrder: %w", err)
+	}
+	if !dryrun.Confirmed(header) {
+		return errors.New("the server did not confirm x-dry-run: api.v1 CreateOrder may have created the order")
+	}
+	logger.Printf("Dry run: api.v1 CreateOrder passed, %s confirmed by the server", dryrun.Header)
+	return nil
+}
+
+func createOrder(ctx context.Context, cV2 pbv2.EchoAPIClient, req *pbv2.CreateOrdersRequest, callOpts []grpc.CallOption) (*pbv2.CreateOrdersResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return cV2.CreateOrders(ctx, req, callOpts...)
+}
+
+// runEchoWithMetadata печатает заголовки вызова так, как их увидел сервер:
+// вместе с добавленными интерсепторами клиента и транспортом.
+func runEchoWithMetadata(ctx context.Context, cV2 pbv2.EchoAPIClient, callOpts []grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	ctx = tracectx.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	resp, err := cV2.EchoWithMetadata(ctx, &pbv2.EchoWithMetadataRequest{}, callOpts...)
+	if err != nil {
+		return fmt.Errorf("could not echo metadata: %w", err)
+	}
+
+	keys := make([]string, 0, len(resp.GetMetadata()))
+	for k := range resp.GetMetadata() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	p := resp.GetPeer()
+	logger.Printf("Server saw peer %s, tls %q", p.GetAddress(), p.GetTlsVersion())
+	for _, k := range keys {
+		logger.Printf("    %s: %s", k, strings.Join(resp.GetMetadata()[k].GetValues(), ", "))
+	}
+	return nil
+}
+
+// runProfile показывает совместимость ревизий схемы. Клиент следующей
+// ревизии вызывает методы api.v2.EchoAPI своими сообщениями nextpb.Profile:
+// формат на проводе совместим, а сгенерированного клиента для них нет, поэтому
+// вызов идет через conn.Invoke. Сервер знает только id и name, остальные поля
+// он хранит как unknown и должен вернуть их без изменений - и в ответе
+// SaveProfile, и позже в GetProfile.
+func runProfile(ctx context.Context, conn *grpc.ClientConn, callOpts []grpc.CallOption) error {
+	sent := &nextpb.Profile{
+		Id:      uuid.NewString(),
+		Name:    "Ivan",
+		Email:   "ivan@example.com",
+		Tags:    []string{"beta", "newsletter"},
+		Address: &nextpb.Address{City: "Moscow", Street: "Tverskaya 1"},
+	}
+
+	saved := &nextpb.Profile{}
+	if err := conn.Invoke(ctx, pbv2.EchoAPI_SaveProfile_FullMethodName, sent, saved, callOpts...); err != nil {
+		return fmt.Errorf("SaveProfile: %w", err)
+	}
+	loaded := &nextpb.Profile{}
+	req := &pbv2.GetProfileRequest{Id: sent.GetId()}
+	if err := conn.Invoke(ctx, pbv2.EchoAPI_GetProfile_FullMethodName, req, loaded, callOpts...); err != nil {
+		return fmt.Errorf("GetProfile: %w", err)
+	}
+
+	// так тот же ответ видит клиент текущей ревизии: новые поля для него
+	// unknown, но не потеряны
+	data, err := proto.Marshal(loaded)
+	if err != nil {
+		return err
+	}
+	current := &pbv2.Profile{}
+	if err := proto.Unmarshal(data, current); err != nil {
+		return err
+	}
+	log.Printf("[PROFILE] api.v2 видит id=%s name=%s и %d байт незнакомых полей",
+		current.GetId(), current.GetName(), len(current.ProtoReflect().GetUnknown()))
+
+	if !proto.Equal(sent, saved) {
+		return fmt.Errorf("SaveProfile вернул профиль с потерянными полями: отправлен {%v}, получен {%v}", sent, saved)
+	}
+	if !proto.Equal(sent, loaded) {
+		return fmt.Errorf("GetProfile вернул профиль с потерянными полями: отправлен {%v}, получен {%v}", sent, loaded)
+	}
+	log.Printf("[PROFILE] новые поля пережили сервер: email=%s tags=%v address=%s, %s",
+		loaded.GetEmail(), loaded.GetTags(), loaded.GetAddress().GetCity(), loaded.GetAddress().GetStreet())
+	return nil
 }
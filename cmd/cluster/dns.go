@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer is a tiny authoritative DNS server for one name: it answers A
+// queries for the name with the addresses currently published and every
+// other query of the name with an empty answer. The client resolves the
+// replicas through it, the harness changes the published set at run time.
+type dnsServer struct {
+	name string
+	conn net.PacketConn
+
+	mu    sync.Mutex
+	addrs []netip.Addr
+}
+
+// newDNSServer listens on addr (UDP) and answers for name.
+func newDNSServer(addr, name string) (*dnsServer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dnsServer{name: dnsmessage.MustNewName(strings.TrimSuffix(name, ".") + ".").String(), conn: conn}, nil
+}
+
+// publish replaces the addresses returned for the name.
+func (s *dnsServer) publish(addrs []netip.Addr) {
+	s.mu.Lock()
+	s.addrs = addrs
+	s.mu.Unlock()
+}
+
+func (s *dnsServer) published() []netip.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addrs
+}
+
+// serve answers queries until close.
+func (s *dnsServer) serve() error {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			log.Printf("[DNS] bad query from %s: %v", from, err)
+			continue
+		}
+		if _, err := s.conn.WriteTo(resp, from); err != nil {
+			log.Printf("[DNS] reply to %s: %v", from, err)
+		}
+	}
+}
+
+func (s *dnsServer) answer(query []byte) ([]byte, error) {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+		Questions: req.Questions,
+	}
+	for _, q := range req.Questions {
+		if !strings.EqualFold(q.Name.String(), s.name) {
+			resp.RCode = dnsmessage.RCodeNameError
+			continue
+		}
+		if q.Type != dnsmessage.TypeA {
+			continue
+		}
+		for _, a := range s.published() {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{
+				// a short TTL, although grpc ignores it and resolves again
+				// only when asked to
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 1},
+				Body:   &dnsmessage.AResource{A: a.As4()},
+			})
+		}
+	}
+	return resp.Pack()
+}
+
+func (s *dnsServer) close() error {
+	return s.conn.Close()
+}
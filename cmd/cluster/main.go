@@ -0,0 +1,236 @@
+// cluster runs a small cluster on one machine: N in-process replicas of an
+// Echo server on sequential ports and a client that balances its calls over
+// them round robin and prints which replica answered each one. Every
+// -restart-every one replica after another is drained and restarted, which
+// shows how a rolling restart looks from the client: the draining replica
+// reports NOT_SERVING, the balancer stops picking it, the calls go on
+// without errors.
+//
+// With -dns the client finds the replicas the way it would in production:
+// it dials a dns:/// target served by a DNS server inside the process, and
+// every -rescale-every the harness takes the last replica out of the DNS
+// answer or puts it back. The replicas listen on 127.0.0.1, 127.0.0.2 and
+// so on, since an A record has no port.
+//
+//	go run ./cmd/cluster -replicas 3 -base-port 6001
+//	go run ./cmd/cluster -dns 127.0.0.1:5353 -restart-every 0
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health" // client-side health checking
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/dns"
+	"google.golang.org/grpc/resolver/manual"
+
+	"github.com/easyp-tech/course-grpc/internal/connstate"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// shutdownTimeout bounds the shutdown of the client and all replicas.
+const shutdownTimeout = 10 * time.Second
+
+// dnsName is the name the DNS server of -dns answers for.
+const dnsName = "echo.cluster"
+
+// serviceConfig balances round robin over the replicas that report SERVING
+// for the whole server.
+const serviceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": ""}
+}`
+
+func main() {
+	replicas := flag.Int("replicas", 3, "number of server replicas")
+	basePort := flag.Int("base-port", 6001, "port of the first replica, the others follow")
+	interval := flag.Duration("interval", 200*time.Millisecond, "pause between two client calls")
+	restartEvery := flag.Duration("restart-every", 10*time.Second, "restart the next replica this often, 0 disables rolling restarts")
+	drainDelay := flag.Duration("drain-delay", time.Second, "time a replica reports NOT_SERVING before it stops")
+	downtime := flag.Duration("downtime", 2*time.Second, "time a restarted replica stays down")
+	dnsAddr := flag.String("dns", "", "serve the replica addresses over DNS on this UDP address and dial a dns:/// target, empty to pass them to the client directly")
+	rescaleEvery := flag.Duration("rescale-every", 8*time.Second, "with -dns, remove the last replica from the DNS answer or add it back this often, 0 disables it")
+	resolveEvery := flag.Duration("resolve-every", 2*time.Second, "with -dns, resolve the target again this often")
+	flag.Parse()
+
+	if *replicas < 1 {
+		log.Fatal("-replicas must be at least 1")
+	}
+
+	cluster := make([]*replica, *replicas)
+	addrs := make([]resolver.Address, *replicas)
+	ips := make([]netip.Addr, *replicas)
+	for i := range cluster {
+		cluster[i] = &replica{
+			name: fmt.Sprintf("replica-%d", i+1),
+			addr: fmt.Sprintf("localhost:%d", *basePort+i),
+		}
+		if *dnsAddr != "" {
+			ips[i] = netip.AddrFrom4([4]byte{127, 0, 0, byte(i + 1)})
+			cluster[i].addr = netip.AddrPortFrom(ips[i], uint16(*basePort)).String()
+		}
+		addrs[i] = resolver.Address{Addr: cluster[i].addr}
+		if err := cluster[i].start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	g := graceful.New(shutdownTimeout)
+
+	// the addresses are known up front, a manual resolver hands them to the
+	// balancer without DNS
+	r := manual.NewBuilderWithScheme("cluster")
+	r.InitialState(resolver.State{Addresses: addrs})
+	target := r.Scheme() + ":///echo"
+	var resolverBuilder resolver.Builder = r
+
+	var names *dnsServer
+	if *dnsAddr != "" {
+		var err error
+		names, err = newDNSServer(*dnsAddr, dnsName)
+		if err != nil {
+			log.Fatalf("Failed to start DNS server: %v", err)
+		}
+		names.publish(ips)
+		g.Add("DNS server", names.serve, func(context.Context) error { return names.close() })
+
+		// the dns resolver logs every new address set; by default it would
+		// query again only after a connection failed, at most every 30s
+		target = fmt.Sprintf("dns://%s/%s:%d", *dnsAddr, dnsName, *basePort)
+		resolverBuilder = connstate.LoggingResolver(dns.NewBuilder(), *resolveEvery)
+		dns.SetMinResolutionInterval(*resolveEvery)
+		log.Printf("[DNS] %s on %s: %v", dnsName, *dnsAddr, ips)
+	}
+
+	timings := servertiming.NewCollector()
+	conn, err := grpc.NewClient(target,
+		grpc.WithResolvers(resolverBuilder),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithChainUnaryInterceptor(
+			requestid.UnaryClientInterceptor(),
+			timings.UnaryClientInterceptor(),
+		),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+	client := pbv2.NewEchoAPIClient(conn)
+
+	// stopped last, once the client no longer calls them
+	g.Add("replicas", nil, func(ctx context.Context) error {
+		var errs []error
+		for _, rep := range cluster {
+			errs = append(errs, rep.stop(ctx, 0))
+		}
+		return errors.Join(errs...)
+	})
+	g.AddContext("client", func(ctx context.Context) error {
+		return call(ctx, client, *interval)
+	})
+	if names != nil && *rescaleEvery > 0 && len(ips) > 1 {
+		g.AddContext("rescale", func(ctx context.Context) error {
+			return rescale(ctx, names, ips, *rescaleEvery)
+		})
+	}
+	if *restartEvery > 0 {
+		g.AddContext("rolling restart", func(ctx context.Context) error {
+			return rollingRestart(ctx, cluster, *restartEvery, *drainDelay, *downtime)
+		})
+	}
+
+	log.Printf("Cluster of %d replicas running, press Ctrl+C to stop", *replicas)
+	if err := g.Run(context.Background()); err != nil {
+		log.Printf("Cluster stopped with error: %v", err)
+	}
+	timings.Log()
+}
+
+// call sends an Echo every interval and prints the replica that answered.
+func call(ctx context.Context, client pbv2.EchoAPIClient, interval time.Duration) error {
+	for n := 1; ; n++ {
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		var trailer metadata.MD
+		start := time.Now()
+		_, err := client.Echo(callCtx, &pbv2.EchoRequest{Message: fmt.Sprintf("call %d", n)}, grpc.Trailer(&trailer))
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			log.Printf("Call %d failed after %v: %v", n, time.Since(start).Round(time.Microsecond), err)
+		default:
+			server := "unknown"
+			if ids := trailer.Get(servertiming.ServerIDKey); len(ids) > 0 {
+				server = ids[0]
+			}
+			log.Printf("Call %d -> %s in %v", n, server, time.Since(start).Round(time.Microsecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// rescale takes the last replica out of the DNS answer and puts it back,
+// alternating every interval. The replica keeps running: the client stops
+// and starts using it only because the resolved address set changed.
+func rescale(ctx context.Context, names *dnsServer, ips []netip.Addr, interval time.Duration) error {
+	for all := false; ; all = !all {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+
+		published := ips[:len(ips)-1]
+		if all {
+			published = ips
+		}
+		names.publish(published)
+		log.Printf("[DNS] %s: published %v", dnsName, published)
+	}
+}
+
+// rollingRestart drains and restarts one replica after another, one every
+// interval.
+func rollingRestart(ctx context.Context, cluster []*replica, interval, drainDelay, downtime time.Duration) error {
+	for i := 0; ; i = (i + 1) % len(cluster) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+
+		rep := cluster[i]
+		log.Printf("[CLUSTER] Rolling restart: %s", rep.name)
+		if err := rep.stop(ctx, drainDelay); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(downtime):
+		}
+		if err := rep.start(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// replica is one in-process server. It can be stopped and started again on
+// the same address, which is what a rolling restart does.
+type replica struct {
+	name string
+	addr string
+
+	mu     sync.Mutex
+	server *grpc.Server
+	health *health.Server
+}
+
+// echoServer answers v2 Echo with the name of the replica in the message.
+type echoServer struct {
+	pbv2.UnimplementedEchoAPIServer
+
+	name string
+}
+
+func (s *echoServer) Echo(ctx context.Context, req *pbv2.EchoRequest) (*pbv2.EchoResponse, error) {
+	id, _ := requestid.FromContext(ctx)
+	return &pbv2.EchoResponse{
+		Message:    fmt.Sprintf("%s: %s", s.name, req.GetMessage()),
+		ServerTime: timestamppb.Now(),
+		RequestId:  id,
+	}, nil
+}
+
+// start listens on the address of the replica and serves until stop.
+func (r *replica) start() error {
+	lis, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.name, err)
+	}
+
+	// the replica name goes into the x-server-id trailer, that is how the
+	// client tells the replicas apart
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			requestid.UnaryServerInterceptor(),
+			servertiming.UnaryServerInterceptor(r.name),
+		),
+	)
+	pbv2.RegisterEchoAPIServer(s, &echoServer{name: r.name})
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+
+	r.mu.Lock()
+	r.server, r.health = s, hs
+	r.mu.Unlock()
+
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("[CLUSTER] %s: serve: %v", r.name, err)
+		}
+	}()
+	log.Printf("[CLUSTER] %s: serving on %s", r.name, r.addr)
+	return nil
+}
+
+// stop drains the replica: it reports NOT_SERVING so the client's balancer
+// takes it out of rotation, waits drainDelay and stops gracefully, forcefully
+// once ctx is done.
+func (r *replica) stop(ctx context.Context, drainDelay time.Duration) error {
+	r.mu.Lock()
+	s, hs := r.server, r.health
+	r.server, r.health = nil, nil
+	r.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+
+	log.Printf("[CLUSTER] %s: draining", r.name)
+	hs.Shutdown()
+	select {
+	case <-time.After(drainDelay):
+	case <-ctx.Done():
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+		return fmt.Errorf("%s: graceful stop: %w", r.name, ctx.Err())
+	}
+	log.Printf("[CLUSTER] %s: stopped", r.name)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// runChannelz prints the servers of the process with their listeners and
+// open connections, followed by the channels the process dials itself.
+func runChannelz(ctx context.Context, conn *grpc.ClientConn, _ []string) (int, error) {
+	client := channelzpb.NewChannelzClient(conn)
+
+	servers, err := client.GetServers(ctx, &channelzpb.GetServersRequest{})
+	if err != nil {
+		return 1, err
+	}
+	for _, srv := range servers.GetServer() {
+		d := srv.GetData()
+		fmt.Printf("server %d: calls started=%d succeeded=%d failed=%d, last call %s\n",
+			srv.GetRef().GetServerId(), d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed(),
+			since(d.GetLastCallStartedTimestamp()))
+
+		for _, ref := range srv.GetListenSocket() {
+			sock, err := client.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: ref.GetSocketId()})
+			if err != nil {
+				return 1, err
+			}
+			fmt.Printf("  listening on %s\n", formatAddress(sock.GetSocket().GetLocal()))
+		}
+
+		sockets, err := client.GetServerSockets(ctx, &channelzpb.GetServerSocketsRequest{ServerId: srv.GetRef().GetServerId()})
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("  %d connections\n", len(sockets.GetSocketRef()))
+		for _, ref := range sockets.GetSocketRef() {
+			sock, err := client.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: ref.GetSocketId()})
+			if err != nil {
+				return 1, err
+			}
+			printSocket(sock.GetSocket())
+		}
+	}
+
+	channels, err := client.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{})
+	if err != nil {
+		return 1, err
+	}
+	for _, ch := range channels.GetChannel() {
+		d := ch.GetData()
+		fmt.Printf("channel %d to %s: %s, calls started=%d succeeded=%d failed=%d\n",
+			ch.GetRef().GetChannelId(), d.GetTarget(), d.GetState().GetState(),
+			d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed())
+	}
+	return 0, nil
+}
+
+func printSocket(s *channelzpb.Socket) {
+	d := s.GetData()
+	fmt.Printf("    %s: streams started=%d succeeded=%d failed=%d, messages sent=%d received=%d\n",
+		formatAddress(s.GetRemote()), d.GetStreamsStarted(), d.GetStreamsSucceeded(), d.GetStreamsFailed(),
+		d.GetMessagesSent(), d.GetMessagesReceived())
+}
+
+func formatAddress(a *channelzpb.Address) string {
+	switch {
+	case a.GetTcpipAddress() != nil:
+		ip := net.IP(a.GetTcpipAddress().GetIpAddress())
+		return net.JoinHostPort(ip.String(), strconv.Itoa(int(a.GetTcpipAddress().GetPort())))
+	case a.GetUdsAddress() != nil:
+		return "unix:" + a.GetUdsAddress().GetFilename()
+	case a.GetOtherAddress() != nil:
+		return a.GetOtherAddress().GetName()
+	}
+	return "?"
+}
+
+// since formats how long ago ts was, "never" for an unset one.
+func since(ts *timestamppb.Timestamp) string {
+	if ts == nil || ts.AsTime().IsZero() || ts.GetSeconds() == 0 {
+		return "never"
+	}
+	return time.Since(ts.AsTime()).Round(time.Second).String() + " ago"
+}
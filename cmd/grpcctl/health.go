@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// watchRetryDelay is the pause before watch opens a new stream after the
+// previous one failed, e.g. while the server restarts.
+const watchRetryDelay = time.Second
+
+// runHealth prints the status of a service and exits with 1 unless it is
+// SERVING.
+func runHealth(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	service := serviceArg(args)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return 1, err
+	}
+
+	fmt.Printf("%s: %s\n", displayName(service), resp.GetStatus())
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// runWatch prints every status change of a service until ctx is done. A
+// broken stream is opened again, so the server may restart meanwhile.
+func runWatch(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	service := serviceArg(args)
+	client := healthpb.NewHealthClient(conn)
+
+	for {
+		err := watchOnce(ctx, client, service)
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+		if status.Code(err) == codes.Unimplemented {
+			return 1, err
+		}
+		fmt.Printf("%s %s: %v\n", time.Now().Format(time.TimeOnly), displayName(service), err)
+
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+func watchOnce(ctx context.Context, client healthpb.HealthClient, service string) error {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return status.Error(codes.Unavailable, "server closed the watch")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s: %s\n", time.Now().Format(time.TimeOnly), displayName(service), resp.GetStatus())
+	}
+}
+
+func serviceArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+// displayName names the empty service, which stands for the whole server.
+func displayName(service string) string {
+	if service == "" {
+		return "(server)"
+	}
+	return service
+}
@@ -0,0 +1,127 @@
+// grpcctl is a small operations tool for the course servers, or any gRPC
+// server that exposes the standard health, reflection and channelz
+// services.
+//
+//	go run ./cmd/grpcctl -addr localhost:5001 health
+//	go run ./cmd/grpcctl -addr localhost:5001 health api.v2.EchoAPI
+//	go run ./cmd/grpcctl -addr localhost:5001 watch readiness
+//	go run ./cmd/grpcctl -addr localhost:5001 list-services -methods
+//	go run ./cmd/grpcctl -addr localhost:5001 reflection
+//	go run ./cmd/grpcctl -addr localhost:5001 channelz
+//	go run ./cmd/grpcctl -addr localhost:5001 schema -o schema api.v2.EchoAPI
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+)
+
+// command is one subcommand; it returns the exit code of the tool.
+type command struct {
+	usage string
+	run   func(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error)
+}
+
+var commands = map[string]command{
+	"health": {
+		usage: "health [service]           check the health of a service, the whole server by default",
+		run:   runHealth,
+	},
+	"watch": {
+		usage: "watch [service]            follow the health of a service until interrupted",
+		run:   runWatch,
+	},
+	"list-services": {
+		usage: "list-services [-methods]   list the services via reflection",
+		run:   runListServices,
+	},
+	"reflection": {
+		usage: "reflection                 report which reflection API versions the server answers",
+		run:   runReflection,
+	},
+	"channelz": {
+		usage: "channelz                   summarize the servers, sockets and channels",
+		run:   runChannelz,
+	},
+	"schema": {
+		usage: "schema [-format proto|json|binary] [-o out] [-include-imports] [service...]\n" +
+			"                             dump the descriptors of the services via reflection",
+		run: runSchema,
+	},
+}
+
+var commandOrder = []string{"health", "watch", "list-services", "reflection", "channelz", "schema"}
+
+func main() {
+	addr := flag.String("addr", "localhost:5001", "server address")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	var tlsOpts tlsconfig.ClientOptions
+	flag.StringVar(&tlsOpts.CAFile, "tls-ca", "", "CA bundle used to verify the server, e.g. of self-signed lab certificates, implies -tls")
+	flag.StringVar(&tlsOpts.CertFile, "tls-cert", "", "client certificate for mTLS, implies -tls")
+	flag.StringVar(&tlsOpts.KeyFile, "tls-key", "", "client private key for mTLS")
+	flag.StringVar(&tlsOpts.ServerName, "tls-server-name", "", "name the server certificate must be issued for instead of the host of -addr, implies -tls")
+	flag.BoolVar(&tlsOpts.InsecureSkipVerify, "tls-insecure-skip-verify", false, "accept any server certificate, for labs only, implies -tls")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of every call except watch")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: grpcctl [flags] <command> [args]\n\ncommands:\n")
+		for _, name := range commandOrder {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS || tlsOpts.Enabled() {
+		tlsCfg, err := tlsconfig.Client(tlsOpts)
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+		creds = credentials.NewTLS(tlsCfg)
+		if tlsOpts.InsecureSkipVerify {
+			log.Printf("WARNING: the server certificate is not verified")
+		}
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if flag.Arg(0) != "watch" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	code, err := cmd.run(ctx, conn, flag.Args()[1:])
+	if err != nil {
+		log.Printf("%s: %v", flag.Arg(0), err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	conn.Close()
+	os.Exit(code)
+}
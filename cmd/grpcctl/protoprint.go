@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxFieldNumber is the largest valid field number, "max" in ranges.
+const maxFieldNumber = 1<<29 - 1
+
+// printer writes a file descriptor back as .proto source. Reflection does
+// not carry comments, and the declarations come out grouped by kind rather
+// than in their original order, but the result compiles to the same
+// descriptors.
+type printer struct {
+	sb     *strings.Builder
+	fd     protoreflect.FileDescriptor
+	types  *dynamicpb.Types
+	indent int
+}
+
+func newPrinter(sb *strings.Builder, fd protoreflect.FileDescriptor, types *dynamicpb.Types) *printer {
+	return &printer{sb: sb, fd: fd, types: types}
+}
+
+func (p *printer) line(format string, args ...any) {
+	if format != "" {
+		p.sb.WriteString(strings.Repeat("  ", p.indent))
+	}
+	fmt.Fprintf(p.sb, format, args...)
+	p.sb.WriteByte('\n')
+}
+
+func (p *printer) file() {
+	switch p.fd.Syntax() {
+	case protoreflect.Proto2:
+		p.line(`syntax = "proto2";`)
+	case protoreflect.Proto3:
+		p.line(`syntax = "proto3";`)
+	case protoreflect.Editions:
+		edition := protodesc.ToFileDescriptorProto(p.fd).GetEdition().String()
+		p.line(`edition = %q;`, strings.TrimPrefix(edition, "EDITION_"))
+	}
+	p.line("")
+
+	if p.fd.Package() != "" {
+		p.line("package %s;", p.fd.Package())
+		p.line("")
+	}
+
+	imports := p.fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		switch {
+		case imp.IsPublic:
+			p.line("import public %q;", imp.Path())
+		case imp.IsWeak:
+			p.line("import weak %q;", imp.Path())
+		default:
+			p.line("import %q;", imp.Path())
+		}
+	}
+	if imports.Len() > 0 {
+		p.line("")
+	}
+
+	if p.optionStatements(p.fd.Options()) {
+		p.line("")
+	}
+
+	for i := 0; i < p.fd.Enums().Len(); i++ {
+		p.enum(p.fd.Enums().Get(i))
+		p.line("")
+	}
+	for i := 0; i < p.fd.Messages().Len(); i++ {
+		p.message(p.fd.Messages().Get(i))
+		p.line("")
+	}
+	if p.extensions(p.fd.Extensions()) {
+		p.line("")
+	}
+	for i := 0; i < p.fd.Services().Len(); i++ {
+		p.service(p.fd.Services().Get(i))
+		p.line("")
+	}
+}
+
+func (p *printer) message(md protoreflect.MessageDescriptor) {
+	p.line("message %s {", md.Name())
+	p.indent++
+
+	p.optionStatements(md.Options())
+
+	fields := md.Fields()
+	printed := make(map[protoreflect.OneofDescriptor]bool)
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		oneof := f.ContainingOneof()
+		if oneof == nil || oneof.IsSynthetic() {
+			p.field(f)
+			continue
+		}
+		// a oneof is printed in place of its first field
+		if printed[oneof] {
+			continue
+		}
+		printed[oneof] = true
+
+		p.line("oneof %s {", oneof.Name())
+		p.indent++
+		p.optionStatements(oneof.Options())
+		for j := 0; j < oneof.Fields().Len(); j++ {
+			p.field(oneof.Fields().Get(j))
+		}
+		p.indent--
+		p.line("}")
+	}
+
+	for i := 0; i < md.Enums().Len(); i++ {
+		p.enum(md.Enums().Get(i))
+	}
+	for i := 0; i < md.Messages().Len(); i++ {
+		if nested := md.Messages().Get(i); !nested.IsMapEntry() {
+			p.message(nested)
+		}
+	}
+	p.extensions(md.Extensions())
+
+	for i := 0; i < md.ExtensionRanges().Len(); i++ {
+		r := md.ExtensionRanges().Get(i)
+		opts := p.options(md.ExtensionRangeOptions(i))
+		p.line("extensions %s%s;", numberRange(int64(r[0]), int64(r[1])-1, maxFieldNumber), inlineOptions(opts))
+	}
+
+	var reserved []string
+	for i := 0; i < md.ReservedRanges().Len(); i++ {
+		r := md.ReservedRanges().Get(i)
+		reserved = append(reserved, numberRange(int64(r[0]), int64(r[1])-1, maxFieldNumber))
+	}
+	if len(reserved) > 0 {
+		p.line("reserved %s;", strings.Join(reserved, ", "))
+	}
+	if names := reservedNames(md.ReservedNames()); names != "" {
+		p.line("reserved %s;", names)
+	}
+
+	p.indent--
+	p.line("}")
+}
+
+func (p *printer) field(f protoreflect.FieldDescriptor) {
+	var label string
+	switch {
+	case f.IsMap():
+	case f.Cardinality() == protoreflect.Repeated:
+		label = "repeated "
+	case p.fd.Syntax() == protoreflect.Editions:
+	case f.Cardinality() == protoreflect.Required:
+		label = "required "
+	case f.ContainingOneof() != nil && !f.ContainingOneof().IsSynthetic():
+	case p.fd.Syntax() == protoreflect.Proto2 || f.HasOptionalKeyword():
+		label = "optional "
+	}
+
+	opts := p.options(f.Options())
+	if f.HasDefault() {
+		opts = append([]string{"default = " + p.scalar(f, f.Default())}, opts...)
+	}
+	if !f.IsExtension() && f.HasJSONName() && f.JSONName() != defaultJSONName(f.Name()) {
+		opts = append([]string{"json_name = " + strconv.Quote(f.JSONName())}, opts...)
+	}
+
+	p.line("%s%s %s = %d%s;", label, p.fieldType(f), f.Name(), f.Number(), inlineOptions(opts))
+}
+
+func (p *printer) fieldType(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", p.fieldType(f.MapKey()), p.fieldType(f.MapValue()))
+	}
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return p.typeName(f.Message().FullName())
+	case protoreflect.EnumKind:
+		return p.typeName(f.Enum().FullName())
+	}
+	return f.Kind().String()
+}
+
+// typeName shortens names of the own package, other names stay fully
+// qualified.
+func (p *printer) typeName(name protoreflect.FullName) string {
+	if pkg := p.fd.Package(); pkg != "" && strings.HasPrefix(string(name), string(pkg)+".") {
+		return strings.TrimPrefix(string(name), string(pkg)+".")
+	}
+	return string(name)
+}
+
+func (p *printer) enum(ed protoreflect.EnumDescriptor) {
+	p.line("enum %s {", ed.Name())
+	p.indent++
+
+	p.optionStatements(ed.Options())
+	for i := 0; i < ed.Values().Len(); i++ {
+		v := ed.Values().Get(i)
+		p.line("%s = %d%s;", v.Name(), v.Number(), inlineOptions(p.options(v.Options())))
+	}
+
+	var reserved []string
+	for i := 0; i < ed.ReservedRanges().Len(); i++ {
+		r := ed.ReservedRanges().Get(i)
+		reserved = append(reserved, numberRange(int64(r[0]), int64(r[1]), math.MaxInt32))
+	}
+	if len(reserved) > 0 {
+		p.line("reserved %s;", strings.Join(reserved, ", "))
+	}
+	if names := reservedNames(ed.ReservedNames()); names != "" {
+		p.line("reserved %s;", names)
+	}
+
+	p.indent--
+	p.line("}")
+}
+
+// extensions prints extend blocks grouped by the extended message and
+// reports whether there were any.
+func (p *printer) extensions(exts protoreflect.ExtensionDescriptors) bool {
+	var extendees []protoreflect.FullName
+	byExtendee := make(map[protoreflect.FullName][]protoreflect.FieldDescriptor)
+	for i := 0; i < exts.Len(); i++ {
+		x := exts.Get(i)
+		name := x.ContainingMessage().FullName()
+		if _, ok := byExtendee[name]; !ok {
+			extendees = append(extendees, name)
+		}
+		byExtendee[name] = append(byExtendee[name], x)
+	}
+
+	for _, name := range extendees {
+		p.line("extend %s {", p.typeName(name))
+		p.indent++
+		for _, x := range byExtendee[name] {
+			p.field(x)
+		}
+		p.indent--
+		p.line("}")
+	}
+	return len(extendees) > 0
+}
+
+func (p *printer) service(sd protoreflect.ServiceDescriptor) {
+	p.line("service %s {", sd.Name())
+	p.indent++
+
+	p.optionStatements(sd.Options())
+	for i := 0; i < sd.Methods().Len(); i++ {
+		m := sd.Methods().Get(i)
+		sig := fmt.Sprintf("rpc %s(%s%s) returns (%s%s)", m.Name(),
+			streamPrefix(m.IsStreamingClient()), p.typeName(m.Input().FullName()),
+			streamPrefix(m.IsStreamingServer()), p.typeName(m.Output().FullName()))
+
+		opts := p.options(m.Options())
+		if len(opts) == 0 {
+			p.line("%s;", sig)
+			continue
+		}
+		p.line("%s {", sig)
+		p.indent++
+		for _, o := range opts {
+			p.line("option %s;", o)
+		}
+		p.indent--
+		p.line("}")
+	}
+
+	p.indent--
+	p.line("}")
+}
+
+// optionStatements prints "option x = y;" lines and reports whether there
+// were any.
+func (p *printer) optionStatements(opts proto.Message) bool {
+	list := p.options(opts)
+	for _, o := range list {
+		p.line("option %s;", o)
+	}
+	return len(list) > 0
+}
+
+// options formats the set options as "name = value", extensions as
+// "(full.name) = value".
+func (p *printer) options(opts proto.Message) []string {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil
+	}
+
+	// the options were decoded without the extension types of the server,
+	// decode them again so the extensions are known fields
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	m := opts.ProtoReflect().New().Interface()
+	if err := (proto.UnmarshalOptions{Resolver: p.types}).Unmarshal(b, m); err != nil {
+		return nil
+	}
+
+	var out []string
+	m.ProtoReflect().Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(f.Name())
+		if f.IsExtension() {
+			name = "(" + string(f.FullName()) + ")"
+		}
+		if f.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				out = append(out, name+" = "+p.value(f, v.List().Get(i)))
+			}
+			return true
+		}
+		out = append(out, name+" = "+p.value(f, v))
+		return true
+	})
+	slices.Sort(out)
+	return out
+}
+
+func (p *printer) value(f protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if f.Kind() == protoreflect.MessageKind || f.Kind() == protoreflect.GroupKind {
+		text := prototext.MarshalOptions{Resolver: p.types}.Format(v.Message().Interface())
+		return "{" + strings.Join(strings.Fields(text), " ") + "}"
+	}
+	return p.scalar(f, v)
+}
+
+func (p *printer) scalar(f protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch f.Kind() {
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return strconv.Quote(string(v.Bytes()))
+	case protoreflect.EnumKind:
+		if ev := f.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	}
+	return v.String()
+}
+
+func inlineOptions(opts []string) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(opts, ", ") + "]"
+}
+
+// numberRange formats an inclusive range of field or enum numbers.
+func numberRange(start, end, max int64) string {
+	if start == end {
+		return strconv.FormatInt(start, 10)
+	}
+	if end >= max {
+		return fmt.Sprintf("%d to max", start)
+	}
+	return fmt.Sprintf("%d to %d", start, end)
+}
+
+func reservedNames(names protoreflect.Names) string {
+	quoted := make([]string, names.Len())
+	for i := range quoted {
+		quoted[i] = strconv.Quote(string(names.Get(i)))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// defaultJSONName is the JSON name protoc derives from a field name.
+func defaultJSONName(name protoreflect.Name) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range string(name) {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/easyp-tech/course-grpc/internal/reflection"
+)
+
+// runListServices prints the services registered on the server, with
+// -methods also their methods and streaming kinds.
+func runListServices(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("list-services", flag.ContinueOnError)
+	methods := fs.Bool("methods", false, "print the methods of every service")
+	if err := fs.Parse(args); err != nil {
+		return 2, nil
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return 1, err
+	}
+	defer stream.CloseSend()
+
+	resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return 1, err
+	}
+
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	slices.Sort(services)
+
+	for _, name := range services {
+		fmt.Println(name)
+		if !*methods {
+			continue
+		}
+
+		svc, err := describeService(stream, name)
+		if err != nil {
+			fmt.Printf("  (%v)\n", err)
+			continue
+		}
+		for _, m := range svc.GetMethod() {
+			fmt.Printf("  %s(%s%s) returns (%s%s)\n", m.GetName(),
+				streamPrefix(m.GetClientStreaming()), m.GetInputType()[1:],
+				streamPrefix(m.GetServerStreaming()), m.GetOutputType()[1:])
+		}
+	}
+	return 0, nil
+}
+
+// reflect sends one request and returns its response, reflection errors
+// included.
+func reflect(
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient, req *reflectionpb.ServerReflectionRequest,
+) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", e.GetErrorCode(), e.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+// describeService finds the descriptor of the full service name in the file
+// that defines it.
+func describeService(
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient, name string,
+) (*descriptorpb.ServiceDescriptorProto, error) {
+	resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the response holds the file and its dependencies
+	for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		var fd descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(raw, &fd); err != nil {
+			return nil, err
+		}
+		for _, svc := range fd.GetService() {
+			full := svc.GetName()
+			if fd.GetPackage() != "" {
+				full = fd.GetPackage() + "." + full
+			}
+			if full == name {
+				return svc, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no descriptor for %s", name)
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+// runReflection prints which reflection API versions the server answers and
+// exits with 1 if it answers none. Older grpcurl builds need v1alpha.
+func runReflection(ctx context.Context, conn *grpc.ClientConn, _ []string) (int, error) {
+	supports := reflection.Check(ctx, conn)
+	for _, s := range supports {
+		switch {
+		case s.Err != nil:
+			fmt.Printf("%-8s %s: %v\n", s.Version, s.Service, s.Err)
+		case s.Supported:
+			fmt.Printf("%-8s %s: supported, %d services\n", s.Version, s.Service, s.Services)
+		default:
+			fmt.Printf("%-8s %s: not supported\n", s.Version, s.Service)
+		}
+	}
+	if !reflection.Any(supports) {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// runSchema writes the descriptors of the services exposed by the server,
+// by default of all of them, as .proto source, JSON or a binary descriptor
+// set usable by protoc and buf.
+func runSchema(ctx context.Context, conn *grpc.ClientConn, args []string) (int, error) {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	format := fs.String("format", "proto", "output format: proto, json or binary")
+	out := fs.String("o", "", "output file, a directory for -format proto; stdout if empty")
+	imports := fs.Bool("include-imports", false, "also write the imported files, like google/protobuf/*.proto")
+	if err := fs.Parse(args); err != nil {
+		return 2, nil
+	}
+	if *format == "binary" && *out == "" {
+		return 2, fmt.Errorf("-format binary needs -o")
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return 1, err
+	}
+	defer stream.CloseSend()
+
+	services := fs.Args()
+	if len(services) == 0 {
+		if services, err = listServices(stream); err != nil {
+			return 1, err
+		}
+	}
+	set, roots, err := fetchFiles(stream, services)
+	if err != nil {
+		return 1, err
+	}
+	// the imports are still needed to resolve the types of the .proto output
+	written := set
+	if !*imports {
+		written = &descriptorpb.FileDescriptorSet{}
+		for _, fd := range set.File {
+			if slices.Contains(roots, fd.GetName()) {
+				written.File = append(written.File, fd)
+			}
+		}
+	}
+
+	switch *format {
+	case "binary":
+		b, err := proto.Marshal(written)
+		if err != nil {
+			return 1, err
+		}
+		return 0, os.WriteFile(*out, b, 0o644)
+	case "json":
+		b, err := protojson.MarshalOptions{Multiline: true}.Marshal(written)
+		if err != nil {
+			return 1, err
+		}
+		return 0, writeOutput(*out, append(b, '\n'))
+	case "proto":
+		return 0, writeProtoFiles(set, written, *out)
+	}
+	return 2, fmt.Errorf("unknown format %q", *format)
+}
+
+// listServices returns the names of all services the server lists.
+func listServices(stream reflectionpb.ServerReflection_ServerReflectionInfoClient) ([]string, error) {
+	resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	slices.Sort(services)
+	return services, nil
+}
+
+// fetchFiles collects the files that define the services and everything
+// they import, ordered so that every file comes after its dependencies.
+// roots are the names of the files defining the services.
+func fetchFiles(
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient, services []string,
+) (*descriptorpb.FileDescriptorSet, []string, error) {
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	add := func(resp *reflectionpb.ServerReflectionResponse) (string, error) {
+		var first string
+		for i, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, fd); err != nil {
+				return "", err
+			}
+			files[fd.GetName()] = fd
+			// the requested file comes first, its dependencies follow
+			if i == 0 {
+				first = fd.GetName()
+			}
+		}
+		return first, nil
+	}
+
+	var roots []string
+	for _, s := range services {
+		resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: s},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", s, err)
+		}
+		name, err := add(resp)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the server sends every file once per stream, a service of an
+		// already sent file gets an empty response
+		if name == "" {
+			name = fileOfService(files, s)
+		}
+		if name != "" && !slices.Contains(roots, name) {
+			roots = append(roots, name)
+		}
+	}
+
+	// fetch dependencies the server did not send along
+	for missing := missingDeps(files); len(missing) > 0; missing = missingDeps(files) {
+		for _, name := range missing {
+			resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if _, err := add(resp); err != nil {
+				return nil, nil, err
+			}
+			if files[name] == nil {
+				return nil, nil, fmt.Errorf("server did not send %s", name)
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, dep := range files[name].GetDependency() {
+			visit(dep)
+		}
+		set.File = append(set.File, files[name])
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		visit(name)
+	}
+	return set, roots, nil
+}
+
+func missingDeps(files map[string]*descriptorpb.FileDescriptorProto) []string {
+	var missing []string
+	for _, fd := range files {
+		for _, dep := range fd.GetDependency() {
+			if files[dep] == nil && !slices.Contains(missing, dep) {
+				missing = append(missing, dep)
+			}
+		}
+	}
+	return missing
+}
+
+func fileOfService(files map[string]*descriptorpb.FileDescriptorProto, service string) string {
+	for name, fd := range files {
+		for _, svc := range fd.GetService() {
+			if fd.GetPackage()+"."+svc.GetName() == service || svc.GetName() == service {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// writeProtoFiles prints the written files of the complete set as .proto
+// source: into their paths below dir, or one after another to stdout.
+func writeProtoFiles(set, written *descriptorpb.FileDescriptorSet, dir string) error {
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return fmt.Errorf("resolve descriptors: %w", err)
+	}
+	// options are decoded again with these types, so extensions like the
+	// validation rules are printed by name
+	types := dynamicpb.NewTypes(registry)
+
+	for _, fdp := range written.File {
+		fd, err := registry.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return err
+		}
+
+		var sb strings.Builder
+		newPrinter(&sb, fd, types).file()
+		source := strings.TrimRight(sb.String(), "\n") + "\n"
+
+		if dir == "" {
+			fmt.Printf("// %s\n\n%s\n", fd.Path(), source)
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(fd.Path()))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "wrote", path)
+	}
+	return nil
+}
+
+func writeOutput(path string, data []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/mirror"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+)
+
+// frame is one message passed through as it is, without decoding it.
+type frame struct {
+	payload []byte
+}
+
+// rawCodec hands the bytes of a message over instead of decoding them, so
+// the proxy forwards any service without its descriptors. It keeps the
+// name of the proto codec: the content-type the backend sees does not
+// change.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) (mem.BufferSlice, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "proxy: can not marshal %T", v)
+	}
+	return mem.BufferSlice{mem.SliceBuffer(f.payload)}, nil
+}
+
+func (rawCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return status.Errorf(codes.Internal, "proxy: can not unmarshal into %T", v)
+	}
+	f.payload = data.Materialize()
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// proxy forwards every call to the backend of its route.
+type proxy struct {
+	routes *routingConfig
+	conns  map[string]*grpc.ClientConn
+	// mirror copies a share of the calls to a shadow backend, nil for none
+	mirror *mirror.Mirror
+}
+
+// handle is the grpc.UnknownServiceHandler of the proxy server. Every call
+// is forwarded as a bidi stream, which covers the other kinds as well. The
+// deadline and cancellation of the call go to the backend with the context.
+// A mirrored call sends its requests to the shadow backend as well, and the
+// end of the shadow is compared with the end of the call.
+func (p *proxy) handle(_ any, ss grpc.ServerStream) (err error) {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "proxy: no method in the stream")
+	}
+	r, backend := p.routes.match(method)
+
+	in, _ := metadata.FromIncomingContext(ss.Context())
+	clientPeer, _ := peer.FromContext(ss.Context())
+	out := p.routes.rewrite(in, clientPeer, r)
+	log.Printf("%s=%s [ROUTE] %s -> %s", requestid.LogKey, out.Get(requestid.Key)[0], method, backend)
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ss.Context(), out))
+	defer cancel()
+	shadow := p.mirror.NewStream(ctx, method, func() any { return &frame{} }, grpc.ForceCodecV2(rawCodec{}))
+	defer func() { shadow.Finish(err) }()
+	cs, err := p.conns[backend].NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method,
+		grpc.ForceCodecV2(rawCodec{}))
+	if err != nil {
+		return err
+	}
+
+	// requests go to the backend in the background; a failure to send shows
+	// up as the status of the backend stream below
+	go func() {
+		for {
+			f := &frame{}
+			if err := ss.RecvMsg(f); err != nil {
+				if errors.Is(err, io.EOF) {
+					_ = cs.CloseSend()
+				} else {
+					cancel()
+				}
+				shadow.CloseSend()
+				return
+			}
+			shadow.Send(f)
+			if err := cs.SendMsg(f); err != nil {
+				return
+			}
+		}
+	}()
+
+	headerSent := false
+	for {
+		f := &frame{}
+		err := cs.RecvMsg(f)
+		if !headerSent {
+			// the header of the backend arrives with its first message or
+			// with its status
+			if md, herr := cs.Header(); herr == nil {
+				if err := ss.SetHeader(md); err != nil {
+					return err
+				}
+			}
+			headerSent = true
+		}
+		if err != nil {
+			ss.SetTrailer(cs.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := ss.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
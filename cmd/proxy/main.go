@@ -0,0 +1,90 @@
+// proxy is a transparent gRPC proxy in front of the course servers. It
+// forwards every call without knowing its service: the routing table sends
+// the calls of a method or a whole service to a backend, the rest to the
+// default one, and rewrites the metadata on the way. The proxy strips the
+// hop-by-hop headers, appends the client address to x-forwarded-for and adds
+// an x-request-id when the call has none. With -mirror-target a share of the
+// calls is copied to a shadow backend as well.
+//
+//	go run ./cmd/proxy -listen :8443
+//	go run ./cmd/proxy -listen :8443 -routes my-routes.yaml
+//	go run ./cmd/proxy -listen :8443 -mirror-target localhost:5002 -mirror-percent 50
+//	go run ./cmd/client -target localhost:8443
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	_ "github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/mirror"
+)
+
+// shutdownTimeout bounds how long calls in flight may take after Ctrl+C.
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	listen := flag.String("listen", ":8443", "address the proxy listens on")
+	routesPath := flag.String("routes", "", "YAML routing table (see cmd/proxy/routes.yaml), empty for the built-in one")
+	mirrorTarget := flag.String("mirror-target", "", "shadow backend, e.g. one with a new API version, that gets a copy of a share of the calls; its responses are discarded, its failures and codes unlike the primary ones are logged; empty for none")
+	mirrorPercent := flag.Float64("mirror-percent", 10, "percentage of the calls copied to -mirror-target")
+	mirrorTimeout := flag.Duration("mirror-timeout", 30*time.Second, "timeout of a shadow call, independent of the deadline of the call it copies")
+	flag.Parse()
+	if *mirrorPercent < 0 || *mirrorPercent > 100 {
+		log.Fatalf("-mirror-percent must be between 0 and 100, got %v", *mirrorPercent)
+	}
+
+	routes, err := loadRoutingConfig(*routesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &proxy{routes: routes, conns: make(map[string]*grpc.ClientConn)}
+	for _, backend := range routes.backends() {
+		conn, err := grpc.NewClient(backend, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("Failed to create client for %s: %v", backend, err)
+		}
+		defer conn.Close()
+		p.conns[backend] = conn
+	}
+	for _, r := range routes.Routes {
+		target := r.Method
+		if target == "" {
+			target = r.Service
+		}
+		log.Printf("Route %s -> %s", target, r.Backend)
+	}
+	log.Printf("Route * -> %s", routes.Default)
+	if *mirrorTarget != "" {
+		conn, err := grpc.NewClient(*mirrorTarget, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("Failed to create client for %s: %v", *mirrorTarget, err)
+		}
+		defer conn.Close()
+		p.mirror = mirror.New(conn, *mirrorPercent, *mirrorTimeout)
+		log.Printf("Mirror %v%% -> %s", *mirrorPercent, *mirrorTarget)
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(
+		grpc.UnknownServiceHandler(p.handle),
+		grpc.ForceServerCodecV2(rawCodec{}),
+	)
+
+	g := graceful.New(shutdownTimeout)
+	g.AddGRPCServer("proxy", s, lis)
+	if err := g.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+)
+
+// defaultRoutes is the routing table used without -routes.
+//
+//go:embed routes.yaml
+var defaultRoutes []byte
+
+// forwardedForKey lists the client and the proxies a call passed, the
+// original client first.
+const forwardedForKey = "x-forwarded-for"
+
+// hopByHop are the headers that describe one connection and must not be
+// forwarded (RFC 9110, section 7.6.1). gRPC drops most of them itself; the
+// proxy strips them for clients that send them as metadata anyway.
+var hopByHop = []string{
+	"connection", "keep-alive", "proxy-connection", "proxy-authenticate",
+	"proxy-authorization", "te", "trailer", "transfer-encoding", "upgrade",
+}
+
+// routingConfig is the routing table, see routes.yaml.
+type routingConfig struct {
+	Default string        `yaml:"default"`
+	Routes  []route       `yaml:"routes"`
+	Headers headersConfig `yaml:"headers"`
+}
+
+// route sends the calls of one method or of a whole service to a backend.
+type route struct {
+	// Method is a full method name, /api.v2.EchoAPI/Echo.
+	Method string `yaml:"method"`
+	// Service is a full service name, api.v2.EchoAPI.
+	Service string `yaml:"service"`
+	Backend string `yaml:"backend"`
+	// Set is metadata set on the calls of this route, after headers.set.
+	Set map[string]string `yaml:"set"`
+}
+
+type headersConfig struct {
+	Set   map[string]string `yaml:"set"`
+	Strip []string          `yaml:"strip"`
+}
+
+// loadRoutingConfig reads the table from path, the built-in one for an
+// empty path.
+func loadRoutingConfig(path string) (*routingConfig, error) {
+	data := defaultRoutes
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read routes: %w", err)
+		}
+	}
+
+	var cfg routingConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse routes: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("routes: %w", err)
+	}
+	return &cfg, nil
+}
+
+func (c *routingConfig) validate() error {
+	if c.Default == "" {
+		return fmt.Errorf("default backend is required")
+	}
+	for i, r := range c.Routes {
+		switch {
+		case (r.Method == "") == (r.Service == ""):
+			return fmt.Errorf("route %d: set either method or service", i)
+		case r.Method != "" && (!strings.HasPrefix(r.Method, "/") || strings.Count(r.Method, "/") != 2):
+			return fmt.Errorf("route %d: %q is not a full method name like /api.v2.EchoAPI/Echo", i, r.Method)
+		case strings.Contains(r.Service, "/"):
+			return fmt.Errorf("route %d: %q is not a service name like api.v2.EchoAPI", i, r.Service)
+		case r.Backend == "":
+			return fmt.Errorf("route %d: backend is required", i)
+		}
+		if err := validKeys(r.Set); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+	}
+	if err := validKeys(c.Headers.Set); err != nil {
+		return fmt.Errorf("headers: %w", err)
+	}
+	return nil
+}
+
+// validKeys rejects metadata gRPC does not let a call set.
+func validKeys(set map[string]string) error {
+	for key := range set {
+		if key != strings.ToLower(key) || strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, ":") {
+			return fmt.Errorf("can not set metadata key %q: keys are lowercase and grpc- and : are reserved", key)
+		}
+	}
+	return nil
+}
+
+// match returns the route of method and its backend; a nil route stands
+// for the default backend.
+func (c *routingConfig) match(method string) (*route, string) {
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if r.Method == method || r.Service == service {
+			return r, r.Backend
+		}
+	}
+	return nil, c.Default
+}
+
+// backends returns every backend of the table once.
+func (c *routingConfig) backends() []string {
+	seen := map[string]bool{c.Default: true}
+	backends := []string{c.Default}
+	for _, r := range c.Routes {
+		if !seen[r.Backend] {
+			seen[r.Backend] = true
+			backends = append(backends, r.Backend)
+		}
+	}
+	return backends
+}
+
+// rewrite turns the incoming metadata of a call on r into the metadata
+// sent to the backend.
+func (c *routingConfig) rewrite(in metadata.MD, p *peer.Peer, r *route) metadata.MD {
+	out := in.Copy()
+
+	// headers named in connection are hop-by-hop as well
+	for _, v := range out.Get("connection") {
+		for _, name := range strings.Split(v, ",") {
+			out.Delete(strings.TrimSpace(name))
+		}
+	}
+	for _, key := range hopByHop {
+		out.Delete(key)
+	}
+	for _, key := range c.Headers.Strip {
+		out.Delete(key)
+	}
+
+	if p != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		chain := append(out.Get(forwardedForKey), host)
+		out.Set(forwardedForKey, strings.Join(chain, ", "))
+	}
+	if len(out.Get(requestid.Key)) == 0 {
+		out.Set(requestid.Key, uuid.NewString())
+	}
+
+	for key, value := range c.Headers.Set {
+		out.Set(key, value)
+	}
+	if r != nil {
+		for key, value := range r.Set {
+			out.Set(key, value)
+		}
+	}
+	return out
+}
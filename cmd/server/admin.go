@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/easyp-tech/course-grpc/internal/maintenance"
+	"github.com/easyp-tech/course-grpc/internal/tenant"
+	adminpb "github.com/easyp-tech/course-grpc/pkg/api/admin/v1"
+)
+
+// adminServer - служебное API сервера, доступ к нему проверяет adminGuard.
+type adminServer struct {
+	adminpb.UnimplementedAdminAPIServer
+
+	maintenance *maintenance.Mode
+	tenants     *tenant.Registry
+}
+
+func (s *adminServer) SetMaintenance(ctx context.Context, req *adminpb.SetMaintenanceRequest) (*adminpb.MaintenanceStatus, error) {
+	return toMaintenanceStatus(s.maintenance.Set(ctx, req.GetEnabled(), req.GetReason())), nil
+}
+
+func (s *adminServer) GetMaintenance(ctx context.Context, req *adminpb.GetMaintenanceRequest) (*adminpb.MaintenanceStatus, error) {
+	return toMaintenanceStatus(s.maintenance.Status()), nil
+}
+
+func (s *adminServer) ListTenants(ctx context.Context, req *adminpb.ListTenantsRequest) (*adminpb.ListTenantsResponse, error) {
+	resp := &adminpb.ListTenantsResponse{}
+	for _, st := range s.tenants.Stats(req.GetPrefix()) {
+		resp.Tenants = append(resp.Tenants, &adminpb.TenantStats{
+			Tenant:        st.Tenant,
+			Calls:         st.Calls,
+			Failed:        st.Failed,
+			Throttled:     st.Throttled,
+			QuotaExceeded: st.QuotaExceeded,
+			InFlight:      uint32(st.InFlight),
+			LastCall:      timestamppb.New(st.LastCall),
+		})
+	}
+	return resp, nil
+}
+
+func toMaintenanceStatus(st maintenance.Status) *adminpb.MaintenanceStatus {
+	return &adminpb.MaintenanceStatus{
+		Enabled:  st.Enabled,
+		Reason:   st.Reason,
+		Since:    timestamppb.New(st.Since),
+		InFlight: uint32(st.InFlight),
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/grpc"
+
+	"github.com/easyp-tech/course-grpc/internal/auth"
+	"github.com/easyp-tech/course-grpc/internal/faults"
+	"github.com/easyp-tech/course-grpc/pkg/server"
+)
+
+// defaultInterceptors - набор интерсепторов, с которым сервер запускается
+// без флага -interceptors
+//
+//go:embed interceptors.yaml
+var defaultInterceptors []byte
+
+// interceptorConfig задает, какие интерсепторы включены и в каком порядке
+// они вызываются, см. interceptors.yaml
+type interceptorConfig struct {
+	Unary       []string          `yaml:"unary"`
+	Stream      []string          `yaml:"stream"`
+	RateLimit   rateLimitConfig   `yaml:"ratelimit"`
+	Faults      faultsConfig      `yaml:"faults"`
+	Cache       cacheConfig       `yaml:"cache"`
+	Admission   admissionConfig   `yaml:"admission"`
+	Idempotency idempotencyConfig `yaml:"idempotency"`
+	Quota       quotaConfig       `yaml:"quota"`
+	RequiredMD  requiredMDConfig  `yaml:"requiredmd"`
+	Lifetime    lifetimeConfig    `yaml:"lifetime"`
+	Auth        authConfig        `yaml:"auth"`
+}
+
+type rateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type cacheConfig struct {
+	TTL  time.Duration `yaml:"ttl"`
+	Size int           `yaml:"size"`
+}
+
+type idempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// quotaConfig - сколько вызовов каждого метода из limits пользователь может
+// сделать за window
+type quotaConfig struct {
+	Window time.Duration  `yaml:"window"`
+	Limits map[string]int `yaml:"limits"`
+}
+
+// requiredMDConfig - ключи метаданных, без которых requiredmd отклоняет вызов
+type requiredMDConfig struct {
+	Keys []string `yaml:"keys"`
+}
+
+// lifetimeConfig - сколько живут server и bidi стримы методов из methods,
+// остальных - default; 0 не ограничивает
+type lifetimeConfig struct {
+	Default time.Duration            `yaml:"default"`
+	Methods map[string]time.Duration `yaml:"methods"`
+}
+
+// authConfig - кто делает вызов (provider: api_key, jwt или mtls) и какие
+// роли нужны для сервисов и методов из rules; правила одни для всех
+// провайдеров
+type authConfig struct {
+	Provider string              `yaml:"provider"`
+	APIKey   apiKeyConfig        `yaml:"api_key"`
+	JWT      jwtConfig           `yaml:"jwt"`
+	MTLS     mtlsConfig          `yaml:"mtls"`
+	Rules    map[string][]string `yaml:"rules"`
+}
+
+// apiKeyConfig - ключи помимо -admin-token: субъект -> переменная окружения
+// с ключом, роли и tenant
+type apiKeyConfig struct {
+	Keys map[string]apiKeyEntry `yaml:"keys"`
+}
+
+type apiKeyEntry struct {
+	Env   string   `yaml:"env"`
+	Roles []string `yaml:"roles"`
+	// Tenant - tenant всех вызовов с этим ключом, пустой - без tenant
+	Tenant string `yaml:"tenant"`
+}
+
+// jwtConfig - что должно быть в claims iss и aud; пустое значение не
+// проверяется. Секрет подписи - флаг -jwt-secret
+type jwtConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+// mtlsConfig - роли по common name сертификата клиента
+type mtlsConfig struct {
+	Roles map[string][]string `yaml:"roles"`
+}
+
+// Провайдеры личности для auth.
+const (
+	providerAPIKey = "api_key"
+	providerJWT    = "jwt"
+	providerMTLS   = "mtls"
+)
+
+// adminRole - роль, которой открыт AdminAPI, если rules не говорят иного
+const adminRole = "admin"
+
+type admissionConfig struct {
+	MaxInFlight  int           `yaml:"max_in_flight"`
+	Queue        int           `yaml:"queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	RetryDelay   time.Duration `yaml:"retry_delay"`
+}
+
+type faultsConfig struct {
+	Delay     time.Duration `yaml:"delay"`
+	ErrorRate float64       `yaml:"error_rate"`
+	Code      string        `yaml:"code"`
+}
+
+// loadInterceptorConfig читает конфигурацию из path, для пустого пути -
+// встроенную
+func loadInterceptorConfig(path string) (*interceptorConfig, error) {
+	data := defaultInterceptors
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read interceptors config: %w", err)
+		}
+	}
+
+	var cfg interceptorConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse interceptors config: %w", err)
+	}
+	if cfg.RateLimit.Rate <= 0 || cfg.RateLimit.Burst <= 0 {
+		if slices.Contains(cfg.Unary, "ratelimit") || slices.Contains(cfg.Stream, "ratelimit") {
+			return nil, fmt.Errorf("ratelimit: rate and burst must be positive")
+		}
+	}
+	if (cfg.Cache.TTL <= 0 || cfg.Cache.Size <= 0) && slices.Contains(cfg.Unary, "cache") {
+		return nil, fmt.Errorf("cache: ttl and size must be positive")
+	}
+	if cfg.Idempotency.TTL <= 0 && slices.Contains(cfg.Unary, "idempotency") {
+		return nil, fmt.Errorf("idempotency: ttl must be positive")
+	}
+	if (cfg.Admission.MaxInFlight <= 0 || cfg.Admission.Queue < 0) && slices.Contains(cfg.Unary, "admission") {
+		return nil, fmt.Errorf("admission: max_in_flight must be positive and queue not negative")
+	}
+	if slices.Contains(cfg.Unary, "quota") || slices.Contains(cfg.Stream, "quota") {
+		if err := cfg.Quota.validate(); err != nil {
+			return nil, fmt.Errorf("quota: %w", err)
+		}
+	}
+	if slices.Contains(cfg.Unary, "requiredmd") || slices.Contains(cfg.Stream, "requiredmd") {
+		if err := cfg.RequiredMD.validate(); err != nil {
+			return nil, fmt.Errorf("requiredmd: %w", err)
+		}
+	}
+	if slices.Contains(cfg.Unary, "auth") || slices.Contains(cfg.Stream, "auth") {
+		if err := cfg.Auth.validate(); err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	}
+	if slices.Contains(cfg.Stream, "lifetime") {
+		if err := cfg.Lifetime.validate(); err != nil {
+			return nil, fmt.Errorf("lifetime: %w", err)
+		}
+	}
+	return &cfg, nil
+}
+
+func (c authConfig) validate() error {
+	switch c.Provider {
+	case "", providerAPIKey, providerJWT, providerMTLS:
+	default:
+		return fmt.Errorf("unknown provider %q, want %s, %s or %s", c.Provider, providerAPIKey, providerJWT, providerMTLS)
+	}
+	for name, roles := range c.Rules {
+		if strings.HasPrefix(name, "/") && strings.Count(name, "/") != 2 {
+			return fmt.Errorf("%q is neither a service like api.admin.v1.AdminAPI nor a full method name like /api.v2.EchoAPI/CancelOrder", name)
+		}
+		if len(roles) == 0 {
+			return fmt.Errorf("rule of %s lists no roles", name)
+		}
+	}
+	for subject, key := range c.APIKey.Keys {
+		if key.Env == "" {
+			return fmt.Errorf("api_key: key of %s names no env variable", subject)
+		}
+	}
+	return nil
+}
+
+// identityProvider создает провайдер из секции auth. С api_key ключ
+// adminToken дает субъект admin с ролью admin; jwtSecret подписывает токены
+// провайдера jwt; clientCerts - сервер проверяет сертификаты клиентов
+// (-tls-cert и -tls-client-ca), без этого mtls не узнает ни одного клиента
+func (c authConfig) identityProvider(adminToken, jwtSecret string, clientCerts bool) (auth.IdentityProvider, error) {
+	switch c.Provider {
+	case providerJWT:
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("auth: provider jwt needs -jwt-secret or $JWT_SECRET")
+		}
+		return auth.NewJWT([]byte(jwtSecret), c.JWT.Issuer, c.JWT.Audience), nil
+	case providerMTLS:
+		if !clientCerts {
+			return nil, fmt.Errorf("auth: provider mtls needs -tls-cert, -tls-key and -tls-client-ca")
+		}
+		return auth.NewClientCert(c.MTLS.Roles), nil
+	}
+	keys := map[string]auth.Identity{
+		adminToken: {Subject: adminRole, Roles: []string{adminRole}},
+	}
+	for subject, key := range c.APIKey.Keys {
+		keys[os.Getenv(key.Env)] = auth.Identity{Subject: subject, Roles: key.Roles, Tenant: key.Tenant}
+	}
+	return auth.NewAPIKeys(keys), nil
+}
+
+// rules - правила из секции auth; AdminAPI без своего правила открыт роли
+// admin
+func (c authConfig) rules(adminService string) auth.Rules {
+	rules := auth.Rules{}
+	for name, roles := range c.Rules {
+		rules[name] = roles
+	}
+	if _, ok := rules[adminService]; !ok {
+		rules[adminService] = []string{adminRole}
+	}
+	return rules
+}
+
+func (c lifetimeConfig) validate() error {
+	if c.Default < 0 {
+		return fmt.Errorf("default must not be negative")
+	}
+	for method, d := range c.Methods {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return fmt.Errorf("%q is not a full method name like /api.v2.EchoAPI/SyncOrders", method)
+		}
+		if d < 0 {
+			return fmt.Errorf("duration of %s must not be negative", method)
+		}
+	}
+	return nil
+}
+
+func (c requiredMDConfig) validate() error {
+	if len(c.Keys) == 0 {
+		return fmt.Errorf("keys must not be empty")
+	}
+	for _, key := range c.Keys {
+		if key == "" || key != strings.ToLower(key) {
+			return fmt.Errorf("key %q must be non-empty and lowercase, as gRPC sends it", key)
+		}
+	}
+	return nil
+}
+
+func (c quotaConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	for method, limit := range c.Limits {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return fmt.Errorf("%q is not a full method name like /api.v2.EchoAPI/CreateOrders", method)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("limit of %s must be positive", method)
+		}
+	}
+	return nil
+}
+
+// faults переводит секцию faults в параметры интерсептора
+func (c *interceptorConfig) faults() (faults.Config, error) {
+	cfg := faults.Config{Delay: c.Faults.Delay, ErrorRate: c.Faults.ErrorRate}
+	if c.Faults.Code == "" {
+		return cfg, nil
+	}
+	code, err := faults.ParseCode(c.Faults.Code)
+	if err != nil {
+		return cfg, fmt.Errorf("faults: %w", err)
+	}
+	cfg.Code = code
+	return cfg, nil
+}
+
+// interceptorSet - все интерсепторы, которые можно включить в конфигурации,
+// по именам
+type interceptorSet struct {
+	unary  map[string]grpc.UnaryServerInterceptor
+	stream map[string]grpc.StreamServerInterceptor
+}
+
+// options собирает цепочки интерсепторов в порядке из cfg.
+// Неизвестное имя или повтор - ошибка, чтобы опечатка в конфигурации не
+// выключала интерсептор молча.
+func (s interceptorSet) options(cfg *interceptorConfig) ([]server.Option, error) {
+	unary, err := pick("unary", s.unary, cfg.Unary)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := pick("stream", s.stream, cfg.Stream)
+	if err != nil {
+		return nil, err
+	}
+	opts := make([]server.Option, 0, len(unary)+len(stream))
+	for _, interceptor := range unary {
+		opts = append(opts, server.WithInterceptor(interceptor, nil))
+	}
+	for _, interceptor := range stream {
+		opts = append(opts, server.WithInterceptor(nil, interceptor))
+	}
+	return opts, nil
+}
+
+func pick[T any](kind string, available map[string]T, names []string) ([]T, error) {
+	chain := make([]T, 0, len(names))
+	for i, name := range names {
+		interceptor, ok := available[name]
+		if !ok {
+			known := make([]string, 0, len(available))
+			for k := range available {
+				known = append(known, k)
+			}
+			slices.Sort(known)
+			return nil, fmt.Errorf("unknown %s interceptor %q, available: %s", kind, name, strings.Join(known, ", "))
+		}
+		if slices.Contains(names[:i], name) {
+			return nil, fmt.Errorf("%s interceptor %q is listed twice", kind, name)
+		}
+		chain = append(chain, interceptor)
+	}
+	return chain, nil
+}
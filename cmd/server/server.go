@@ -1,96 +1,118 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
+	"slices"
+	"strings"
 	"time"
 
 	"buf.build/go/protovalidate"
 	protovalidate_middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/protovalidate"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
-	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
-
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionpbalpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+
+	"github.com/easyp-tech/course-grpc/internal/admission"
+	"github.com/easyp-tech/course-grpc/internal/auth"
+	"github.com/easyp-tech/course-grpc/internal/backpressure"
+	"github.com/easyp-tech/course-grpc/internal/binlog"
+	"github.com/easyp-tech/course-grpc/internal/cache"
+	"github.com/easyp-tech/course-grpc/internal/callctx"
+	"github.com/easyp-tech/course-grpc/internal/chain"
+	"github.com/easyp-tech/course-grpc/internal/clientmeta"
+	"github.com/easyp-tech/course-grpc/internal/connlimit"
+	// регистрируем gzip и zstd компрессоры, чтобы принимать сжатые запросы
+	_ "github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/connstate"
+	"github.com/easyp-tech/course-grpc/internal/depcheck"
+	"github.com/easyp-tech/course-grpc/internal/deprecation"
+	"github.com/easyp-tech/course-grpc/internal/echoapi"
+	"github.com/easyp-tech/course-grpc/internal/echostream"
+	"github.com/easyp-tech/course-grpc/internal/encryption"
+	"github.com/easyp-tech/course-grpc/internal/farewell"
+	"github.com/easyp-tech/course-grpc/internal/faults"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/handoff"
+	"github.com/easyp-tech/course-grpc/internal/idempotency"
+	"github.com/easyp-tech/course-grpc/internal/journal"
+	"github.com/easyp-tech/course-grpc/internal/lifetime"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/logsample"
+	"github.com/easyp-tech/course-grpc/internal/maintenance"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/internal/msgsize"
+	"github.com/easyp-tech/course-grpc/internal/msgtrace"
+	"github.com/easyp-tech/course-grpc/internal/orders"
+	"github.com/easyp-tech/course-grpc/internal/panics"
+	"github.com/easyp-tech/course-grpc/internal/peerinfo"
+	"github.com/easyp-tech/course-grpc/internal/probes"
+	"github.com/easyp-tech/course-grpc/internal/problem"
+	"github.com/easyp-tech/course-grpc/internal/proxyproto"
+	"github.com/easyp-tech/course-grpc/internal/quota"
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+	"github.com/easyp-tech/course-grpc/internal/reflection"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/requiredmd"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	"github.com/easyp-tech/course-grpc/internal/shutdownreport"
+	"github.com/easyp-tech/course-grpc/internal/signing"
+	"github.com/easyp-tech/course-grpc/internal/sockopt"
+	"github.com/easyp-tech/course-grpc/internal/ssebridge"
+	"github.com/easyp-tech/course-grpc/internal/tenant"
+	"github.com/easyp-tech/course-grpc/internal/timeouts"
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	"github.com/easyp-tech/course-grpc/internal/wiresize"
+	"github.com/easyp-tech/course-grpc/internal/wsbridge"
+	adminpb "github.com/easyp-tech/course-grpc/pkg/api/admin/v1"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
 	pb "github.com/easyp-tech/course-grpc/pkg/api/v1"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/server"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
 )
 
 const (
+	// значения флагов -keepalive-* по умолчанию
 	keepaliveTime    = 50 * time.Second
 	keepaliveTimeout = 10 * time.Second
 	keepaliveMinTime = 30 * time.Second
-)
-
-type usecases interface {
-	CreateOrder(ctx context.Context, productID string, count int) error
-}
-
-type server struct {
-	pb.UnimplementedEchoAPIServer
-
-	usecases usecases
-}
-
-func (s *server) HelloWorld(ctx context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
-	if err := protovalidate.Validate(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
-	} else {
-		log.Printf("Validation OK")
-	}
-
-	log.Printf("Request: %s", req.GetMessage())
-	return &pb.EchoResponse{Message: "pong"}, nil
-}
-
-func (s *server) CreateOrder(ctx context.Context, req *pb.CreateOrdersRequest) (*pb.CreateOrderResponse, error) {
-	for _, createOrder := range req.GetCreateOrder() {
-		if err := s.usecases.CreateOrder(ctx, createOrder.ProductId, int(createOrder.Count)); err != nil {
-			st := status.New(codes.FailedPrecondition, "Custom error")
-			errMsg := &pb.CustomError{Reason: err.Error()}
-
-			var err error
-			// дополняем ее деталями: которые содержат структуру сообщения из proto файла.
-			st, err = st.WithDetails(errMsg)
-			if err != nil {
-				return nil, err
-			}
 
-			return nil, st.Err()
-		}
-	}
-
-	return &pb.CreateOrderResponse{}, nil
-}
-
-func (s *server) WithError(ctx context.Context, in *pb.EchoRequest) (*pb.EchoResponse, error) {
-	// формируем кастомную ошибку
-	st := status.New(codes.FailedPrecondition, "Custom error")
-	errMsg := &pb.CustomError{Reason: "some reason"}
-
-	var err error
-	// дополняем ее деталями: которые содержат структуру сообщения из proto файла.
-	st, err = st.WithDetails(errMsg)
-	if err != nil {
-		return nil, err
-	}
-
-	return nil, st.Err()
-}
+	// сколько ждем после перевода readiness в NOT_SERVING, чтобы балансировщики
+	// успели убрать сервер из ротации
+	drainDelay = 2 * time.Second
+	// общий лимит на остановку всех компонентов, включая drain
+	shutdownTimeout = 15 * time.Second
+
+	// через сколько клиентам советуем повторить вызов в режиме обслуживания
+	maintenanceRetryDelay = 5 * time.Second
+	// через сколько советуем переподключиться стримам, закрытым при остановке
+	farewellRetryDelay = time.Second
+	// сколько ждем ответа одной проверки зависимости
+	dependencyCheckTimeout = 2 * time.Second
+)
 
 func interceptorLog(
 	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
 ) (interface{}, error) {
-	log.Printf("[INTERCEPTOR LOG] Request: %s", info.FullMethod)
+	logctx.Logger(ctx).Printf("[INTERCEPTOR LOG] Request: %s", info.FullMethod)
 
 	resp, err := handler(ctx, req)
 	return resp, err
@@ -108,93 +130,552 @@ func interceptorStat(
 	// Вычисляыем время выполенния
 	duration := time.Since(start)
 	if err != nil {
-		log.Printf("[INTERCEPTOR STAT] %s failed after %v: %v", info.FullMethod, duration, err)
+		logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] %s failed after %v: %v", info.FullMethod, duration, err)
 	} else {
-		log.Printf("[INTERCEPTOR STAT] %s completed in %v", info.FullMethod, duration)
+		logctx.Logger(ctx).Printf("[INTERCEPTOR STAT] %s completed in %v", info.FullMethod, duration)
 	}
 
 	return resp, err
 }
 
 func main() {
-	l, err := net.Listen("tcp", ":5001")
+	metricsAddr := flag.String("metrics-addr", ":9001", "адрес эндпоинта /metrics для Prometheus, пустая строка отключает его")
+	requireClientMeta := flag.Bool("require-client-meta", false, "отклонять вызовы без заголовка client-timestamp")
+	bridgeAddr := flag.String("bridge-addr", ":5080", "адрес REST gateway и WebSocket и SSE мостов для серверных стримов, пустая строка отключает их")
+	journalPath := flag.String("journal", "", "файл журнала сообщений для EchoReplay, пустая строка - журнал только в памяти")
+	backpressureThreshold := flag.Float64("backpressure-threshold", backpressure.DefaultThreshold, "доля емкости очереди стрима, выше которой сервер предупреждает в логе, если очередь держится дольше -backpressure-hold; 0 - только метрики")
+	backpressureHold := flag.Duration("backpressure-hold", backpressure.DefaultHold, "сколько очередь стрима должна быть заполнена выше порога, чтобы сервер предупредил")
+	heartbeat := flag.Duration("heartbeat", 5*time.Second, "как долго EchoReplay ждет новых сообщений журнала, прежде чем отправить heartbeat, 0 - без heartbeat")
+	sessionTTL := flag.Duration("session-ttl", 5*time.Minute, "сколько хранить сессию EchoBidirectionalStreamReliable после обрыва стрима, чтобы клиент вернулся в нее по токену; 0 - без сессий")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 32, "сколько соединений держим открытыми с одного IP, 0 - без ограничения")
+	maxConns := flag.Int("max-conns", 1024, "сколько соединений держим открытыми всего, 0 - без ограничения")
+	reflectionMode := flag.String("reflection", reflection.Both, "версии API рефлексии: both (v1 и v1alpha), v1, v1alpha или off")
+	certFile := flag.String("tls-cert", "", "сертификат сервера, включает TLS; с ним же сервер подключается по TLS к себе (мосты), звеньям ChainedEcho, BroadcastEcho и -depends-on")
+	keyFile := flag.String("tls-key", "", "закрытый ключ сервера")
+	clientCAFile := flag.String("tls-client-ca", "", "CA для сертификатов клиентов, включает mTLS; нужен для auth с provider: mtls")
+	caFile := flag.String("tls-ca", "", "с -tls-cert: CA для проверки сертификатов серверов, которые вызывает этот сервер, вместо системных")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "ждать PROXY protocol заголовок (v1 или v2) от nginx/HAProxy на каждом соединении")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "токен для AdminAPI (по умолчанию из $ADMIN_TOKEN), пустой - AdminAPI закрыт")
+	reportPath := flag.String("shutdown-report", shutdownreport.Stdout, `куда при завершении записать отчет в JSON: время работы, число вызовов по методам и кодам, сколько стримов закрыто при остановке и сколько оборвано, длительность этапов остановки; "-" - stdout, пустая строка - не писать`)
+	binlogPath := flag.String("binlog", "", "файл бинарного лога gRPC (читается cmd/binlogcat), пустая строка отключает его")
+	traceSpans := flag.Bool("trace-spans", false, "писать в лог OpenTelemetry спаны стримов с событиями сообщений (интерсептор msgtrace)")
+	// строки лога на каждое сообщение стримов, например -log-sample '*=100:20'
+	// оставляет одно сообщение из 100 и не больше 20 строк в секунду
+	logSampling := logsample.Flag{}
+	// опции сокетов слушателя: Nagle, SO_REUSEPORT и TCP keepalive, который
+	// находит пропавших клиентов ниже HTTP/2 пингов grpc
+	sockOpts := sockopt.Default
+	flag.BoolVar(&sockOpts.NoDelay, "tcp-nodelay", true, "TCP_NODELAY на принятых соединениях, false включает алгоритм Нейгла")
+	flag.BoolVar(&sockOpts.ReusePort, "reuseport", false, "SO_REUSEPORT на слушающем сокете: несколько процессов на одном порту")
+	flag.DurationVar(&sockOpts.KeepAlive, "tcp-keepalive", 0, "простой соединения до первой TCP keepalive пробы и интервал проб, 0 - 15s по умолчанию Go, отрицательное значение отключает пробы")
+	flag.IntVar(&sockOpts.KeepAliveCount, "tcp-keepalive-count", 0, "сколько проб без ответа закрывают соединение, 0 - по умолчанию ОС")
+	flag.Var(logSampling, "log-sample", "выборка строк лога на каждое сообщение стрима: handler=every[:per_second], '*' - все обработчики, можно повторять")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "секрет HS256 подписи JWT (по умолчанию из $JWT_SECRET) для auth с provider: jwt")
+	signingKey := flag.String("signing-key", os.Getenv("SIGNING_KEY"), "общий ключ HMAC подписи запросов (по умолчанию из $SIGNING_KEY) для интерсептора signing")
+	encryptionKey := flag.String("encryption-key", os.Getenv("ENCRYPTION_KEY"), "общий ключ AES-GCM шифрования сообщений (по умолчанию из $ENCRYPTION_KEY), пустой - шифрование недоступно")
+	// keepalive: сервер пингует клиента после keepalive-time тишины и закрывает
+	// соединение без ответа за keepalive-timeout; клиента, который пингует чаще
+	// keepalive-min-time, сервер отключает с GOAWAY ENHANCE_YOUR_CALM
+	var kaParams keepalive.ServerParameters
+	var kaPolicy keepalive.EnforcementPolicy
+	flag.DurationVar(&kaParams.Time, "keepalive-time", keepaliveTime, "через сколько тишины на соединении сервер шлет keepalive пинг")
+	flag.DurationVar(&kaParams.Timeout, "keepalive-timeout", keepaliveTimeout, "сколько ждем ответа на пинг, прежде чем закрыть соединение")
+	flag.DurationVar(&kaParams.MaxConnectionIdle, "max-connection-idle", 0, "закрывать соединения без вызовов через это время (GOAWAY), 0 - никогда")
+	flag.DurationVar(&kaParams.MaxConnectionAge, "max-connection-age", 0, "закрывать соединения старше этого времени (GOAWAY), 0 - никогда")
+	flag.DurationVar(&kaParams.MaxConnectionAgeGrace, "max-connection-age-grace", 0, "сколько ждем завершения вызовов после max-connection-age, 0 - сколько угодно")
+	flag.DurationVar(&kaPolicy.MinTime, "keepalive-min-time", keepaliveMinTime, "как часто клиенту можно пинговать сервер")
+	flag.BoolVar(&kaPolicy.PermitWithoutStream, "keepalive-permit-without-stream", true, "разрешать пинги на соединениях без активных вызовов")
+	// лимиты размера сообщений: интерсептор msgsize отклоняет сообщения больше
+	// них с ResourceExhausted и ErrorInfo
+	var sizeLimits msgsize.Limits
+	flag.IntVar(&sizeLimits.MaxRecv, "max-recv-msg-size", msgsize.DefaultMax, "наибольший размер принимаемого сообщения в байтах, 0 - лимит gRPC по умолчанию")
+	flag.IntVar(&sizeLimits.MaxSend, "max-send-msg-size", msgsize.DefaultMax, "наибольший размер отправляемого сообщения в байтах, 0 - без лимита")
+	wireSoftLimit := flag.Int("wire-soft-limit", wiresize.DefaultSoftLimit, "предупреждать о сообщениях больше стольких байт до сжатия, задолго до лимитов размера; 0 - не предупреждать")
+	chainTarget := flag.String("chain-target", "localhost:5001", "следующее звено ChainedEcho, по умолчанию этот же сервер")
+	chainForward := flag.String("chain-forward", strings.Join(chain.DefaultAllow, ","), "ключи метаданных через запятую, которые ChainedEcho передает следующему звену")
+	chainReserve := flag.Duration("chain-reserve", 20*time.Millisecond, "сколько дедлайна звено ChainedEcho оставляет себе, вызывая следующее")
+	broadcastTargets := flag.String("broadcast-targets", "localhost:5001,localhost:5002", "серверы через запятую, Echo которых вызывает BroadcastEcho; по умолчанию этот же сервер и незапущенный, чтобы показать частичный отказ")
+	dependsOn := flag.String("depends-on", "", "адреса gRPC серверов через запятую, без которых сервер не готов: их health должен быть SERVING")
+	startupTimeout := flag.Duration("startup-timeout", 30*time.Second, "сколько при запуске ждем доступности зависимостей, прежде чем завершиться с ошибкой")
+	dependencyCheckEvery := flag.Duration("dependency-check-every", 5*time.Second, "как часто проверяем зависимости после запуска")
+	interceptorsPath := flag.String("interceptors", "", "YAML с набором и порядком интерсепторов (см. cmd/server/interceptors.yaml), пустая строка - набор по умолчанию")
+	flag.Parse()
+	runReport := shutdownreport.New("server")
+
+	interceptors, err := loadInterceptorConfig(*interceptorsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	faultsConfig, err := interceptors.faults()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// по SIGUSR2 сервер запускает свою новую копию и передает ей слушающие
+	// сокеты; копия, запущенная так, берет их вместо новых
+	restart, err := handoff.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tcpListener, err := restart.Listen("grpc", func() (net.Listener, error) {
+		return sockOpts.ListenConfig().Listen(context.Background(), "tcp", ":5001")
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Сокеты: %s", sockOpts)
+	// за прокси адрес клиента приходит в PROXY protocol заголовке, его и
+	// видит peer.FromContext
+	var base net.Listener = sockOpts.Wrap(tcpListener)
+	if *proxyProtocol {
+		base = proxyproto.NewListener(base)
+	}
+	// лишние соединения закрываются сразу при accept, счетчики - в метриках
+	l := connlimit.NewListener(base, *maxConnsPerIP, *maxConns)
+
+	// с -tls-cert сервер принимает только TLS, а с -tls-client-ca еще и
+	// требует сертификат клиента (mTLS). Свои вызовы - мосты, ChainedEcho,
+	// BroadcastEcho, проверки -depends-on - он тогда тоже делает по TLS и
+	// предъявляет свой сертификат
+	serverCreds, dialCreds := insecure.NewCredentials(), insecure.NewCredentials()
+	if *certFile != "" {
+		serverTLS, err := tlsconfig.Server(*certFile, *keyFile, *clientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+		dialTLS, err := tlsconfig.Client(tlsconfig.ClientOptions{CAFile: *caFile, CertFile: *certFile, KeyFile: *keyFile})
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+		serverCreds, dialCreds = credentials.NewTLS(serverTLS), credentials.NewTLS(dialTLS)
+		log.Printf("TLS enabled (mTLS: %t)", *clientCAFile != "")
+	}
+
 	// создание валидатора
 	validator, err := protovalidate.New()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// инитим интерсептор
-	interceptorValidator := protovalidate_middleware.UnaryServerInterceptor(validator)
-
-	// Создание gRPC сервера с параметрами
-	s := grpc.NewServer(
-		grpc.Creds(insecure.NewCredentials()),
-		grpc.KeepaliveParams(
-			keepalive.ServerParameters{ //nolint:exhaustruct
-				Time:    keepaliveTime,
-				Timeout: keepaliveTimeout,
-			},
-		),
-		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
-			MinTime:             keepaliveMinTime,
-			PermitWithoutStream: true,
-		}),
-		// Создаем интерсепторы
-		grpc.ChainUnaryInterceptor(
-			interceptorStat,
-			interceptorLog,
-			interceptorValidator,
-		),
-	)
-
-	// Регистрируем наш обработчик
-	pb.RegisterEchoAPIServer(s, &server{usecases: &Usecases{}})
+	// устаревшие версии API и их замены
+	deprecatedServices := deprecation.Services{
+		pb.EchoAPI_ServiceDesc.ServiceName: pbv2.EchoAPI_ServiceDesc.ServiceName,
+	}
 
 	// Создаем healthcheck
 	healthServer := health.NewServer()
-	// Регистрируем healthcheck
-	healthpb.RegisterHealthServer(s, healthServer)
-
-	// Выставляем статус хелсчека
-	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
-
-	// Подключаем рефлексию для возможности использовать grpcurl и прочие утилиты для запросов
-	reflection.Register(s)
+	// liveness сразу SERVING, readiness и статусы сервисов - только когда сервер
+	// готов принимать трафик
+	serverProbes := probes.New(healthServer,
+		"",
+		pb.EchoAPI_ServiceDesc.ServiceName,
+		pbv2.EchoAPI_ServiceDesc.ServiceName,
+		stream.EchoService_ServiceDesc.ServiceName,
+	)
 
-	wg := sync.WaitGroup{}
-	// запускаем сам сервер в отдельной горутина
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		log.Println("Starting server...")
+	// кто вызывает, решает провайдер из секции auth, а что ему можно - общие
+	// для всех провайдеров правила; AdminAPI по умолчанию только для admin
+	identities, err := interceptors.Auth.identityProvider(*adminToken, *jwtSecret, *certFile != "" && *clientCAFile != "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	authGuard := auth.NewGuard(identities, interceptors.Auth.rules(adminpb.AdminAPI_ServiceDesc.ServiceName))
+	log.Printf("Auth: %s identities", cmp.Or(interceptors.Auth.Provider, providerAPIKey))
+	// служебные сервисы: работают в режиме обслуживания и без подписи запросов
+	systemServices := []string{
+		adminpb.AdminAPI_ServiceDesc.ServiceName,
+		healthpb.Health_ServiceDesc.ServiceName,
+		reflectionpb.ServerReflection_ServiceDesc.ServiceName,
+		reflectionpbalpha.ServerReflection_ServiceDesc.ServiceName,
+		channelzpb.Channelz_ServiceDesc.ServiceName,
+	}
+	// в режиме обслуживания новые вызовы отклоняются, кроме служебных
+	maintenanceMode := maintenance.New(serverProbes, maintenanceRetryDelay, systemServices...)
+	// при остановке открытые server и bidi стримы закрываются с Unavailable и
+	// RetryInfo, а не обрываются по истечении shutdownTimeout
+	streamFarewell := farewell.New(farewellRetryDelay)
+	// подпись проверяется, только если signing включен в конфигурации
+	if *signingKey == "" && slices.Contains(interceptors.Unary, "signing") {
+		log.Fatal("signing interceptor needs -signing-key or $SIGNING_KEY")
+	}
+	signatures := signing.NewVerifier([]byte(*signingKey), signing.DefaultMaxSkew, systemServices...)
+	// с ключом сервер понимает зашифрованные вызовы, интерсептор encryption
+	// отклоняет все остальные
+	if *encryptionKey != "" {
+		if err := encryption.Register(*encryptionKey); err != nil {
+			log.Fatal(err)
+		}
+	} else if slices.Contains(interceptors.Unary, "encryption") || slices.Contains(interceptors.Stream, "encryption") {
+		log.Fatal("encryption interceptor needs -encryption-key or $ENCRYPTION_KEY")
+	}
+	encryptionGuard := encryption.NewGuard(systemServices...)
+
+	// идентификатор инстанса уходит клиентам в трейлерах вместе со временем обработки
+	instanceID := servertiming.InstanceID()
+	log.Printf("Instance ID: %s", instanceID)
+
+	// все интерсепторы по именам, включаются и упорядочиваются конфигурацией;
+	// лимит общий у всех вызовов tenant из учетных данных, без tenant - у
+	// каждого субъекта из auth, у остальных - у каждого IP; tenant из
+	// заголовка клиента лимит не выбирает
+	callLimiter := ratelimit.New(interceptors.RateLimit.Rate, interceptors.RateLimit.Burst).WithKey(tenant.Key)
+	// счетчики вызовов по tenant для AdminAPI и метрик
+	tenants := tenant.NewRegistry()
+	// при перегрузке вызовы ждут в очереди, а при полной очереди отклоняются
+	// с ResourceExhausted и RetryInfo; служебные сервисы не ограничиваются
+	admissionControl := admission.New(interceptors.Admission.MaxInFlight, interceptors.Admission.Queue,
+		interceptors.Admission.QueueTimeout, interceptors.Admission.RetryDelay, systemServices...)
+	// обязательные метаданные: без них вызов отклоняется до всех остальных
+	// проверок, с перечнем недостающих ключей в BadRequest
+	requiredMetadata := requiredmd.New(interceptors.RequiredMD.Keys, systemServices...)
+	// квоты пользователей: сверх квоты - ResourceExhausted с QuotaFailure и
+	// RetryInfo до конца окна
+	userQuotas := quota.New(interceptors.Quota.Window, interceptors.Quota.Limits)
+	// предельная длительность server и bidi стримов: дольше стрим
+	// закрывается с DeadlineExceeded, и клиент открывает новый
+	streamLifetime := lifetime.New(interceptors.Lifetime.Default, interceptors.Lifetime.Methods)
+	available := interceptorSet{
+		unary: map[string]grpc.UnaryServerInterceptor{
+			"tracectx":     tracectx.UnaryServerInterceptor(),
+			"requestid":    requestid.UnaryServerInterceptor(),
+			"timeouts":     timeouts.UnaryServerInterceptor(),
+			"peerinfo":     peerinfo.UnaryServerInterceptor(),
+			"callctx":      callctx.UnaryServerInterceptor(),
+			"tenants":      tenants.UnaryServerInterceptor(),
+			"requiredmd":   requiredMetadata.UnaryServerInterceptor(),
+			"deprecation":  deprecation.UnaryServerInterceptor(deprecatedServices),
+			"auth":         authGuard.UnaryServerInterceptor(),
+			"maintenance":  maintenanceMode.UnaryServerInterceptor(),
+			"msgsize":      sizeLimits.UnaryServerInterceptor(),
+			"signing":      signatures.UnaryServerInterceptor(),
+			"encryption":   encryptionGuard.UnaryServerInterceptor(),
+			"ratelimit":    callLimiter.UnaryServerInterceptor(),
+			"admission":    admissionControl.UnaryServerInterceptor(),
+			"servertiming": servertiming.UnaryServerInterceptor(instanceID),
+			"stat":         interceptorStat,
+			// паника в обработчике превращается в codes.Internal вместо падения сервера
+			"recovery":    panics.UnaryServerInterceptor(),
+			"log":         interceptorLog,
+			"faults":      faults.UnaryServerInterceptor(faultsConfig),
+			"clientmeta":  clientmeta.UnaryServerInterceptor(*requireClientMeta),
+			"idempotency": idempotency.NewKeys(interceptors.Idempotency.TTL).WithCaller(auth.Caller).UnaryServerInterceptor(),
+			"quota":       userQuotas.UnaryServerInterceptor(),
+			"cache":       cache.New(interceptors.Cache.TTL, interceptors.Cache.Size).UnaryServerInterceptor(),
+			"validation":  protovalidate_middleware.UnaryServerInterceptor(validator),
+		},
+		stream: map[string]grpc.StreamServerInterceptor{
+			"tracectx":     tracectx.StreamServerInterceptor(),
+			"requestid":    requestid.StreamServerInterceptor(),
+			"timeouts":     timeouts.StreamServerInterceptor(),
+			"msgtrace":     msgtrace.StreamServerInterceptor(),
+			"peerinfo":     peerinfo.StreamServerInterceptor(),
+			"callctx":      callctx.StreamServerInterceptor(),
+			"tenants":      tenants.StreamServerInterceptor(),
+			"requiredmd":   requiredMetadata.StreamServerInterceptor(),
+			"auth":         authGuard.StreamServerInterceptor(),
+			"maintenance":  maintenanceMode.StreamServerInterceptor(),
+			"farewell":     streamFarewell.StreamServerInterceptor(),
+			"lifetime":     streamLifetime.StreamServerInterceptor(),
+			"msgsize":      sizeLimits.StreamServerInterceptor(),
+			"encryption":   encryptionGuard.StreamServerInterceptor(),
+			"ratelimit":    callLimiter.StreamServerInterceptor(),
+			"quota":        userQuotas.StreamServerInterceptor(),
+			"servertiming": servertiming.StreamServerInterceptor(instanceID),
+			"recovery":     panics.StreamServerInterceptor(),
+			"faults":       faults.StreamServerInterceptor(faultsConfig),
+			"clientmeta":   clientmeta.StreamServerInterceptor(*requireClientMeta),
+			"validation":   protovalidate_middleware.StreamServerInterceptor(validator),
+		},
+	}
+	chains, err := available.options(interceptors)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Unary interceptors: %s", strings.Join(interceptors.Unary, ", "))
+	log.Printf("Stream interceptors: %s", strings.Join(interceptors.Stream, ", "))
+
+	// Параметры gRPC сервера
+	grpcOpts := []grpc.ServerOption{
+		grpc.Creds(serverCreds),
+		grpc.KeepaliveParams(kaParams),
+		grpc.KeepaliveEnforcementPolicy(kaPolicy),
+	}
+	log.Printf("Keepalive: ping after %v, timeout %v; clients may ping every %v (without calls: %t)",
+		kaParams.Time, kaParams.Timeout, kaPolicy.MinTime, kaPolicy.PermitWithoutStream)
+	// лимит gRPC на прием выше -max-recv-msg-size, чтобы чуть большие
+	// сообщения отклонял интерсептор msgsize с понятной ошибкой
+	grpcOpts = append(grpcOpts, sizeLimits.ServerOptions()...)
+	log.Printf("Message size limits: %s", sizeLimits)
+	// размеры сообщений на проводе по методам - в метрики, сообщения больше
+	// -wire-soft-limit - в лог
+	grpcOpts = append(grpcOpts, grpc.StatsHandler(wiresize.NewAccounting(*wireSoftLimit)))
+	// вызовы и стримы для отчета о завершении, независимо от списка интерсепторов
+	grpcOpts = append(grpcOpts, grpc.StatsHandler(runReport))
+	// бинарный лог: заголовки, сообщения и статусы всех вызовов
+	var binlogSink *binlog.FileSink
+	if *binlogPath != "" {
+		binlogSink, err = binlog.Create(*binlogPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcOpts = append(grpcOpts, grpc.StatsHandler(binlog.NewHandler(binlogSink)))
+	}
+	// спаны стримов с событиями сообщений; без провайдера msgtrace ничего не
+	// записывает
+	var spans *sdktrace.TracerProvider
+	if *traceSpans {
+		spans = msgtrace.LogSpans()
+	}
+	// Интерсепторы
+	opts := append(chains, server.WithServerOptions(grpcOpts...))
 
-		if err := s.Serve(l); err != nil {
-			log.Fatalf("start: %v", err)
+	// заказы обеих версий API хранятся вместе, в памяти
+	orderUsecases := echoapi.NewOrderUsecases(orders.NewStore())
+	// Регистрируем наш обработчик
+	opts = append(opts, server.WithService(&pb.EchoAPI_ServiceDesc, echoapi.NewV1(orderUsecases)))
+	// вторая версия API работает рядом с первой, вызовы v1 получают
+	// заголовки deprecation и warning
+	// следующее звено ChainedEcho; trace id и request id уходят ему из
+	// контекста через клиентские интерсепторы, остальные метаданные - по
+	// списку -chain-forward
+	chainForwardKeys, err := chain.ParseAllow(*chainForward)
+	if err != nil {
+		log.Fatal(err)
+	}
+	downstreamOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(dialCreds),
+		grpc.WithChainUnaryInterceptor(
+			tracectx.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			// DeadlineExceeded следующего звена - причина DeadlineExceeded
+			// этого вызова
+			timeouts.UnaryClientInterceptor(),
+		),
+	}
+	chainConn, err := grpc.NewClient(*chainTarget, downstreamOpts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chainConn.Close()
+	// серверы BroadcastEcho, по соединению на каждый; метаданные и запас
+	// дедлайна - те же, что у ChainedEcho
+	var broadcast []echoapi.BroadcastTarget
+	for _, addr := range strings.Split(*broadcastTargets, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		conn, err := grpc.NewClient(addr, downstreamOpts...)
+		if err != nil {
+			log.Fatalf("broadcast target %s: %v", addr, err)
+		}
+		defer conn.Close()
+		broadcast = append(broadcast, echoapi.BroadcastTarget{Name: addr, Client: pbv2.NewEchoAPIClient(conn)})
+	}
+	opts = append(opts, server.WithService(&pbv2.EchoAPI_ServiceDesc, echoapi.NewV2(orderUsecases, instanceID).
+		WithChain(pbv2.NewEchoAPIClient(chainConn), chainForwardKeys, *chainReserve).
+		WithBroadcast(broadcast)))
+	// Стриминговый сервис из cmd/stream работает на этом же сервере,
+	// ответы bidi стримов пишутся в журнал, из которого их отдает EchoReplay
+	messageJournal, err := journal.Open(*journalPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	samplers, err := logSampling.Samplers(echostream.Handlers...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	streamAPI := echostream.NewAPI(
+		ratelimit.New(echostream.DefaultMsgRate, echostream.DefaultMsgBurst).WithKey(tenant.Key),
+		messageJournal,
+	).WithLogSampling(samplers).
+		WithBackpressure(backpressure.New(*backpressureThreshold, *backpressureHold)).
+		WithHeartbeat(*heartbeat)
+	if *sessionTTL > 0 {
+		streamAPI.WithSessions(*sessionTTL)
+	}
+	opts = append(opts, server.WithService(&stream.EchoService_ServiceDesc, streamAPI))
+
+	// зависимости: хранилище (журнал) и серверы из -depends-on; пока хоть
+	// одна недоступна, readiness - NOT_SERVING
+	checks := []depcheck.Check{{
+		Name: "journal",
+		Ping: func(context.Context) error { return messageJournal.Ping() },
+	}}
+	for _, addr := range strings.Split(*dependsOn, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(dialCreds))
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
+		defer conn.Close()
+		checks = append(checks, depcheck.GRPCHealth(addr, conn, ""))
+	}
+	dependencies := depcheck.New(serverProbes, dependencyCheckTimeout, checks...)
+
+	opts = append(opts,
+		// Регистрируем healthcheck
+		server.WithHealth(healthServer),
+		// служебное API: режим обслуживания
+		server.WithService(&adminpb.AdminAPI_ServiceDesc, &adminServer{maintenance: maintenanceMode, tenants: tenants}),
+		// Подключаем рефлексию для возможности использовать grpcurl и прочие утилиты для запросов;
+		// старые сборки grpcurl знают только v1alpha, поэтому по умолчанию обе версии
+		server.WithReflection(*reflectionMode),
+	)
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	// ждем сигнал о завершении работы сервера
-	<-quit
-	log.Println("Shutting down server...")
+	// Создание gRPC сервера с параметрами
+	srv, err := server.New(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := srv.GRPC()
+	// channelz: статистика соединений и вызовов для cmd/grpcctl
+	channelzsvc.RegisterChannelzServiceToServer(s)
+
+	// Компоненты останавливаются в обратном порядке: сначала readiness,
+	// потом gRPC сервер дожидается текущих вызовов, последними - метрики
+	g := graceful.New(shutdownTimeout)
+	// стримы, открытые в начале остановки, отчет делит на закрытые и оборванные
+	g.OnShutdown(runReport.Shutdown)
+	// Отдаем метрики Prometheus по HTTP
+	if *metricsAddr != "" {
+		metricsListener, err := restart.Listen("metrics", func() (net.Listener, error) {
+			return net.Listen("tcp", *metricsAddr)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.AddHTTPListener("metrics", metrics.NewServer(*metricsAddr), metricsListener)
+	}
+	// журнал закрывается после сервера, когда в него уже никто не пишет
+	g.Add("journal", nil, func(context.Context) error { return messageJournal.Close() })
+	if binlogSink != nil {
+		g.Add("binary log", nil, func(context.Context) error { return binlogSink.Close() })
+	}
+	if spans != nil {
+		g.Add("trace spans", nil, spans.Shutdown)
+	}
+	g.AddGRPCServer("gRPC server", s, l)
+	// открытые стримы закрываются до GracefulStop, иначе он их дожидается
+	g.Add("stream farewell", nil, streamFarewell.Drain)
+	// мосты и gateway ходят в gRPC сервер как клиенты, поэтому останавливаются
+	// раньше него
+	if *bridgeAddr != "" {
+		conn, err := grpc.NewClient("localhost:5001",
+			grpc.WithTransportCredentials(dialCreds),
+			grpc.WithChainUnaryInterceptor(
+				tracectx.UnaryClientInterceptor(),
+				requestid.UnaryClientInterceptor(),
+			),
+			grpc.WithChainStreamInterceptor(
+				tracectx.StreamClientInterceptor(),
+				requestid.StreamClientInterceptor(),
+			),
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
 
-	// после получения сигнала останавливаем сервер
-	s.GracefulStop()
-	wg.Wait()
+		ws, sse := newStreamBridges(stream.NewEchoServiceClient(conn))
+		gw, err := newGateway(conn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/ws/", ws)
+		mux.Handle("/sse/", sse)
+		mux.Handle("/", gw)
+
+		bridgeListener, err := restart.Listen("bridges", func() (net.Listener, error) {
+			return net.Listen("tcp", *bridgeAddr)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.AddHTTPListener("stream bridges", &http.Server{Addr: *bridgeAddr, Handler: mux}, bridgeListener)
+		// открытые сокеты и SSE стримы не дают http.Server.Shutdown завершиться,
+		// их закрывают сами мосты
+		g.Add("bridged streams", nil, func(ctx context.Context) error {
+			return errors.Join(ws.Shutdown(ctx), sse.Shutdown(ctx))
+		})
+	}
+	// при запуске ждем все зависимости, потом проверяем их в фоне; недоступная
+	// при запуске зависимость останавливает сервер
+	g.AddContext("dependency checks", func(ctx context.Context) error {
+		startupCtx, cancel := context.WithTimeout(ctx, *startupTimeout)
+		defer cancel()
+		if err := dependencies.Startup(startupCtx, connstate.DefaultBackoff()); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		return dependencies.Run(ctx, *dependencyCheckEvery)
+	})
+	// после передачи сокетов новой копии этот процесс завершается как по
+	// SIGTERM: дожидается текущих вызовов и закрывает стримы
+	g.AddContext("handoff", func(ctx context.Context) error {
+		return restart.Run(ctx, *startupTimeout)
+	})
+	// сначала перестаем быть ready, потом даем время на drain
+	g.Add("readiness", nil, serverProbes.Drain(drainDelay))
+
+	log.Println("Starting server...")
+	// SERVING - как только все зависимости ответят
+	serverProbes.Ready()
+	// предыдущий процесс, если сокеты пришли от него, может завершаться
+	restart.Ready()
+	// ждем сигнал о завершении работы или падение одного из компонентов
+	runErr := g.Run(context.Background())
+	if err := runReport.Write(*reportPath, g.Phases(), runErr); err != nil {
+		log.Print(err)
+	}
+	if runErr != nil {
+		log.Fatalf("server: %v", runErr)
+	}
 }
 
-type Usecases struct {
+// newGateway отдает EchoAPI v1 и v2 по HTTP с JSON: POST /api.v2.EchoAPI/Echo.
+// Ошибки приходят в формате problem+json (RFC 7807) с деталями статуса в
+// виде полей, а accept-language и idempotency-key передаются серверу как есть,
+// чтобы работали локализация ошибок и повторы CreateOrders.
+func newGateway(conn *grpc.ClientConn) (http.Handler, error) {
+	gw := runtime.NewServeMux(
+		runtime.WithErrorHandler(problem.ErrorHandler),
+		runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+			switch strings.ToLower(key) {
+			case "accept-language", idempotency.KeyHeader:
+				return strings.ToLower(key), true
+			}
+			return runtime.DefaultHeaderMatcher(key)
+		}),
+	)
+	ctx := context.Background()
+	if err := pb.RegisterEchoAPIHandler(ctx, gw, conn); err != nil {
+		return nil, fmt.Errorf("register gateway v1: %w", err)
+	}
+	if err := pbv2.RegisterEchoAPIHandler(ctx, gw, conn); err != nil {
+		return nil, fmt.Errorf("register gateway v2: %w", err)
+	}
+	return gw, nil
 }
 
-func (u *Usecases) CreateOrder(ctx context.Context, productID string, count int) error {
-	if count > 10 {
-		return errors.New("there are more than one order")
+// newStreamBridges отдает серверные стримы тем, у кого нет gRPC: браузерам
+// через WebSocket (запрос первым JSON фреймом, ответы JSON фреймами) и любым
+// HTTP клиентам через text/event-stream
+func newStreamBridges(client stream.EchoServiceClient) (*wsbridge.Bridge, *ssebridge.Bridge) {
+	echoServerStream := func(ctx context.Context, req *stream.EchoRequest) (streams.Receiver[stream.EchoResponse], error) {
+		return client.EchoServerStream(ctx, req)
 	}
-	return nil
+
+	ws := wsbridge.New(wsbridge.DefaultLimits())
+	wsbridge.Handle(ws, "/ws/echo/server-stream", echoServerStream)
+
+	sse := ssebridge.New()
+	ssebridge.Handle(sse, "/sse/echo/server-stream", echoServerStream)
+
+	return ws, sse
 }
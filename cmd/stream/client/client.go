@@ -1,38 +1,97 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/binlog"
+	"github.com/easyp-tech/course-grpc/internal/clock"
+	"github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/connstate"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/headers"
+	"github.com/easyp-tech/course-grpc/internal/latency"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/progress"
+	"github.com/easyp-tech/course-grpc/internal/rejoin"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	"github.com/easyp-tech/course-grpc/internal/session"
+	"github.com/easyp-tech/course-grpc/internal/shutdownreport"
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	"github.com/easyp-tech/course-grpc/internal/wiresize"
 	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/chunk"
+	"github.com/easyp-tech/course-grpc/pkg/client"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
 )
 
+// shutdownTimeout bounds how long the test loops may take to finish after
+// Ctrl+C.
+const shutdownTimeout = 10 * time.Second
+
 type Client struct {
-	conn   *grpc.ClientConn
-	client stream.EchoServiceClient
+	conn     *grpc.ClientConn
+	client   stream.EchoServiceClient
+	callOpts []grpc.CallOption
+	// clock paces the messages and the test loops
+	clock clock.Clock
+
+	// uploadBatchSize and uploadFlushInterval configure the batching sender
+	// used by the client stream test.
+	uploadBatchSize     int
+	uploadFlushInterval time.Duration
+	// ackLoss is the share of first deliveries the reliable stream test does
+	// not acknowledge, which makes the server redeliver them.
+	ackLoss float64
+	// progressInterval is how often the upload tests log their progress, 0
+	// logs only the final state.
+	progressInterval time.Duration
+
+	// replayOffset is the last journal offset seen by the replay test, it
+	// resumes from the next one after reconnecting.
+	replayOffset uint64
+	// reliableSession is the session token of the last reliable stream test,
+	// the next run rejoins the session with it.
+	reliableSession string
 }
 
-func NewClient(addr string) (*Client, error) {
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewClient dials addr with the defaults of pkg/client and opts. callOpts
+// are applied to every stream the client opens.
+func NewClient(addr string, callOpts []grpc.CallOption, opts ...client.Option) (*Client, error) {
+	opts = append([]client.Option{
+		client.WithDialOptions(grpc.WithStatsHandler(wiresize.NewLogger("client"))),
+	}, opts...)
+
+	conn, err := client.Dial(addr, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	client := stream.NewEchoServiceClient(conn)
-
 	return &Client{
-		conn:   conn,
-		client: client,
+		conn:     conn,
+		client:   stream.NewEchoServiceClient(conn),
+		callOpts: callOpts,
+		clock:    clock.Real,
 	}, nil
 }
 
@@ -41,209 +100,287 @@ func (c *Client) Close() error {
 }
 
 // testClientStream tests client streaming
-func (c *Client) testClientStream(ctx context.Context, clientID int) error {
-	log.Printf("[Client-%d] Starting client stream test", clientID)
+func (c *Client) testClientStream(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	// every run is a trace and a request of its own, the ids are in all lines below
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
 
-	streamClient, err := c.client.EchoClientStream(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create client stream: %w", err)
-	}
+	logger.Printf("[Client-%d] Starting client stream test", clientID)
 
-	// Send multiple messages
-	messages := []string{
-		fmt.Sprintf("Hello from client-%d message-1", clientID),
-		fmt.Sprintf("Hello from client-%d message-2", clientID),
-		fmt.Sprintf("Hello from client-%d message-3", clientID),
+	streamClient, err := c.client.EchoClientStream(ctx, c.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client stream: %w", err)
 	}
 
-	for i, msg := range messages {
+	reqs := s.requests()
+	tracker := progress.NewTracker(len(reqs), 0, c.progressInterval, func(r progress.Report) {
+		logger.Printf("[Client-%d] Sent %s", clientID, r)
+	})
+
+	// Messages are pushed into a batching sender that writes them to the
+	// stream once uploadBatchSize are pending or uploadFlushInterval passes,
+	// the tracker counts them when they are written
+	batcher := streams.NewBatchSender[stream.EchoRequest](
+		progress.NewSender[stream.EchoRequest](streamClient, tracker), c.uploadBatchSize, c.uploadFlushInterval)
+	batcher.OnFlush(func(n int) {
+		logger.Printf("[Client-%d] Flushed batch of %d messages", clientID, n)
+	})
+
+	// Send the scenario messages
+	for i, req := range reqs {
 		select {
 		case <-ctx.Done():
-			log.Printf("[Client-%d] Context cancelled during client stream send", clientID)
-			return ctx.Err()
+			logger.Printf("[Client-%d] Context cancelled during client stream send", clientID)
+			return nil, ctx.Err()
 		default:
 		}
 
-		if err := streamClient.Send(&stream.EchoRequest{Message: msg}); err != nil {
-			return fmt.Errorf("failed to send message %d: %w", i, err)
+		logger.Printf("[Client-%d] Queued: %s", clientID, req.Message)
+		if err := batcher.Push(req); err != nil {
+			return nil, fmt.Errorf("failed to send message %d: %w", i, err)
 		}
-		log.Printf("[Client-%d] Sent: %s", clientID, msg)
-		time.Sleep(500 * time.Millisecond)
+		// a canceled ctx is reported at the top of the loop
+		_ = c.clock.Sleep(ctx, s.Pace)
+	}
+
+	// Flush the remainder
+	if err := batcher.Close(); err != nil {
+		return nil, fmt.Errorf("failed to flush messages: %w", err)
 	}
+	tracker.Done()
 
 	// Close and receive response
 	resp, err := streamClient.CloseAndRecv()
 	if err != nil {
-		return fmt.Errorf("failed to close and receive: %w", err)
+		return nil, fmt.Errorf("failed to close and receive: %w", err)
 	}
 
-	log.Printf("[Client-%d] Client stream response: %s", clientID, resp.Message)
-	return nil
+	logger.Printf("[Client-%d] Client stream response: %s", clientID, resp.Message)
+	return []*stream.EchoResponse{resp}, nil
 }
 
 // testServerStream tests server streaming
-func (c *Client) testServerStream(ctx context.Context, clientID int) error {
-	log.Printf("[Client-%d] Starting server stream test", clientID)
+func (c *Client) testServerStream(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
 
-	req := &stream.EchoRequest{
-		Message: fmt.Sprintf("Hello from client-%d for server stream", clientID),
-	}
+	logger.Printf("[Client-%d] Starting server stream test", clientID)
+
+	// the first scenario message is the request
+	req := s.requests()[0]
 
-	streamClient, err := c.client.EchoServerStream(ctx, req)
+	streamClient, err := c.client.EchoServerStream(ctx, req, c.callOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create server stream: %w", err)
+		return nil, fmt.Errorf("failed to create server stream: %w", err)
 	}
 
-	log.Printf("[Client-%d] Sent request: %s", clientID, req.Message)
+	logger.Printf("[Client-%d] Sent request: %s", clientID, req.Message)
 
 	// Receive multiple responses
+	var responses []*stream.EchoResponse
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("[Client-%d] Context cancelled during server stream receive", clientID)
-			return ctx.Err()
+			logger.Printf("[Client-%d] Context cancelled during server stream receive", clientID)
+			return nil, ctx.Err()
 		default:
 		}
 
 		resp, err := streamClient.Recv()
 		if err == io.EOF {
-			log.Printf("[Client-%d] Server stream finished", clientID)
+			logger.Printf("[Client-%d] Server stream finished", clientID)
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to receive from server stream: %w", err)
+			return nil, fmt.Errorf("failed to receive from server stream: %w", err)
 		}
 
-		log.Printf("[Client-%d] Server stream response: %s", clientID, resp.Message)
+		logger.Printf("[Client-%d] Server stream response: %s", clientID, resp.Message)
+		responses = append(responses, resp)
 	}
 
-	return nil
+	return responses, nil
 }
 
 // testBidirectionalStreamSync tests bidirectional streaming (sync)
-func (c *Client) testBidirectionalStreamSync(ctx context.Context, clientID int) error {
-	log.Printf("[Client-%d] Starting bidirectional stream sync test", clientID)
+func (c *Client) testBidirectionalStreamSync(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	logger.Printf("[Client-%d] Starting bidirectional stream sync test", clientID)
 
-	streamClient, err := c.client.EchoBidirectionalStreamSync(ctx)
+	streamClient, err := c.client.EchoBidirectionalStreamSync(ctx, c.callOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create bidirectional stream: %w", err)
+		return nil, fmt.Errorf("failed to create bidirectional stream: %w", err)
 	}
 
-	// Send messages and receive responses concurrently
-	var wg sync.WaitGroup
-	errCh := make(chan error, 2)
-
-	// Sender goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer streamClient.CloseSend()
+	// Send messages and receive responses concurrently; written by the
+	// receiver only, read once RunBidi has returned
+	var responses []*stream.EchoResponse
+	err = streams.RunBidi(ctx, streamClient,
+		func(ctx context.Context, send func(*stream.EchoRequest) error) error {
+			for i, req := range s.requests() {
+				if err := send(req); err != nil {
+					return fmt.Errorf("failed to send sync message %d: %w", i, err)
+				}
+				logger.Printf("[Client-%d] Sent sync: %s", clientID, req.Message)
+				if err := c.clock.Sleep(ctx, s.Pace); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func(resp *stream.EchoResponse) error {
+			logger.Printf("[Client-%d] Sync response: %s", clientID, resp.Message)
+			responses = append(responses, resp)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("sync stream: %w", err)
+	}
 
-		messages := []string{
-			fmt.Sprintf("Sync message 1 from client-%d", clientID),
-			fmt.Sprintf("Sync message 2 from client-%d", clientID),
-			fmt.Sprintf("Sync message 3 from client-%d", clientID),
-		}
+	logger.Printf("[Client-%d] Bidirectional sync stream finished", clientID)
+	return responses, nil
+}
 
-		for i, msg := range messages {
-			select {
-			case <-ctx.Done():
-				errCh <- ctx.Err()
-				return
-			default:
-			}
+// testBidirectionalStreamAsync tests bidirectional streaming (async)
+func (c *Client) testBidirectionalStreamAsync(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
 
-			if err := streamClient.Send(&stream.EchoRequest{Message: msg}); err != nil {
-				errCh <- fmt.Errorf("failed to send sync message %d: %w", i, err)
-				return
-			}
-			log.Printf("[Client-%d] Sent sync: %s", clientID, msg)
-			time.Sleep(1 * time.Second)
-		}
-	}()
+	logger.Printf("[Client-%d] Starting bidirectional stream async test", clientID)
 
-	// Receiver goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	streamClient, err := c.client.EchoBidirectionalStreamAsync(ctx, c.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create async bidirectional stream: %w", err)
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				errCh <- ctx.Err()
-				return
-			default:
+	var responses []*stream.EchoResponse
+	err = streams.RunBidi(ctx, streamClient,
+		func(ctx context.Context, send func(*stream.EchoRequest) error) error {
+			// The server processes messages in parallel but answers each
+			// conversation in order
+			for i, msg := range s.requests() {
+				if err := send(msg); err != nil {
+					return fmt.Errorf("failed to send async message %d: %w", i, err)
+				}
+				logger.Printf("[Client-%d] Sent async [%s]: %s", clientID, msg.ConversationId, msg.Message)
+				if err := c.clock.Sleep(ctx, s.Pace); err != nil {
+					return err
+				}
 			}
+			return nil
+		},
+		func(resp *stream.EchoResponse) error {
+			logger.Printf("[Client-%d] Async response [%s]: %s", clientID, resp.ConversationId, resp.Message)
+			responses = append(responses, resp)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("async stream: %w", err)
+	}
 
-			resp, err := streamClient.Recv()
-			if err == io.EOF {
-				log.Printf("[Client-%d] Bidirectional sync stream finished", clientID)
-				return
-			}
-			if err != nil {
-				errCh <- fmt.Errorf("failed to receive from sync stream: %w", err)
-				return
-			}
+	logger.Printf("[Client-%d] Bidirectional async stream finished", clientID)
+	return responses, nil
+}
 
-			log.Printf("[Client-%d] Sync response: %s", clientID, resp.Message)
+// testBidirectionalStreamReliable tests at-least-once delivery: every response
+// is acknowledged by its delivery id, except a random share of first
+// deliveries, which the server then sends again and the client drops as
+// duplicates. The stream is closed once every response has been acked.
+//
+// Every run presents the session token of the previous one. A run that
+// rejoins the session first gets the backlog the previous run left: the
+// responses it did not ack and the ones journaled for its conversations
+// since. They are acked but not counted as responses of this run.
+func (c *Client) testBidirectionalStreamReliable(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	logger.Printf("[Client-%d] Starting bidirectional stream reliable test", clientID)
+
+	if c.reliableSession != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, rejoin.TokenHeader, c.reliableSession)
+	}
+	streamClient, err := c.client.EchoBidirectionalStreamReliable(ctx, c.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reliable bidirectional stream: %w", err)
+	}
+	header, err := streamClient.Header()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive reliable stream header: %w", err)
+	}
+	// a server without sessions sends no token
+	var backlog int
+	if v := header.Get(rejoin.TokenHeader); len(v) > 0 {
+		if n := header.Get(rejoin.BacklogHeader); len(n) > 0 {
+			backlog, _ = strconv.Atoi(n[0])
 		}
-	}()
-
-	// Wait for completion or error
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		return nil
-	case err := <-errCh:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+		if v[0] == c.reliableSession {
+			logger.Printf("[Client-%d] Rejoined session, %d responses in backlog", clientID, backlog)
+		} else if c.reliableSession != "" {
+			logger.Printf("[Client-%d] Session expired, starting a new one", clientID)
+		}
+		c.reliableSession = v[0]
 	}
-}
 
-// testBidirectionalStreamAsync tests bidirectional streaming (async)
-func (c *Client) testBidirectionalStreamAsync(ctx context.Context, clientID int) error {
-	log.Printf("[Client-%d] Starting bidirectional stream async test", clientID)
-
-	streamClient, err := c.client.EchoBidirectionalStreamAsync(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create async bidirectional stream: %w", err)
+	reqs := s.requests()
+	total := len(reqs)
+	// first deliveries only, duplicates are dropped
+	var responses []*stream.EchoResponse
+
+	// messages and acks are sent from different goroutines, a stream allows
+	// only one Send at a time
+	var sendMu sync.Mutex
+	send := func(req *stream.EchoRequest) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return streamClient.Send(req)
 	}
 
 	var wg sync.WaitGroup
 	errCh := make(chan error, 2)
+	allAcked := make(chan struct{})
 
 	// Sender goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer streamClient.CloseSend()
-
-		messages := []string{
-			fmt.Sprintf("Async message 1 from client-%d", clientID),
-			fmt.Sprintf("Async message 2 from client-%d", clientID),
-			fmt.Sprintf("Async message 3 from client-%d", clientID),
-		}
 
-		for i, msg := range messages {
-			select {
-			case <-ctx.Done():
-				errCh <- ctx.Err()
+		for i, msg := range reqs {
+			if err := send(msg); err != nil {
+				errCh <- fmt.Errorf("failed to send reliable message %d: %w", i, err)
 				return
-			default:
 			}
-
-			if err := streamClient.Send(&stream.EchoRequest{Message: msg}); err != nil {
-				errCh <- fmt.Errorf("failed to send async message %d: %w", i, err)
+			logger.Printf("[Client-%d] Sent reliable: %s", clientID, msg.Message)
+			if err := c.clock.Sleep(ctx, s.Pace); err != nil {
+				errCh <- err
 				return
 			}
-			log.Printf("[Client-%d] Sent async: %s", clientID, msg)
-			time.Sleep(800 * time.Millisecond)
+		}
+
+		// closing earlier would leave the server no way to receive the acks
+		select {
+		case <-allAcked:
+		case <-ctx.Done():
+			errCh <- ctx.Err()
+			return
+		}
+
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		if err := streamClient.CloseSend(); err != nil {
+			errCh <- fmt.Errorf("failed to close reliable stream: %w", err)
 		}
 	}()
 
@@ -252,25 +389,48 @@ func (c *Client) testBidirectionalStreamAsync(ctx context.Context, clientID int)
 	go func() {
 		defer wg.Done()
 
-		for {
-			select {
-			case <-ctx.Done():
-				errCh <- ctx.Err()
-				return
-			default:
-			}
+		dedup := streams.NewDedup()
+		acked := make(map[uint64]bool)
 
+		for {
 			resp, err := streamClient.Recv()
 			if err == io.EOF {
-				log.Printf("[Client-%d] Bidirectional async stream finished", clientID)
+				logger.Printf("[Client-%d] Bidirectional reliable stream finished", clientID)
 				return
 			}
 			if err != nil {
-				errCh <- fmt.Errorf("failed to receive from async stream: %w", err)
+				errCh <- fmt.Errorf("failed to receive from reliable stream: %w", err)
 				return
 			}
 
-			log.Printf("[Client-%d] Async response: %s", clientID, resp.Message)
+			id := resp.GetDeliveryId()
+			duplicate := dedup.Seen(id)
+			switch {
+			case duplicate:
+				logger.Printf("[Client-%d] Duplicate delivery %d dropped", clientID, id)
+			case dedup.Len() <= backlog:
+				// the server sends the backlog before anything else
+				logger.Printf("[Client-%d] Backlog response %d: %s", clientID, id, resp.Message)
+			default:
+				logger.Printf("[Client-%d] Reliable response %d: %s", clientID, id, resp.Message)
+				responses = append(responses, resp)
+				if rand.Float64() < c.ackLoss {
+					logger.Printf("[Client-%d] Losing ack for %d", clientID, id)
+					continue
+				}
+			}
+
+			if err := send(&stream.EchoRequest{AckIds: []uint64{id}}); err != nil {
+				errCh <- fmt.Errorf("failed to ack delivery %d: %w", id, err)
+				return
+			}
+
+			if !acked[id] {
+				acked[id] = true
+				if len(acked) == total+backlog {
+					close(allAcked)
+				}
+			}
 		}
 	}()
 
@@ -283,179 +443,466 @@ func (c *Client) testBidirectionalStreamAsync(ctx context.Context, clientID int)
 
 	select {
 	case <-done:
-		return nil
+		return responses, nil
 	case err := <-errCh:
-		return err
+		return nil, err
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	}
 }
 
-func main() {
-	log.Println("Starting gRPC Echo Stream Client...")
+// testReplay subscribes to the message journal for a few seconds, then drops
+// the stream. The next run resumes after the last offset it has seen, so it
+// first catches up on everything journaled in between and then follows live.
+func (c *Client) testReplay(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
 
-	// Create client
-	client, err := NewClient("localhost:8080")
+	ctx, cancel := context.WithTimeout(ctx, s.Duration)
+	defer cancel()
+
+	from := c.replayOffset + 1
+	logger.Printf("[Client-%d] Starting replay from offset %d", clientID, from)
+
+	streamClient, err := c.client.EchoReplay(ctx, &stream.ReplayRequest{FromOffset: from}, c.callOpts...)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("failed to create replay stream: %w", err)
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Failed to close client connection: %v", err)
-		}
-	}()
 
-	// Setup signal handling
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var replayed []*stream.EchoResponse
+	for {
+		resp, err := streamClient.Recv()
+		// with -message-gap a quiet journal or a stuck server ends the
+		// stream early; the next run resumes like after any disconnect
+		if errors.Is(err, client.ErrStreamStalled) || errors.Is(err, client.ErrStreamWedged) {
+			logger.Printf("[Client-%d] %v, disconnecting after %d messages at offset %d", clientID, err, len(replayed), c.replayOffset)
+			return replayed, nil
+		}
+		if status.Code(err) == codes.DeadlineExceeded {
+			logger.Printf("[Client-%d] Disconnecting after %d messages at offset %d", clientID, len(replayed), c.replayOffset)
+			return replayed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive from replay stream: %w", err)
+		}
+		if resp.GetHeartbeat() {
+			// without -message-gap the heartbeats reach the test
+			continue
+		}
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		c.replayOffset = resp.GetOffset()
+		replayed = append(replayed, resp)
+		logger.Printf("[Client-%d] Replayed #%d: %s", clientID, resp.GetOffset(), resp.Message)
+	}
+}
 
-	go func() {
-		sig := <-sigCh
-		log.Printf("Received signal: %v, initiating graceful shutdown...", sig)
-		cancel()
-	}()
+// testPull asks EchoPull for s.Count responses and grants s.Credits at a
+// time: the server sends a batch and pauses until the client has processed
+// it, taking s.Pace for each response, and granted the next one. Once the
+// credits cover every response the client half-closes.
+func (c *Client) testPull(ctx context.Context, s StreamSpec) ([]*stream.EchoResponse, error) {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	streamClient, err := c.client.EchoPull(ctx, c.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pull stream: %w", err)
+	}
 
-	// Start all streaming methods in separate goroutines
-	var wg sync.WaitGroup
-	clientID := 1
+	message := s.requests()[0].GetMessage()
+	granted := min(s.Credits, s.Count)
+	logger.Printf("[Client-%d] Pulling %d responses, granting %d credits", clientID, s.Count, granted)
+	if err := streamClient.Send(&stream.PullRequest{Message: message, Count: uint32(s.Count), Credits: uint32(granted)}); err != nil {
+		return nil, fmt.Errorf("failed to send pull request: %w", err)
+	}
 
-	// Test Client Stream
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("[Client-%d] Client stream test cancelled", clientID)
-				return
-			default:
-			}
+	// once every response is granted, there is nothing more to send
+	closeIfGranted := func() error {
+		if granted < s.Count {
+			return nil
+		}
+		if err := streamClient.CloseSend(); err != nil {
+			return fmt.Errorf("failed to close pull stream: %w", err)
+		}
+		return nil
+	}
+	if err := closeIfGranted(); err != nil {
+		return nil, err
+	}
 
-			if err := client.testClientStream(ctx, clientID); err != nil {
-				if ctx.Err() != nil {
-					return // Context was cancelled
-				}
-				log.Printf("[Client-%d] Client stream error: %v", clientID, err)
-			}
+	var responses []*stream.EchoResponse
+	for {
+		resp, err := streamClient.Recv()
+		if err == io.EOF {
+			logger.Printf("[Client-%d] Pull stream finished", clientID)
+			return responses, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive from pull stream: %w", err)
+		}
+		responses = append(responses, resp)
+		logger.Printf("[Client-%d] Pulled: %s", clientID, resp.GetMessage())
 
-			// Wait before next iteration
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(5 * time.Second):
-			}
+		// processing the response
+		if err := streams.Pause(ctx, s.Pace); err != nil {
+			return nil, err
 		}
-	}()
+		if len(responses) < granted || granted == s.Count {
+			continue
+		}
+		more := min(s.Credits, s.Count-granted)
+		granted += more
+		logger.Printf("[Client-%d] Processed %d, granting %d more credits", clientID, len(responses), more)
+		if err := streamClient.Send(&stream.PullRequest{Credits: uint32(more)}); err != nil {
+			return nil, fmt.Errorf("failed to grant credits: %w", err)
+		}
+		if err := closeIfGranted(); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	// Test Server Stream
-	wg.Add(1)
+// testUploadFile sends s.Size random bytes in chunks and checks that the
+// server got the same checksum. It logs the progress of sending next to the
+// progress the server acks.
+func (c *Client) testUploadFile(ctx context.Context, s StreamSpec) error {
+	clientID := s.ClientID
+	ctx = tracectx.Start(ctx)
+	ctx = requestid.Start(ctx)
+	logger := logctx.Logger(ctx)
+
+	data := make([]byte, s.Size)
+	for i := range data {
+		data[i] = byte(rand.IntN(256))
+	}
+	name := fmt.Sprintf("client-%d.bin", clientID)
+	logger.Printf("[Client-%d] Uploading %s, %d bytes in chunks of %d", clientID, name, s.Size, s.ChunkSize)
+
+	// canceling ends the receiving goroutine below if sending fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streamClient, err := c.client.UploadFile(ctx, c.callOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create upload stream: %w", err)
+	}
+
+	// the server acks what it received while the chunks are still sent
+	start := c.clock.Now()
+	result := make(chan error, 1)
+	var final *stream.UploadFileResponse
 	go func() {
-		defer wg.Done()
-		clientID := 2
 		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("[Client-%d] Server stream test cancelled", clientID)
+			resp, err := streamClient.Recv()
+			if err != nil {
+				result <- fmt.Errorf("failed to receive: %w", err)
 				return
-			default:
 			}
-
-			if err := client.testServerStream(ctx, clientID); err != nil {
-				if ctx.Err() != nil {
-					return // Context was cancelled
-				}
-				log.Printf("[Client-%d] Server stream error: %v", clientID, err)
-			}
-
-			// Wait before next iteration
-			select {
-			case <-ctx.Done():
+			if resp.GetComplete() {
+				final = resp
+				result <- nil
 				return
-			case <-time.After(4 * time.Second):
 			}
+			acked := progress.Report{
+				Messages:   int(resp.GetChunks()),
+				Bytes:      resp.GetSize(),
+				TotalBytes: int64(len(data)),
+				Elapsed:    c.clock.Now().Sub(start),
+			}
+			logger.Printf("[Client-%d] Server acked %s", clientID, acked)
 		}
 	}()
 
-	// Test Bidirectional Stream Sync
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		clientID := 3
-		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("[Client-%d] Bidirectional sync test cancelled", clientID)
-				return
-			default:
-			}
+	tracker := progress.NewTracker(0, int64(len(data)), c.progressInterval, func(r progress.Report) {
+		logger.Printf("[Client-%d] Sent %s", clientID, r)
+	})
+	sender := progress.NewSender[stream.FileChunk](streamClient, tracker)
 
-			if err := client.testBidirectionalStreamSync(ctx, clientID); err != nil {
-				if ctx.Err() != nil {
-					return // Context was cancelled
-				}
-				log.Printf("[Client-%d] Bidirectional sync error: %v", clientID, err)
-			}
+	var sum []byte
+	r := chunk.NewReader(bytes.NewReader(data), s.ChunkSize, int64(len(data)))
+	for {
+		ch, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chunk: %w", err)
+		}
 
-			// Wait before next iteration
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(6 * time.Second):
+		req := &stream.FileChunk{
+			TotalSize: ch.TotalSize,
+			Offset:    ch.Offset,
+			Data:      ch.Data,
+			Last:      ch.Last,
+			Sha256:    ch.Checksum,
+		}
+		if ch.Offset == 0 {
+			req.Name = name
+		}
+		if err := sender.Send(req); err != nil {
+			if err == io.EOF {
+				// the server ended the stream, its status comes with Recv
+				return <-result
 			}
+			return fmt.Errorf("failed to send chunk at offset %d: %w", ch.Offset, err)
 		}
-	}()
+		sum = ch.Checksum
+		if err := c.clock.Sleep(ctx, s.Pace); err != nil {
+			return err
+		}
+	}
+	tracker.Done()
 
-	// Test Bidirectional Stream Async
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		clientID := 4
-		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("[Client-%d] Bidirectional async test cancelled", clientID)
-				return
-			default:
-			}
+	if err := streamClient.CloseSend(); err != nil {
+		return fmt.Errorf("failed to close send: %w", err)
+	}
+	if err := <-result; err != nil {
+		return err
+	}
+	resp := final
+	if !bytes.Equal(resp.GetSha256(), sum) {
+		return fmt.Errorf("server checksum %x, sent %x", resp.GetSha256(), sum)
+	}
 
-			if err := client.testBidirectionalStreamAsync(ctx, clientID); err != nil {
-				if ctx.Err() != nil {
-					return // Context was cancelled
-				}
-				log.Printf("[Client-%d] Bidirectional async error: %v", clientID, err)
-			}
+	logger.Printf("[Client-%d] Uploaded %s: %d bytes in %d chunks, sha256 %x", clientID, resp.GetName(), resp.GetSize(), resp.GetChunks(), resp.GetSha256())
+	return nil
+}
 
-			// Wait before next iteration
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(7 * time.Second):
-			}
+// run opens one stream of spec and returns the responses it got.
+func (c *Client) run(ctx context.Context, spec StreamSpec) ([]*stream.EchoResponse, error) {
+	switch spec.Mode {
+	case ModeClientStream:
+		return c.testClientStream(ctx, spec)
+	case ModeServerStream:
+		return c.testServerStream(ctx, spec)
+	case ModeBidiSync:
+		return c.testBidirectionalStreamSync(ctx, spec)
+	case ModeBidiAsync:
+		return c.testBidirectionalStreamAsync(ctx, spec)
+	case ModeBidiReliable:
+		return c.testBidirectionalStreamReliable(ctx, spec)
+	case ModeReplay:
+		return c.testReplay(ctx, spec)
+	case ModeUpload:
+		return nil, c.testUploadFile(ctx, spec)
+	case ModePull:
+		return c.testPull(ctx, spec)
+	}
+	return nil, fmt.Errorf("unknown mode %q", spec.Mode)
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "server address")
+	compressionName := flag.String("compression", compression.Identity, "compression of every stream: identity, gzip or zstd")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	var tlsOpts tlsconfig.ClientOptions
+	flag.StringVar(&tlsOpts.CAFile, "tls-ca", "", "CA bundle used to verify the server, e.g. of self-signed lab certificates, implies -tls")
+	flag.StringVar(&tlsOpts.CertFile, "tls-cert", "", "client certificate for mTLS, implies -tls")
+	flag.StringVar(&tlsOpts.KeyFile, "tls-key", "", "client private key for mTLS")
+	flag.StringVar(&tlsOpts.ServerName, "tls-server-name", "", "name the server certificate must be issued for instead of the host of -addr, implies -tls")
+	flag.BoolVar(&tlsOpts.InsecureSkipVerify, "tls-insecure-skip-verify", false, "accept any server certificate, for labs only, implies -tls")
+	batchSize := flag.Int("upload-batch", 2, "messages per batch in the client stream test")
+	flushInterval := flag.Duration("upload-flush", 700*time.Millisecond, "flush a partial batch after this interval")
+	binlogPath := flag.String("binlog", "", "gRPC binary log file (read it with cmd/binlogcat), empty to disable")
+	recordPath := flag.String("record-session", "", "record every stream to this session file, replay it with -replay-session")
+	replayPath := flag.String("replay-session", "", "replay a recorded session once, compare the responses and exit")
+	replayIgnore := flag.String("replay-ignore", "offset", "comma separated response fields left out of the session comparison")
+	replaySkip := flag.String("replay-skip", "EchoReplay", "comma separated methods not replayed; the journal depends on the server history")
+	replayUnordered := flag.Bool("replay-unordered", false, "compare the responses of a stream regardless of their order")
+	replayPaced := flag.Bool("replay-paced", false, "keep the recorded gaps between sent messages")
+	interactiveMode := flag.String("interactive", "", "send the lines typed on stdin on one bidi_sync or bidi_async stream and print the responses, instead of running the scenario")
+	failFast := flag.Bool("fail-fast", false, "stop every test loop as soon as one of them fails")
+	scenarioPath := flag.String("scenario", "", "YAML scenario of the test streams, empty for the built-in one (see scenario.yaml)")
+	latencyEvery := flag.Duration("latency-every", 30*time.Second, "log per-method stream counts, p50/p95 and status codes of the last interval this often, 0 to disable")
+	progressInterval := flag.Duration("progress", 500*time.Millisecond, "log the progress of client streams and uploads this often, 0 for the final state only")
+	ackLoss := flag.Float64("ack-loss", 0.3, "share of reliable stream responses left unacknowledged on first delivery")
+	keepaliveTime := flag.Duration("keepalive-time", 0, "ping the server after this much inactivity, 0 to disable; the server answers too frequent pings with GOAWAY")
+	keepaliveTimeout := flag.Duration("keepalive-timeout", 20*time.Second, "close the connection if a keepalive ping is not answered within this time")
+	messageGap := flag.Duration("message-gap", 0, "abort a server or bidi stream that waits this long for a message, telling a stream with heartbeats from a wedged one; 0 waits forever")
+	var extraHeaders headers.Flag
+	flag.Var(&extraHeaders, "H", `extra "key: value" header sent on every stream, repeatable; values of *-bin keys are base64`)
+	reportPath := flag.String("shutdown-report", shutdownreport.Stdout, `write a JSON report at exit: uptime, streams by method and code, streams drained and aborted by the shutdown, duration of its phases; "-" for stdout, empty to disable`)
+	flag.Parse()
+	runReport := shutdownreport.New("stream client")
+
+	// GOAWAY frames, failed keepalive pings and closed connections are
+	// logged as [CONN] lines next to the state changes
+	connstate.LogTransportEvents()
+
+	log.Println("Starting gRPC Echo Stream Client...")
+	if m := *interactiveMode; m != "" && m != ModeBidiSync && m != ModeBidiAsync {
+		log.Fatalf("-interactive must be %s or %s", ModeBidiSync, ModeBidiAsync)
+	}
+
+	scenario, err := loadScenario(*scenarioPath)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+
+	callOpts, err := compression.CallOptions(*compressionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Using %s compression", *compressionName)
+
+	// collects processing time and server id from the trailers of every stream
+	timings := servertiming.NewCollector()
+	// durations of the streams per method, printed at shutdown
+	latencies := latency.NewSummary()
+
+	// the trace and request ids come with pkg/client
+	dialOpts := []client.Option{
+		client.WithMetadata(extraHeaders.MD()),
+		client.WithDialOptions(grpc.WithStatsHandler(runReport)),
+		client.WithInterceptor(nil, latencies.StreamClientInterceptor()),
+		client.WithInterceptor(nil, timings.StreamClientInterceptor()),
+		// 0 turns the pings off
+		client.WithKeepalive(keepalive.ClientParameters{
+			Time:    *keepaliveTime,
+			Timeout: *keepaliveTimeout,
+			// streams are opened one after another, ping in the pauses too
+			PermitWithoutStream: true,
+		}),
+		client.WithMessageGap(*messageGap),
+	}
+	if *useTLS || tlsOpts.Enabled() {
+		tlsCfg, err := tlsconfig.Client(tlsOpts)
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+		dialOpts = append(dialOpts, client.WithTLS(tlsCfg))
+		log.Printf("TLS enabled (mTLS: %t)", tlsOpts.CertFile != "")
+		if tlsOpts.InsecureSkipVerify {
+			log.Printf("WARNING: the server certificate is not verified")
+		}
+	}
+	// Headers, messages and status of every stream
+	var binlogSink *binlog.FileSink
+	if *binlogPath != "" {
+		binlogSink, err = binlog.Create(*binlogPath)
+		if err != nil {
+			log.Fatalf("Failed to open binary log: %v", err)
+		}
+		dialOpts = append(dialOpts, client.WithDialOptions(grpc.WithStatsHandler(binlog.NewHandler(binlogSink))))
+	}
+	// Requests and responses of every stream, as a golden file
+	var recorder *session.Recorder
+	if *recordPath != "" {
+		recorder, err = session.Create(*recordPath)
+		if err != nil {
+			log.Fatalf("Failed to create session file: %v", err)
+		}
+		dialOpts = append(dialOpts, client.WithInterceptor(nil, recorder.StreamClientInterceptor()))
+	}
+
+	// Create client
+	client, err := NewClient(*addr, callOpts, dialOpts...)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	client.uploadBatchSize = *batchSize
+	client.uploadFlushInterval = *flushInterval
+	client.ackLoss = *ackLoss
+	client.progressInterval = *progressInterval
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Printf("Failed to close client connection: %v", err)
 		}
 	}()
 
-	log.Println("All streaming clients started. Press Ctrl+C to stop...")
+	// State transitions are logged for the whole run; the watcher returns
+	// once the connection is closed
+	go connstate.Watch(context.Background(), client.conn)
 
-	// Wait for cancellation
-	<-ctx.Done()
-	log.Println("Shutdown signal received, waiting for all goroutines to finish...")
+	// Traffic starts only once the connection is READY, Ctrl+C gives up
+	waitCtx, stopWaiting := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = connstate.WaitReady(waitCtx, client.conn, connstate.DefaultBackoff())
+	stopWaiting()
+	if err != nil {
+		log.Printf("Gave up connecting to %s: %v", *addr, err)
+		return
+	}
+	log.Printf("Connected to %s", *addr)
+
+	if *interactiveMode != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := client.interactive(ctx, *interactiveMode, os.Stdin)
+		// stop cancels ctx, so Ctrl+C is told apart before it
+		interrupted := ctx.Err() != nil
+		stop()
+		if err != nil && !interrupted {
+			log.Printf("Interactive stream failed: %v", err)
+		}
+		timings.Log()
+		return
+	}
 
-	// Wait for all goroutines to finish with timeout
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+	if *replayPath != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		ok, err := replaySession(ctx, client.conn, *replayPath, splitFields(*replaySkip), session.Options{
+			Ignore:    splitFields(*replayIgnore),
+			Unordered: *replayUnordered,
+			Paced:     *replayPaced,
+		}, callOpts)
+		stop()
+		if err != nil {
+			log.Printf("Failed to replay session: %v", err)
+		}
+		if err != nil || !ok {
+			client.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
-	select {
-	case <-done:
-		log.Println("All goroutines finished gracefully")
-	case <-time.After(10 * time.Second):
-		log.Println("Timeout waiting for goroutines to finish")
+	// The test loops are one component of the group: Ctrl+C cancels them and
+	// the group waits for them to return. The component ends by itself once
+	// every loop has ended by its restart policy
+	g := graceful.New(shutdownTimeout)
+	g.OnShutdown(runReport.Shutdown)
+	// added first, so it is closed after every test loop has returned
+	if binlogSink != nil {
+		g.Add("binary log", nil, func(context.Context) error { return binlogSink.Close() })
 	}
+	if recorder != nil {
+		g.Add("session recorder", nil, func(context.Context) error { return recorder.Close() })
+	}
+	if *latencyEvery > 0 {
+		g.AddContext("latency digest", func(ctx context.Context) error {
+			return latencies.Run(ctx, *latencyEvery)
+		})
+	}
+	// the group returns only the error that ended it, not the one of loops
+	// stopped by Ctrl+C
+	loopsErr := make(chan error, 1)
+	g.AddContext("test loops", func(ctx context.Context) error {
+		err := client.runLoops(ctx, scenario.Streams, *failFast)
+		loopsErr <- err
+		return err
+	})
 
+	log.Println("All streaming clients started. Press Ctrl+C to stop...")
+	err = g.Run(context.Background())
+	if err == nil {
+		select {
+		case err = <-loopsErr:
+		default:
+		}
+	}
+
+	timings.Log()
+	latencies.Log()
+	if err := runReport.Write(*reportPath, g.Phases(), err); err != nil {
+		log.Print(err)
+	}
+	if err != nil {
+		log.Printf("Client stopped with error: %v", err)
+		client.Close()
+		os.Exit(1)
+	}
 	log.Println("Client shutdown completed")
 }
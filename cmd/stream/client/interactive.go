@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// interactive opens one bidi stream of mode (bidi_sync or bidi_async),
+// sends every line read from in as a message and prints the responses as
+// they arrive. A line "id> text" sends text in conversation id, so the
+// ordering of the async stream can be tried out. The stream is closed at
+// the end of in (Ctrl+D) or when ctx is done.
+func (c *Client) interactive(ctx context.Context, mode string, in io.Reader) error {
+	var (
+		s   streams.ClientBidi[stream.EchoRequest, stream.EchoResponse]
+		err error
+	)
+	switch mode {
+	case ModeBidiSync:
+		s, err = c.client.EchoBidirectionalStreamSync(ctx, c.callOpts...)
+	case ModeBidiAsync:
+		s, err = c.client.EchoBidirectionalStreamAsync(ctx, c.callOpts...)
+	default:
+		return fmt.Errorf("interactive mode needs %s or %s, not %q", ModeBidiSync, ModeBidiAsync, mode)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s stream: %w", mode, err)
+	}
+
+	// the scanner blocks in Read, which ctx cannot interrupt, so it runs on
+	// its own and the sender waits for its lines or for ctx
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(in)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- sc.Err()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Type messages for the %s stream, one per line, \"id> text\" for a conversation, Ctrl+D to finish\n", mode)
+	return streams.RunBidi(ctx, s,
+		func(ctx context.Context, send func(*stream.EchoRequest) error) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case line, ok := <-lines:
+					if !ok {
+						select {
+						case err := <-scanErr:
+							return err
+						default:
+							return nil
+						}
+					}
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
+					if err := send(parseLine(line)); err != nil {
+						return err
+					}
+				}
+			}
+		},
+		func(resp *stream.EchoResponse) error {
+			if id := resp.GetConversationId(); id != "" {
+				fmt.Printf("< [%s] %s\n", id, resp.GetMessage())
+			} else {
+				fmt.Printf("< %s\n", resp.GetMessage())
+			}
+			return nil
+		})
+}
+
+// parseLine turns "id> text" into a message of conversation id, any other
+// line into a message without one.
+func parseLine(line string) *stream.EchoRequest {
+	if id, text, ok := strings.Cut(line, "> "); ok && id != "" && !strings.ContainsAny(id, " \t") {
+		return &stream.EchoRequest{Message: text, ConversationId: id}
+	}
+	return &stream.EchoRequest{Message: line}
+}
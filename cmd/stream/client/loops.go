@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/retry"
+)
+
+// loopResult is how a test loop went.
+type loopResult struct {
+	name     string
+	runs     int
+	failures int
+	// err is why the loop failed: it gave up by its restart policy, or its
+	// last run failed.
+	err error
+}
+
+// runLoops runs every stream of specs in a loop of its own until ctx is done
+// or every loop has ended by its restart policy. With failFast the first
+// loop that fails stops all the others. The error joins the errors of every
+// failed loop, so none of them is lost behind the first one.
+func (c *Client) runLoops(ctx context.Context, specs []StreamSpec, failFast bool) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]loopResult, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i] = c.loop(ctx, spec)
+			if results[i].err != nil && failFast {
+				cancel(fmt.Errorf("%s failed", spec.Name))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cause := context.Cause(ctx); failFast && cause != nil && !errors.Is(cause, context.Canceled) {
+		log.Printf("Stopped every loop: %v", cause)
+	}
+
+	var errs []error
+	for _, r := range results {
+		log.Printf("[LOOP] %s: %d runs, %d failed", r.name, r.runs, r.failures)
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loop runs the stream of spec every spec.Interval until ctx is done or the
+// restart policy of spec ends it. A run cut short by ctx is not counted.
+func (c *Client) loop(ctx context.Context, spec StreamSpec) loopResult {
+	res := loopResult{name: spec.Name}
+	failedInRow := 0
+	for {
+		responses, err := c.run(ctx, spec)
+		if err == nil {
+			if err = spec.Expect.check(responses); err != nil {
+				err = fmt.Errorf("unexpected responses: %w", err)
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("[Client-%d] %s test cancelled", spec.ClientID, spec.Name)
+			return res
+		}
+
+		res.runs++
+		res.err = err
+		wait := spec.Interval
+		if err != nil {
+			res.failures++
+			failedInRow++
+			// a server that shuts down closes its streams with Unavailable
+			// and advises when to reconnect
+			if delay, ok := retry.Delay(err); ok && status.Code(err) == codes.Unavailable {
+				log.Printf("[Client-%d] %s interrupted, reconnecting in %v: %v", spec.ClientID, spec.Name, delay, err)
+				wait = delay
+			} else {
+				log.Printf("[Client-%d] %s error: %v", spec.ClientID, spec.Name, err)
+			}
+		} else {
+			failedInRow = 0
+		}
+
+		switch {
+		case spec.Restart == RestartNever:
+			return res
+		case spec.Restart == RestartOnFailure && err == nil:
+			log.Printf("[Client-%d] %s succeeded, not restarting", spec.ClientID, spec.Name)
+			return res
+		case spec.MaxFailures > 0 && failedInRow >= spec.MaxFailures:
+			log.Printf("[Client-%d] %s failed %d times in a row, giving up", spec.ClientID, spec.Name, failedInRow)
+			res.err = fmt.Errorf("gave up after %d failures in a row: %w", failedInRow, err)
+			return res
+		}
+
+		// Wait before next iteration
+		if err := c.clock.Sleep(ctx, wait); err != nil {
+			log.Printf("[Client-%d] %s test cancelled", spec.ClientID, spec.Name)
+			return res
+		}
+	}
+}
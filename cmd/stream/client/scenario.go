@@ -0,0 +1,214 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+)
+
+// defaultScenario runs every streaming method in a loop, see scenario.yaml.
+//
+//go:embed scenario.yaml
+var defaultScenario []byte
+
+// Streaming modes a scenario stream can use.
+const (
+	ModeClientStream = "client_stream"
+	ModeServerStream = "server_stream"
+	ModeBidiSync     = "bidi_sync"
+	ModeBidiAsync    = "bidi_async"
+	ModeBidiReliable = "bidi_reliable"
+	ModeReplay       = "replay"
+	ModeUpload       = "upload"
+	ModePull         = "pull"
+)
+
+// Restart policies of a loop.
+const (
+	// RestartAlways runs the stream again after every run.
+	RestartAlways = "always"
+	// RestartOnFailure runs it again only after a failed run, a successful
+	// run ends the loop.
+	RestartOnFailure = "on_failure"
+	// RestartNever runs the stream once.
+	RestartNever = "never"
+)
+
+// Scenario describes which streams the client runs and what it sends.
+type Scenario struct {
+	Streams []StreamSpec `yaml:"streams"`
+}
+
+// StreamSpec is one test loop: a stream of the given mode opened every
+// Interval.
+type StreamSpec struct {
+	Name     string `yaml:"name"`
+	ClientID int    `yaml:"client_id"`
+	Mode     string `yaml:"mode"`
+	// Interval is the pause between two runs.
+	Interval time.Duration `yaml:"interval"`
+	// Pace is the pause after every sent message.
+	Pace time.Duration `yaml:"pace"`
+	// Messages are sent in order, Count times over. Text and Conversation
+	// may use {client} for the client id and {n} for the 1-based number of
+	// the message in the run.
+	Messages []MessageSpec `yaml:"messages"`
+	Count    int           `yaml:"count"`
+	// Duration is how long a replay run follows the journal.
+	Duration time.Duration `yaml:"duration"`
+	// Size is the number of random bytes an upload run sends in chunks of
+	// ChunkSize bytes.
+	Size      int `yaml:"size"`
+	ChunkSize int `yaml:"chunk_size"`
+	// Credits is how many responses a pull run grants at a time; it
+	// grants the next ones once it has processed these, taking Pace for
+	// each. A pull run wants Count responses in total.
+	Credits int         `yaml:"credits"`
+	Expect  Expectation `yaml:"expect"`
+	// Restart tells when the loop runs the stream again, see the Restart*
+	// constants; RestartAlways by default.
+	Restart string `yaml:"restart"`
+	// MaxFailures is how many runs in a row may fail before the loop gives
+	// up, 0 for no limit.
+	MaxFailures int `yaml:"max_failures"`
+}
+
+// MessageSpec is a template of one request.
+type MessageSpec struct {
+	Text         string `yaml:"text"`
+	Conversation string `yaml:"conversation"`
+}
+
+// Expectation checks the responses of a run. Zero values are not checked.
+type Expectation struct {
+	// Responses is the number of responses, duplicates of the reliable
+	// stream not counted.
+	Responses int `yaml:"responses"`
+	// Contains must be part of every response message.
+	Contains string `yaml:"contains"`
+}
+
+// loadScenario reads the scenario at path, or the default one for an empty
+// path.
+func loadScenario(path string) (*Scenario, error) {
+	data := defaultScenario
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read scenario: %w", err)
+		}
+	}
+
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if len(s.Streams) == 0 {
+		return nil, errors.New("scenario has no streams")
+	}
+
+	for i := range s.Streams {
+		if err := s.Streams[i].normalize(i); err != nil {
+			return nil, fmt.Errorf("stream %d: %w", i+1, err)
+		}
+	}
+	return &s, nil
+}
+
+// normalize validates the spec and fills in the defaults.
+func (s *StreamSpec) normalize(i int) error {
+	switch s.Mode {
+	case ModeClientStream, ModeServerStream, ModeBidiSync, ModeBidiAsync, ModeBidiReliable:
+		if len(s.Messages) == 0 {
+			return fmt.Errorf("%s needs at least one message", s.Mode)
+		}
+	case ModePull:
+		if len(s.Messages) == 0 {
+			return errors.New("pull needs a message")
+		}
+		if s.Credits <= 0 {
+			s.Credits = 1
+		}
+	case ModeReplay:
+		if s.Duration <= 0 {
+			s.Duration = 3 * time.Second
+		}
+	case ModeUpload:
+		if s.Size <= 0 {
+			return errors.New("upload needs a positive size")
+		}
+		if s.ChunkSize <= 0 {
+			s.ChunkSize = 64 << 10
+		}
+	case "":
+		return errors.New("mode is required")
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+
+	if s.Name == "" {
+		s.Name = s.Mode
+	}
+	if s.ClientID == 0 {
+		s.ClientID = i + 1
+	}
+	if s.Interval <= 0 {
+		s.Interval = 5 * time.Second
+	}
+	if s.Count <= 0 {
+		s.Count = 1
+	}
+	switch s.Restart {
+	case "":
+		s.Restart = RestartAlways
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart policy %q", s.Restart)
+	}
+	if s.MaxFailures < 0 {
+		return errors.New("max_failures must not be negative")
+	}
+	return nil
+}
+
+// requests expands the message templates of the spec.
+func (s *StreamSpec) requests() []*stream.EchoRequest {
+	reqs := make([]*stream.EchoRequest, 0, len(s.Messages)*s.Count)
+	for round := 0; round < s.Count; round++ {
+		for _, m := range s.Messages {
+			r := strings.NewReplacer(
+				"{client}", strconv.Itoa(s.ClientID),
+				"{n}", strconv.Itoa(len(reqs)+1),
+			)
+			reqs = append(reqs, &stream.EchoRequest{
+				Message:        r.Replace(m.Text),
+				ConversationId: r.Replace(m.Conversation),
+			})
+		}
+	}
+	return reqs
+}
+
+// check returns an error describing the first unmet expectation.
+func (e Expectation) check(responses []*stream.EchoResponse) error {
+	if e.Responses > 0 && len(responses) != e.Responses {
+		return fmt.Errorf("expected %d responses, got %d", e.Responses, len(responses))
+	}
+	if e.Contains != "" {
+		for _, resp := range responses {
+			if !strings.Contains(resp.GetMessage(), e.Contains) {
+				return fmt.Errorf("response %q does not contain %q", resp.GetMessage(), e.Contains)
+			}
+		}
+	}
+	return nil
+}
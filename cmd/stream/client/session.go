@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	"github.com/easyp-tech/course-grpc/internal/session"
+)
+
+// replaySession replays the streams of the session file, except
+// those of the skipped methods, and logs the differences to the recorded
+// responses. It reports whether all replayed streams matched.
+func replaySession(
+	ctx context.Context, conn *grpc.ClientConn, file string, skip []string, opts session.Options, callOpts []grpc.CallOption,
+) (bool, error) {
+	streams, err := session.Load(file)
+	if err != nil {
+		return false, err
+	}
+
+	replayed, failed := 0, 0
+	for i := range streams {
+		if slices.Contains(skip, path.Base(streams[i].Method)) {
+			log.Printf("[SESSION] stream %d %s: skipped", i+1, streams[i].Method)
+			continue
+		}
+
+		replayed++
+		res := session.Replay(ctx, conn, &streams[i], opts, callOpts...)
+		if res.OK() {
+			log.Printf("[SESSION] stream %d %s: ok, %d responses", i+1, res.Method, len(res.Received))
+			continue
+		}
+
+		failed++
+		log.Printf("[SESSION] stream %d %s: %d differences", i+1, res.Method, len(res.Diffs))
+		for _, d := range res.Diffs {
+			log.Printf("[SESSION]   %s", d)
+		}
+	}
+
+	log.Printf("[SESSION] %d of %d streams matched %s", replayed-failed, replayed, file)
+	return failed == 0, nil
+}
+
+// splitFields parses a comma separated list of field names.
+func splitFields(s string) []string {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
@@ -1,192 +1,237 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"context"
+	"flag"
 	"log"
 	"net"
-	"sync"
 	"time"
 
 	"google.golang.org/grpc"
-
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/easyp-tech/course-grpc/internal/backpressure"
+	"github.com/easyp-tech/course-grpc/internal/binlog"
+	"github.com/easyp-tech/course-grpc/internal/connlimit"
+	// registers the gzip and zstd compressors so clients may send compressed messages
+	_ "github.com/easyp-tech/course-grpc/internal/compression"
+	"github.com/easyp-tech/course-grpc/internal/echostream"
+	"github.com/easyp-tech/course-grpc/internal/farewell"
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+	"github.com/easyp-tech/course-grpc/internal/journal"
+	"github.com/easyp-tech/course-grpc/internal/lifetime"
+	"github.com/easyp-tech/course-grpc/internal/logsample"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/internal/msgsize"
+	"github.com/easyp-tech/course-grpc/internal/panics"
+	"github.com/easyp-tech/course-grpc/internal/peerinfo"
+	"github.com/easyp-tech/course-grpc/internal/probes"
+	"github.com/easyp-tech/course-grpc/internal/proxyproto"
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+	"github.com/easyp-tech/course-grpc/internal/reflection"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+	"github.com/easyp-tech/course-grpc/internal/shutdownreport"
+	"github.com/easyp-tech/course-grpc/internal/sockopt"
+	"github.com/easyp-tech/course-grpc/internal/tlsconfig"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	"github.com/easyp-tech/course-grpc/internal/wiresize"
 	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
 )
 
-var _ stream.EchoServiceServer = &API{}
-
-type API struct {
-	stream.UnimplementedEchoServiceServer
-}
-
-// EchoClientStream handles client streaming - receives multiple messages from client, returns one response
-func (a *API) EchoClientStream(streamServer stream.EchoService_EchoClientStreamServer) error {
-	log.Println("EchoClientStream: Starting client stream")
+const (
+	// drainDelay is how long readiness reports NOT_SERVING before the server
+	// stops accepting streams, so balancers can take it out of rotation.
+	drainDelay = 2 * time.Second
+	// shutdownTimeout bounds the whole shutdown, including the drain.
+	shutdownTimeout = 15 * time.Second
+	// farewellRetryDelay is advised to the clients of the streams closed at
+	// shutdown.
+	farewellRetryDelay = time.Second
+)
 
-	var messages []string
+func main() {
+	certFile := flag.String("tls-cert", "", "server certificate, enables TLS")
+	keyFile := flag.String("tls-key", "", "server private key")
+	clientCAFile := flag.String("tls-client-ca", "", "CA for client certificates, enables mTLS")
+	metricsAddr := flag.String("metrics-addr", ":9080", "address of the Prometheus /metrics endpoint, empty to disable")
+	journalPath := flag.String("journal", "", "file backing the EchoReplay message journal, empty keeps it in memory")
+	backpressureThreshold := flag.Float64("backpressure-threshold", backpressure.DefaultThreshold, "share of the capacity of a stream queue that logs a warning when the queue stays above it for -backpressure-hold, 0 for the metrics only")
+	backpressureHold := flag.Duration("backpressure-hold", backpressure.DefaultHold, "how long a stream queue has to stay above the threshold before the warning")
+	heartbeat := flag.Duration("heartbeat", 5*time.Second, "how long EchoReplay waits for new journal entries before it sends a heartbeat, 0 for none")
+	sessionTTL := flag.Duration("session-ttl", 5*time.Minute, "how long a session of EchoBidirectionalStreamReliable is kept after its stream ends for the client to rejoin with its token, 0 to issue no tokens")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 32, "open connections allowed from one IP, 0 for no limit")
+	maxConns := flag.Int("max-conns", 1024, "open connections allowed in total, 0 for no limit")
+	reflectionMode := flag.String("reflection", reflection.Both, "reflection API versions to serve: both (v1 and v1alpha), v1, v1alpha or off")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "expect a PROXY protocol header (v1 or v2) from nginx/HAProxy on every connection")
+	binlogPath := flag.String("binlog", "", "gRPC binary log file (read it with cmd/binlogcat), empty to disable")
+	// Per-message log lines of the handlers, e.g. -log-sample '*=100:20'
+	// keeps one message in 100 and at most 20 lines a second per handler
+	logSampling := logsample.Flag{}
+	flag.Var(logSampling, "log-sample", "sample the per-message log lines of a handler as handler=every[:per_second], '*' for all handlers, repeatable")
+	// Socket options of the listener; TCP keepalive finds vanished clients
+	// below the HTTP/2 pings of grpc
+	sockOpts := sockopt.Default
+	flag.BoolVar(&sockOpts.NoDelay, "tcp-nodelay", true, "set TCP_NODELAY on accepted connections, false turns Nagle's algorithm on")
+	flag.BoolVar(&sockOpts.ReusePort, "reuseport", false, "set SO_REUSEPORT on the listening socket so several processes can share the port")
+	flag.DurationVar(&sockOpts.KeepAlive, "tcp-keepalive", 0, "idle time before the first TCP keepalive probe and between probes, 0 for Go's 15s, negative disables probes")
+	flag.IntVar(&sockOpts.KeepAliveCount, "tcp-keepalive-count", 0, "unanswered probes that close a connection, 0 for the OS default")
+	// The server pings a client after keepalive-time of silence and drops the
+	// connection without an answer within keepalive-timeout; a client pinging
+	// more often than keepalive-min-time gets GOAWAY ENHANCE_YOUR_CALM. The
+	// defaults are grpc's own.
+	var kaParams keepalive.ServerParameters
+	var kaPolicy keepalive.EnforcementPolicy
+	flag.DurationVar(&kaParams.Time, "keepalive-time", 2*time.Hour, "ping a client after this much silence on its connection")
+	flag.DurationVar(&kaParams.Timeout, "keepalive-timeout", 20*time.Second, "close the connection if a ping is not answered within this time")
+	flag.DurationVar(&kaParams.MaxConnectionIdle, "max-connection-idle", 0, "close connections without streams after this time (GOAWAY), 0 for never")
+	flag.DurationVar(&kaParams.MaxConnectionAge, "max-connection-age", 0, "close connections older than this (GOAWAY), 0 for never")
+	flag.DurationVar(&kaParams.MaxConnectionAgeGrace, "max-connection-age-grace", 0, "time the streams get to finish after max-connection-age, 0 for unlimited")
+	flag.DurationVar(&kaPolicy.MinTime, "keepalive-min-time", 5*time.Minute, "minimum interval between pings of a client")
+	flag.BoolVar(&kaPolicy.PermitWithoutStream, "keepalive-permit-without-stream", false, "allow pings on connections without active streams")
+	// Stream messages over these limits fail with ResourceExhausted and an
+	// ErrorInfo detail instead of grpc's bare status
+	var sizeLimits msgsize.Limits
+	flag.IntVar(&sizeLimits.MaxRecv, "max-recv-msg-size", msgsize.DefaultMax, "largest message received, in bytes, 0 for grpc's default")
+	flag.IntVar(&sizeLimits.MaxSend, "max-send-msg-size", msgsize.DefaultMax, "largest message sent, in bytes, 0 for no limit")
+	// Server and bidi streams older than this are closed with
+	// DeadlineExceeded; the client opens a new one
+	maxStreamDuration := flag.Duration("max-stream-duration", 0, "close server and bidi streams open longer than this, 0 for no limit")
+	reportPath := flag.String("shutdown-report", shutdownreport.Stdout, `write a JSON report at exit: uptime, calls by method and code, streams drained and aborted by the shutdown, duration of its phases; "-" for stdout, empty to disable`)
+	flag.Parse()
+	runReport := shutdownreport.New("stream server")
 
-	for {
-		req, err := streamServer.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("EchoClientStream: Error receiving message: %v", err)
-			return err
-		}
+	log.Println("Starting gRPC Echo Stream Server...")
 
-		log.Printf("EchoClientStream: Received message: %s", req.Message)
-		messages = append(messages, req.Message)
+	tcpListener, err := sockopt.Listen(context.Background(), "tcp", ":8080", sockOpts)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
 	}
-
-	response := &stream.EchoResponse{
-		Message: fmt.Sprintf("Received %d messages: %v", len(messages), messages),
+	log.Printf("Sockets: %s", sockOpts)
+	// Behind a proxy the client address comes in the PROXY protocol header;
+	// it has to be read before the limits are applied per IP
+	var base net.Listener = tcpListener
+	if *proxyProtocol {
+		base = proxyproto.NewListener(tcpListener)
 	}
-
-	log.Printf("EchoClientStream: Sending response: %s", response.Message)
-	return streamServer.SendAndClose(response)
-}
-
-// EchoServerStream handles server streaming - receives a message and sends back a stream of responses.
-func (a *API) EchoServerStream(req *stream.EchoRequest, streamServer stream.EchoService_EchoServerStreamServer) error {
-	log.Printf("EchoServerStream: Received message: %s", req.Message)
-
-	for i := 1; i <= 5; i++ {
-		response := &stream.EchoResponse{
-			Message: fmt.Sprintf("Echo #%d: %s", i, req.Message),
-		}
-
-		log.Printf("EchoServerStream: Sending response #%d: %s", i, response.Message)
-
-		if err := streamServer.Send(response); err != nil {
-			log.Printf("EchoServerStream: Error sending response: %v", err)
-			return err
-		}
-
-		time.Sleep(100 * time.Millisecond)
+	// Connections over the limits are closed on accept
+	lis := connlimit.NewListener(base, *maxConnsPerIP, *maxConns)
+
+	instanceID := servertiming.InstanceID()
+	log.Printf("Instance ID: %s", instanceID)
+	log.Printf("Keepalive: ping after %v, timeout %v; clients may ping every %v (without streams: %t)",
+		kaParams.Time, kaParams.Timeout, kaPolicy.MinTime, kaPolicy.PermitWithoutStream)
+	log.Printf("Message size limits: %s", sizeLimits)
+
+	streamFarewell := farewell.New(farewellRetryDelay)
+	if *maxStreamDuration < 0 {
+		log.Fatalf("-max-stream-duration must not be negative")
+	}
+	if *maxStreamDuration > 0 {
+		log.Printf("Streams: closed after %v", *maxStreamDuration)
 	}
 
-	log.Println("EchoServerStream: Finished sending responses")
-	return nil
-}
-
-// EchoBidirectionalStreamSync handles bidirectional streaming with synchronous processing
-func (a *API) EchoBidirectionalStreamSync(streamServer stream.EchoService_EchoBidirectionalStreamSyncServer) error {
-	log.Println("EchoBidirectionalStreamSync: Starting bidirectional stream (sync)")
-
-	for {
-		req, err := streamServer.Recv()
-		if err == io.EOF {
-			log.Println("EchoBidirectionalStreamSync: Client closed connection")
-			return nil
-		}
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(kaParams),
+		grpc.KeepaliveEnforcementPolicy(kaPolicy),
+		grpc.StatsHandler(wiresize.NewLogger("server")),
+		// sees every call, whatever the interceptors
+		grpc.StatsHandler(runReport),
+		grpc.ChainStreamInterceptor(
+			// first, so the trace id is in the log lines of everything below
+			tracectx.StreamServerInterceptor(),
+			requestid.StreamServerInterceptor(),
+			// client address and TLS state of every stream
+			peerinfo.StreamServerInterceptor(),
+			servertiming.StreamServerInterceptor(instanceID),
+			panics.StreamServerInterceptor(),
+			// server and bidi streams are closed with Unavailable at shutdown
+			streamFarewell.StreamServerInterceptor(),
+			lifetime.New(*maxStreamDuration, nil).StreamServerInterceptor(),
+			sizeLimits.StreamServerInterceptor(),
+		),
+	}
+	opts = append(opts, sizeLimits.ServerOptions()...)
+	if *certFile != "" {
+		tlsCfg, err := tlsconfig.Server(*certFile, *keyFile, *clientCAFile)
 		if err != nil {
-			log.Printf("EchoBidirectionalStreamSync: Error receiving message: %v", err)
-			return err
-		}
-
-		log.Printf("EchoBidirectionalStreamSync: Received message: %s", req.Message)
-
-		response := &stream.EchoResponse{
-			Message: fmt.Sprintf("Sync Echo: %s", req.Message),
+			log.Fatalf("Failed to load TLS config: %v", err)
 		}
-
-		if err := streamServer.Send(response); err != nil {
-			log.Printf("EchoBidirectionalStreamSync: Error sending response: %v", err)
-			return err
-		}
-
-		log.Printf("EchoBidirectionalStreamSync: Sent response: %s", response.Message)
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		log.Printf("TLS enabled (mTLS: %t)", *clientCAFile != "")
 	}
-}
-
-// EchoBidirectionalStreamAsync handles bidirectional streaming with asynchronous processing
-// func (a *API) EchoBidirectionalStreamAsync(streamServer grpc.BidiStreamingServer[stream.EchoRequest, stream.EchoResponse]) error {
-func (a *API) EchoBidirectionalStreamAsync(streamServer stream.EchoService_EchoBidirectionalStreamAsyncServer) error {
-	log.Println("EchoBidirectionalStreamAsync: Starting bidirectional stream (async)")
-
-	ctx := streamServer.Context()
-	requestCh := make(chan *stream.EchoRequest, 10)
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer close(requestCh)
-
-		for {
-			req, err := streamServer.Recv()
-			if err == io.EOF {
-				log.Println("EchoBidirectionalStreamAsync: Client closed connection")
-				return
-			}
-			if err != nil {
-				log.Printf("EchoBidirectionalStreamAsync: Error receiving message: %v", err)
-				return
-			}
-
-			log.Printf("EchoBidirectionalStreamAsync: Received message: %s", req.Message)
-
-			select {
-			case requestCh <- req:
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		for {
-			select {
-			case req, ok := <-requestCh:
-				if !ok {
-					log.Println("EchoBidirectionalStreamAsync: Request channel closed")
-					return
-				}
-
-				time.Sleep(200 * time.Millisecond)
-
-				response := &stream.EchoResponse{
-					Message: fmt.Sprintf("Async Echo (processed): %s", req.Message),
-				}
-
-				if err := streamServer.Send(response); err != nil {
-					log.Printf("EchoBidirectionalStreamAsync: Error sending response: %v", err)
-					return
-				}
-
-				log.Printf("EchoBidirectionalStreamAsync: Sent async response: %s", response.Message)
-
-			case <-ctx.Done():
-				log.Println("EchoBidirectionalStreamAsync: Context cancelled")
-				return
-			}
+	// Headers, messages and status of every stream
+	var binlogSink *binlog.FileSink
+	if *binlogPath != "" {
+		binlogSink, err = binlog.Create(*binlogPath)
+		if err != nil {
+			log.Fatalf("Failed to open binary log: %v", err)
 		}
-	}()
-
-	wg.Wait()
-	log.Println("EchoBidirectionalStreamAsync: Stream finished")
-	return nil
-}
-
-func main() {
-	log.Println("Starting gRPC Echo Stream Server...")
+		opts = append(opts, grpc.StatsHandler(binlog.NewHandler(binlogSink)))
+	}
 
-	lis, err := net.Listen("tcp", ":8080")
+	s := grpc.NewServer(opts...)
+	// Replies of the bidi streams are journaled and served again by EchoReplay
+	messageJournal, err := journal.Open(*journalPath)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		log.Fatalf("Failed to open journal: %v", err)
+	}
+	samplers, err := logSampling.Samplers(echostream.Handlers...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api := echostream.NewAPI(ratelimit.New(echostream.DefaultMsgRate, echostream.DefaultMsgBurst), messageJournal).
+		WithLogSampling(samplers).
+		WithBackpressure(backpressure.New(*backpressureThreshold, *backpressureHold)).
+		WithHeartbeat(*heartbeat)
+	if *sessionTTL > 0 {
+		api.WithSessions(*sessionTTL)
 	}
-
-	s := grpc.NewServer()
-	api := &API{}
 
 	stream.RegisterEchoServiceServer(s, api)
 
-	log.Println("gRPC server listening on :8080")
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	// Health statuses are reported for the server as a whole (""), for the
+	// EchoService itself and for the liveness/readiness probes.
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	serverProbes := probes.New(healthServer, "", stream.EchoService_ServiceDesc.ServiceName)
+
+	// Reflection lets grpcurl and similar tools discover the service,
+	// channelz exposes connection and call statistics to cmd/grpcctl
+	if err := reflection.Register(s, *reflectionMode); err != nil {
+		log.Fatal(err)
+	}
+	channelzsvc.RegisterChannelzServiceToServer(s)
+
+	// Components are stopped in reverse order: readiness goes first, then the
+	// gRPC server drains its streams, the metrics endpoint stops last.
+	g := graceful.New(shutdownTimeout)
+	// the streams open when the shutdown begins are the ones to drain
+	g.OnShutdown(runReport.Shutdown)
+	if *metricsAddr != "" {
+		g.AddHTTPServer("metrics", metrics.NewServer(*metricsAddr))
+	}
+	// closed after the server, once nothing writes to it anymore
+	g.Add("journal", nil, func(context.Context) error { return messageJournal.Close() })
+	if binlogSink != nil {
+		g.Add("binary log", nil, func(context.Context) error { return binlogSink.Close() })
+	}
+	g.AddGRPCServer("gRPC server", s, lis)
+	// open streams are closed before GracefulStop, which would wait for them
+	g.Add("stream farewell", nil, streamFarewell.Drain)
+	g.Add("readiness", nil, serverProbes.Drain(drainDelay))
+
+	serverProbes.Ready()
+	runErr := g.Run(context.Background())
+	if err := runReport.Write(*reportPath, g.Phases(), runErr); err != nil {
+		log.Print(err)
+	}
+	if runErr != nil {
+		log.Fatalf("Server stopped: %v", runErr)
 	}
+	log.Println("Server stopped")
 }
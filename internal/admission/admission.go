@@ -0,0 +1,310 @@
+// Package admission protects a server from overload by bounding the calls
+// it handles at once. A call over the limit waits in a bounded queue for a
+// free slot; when the queue is full or the wait takes too long, the call is
+// rejected with ResourceExhausted and a RetryInfo detail, which the retry
+// interceptor of the clients honors, so overload turns into delayed retries
+// instead of ever longer response times.
+//
+// Calls carry a priority in the x-priority header. While the server is
+// saturated, a freed slot goes to the waiting calls by weighted round robin
+// over the priorities, so interactive calls get ahead of bulk traffic
+// without starving it, and a full queue makes room for a call by dropping
+// the newest waiting call of a lower priority.
+package admission
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+// PriorityHeader carries the priority of a call: high, normal or low.
+const PriorityHeader = "x-priority"
+
+// Priority orders the calls waiting for a slot.
+type Priority int
+
+const (
+	// Low is for bulk traffic: exports, syncs, batch jobs.
+	Low Priority = iota
+	// Normal is the priority of calls without the header.
+	Normal
+	// High is for calls a user waits for.
+	High
+
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{Low: "low", Normal: "normal", High: "high"}
+
+// weights are the shares of freed slots the priorities get while calls of
+// all of them wait.
+var weights = [numPriorities]int{Low: 1, Normal: 4, High: 16}
+
+func (p Priority) String() string {
+	if p < Low || p >= numPriorities {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority parses high, normal or low, in any case.
+func ParsePriority(s string) (Priority, bool) {
+	i := slices.Index(priorityNames[:], strings.ToLower(strings.TrimSpace(s)))
+	if i < 0 {
+		return Normal, false
+	}
+	return Priority(i), true
+}
+
+// FromIncomingContext returns the priority of the incoming call, Normal
+// when the header is missing or unknown.
+func FromIncomingContext(ctx context.Context) Priority {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(PriorityHeader); len(v) > 0 {
+		p, _ := ParsePriority(v[0])
+		return p
+	}
+	return Normal
+}
+
+// NewOutgoingContext returns ctx for a call of priority p.
+func NewOutgoingContext(ctx context.Context, p Priority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PriorityHeader, p.String())
+}
+
+// Controller admits calls. It is safe for concurrent use.
+type Controller struct {
+	maxInFlight int
+	queueSize   int
+	maxWait     time.Duration
+	retryDelay  time.Duration
+	exempt      map[string]struct{}
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	// waiting are the queues of the priorities, oldest call first
+	waiting [numPriorities][]*waiter
+	// current are the counters of the smooth weighted round robin
+	current [numPriorities]int
+}
+
+// waiter is a call in the queue. It gets nil on admit once a slot is handed
+// over to it, or the error to return when it is displaced.
+type waiter struct {
+	priority Priority
+	admit    chan error
+}
+
+// New creates a controller that runs at most maxInFlight calls and lets at
+// most queueSize more wait up to maxWait for a slot. retryDelay is advised
+// to rejected clients; calls to the exempt services (health, admin) are
+// always admitted and not counted.
+func New(maxInFlight, queueSize int, maxWait, retryDelay time.Duration, exempt ...string) *Controller {
+	c := &Controller{
+		maxInFlight: maxInFlight,
+		queueSize:   queueSize,
+		maxWait:     maxWait,
+		retryDelay:  retryDelay,
+		exempt:      make(map[string]struct{}, len(exempt)),
+	}
+	for _, s := range exempt {
+		c.exempt[s] = struct{}{}
+	}
+	return c
+}
+
+// UnaryServerInterceptor runs the handler once the call is admitted.
+// Streams are not limited: they live long and would hold their slots for
+// good, the connection and rate limits bound them instead.
+func (c *Controller) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		service, _, _ := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+		if _, ok := c.exempt[service]; ok {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		priority := FromIncomingContext(ctx)
+		release, err := c.acquire(ctx, info.FullMethod, priority)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			release()
+			metrics.AdmissionLatency.WithLabelValues(priority.String()).Observe(time.Since(start).Seconds())
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room in it or if
+// a call of a lower priority can be dropped from it.
+func (c *Controller) acquire(ctx context.Context, method string, p Priority) (func(), error) {
+	logger := logctx.Logger(ctx)
+
+	c.mu.Lock()
+	// a free slot is taken directly only when nobody waits for it
+	if c.running < c.maxInFlight && c.queued == 0 {
+		c.running++
+		c.mu.Unlock()
+		return c.admitted(), nil
+	}
+	if c.queued >= c.queueSize {
+		victim := c.lowerThan(p)
+		if victim == nil {
+			c.mu.Unlock()
+			metrics.AdmissionRejected.WithLabelValues("queue_full", p.String()).Inc()
+			logger.Printf("[ADMISSION] %s: %s priority rejected, %d calls running and %d waiting", method, p, c.maxInFlight, c.queueSize)
+			return nil, c.overloaded("server overloaded, queue is full")
+		}
+		c.remove(victim)
+		victim.admit <- c.overloaded(fmt.Sprintf("server overloaded, the queue was taken by %s priority calls", p))
+	}
+	w := &waiter{priority: p, admit: make(chan error, 1)}
+	c.waiting[p] = append(c.waiting[p], w)
+	c.queued++
+	metrics.AdmissionQueued.WithLabelValues(p.String()).Inc()
+	c.mu.Unlock()
+
+	t := time.NewTimer(c.maxWait)
+	defer t.Stop()
+
+	start := time.Now()
+	select {
+	case err := <-w.admit:
+		if err != nil {
+			metrics.AdmissionRejected.WithLabelValues("displaced", p.String()).Inc()
+			logger.Printf("[ADMISSION] %s: %s priority dropped from the queue after %v for a higher priority", method, p, time.Since(start).Round(time.Millisecond))
+			return nil, err
+		}
+		logger.Printf("[ADMISSION] %s: %s priority admitted after %v in the queue", method, p, time.Since(start).Round(time.Millisecond))
+		return c.admitted(), nil
+	case <-t.C:
+		if !c.abandon(w) {
+			// a slot or a rejection arrived just now
+			if err := <-w.admit; err != nil {
+				return nil, err
+			}
+			return c.admitted(), nil
+		}
+		metrics.AdmissionRejected.WithLabelValues("timeout", p.String()).Inc()
+		logger.Printf("[ADMISSION] %s: %s priority rejected after %v in the queue", method, p, c.maxWait)
+		return nil, c.overloaded(fmt.Sprintf("server overloaded, no free slot within %v", c.maxWait))
+	case <-ctx.Done():
+		if !c.abandon(w) {
+			if err := <-w.admit; err == nil {
+				// the slot was already handed over and goes to the next call
+				c.release()
+			}
+		}
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// admitted counts a call that got a slot and returns its release.
+func (c *Controller) admitted() func() {
+	metrics.AdmissionInFlight.Inc()
+	return func() {
+		metrics.AdmissionInFlight.Dec()
+		c.release()
+	}
+}
+
+// release hands the slot of a finished call to the next waiting call, or
+// frees it when nobody waits.
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w := c.next(); w != nil {
+		w.admit <- nil
+		return
+	}
+	c.running--
+}
+
+// next takes the next call out of the queue by smooth weighted round robin:
+// every priority with waiting calls earns its weight, the richest one is
+// served and pays for it with the weights of all of them. Ties go to the
+// higher priority. c.mu must be held.
+func (c *Controller) next() *waiter {
+	best, total := Priority(-1), 0
+	for p := High; p >= Low; p-- {
+		if len(c.waiting[p]) == 0 {
+			c.current[p] = 0
+			continue
+		}
+		c.current[p] += weights[p]
+		total += weights[p]
+		if best < 0 || c.current[p] > c.current[best] {
+			best = p
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	c.current[best] -= total
+	w := c.waiting[best][0]
+	c.remove(w)
+	return w
+}
+
+// lowerThan returns the newest waiting call of the lowest priority below
+// p, nil when there is none. c.mu must be held.
+func (c *Controller) lowerThan(p Priority) *waiter {
+	for q := Low; q < p; q++ {
+		if n := len(c.waiting[q]); n > 0 {
+			return c.waiting[q][n-1]
+		}
+	}
+	return nil
+}
+
+// remove takes w out of the queue and reports whether it was there. c.mu
+// must be held.
+func (c *Controller) remove(w *waiter) bool {
+	q := c.waiting[w.priority]
+	i := slices.Index(q, w)
+	if i < 0 {
+		return false
+	}
+	c.waiting[w.priority] = slices.Delete(q, i, i+1)
+	c.queued--
+	metrics.AdmissionQueued.WithLabelValues(w.priority.String()).Dec()
+	return true
+}
+
+// abandon takes a call that stopped waiting out of the queue. It reports
+// false when the call was already admitted or displaced.
+func (c *Controller) abandon(w *waiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remove(w)
+}
+
+func (c *Controller) overloaded(msg string) error {
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(c.retryDelay),
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,185 @@
+// Package attempts counts the extra attempts client calls needed, per method
+// and kind:
+//
+//   - "transparent": gRPC repeated an attempt by itself because the request
+//     never reached the server application, e.g. the connection went away
+//     before the stream started;
+//   - "policy": gRPC retried per the retryPolicy of the service config;
+//   - "application": the retry interceptor repeated the call after an error
+//     with RetryInfo.
+//
+// The counts go to Prometheus and to a summary printed when the client exits.
+// gRPC-Go does not implement the hedgingPolicy of the service config, and
+// the course clients do not hedge, so there are no hedged attempts to count.
+package attempts
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+// Kinds of extra attempts.
+const (
+	Transparent = "transparent"
+	Policy      = "policy"
+	Application = "application"
+)
+
+// Summary counts the calls and their extra attempts per method.
+type Summary struct {
+	mu      sync.Mutex
+	methods map[string]*counts
+}
+
+type counts struct {
+	calls int
+	extra map[string]int
+}
+
+// NewSummary creates an empty Summary.
+func NewSummary() *Summary {
+	return &Summary{methods: make(map[string]*counts)}
+}
+
+// Record counts one extra attempt of kind for method.
+func (s *Summary) Record(method, kind string) {
+	metrics.ClientRetries.WithLabelValues(method, kind).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(method).extra[kind]++
+}
+
+// OnRetry records an application retry; it fits retry.Policy.OnRetry.
+func (s *Summary) OnRetry(_ context.Context, method string) {
+	s.Record(method, Application)
+}
+
+// get must be called with s.mu locked.
+func (s *Summary) get(method string) *counts {
+	c, ok := s.methods[method]
+	if !ok {
+		c = &counts{extra: make(map[string]int)}
+		s.methods[method] = c
+	}
+	return c
+}
+
+// call tracks the attempts gRPC makes for one call.
+type call struct {
+	method string
+	mu     sync.Mutex
+	begun  int
+}
+
+type callKey struct{}
+
+func (s *Summary) start(ctx context.Context, method string) context.Context {
+	s.mu.Lock()
+	s.get(method).calls++
+	s.mu.Unlock()
+
+	return context.WithValue(ctx, callKey{}, &call{method: method})
+}
+
+// UnaryClientInterceptor marks the start of every gRPC call. It goes after
+// the retry interceptor, so every application attempt is a call of its own.
+func (s *Summary) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(s.start(ctx, method), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor marks the start of every stream.
+func (s *Summary) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(s.start(ctx, method), desc, cc, method, opts...)
+	}
+}
+
+// StatsHandler counts the attempts gRPC makes within a call: every attempt
+// begins with a stats.Begin, and all but the first are transparent or
+// policy retries.
+func (s *Summary) StatsHandler() stats.Handler {
+	return handler{s}
+}
+
+type handler struct {
+	s *Summary
+}
+
+func (h handler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h handler) HandleRPC(ctx context.Context, st stats.RPCStats) {
+	begin, ok := st.(*stats.Begin)
+	if !ok || !begin.Client {
+		return
+	}
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.begun++
+	first := c.begun == 1
+	c.mu.Unlock()
+
+	switch {
+	case begin.IsTransparentRetryAttempt:
+		h.s.Record(c.method, Transparent)
+	case !first:
+		h.s.Record(c.method, Policy)
+	}
+}
+
+func (h handler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h handler) HandleConn(context.Context, stats.ConnStats) {}
+
+// Log prints the extra attempts of every method that needed any.
+func (s *Summary) Log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	methods := make([]string, 0, len(s.methods))
+	for m, c := range s.methods {
+		if len(c.extra) > 0 {
+			methods = append(methods, m)
+		}
+	}
+	slices.Sort(methods)
+
+	for _, m := range methods {
+		c := s.methods[m]
+		// every application attempt went through the interceptor as a call
+		log.Printf("[ATTEMPTS] %s: %d calls, extra attempts: %d application, %d transparent, %d policy",
+			m, c.calls-c.extra[Application], c.extra[Application], c.extra[Transparent], c.extra[Policy])
+	}
+}
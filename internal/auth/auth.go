@@ -0,0 +1,200 @@
+// Package auth guards selected services and methods. Authentication and
+// authorization are kept apart: an IdentityProvider tells who makes a call
+// from its credentials, a static API key (APIKeys), a JWT (JWT) or the
+// subject of a client certificate (ClientCert), and the Guard checks the
+// roles of that identity against its rules. The rules do not change with
+// the provider, so the three auth lessons share one authorization layer.
+// Services without a rule stay open, but their callers are identified too
+// when they send credentials: limits and quotas count every caller by
+// subject (see Caller), not only the callers of the protected methods.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+)
+
+// Key is the metadata key of the credentials.
+const Key = "authorization"
+
+const bearerPrefix = "Bearer "
+
+// ErrNoCredentials is returned by an IdentityProvider for a call that
+// carries no credentials it knows.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is who makes a call.
+type Identity struct {
+	Subject string
+	Roles   []string
+	// Tenant is the tenant the credentials were issued to, "" for none.
+	// Unlike the tenant of the call context, the client cannot pick it.
+	Tenant string
+}
+
+// HasRole reports whether the identity has one of roles.
+func (i Identity) HasRole(roles ...string) bool {
+	for _, r := range roles {
+		if slices.Contains(i.Roles, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityProvider establishes the identity of the caller from the
+// credentials of the call. It returns ErrNoCredentials when the call has
+// none and another error when they are invalid; the error text goes to the
+// client.
+type IdentityProvider interface {
+	Identify(ctx context.Context) (Identity, error)
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity the Guard established for the call.
+// Calls to open services without valid credentials have none.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Caller returns the key limits and quotas count a caller under:
+// "subject:<subject>" for a call the Guard identified and "ip:<address>"
+// for the others: calls without credentials or with invalid ones to an open
+// method, and every call of a server without the auth interceptor or with
+// it after the limits. Nothing the client merely claims, like the tenant of
+// the call context, goes into it: a client could get a fresh budget with
+// every made-up value.
+func Caller(ctx context.Context) string {
+	if id, ok := FromContext(ctx); ok {
+		return "subject:" + id.Subject
+	}
+	return "ip:" + ratelimit.PeerKey(ctx)
+}
+
+// Rules map a full service name, like "api.admin.v1.AdminAPI", or a full
+// method name, like "/api.v2.EchoAPI/CancelOrder", to the roles allowed to
+// call it: one of them is enough. A rule of a method takes precedence over
+// the one of its service.
+type Rules map[string][]string
+
+// roles returns the roles allowed to call method, false for an open method.
+func (r Rules) roles(method string) ([]string, bool) {
+	if roles, ok := r[method]; ok {
+		return roles, true
+	}
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	roles, ok := r[service]
+	return roles, ok
+}
+
+// Guard identifies the callers of every method and checks the identity of
+// the calls to the methods of its rules.
+type Guard struct {
+	provider IdentityProvider
+	rules    Rules
+}
+
+// NewGuard protects the methods of rules with the identities of provider.
+func NewGuard(provider IdentityProvider, rules Rules) *Guard {
+	return &Guard{provider: provider, rules: rules}
+}
+
+// check returns the context of a call that may go through, with the
+// identity of its caller if it has one.
+func (g *Guard) check(ctx context.Context, method string) (context.Context, error) {
+	roles, ok := g.rules.roles(method)
+	id, err := g.provider.Identify(ctx)
+	if !ok {
+		// an open method: anyone may call it, an identity only tells the
+		// limits whom to count the call for
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				logctx.Logger(ctx).Printf("[AUTH] %s: %v, calling as anonymous", method, err)
+			}
+			return ctx, nil
+		}
+		return context.WithValue(ctx, identityKey{}, id), nil
+	}
+
+	if errors.Is(err, ErrNoCredentials) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		logctx.Logger(ctx).Printf("[AUTH] %s: %v", method, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !id.HasRole(roles...) {
+		logctx.Logger(ctx).Printf("[AUTH] %s: %s has none of the roles %s", method, id.Subject, strings.Join(roles, ", "))
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires one of the roles %s", method, strings.Join(roles, ", "))
+	}
+	return context.WithValue(ctx, identityKey{}, id), nil
+}
+
+// UnaryServerInterceptor rejects calls to the protected methods without a
+// valid identity with one of the roles of their rule. Calls to the other
+// methods go through with the identity of their caller, if it has one.
+func (g *Guard) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := g.check(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func (g *Guard) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := g.check(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream carries the identity to the handler.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// bearer returns the token of the "authorization: Bearer <token>" header.
+func bearer(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(Key)
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: missing %q header", ErrNoCredentials, Key)
+	}
+	token, ok := strings.CutPrefix(values[0], bearerPrefix)
+	if !ok {
+		return "", fmt.Errorf("%q header is not a bearer token", Key)
+	}
+	return token, nil
+}
+
+// Credentials returns the header value a client sends for token, an API key
+// or a JWT.
+func Credentials(token string) string {
+	return bearerPrefix + token
+}
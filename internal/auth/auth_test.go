@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/url"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// caller calls method through the Guard with token, "" for none, and
+// returns auth.Caller as the handler sees it.
+func caller(t *testing.T, g *Guard, method, token string) (string, error) {
+	t.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(Key, Credentials(token)))
+	}
+	var got string
+	_, err := g.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, _ any) (any, error) {
+			got = Caller(ctx)
+			return nil, nil
+		})
+	return got, err
+}
+
+func TestGuard(t *testing.T) {
+	g := NewGuard(
+		NewAPIKeys(map[string]Identity{
+			"admin-key": {Subject: "admin", Roles: []string{"admin"}},
+			"user-key":  {Subject: "user"},
+		}),
+		Rules{"api.admin.v1.AdminAPI": {"admin"}},
+	)
+	const (
+		open      = "/api.v2.EchoAPI/CreateOrders"
+		protected = "/api.admin.v1.AdminAPI/GetMaintenance"
+	)
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		code   codes.Code
+		caller string
+	}{
+		{name: "open method identifies the caller", method: open, token: "user-key", caller: "subject:user"},
+		{name: "open method without credentials", method: open, caller: "ip:unknown"},
+		{name: "open method with invalid credentials", method: open, token: "wrong", caller: "ip:unknown"},
+		{name: "protected method", method: protected, token: "admin-key", caller: "subject:admin"},
+		{name: "protected method without credentials", method: protected, code: codes.Unauthenticated},
+		{name: "protected method with invalid credentials", method: protected, token: "wrong", code: codes.Unauthenticated},
+		{name: "protected method without the role", method: protected, token: "user-key", code: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := caller(t, g, tt.method, tt.token)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v", code, tt.code)
+			}
+			if got != tt.caller {
+				t.Errorf("caller = %q, want %q", got, tt.caller)
+			}
+		})
+	}
+}
+
+func TestCertTenant(t *testing.T) {
+	tests := []struct {
+		uris []string
+		want string
+	}{
+		{uris: []string{"tenant:acme"}, want: "acme"},
+		{uris: []string{"spiffe://course-grpc/client", "tenant:acme", "tenant:other"}, want: "acme"},
+		{uris: []string{"tenant:"}},
+		{},
+	}
+	for _, tt := range tests {
+		cert := &x509.Certificate{}
+		for _, s := range tt.uris {
+			u, err := url.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert.URIs = append(cert.URIs, u)
+		}
+		if got := certTenant(cert); got != tt.want {
+			t.Errorf("certTenant(%v) = %q, want %q", tt.uris, got, tt.want)
+		}
+	}
+}
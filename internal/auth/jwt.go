@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWT identifies callers by JSON Web Tokens sent as bearer tokens and signed
+// with HS256, the shared secret scheme: the subject is the "sub" claim, the
+// roles are the "roles" claim and the tenant is the "tenant" claim. Unlike an API key a token expires and is
+// issued per user by whoever knows the secret, e.g. with IssueJWT.
+type JWT struct {
+	secret   []byte
+	issuer   string
+	audience string
+	// leeway tolerates clocks a bit apart in exp and nbf
+	leeway time.Duration
+	now    func() time.Time
+}
+
+// jwtLeeway is how far the clocks of the issuer and the server may be apart.
+const jwtLeeway = 30 * time.Second
+
+// NewJWT creates the provider of the tokens signed with secret. A non-empty
+// issuer or audience must match the "iss" or "aud" claim.
+func NewJWT(secret []byte, issuer, audience string) *JWT {
+	return &JWT{secret: secret, issuer: issuer, audience: audience, leeway: jwtLeeway, now: time.Now}
+}
+
+// claims are the claims of the tokens, the registered ones, "roles" and
+// "tenant".
+type claims struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// audience is the "aud" claim, a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+func (p *JWT) Identify(ctx context.Context) (Identity, error) {
+	token, err := bearer(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	c, err := p.verify(token)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid token: %w", err)
+	}
+	return Identity{Subject: c.Subject, Roles: c.Roles, Tenant: c.Tenant}, nil
+}
+
+func (p *JWT) verify(token string) (claims, error) {
+	var c claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, errors.New("not a JWT")
+	}
+
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return c, fmt.Errorf("header: %w", err)
+	}
+	// only the algorithm of the server counts, never the one of the token:
+	// "none" or another key type would let a client sign its own tokens
+	if h.Alg != "HS256" {
+		return c, fmt.Errorf("algorithm %q, want HS256", h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, errors.New("malformed signature")
+	}
+	if !hmac.Equal(sig, p.sign(parts[0]+"."+parts[1])) {
+		return c, errors.New("bad signature")
+	}
+
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return c, fmt.Errorf("claims: %w", err)
+	}
+	now := p.now()
+	if c.ExpiresAt != 0 && now.After(time.Unix(c.ExpiresAt, 0).Add(p.leeway)) {
+		return c, fmt.Errorf("expired at %s", time.Unix(c.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	if c.NotBefore != 0 && now.Add(p.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return c, fmt.Errorf("not valid before %s", time.Unix(c.NotBefore, 0).UTC().Format(time.RFC3339))
+	}
+	if p.issuer != "" && c.Issuer != p.issuer {
+		return c, fmt.Errorf("issuer %q, want %q", c.Issuer, p.issuer)
+	}
+	if p.audience != "" && !slices.Contains(c.Audience, p.audience) {
+		return c, fmt.Errorf("audience %v does not include %q", []string(c.Audience), p.audience)
+	}
+	if c.Subject == "" {
+		return c, errors.New("no subject")
+	}
+	return c, nil
+}
+
+func (p *JWT) sign(signed string) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed base64")
+	}
+	return json.Unmarshal(data, v)
+}
+
+// IssueJWT returns an HS256 token signed with secret for subject with roles,
+// valid for ttl, with the issuer and audience the server of NewJWT expects.
+func IssueJWT(secret []byte, subject string, roles []string, issuer, aud string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	c := claims{
+		Subject:   subject,
+		Roles:     roles,
+		Issuer:    issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	if aud != "" {
+		c.Audience = audience{aud}
+	}
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := (&JWT{secret: secret}).sign(signed)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
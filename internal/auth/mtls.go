@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientCert identifies callers by the common name of their client
+// certificate, verified by the TLS handshake of an mTLS server (see
+// tlsconfig.Server). The tenant is the URI SAN "tenant:<name>" of the
+// certificate, so the CA decides it along with the name. The call carries no credentials of its own, so there is
+// nothing to steal from the metadata; on a plaintext server every call has
+// ErrNoCredentials.
+type ClientCert struct {
+	roles map[string][]string
+}
+
+// NewClientCert creates the provider; roles maps the common names of the
+// certificates to the roles they grant. A verified certificate of another
+// name is an identity without roles.
+func NewClientCert(roles map[string][]string) *ClientCert {
+	return &ClientCert{roles: roles}
+}
+
+func (p *ClientCert) Identify(ctx context.Context) (Identity, error) {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: unknown peer", ErrNoCredentials)
+	}
+	tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: plaintext connection, no client certificate", ErrNoCredentials)
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return Identity{}, fmt.Errorf("%w: no verified client certificate", ErrNoCredentials)
+	}
+	cert := chains[0][0]
+	name := cert.Subject.CommonName
+	return Identity{Subject: name, Roles: p.roles[name], Tenant: certTenant(cert)}, nil
+}
+
+// TenantScheme is the scheme of the URI SAN naming the tenant of a client
+// certificate, e.g. "tenant:acme".
+const TenantScheme = "tenant"
+
+// certTenant returns the tenant of the first "tenant:" URI SAN of cert.
+func certTenant(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == TenantScheme && u.Opaque != "" {
+			return u.Opaque
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+// Package backpressure watches how full the queues of streams are. A queue
+// that fills up means the stage after it cannot keep up: the workers of an
+// async bidi stream are too few for what the client sends, or the client
+// does not read its responses. The queue absorbs a burst, but once it stays
+// full the stream slows the client down, and soon after its messages wait
+// longer than anyone wants.
+//
+// A Watcher samples the queues of every open stream, adds them up in the
+// gauges course_grpc_stream_queue_depth and course_grpc_stream_queue_capacity
+// and logs a warning when a queue stays at or above a share of its capacity
+// for a while:
+//
+//	[BACKPRESSURE] /api.stream.v1.EchoService/EchoBidirectionalStreamAsync: queue buffer at 10/10 for 5s
+package backpressure
+
+import (
+	"context"
+	"time"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// Defaults of the warning.
+const (
+	DefaultThreshold = 0.8
+	DefaultHold      = 5 * time.Second
+)
+
+// sampleInterval is how often the queues of a stream are sampled.
+const sampleInterval = time.Second
+
+// Watcher samples the queues of streams. It is safe for concurrent use.
+type Watcher struct {
+	threshold float64
+	hold      time.Duration
+}
+
+// New creates a watcher that warns about a queue filled to threshold, a
+// share of its capacity, for hold. A threshold of 0 only keeps the gauges.
+func New(threshold float64, hold time.Duration) *Watcher {
+	return &Watcher{threshold: threshold, hold: hold}
+}
+
+// Watch samples queues, e.g. Pipeline.Queues of the pipeline of a stream of
+// method, until ctx is done, and then takes them out of the gauges.
+func (w *Watcher) Watch(ctx context.Context, method string, queues func() []streams.Queue) {
+	go w.watch(ctx, method, queues)
+}
+
+// sample is what a queue last added to the gauges.
+type sample struct {
+	name            string
+	depth, capacity int
+	// full is when the queue reached the threshold, zero below it
+	full   time.Time
+	warned bool
+}
+
+func (w *Watcher) watch(ctx context.Context, method string, queues func() []streams.Queue) {
+	logger := logctx.Logger(ctx)
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	var last []sample
+	defer func() {
+		for _, s := range last {
+			metrics.StreamQueueDepth.WithLabelValues(method, s.name).Sub(float64(s.depth))
+			metrics.StreamQueueCapacity.WithLabelValues(method, s.name).Sub(float64(s.capacity))
+		}
+	}()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			qs := queues()
+			for _, q := range qs[len(last):] {
+				last = append(last, sample{name: q.Name})
+			}
+			for i, q := range qs {
+				s := &last[i]
+				metrics.StreamQueueDepth.WithLabelValues(method, q.Name).Add(float64(q.Len - s.depth))
+				metrics.StreamQueueCapacity.WithLabelValues(method, q.Name).Add(float64(q.Cap - s.capacity))
+				s.depth, s.capacity = q.Len, q.Cap
+
+				if !w.full(q) {
+					s.full, s.warned = time.Time{}, false
+					continue
+				}
+				if s.full.IsZero() {
+					s.full = now
+				}
+				if !s.warned && now.Sub(s.full) >= w.hold {
+					s.warned = true
+					metrics.StreamQueueSaturated.WithLabelValues(method, q.Name).Inc()
+					logger.Printf("[BACKPRESSURE] %s: queue %s at %d/%d for %v",
+						method, q.Name, q.Len, q.Cap, now.Sub(s.full).Round(time.Second))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Watcher) full(q streams.Queue) bool {
+	return w.threshold > 0 && q.Cap > 0 && float64(q.Len) >= w.threshold*float64(q.Cap)
+}
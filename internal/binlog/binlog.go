@@ -0,0 +1,319 @@
+// Package binlog writes gRPC binary logs: every header, message and trailer
+// of every call as a grpc.binarylog.v1.GrpcLogEntry, the format defined by
+// https://github.com/grpc/proposal/blob/master/A16-binary-logging.md.
+//
+// grpc-go can write these logs itself, but only when GRPC_BINARY_LOG_FILTER
+// is set before the process starts. Handler produces the same entries from a
+// stats.Handler, so logging can be switched on by a flag. Files use the
+// framing of grpc-go's file sink (a 4-byte big-endian length before every
+// entry) and are read back by Reader and cmd/binlogcat.
+package binlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/binarylog"
+	binlogpb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MaxPayload is how many bytes of a message are logged, longer ones are
+// truncated and marked so.
+const MaxPayload = 64 * 1024
+
+// FileSink writes entries to a file. It implements binarylog.Sink, so it can
+// also be passed to binarylog.SetSink.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+var _ binarylog.Sink = &FileSink{}
+
+// Create creates or truncates the log file at path.
+func Create(path string) (*FileSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create binary log: %w", err)
+	}
+	return &FileSink{file: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Write appends one entry.
+func (s *FileSink) Write(e *binlogpb.GrpcLogEntry) error {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close flushes and closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := errors.Join(s.w.Flush(), s.file.Close())
+	s.file = nil
+	return err
+}
+
+// Reader reads entries written by FileSink or by grpc-go's own file sink.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a Reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry or io.EOF at the end of the log.
+func (r *Reader) Next() (*binlogpb.GrpcLogEntry, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated entry header: %w", err)
+		}
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("truncated entry: %w", err)
+	}
+
+	e := &binlogpb.GrpcLogEntry{}
+	if err := proto.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("decode entry: %w", err)
+	}
+	return e, nil
+}
+
+// Handler is a stats.Handler that logs every call into a sink. Failures to
+// write are ignored, logging must not break the calls.
+type Handler struct {
+	sink   binarylog.Sink
+	lastID atomic.Uint64
+}
+
+var _ stats.Handler = &Handler{}
+
+// NewHandler creates a Handler writing to sink.
+func NewHandler(sink binarylog.Sink) *Handler {
+	return &Handler{sink: sink}
+}
+
+type callKey struct{}
+
+// call is the state of one RPC.
+type call struct {
+	id      uint64
+	seq     atomic.Uint64
+	method  string
+	mu      sync.Mutex
+	trailer metadata.MD
+}
+
+func (h *Handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, callKey{}, &call{id: h.lastID.Add(1), method: info.FullMethodName})
+}
+
+func (h *Handler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+
+	switch ev := s.(type) {
+	case *stats.OutHeader:
+		if ev.Client {
+			h.write(c, ev.Client, clientHeader(ctx, c.method, ev.Header, ev.RemoteAddr))
+		} else {
+			h.write(c, ev.Client, serverHeader(ev.Header, nil))
+		}
+	case *stats.InHeader:
+		if ev.Client {
+			h.write(c, ev.Client, serverHeader(ev.Header, ev.RemoteAddr))
+		} else {
+			h.write(c, ev.Client, clientHeader(ctx, c.method, ev.Header, ev.RemoteAddr))
+		}
+	case *stats.OutPayload:
+		h.write(c, ev.Client, message(ev.Client, ev.Payload))
+	case *stats.InPayload:
+		h.write(c, ev.Client, message(!ev.Client, ev.Payload))
+	case *stats.OutTrailer:
+		c.mu.Lock()
+		c.trailer = ev.Trailer
+		c.mu.Unlock()
+	case *stats.InTrailer:
+		c.mu.Lock()
+		c.trailer = ev.Trailer
+		c.mu.Unlock()
+	case *stats.End:
+		c.mu.Lock()
+		md := c.trailer
+		c.mu.Unlock()
+		h.write(c, ev.Client, trailer(md, ev.Error))
+	}
+}
+
+func (h *Handler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *Handler) HandleConn(context.Context, stats.ConnStats) {}
+
+func (h *Handler) write(c *call, client bool, e *binlogpb.GrpcLogEntry) {
+	e.Timestamp = timestamppb.Now()
+	e.CallId = c.id
+	e.SequenceIdWithinCall = c.seq.Add(1)
+	e.Logger = binlogpb.GrpcLogEntry_LOGGER_SERVER
+	if client {
+		e.Logger = binlogpb.GrpcLogEntry_LOGGER_CLIENT
+	}
+	_ = h.sink.Write(e)
+}
+
+func clientHeader(ctx context.Context, method string, md metadata.MD, remote net.Addr) *binlogpb.GrpcLogEntry {
+	hdr := &binlogpb.ClientHeader{
+		Metadata:   toMetadata(md),
+		MethodName: method,
+	}
+	if vs := md.Get(":authority"); len(vs) > 0 {
+		hdr.Authority = vs[0]
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		hdr.Timeout = durationpb.New(max(0, time.Until(deadline)))
+	}
+
+	return &binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+		Payload: &binlogpb.GrpcLogEntry_ClientHeader{ClientHeader: hdr},
+		Peer:    toAddress(remote),
+	}
+}
+
+func serverHeader(md metadata.MD, remote net.Addr) *binlogpb.GrpcLogEntry {
+	return &binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+		Payload: &binlogpb.GrpcLogEntry_ServerHeader{ServerHeader: &binlogpb.ServerHeader{Metadata: toMetadata(md)}},
+		Peer:    toAddress(remote),
+	}
+}
+
+// message logs a payload; fromClient tells the direction.
+func message(fromClient bool, payload any) *binlogpb.GrpcLogEntry {
+	e := &binlogpb.GrpcLogEntry{Type: binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE}
+	if fromClient {
+		e.Type = binlogpb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE
+	}
+
+	var data []byte
+	if m, ok := payload.(proto.Message); ok {
+		data, _ = proto.Marshal(m)
+	}
+	msg := &binlogpb.Message{Length: uint32(len(data)), Data: data}
+	if len(data) > MaxPayload {
+		msg.Data = data[:MaxPayload]
+		e.PayloadTruncated = true
+	}
+
+	e.Payload = &binlogpb.GrpcLogEntry_Message{Message: msg}
+	return e
+}
+
+func trailer(md metadata.MD, err error) *binlogpb.GrpcLogEntry {
+	st := status.Convert(err)
+	t := &binlogpb.Trailer{
+		Metadata:      toMetadata(md),
+		StatusCode:    uint32(st.Code()),
+		StatusMessage: st.Message(),
+	}
+	if details := st.Proto().GetDetails(); len(details) > 0 {
+		t.StatusDetails, _ = proto.Marshal(st.Proto())
+	}
+
+	return &binlogpb.GrpcLogEntry{
+		Type:    binlogpb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+		Payload: &binlogpb.GrpcLogEntry_Trailer{Trailer: t},
+	}
+}
+
+// toMetadata converts md leaving out the pseudo headers and grpc- prefixed
+// keys, as grpc-go's binary logger does.
+func toMetadata(md metadata.MD) *binlogpb.Metadata {
+	out := &binlogpb.Metadata{}
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		for _, v := range vs {
+			out.Entry = append(out.Entry, &binlogpb.MetadataEntry{Key: k, Value: []byte(v)})
+		}
+	}
+	return out
+}
+
+func toAddress(addr net.Addr) *binlogpb.Address {
+	if addr == nil {
+		return nil
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return &binlogpb.Address{Type: binlogpb.Address_TYPE_UNKNOWN, Address: addr.String()}
+	}
+
+	a := &binlogpb.Address{Address: tcp.IP.String(), IpPort: uint32(tcp.Port)}
+	if tcp.IP.To4() != nil {
+		a.Type = binlogpb.Address_TYPE_IPV4
+	} else {
+		a.Type = binlogpb.Address_TYPE_IPV6
+	}
+	return a
+}
+
+// FormatAddress prints an Address logged by Handler.
+func FormatAddress(a *binlogpb.Address) string {
+	switch a.GetType() {
+	case binlogpb.Address_TYPE_IPV4, binlogpb.Address_TYPE_IPV6:
+		return net.JoinHostPort(a.GetAddress(), strconv.Itoa(int(a.GetIpPort())))
+	default:
+		return a.GetAddress()
+	}
+}
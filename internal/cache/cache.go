@@ -0,0 +1,169 @@
+// Package cache keeps the responses of unary methods without side effects,
+// those marked with idempotency_level = NO_SIDE_EFFECTS in their proto, for
+// a while and answers repeated requests from memory instead of calling the
+// handler again.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// StatusKey is the response header telling whether the response came from
+// the cache: "hit", "miss", or "bypass" for a request that is not cached.
+const StatusKey = "x-cache"
+
+// Cache is a size-bounded LRU of responses that expire after a TTL.
+type Cache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	// most recently used first
+	lru *list.List
+	// whether a method may be cached, looked up in the descriptors once
+	cacheable map[string]bool
+}
+
+type entry struct {
+	key     [sha256.Size]byte
+	resp    proto.Message
+	expires time.Time
+}
+
+// New creates a cache that keeps up to size responses for ttl each.
+func New(ttl time.Duration, size int) *Cache {
+	return &Cache{
+		ttl:       ttl,
+		size:      size,
+		entries:   make(map[[sha256.Size]byte]*list.Element),
+		lru:       list.New(),
+		cacheable: make(map[string]bool),
+	}
+}
+
+// UnaryServerInterceptor answers cacheable calls from the cache and stores
+// the successful responses of the misses. Every call gets the StatusKey
+// header.
+func (c *Cache) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		msg, ok := req.(proto.Message)
+		if !ok || !c.isCacheable(info.FullMethod) {
+			_ = grpc.SetHeader(ctx, metadata.Pairs(StatusKey, "bypass"))
+			return handler(ctx, req)
+		}
+
+		key, err := requestKey(info.FullMethod, msg)
+		if err != nil {
+			_ = grpc.SetHeader(ctx, metadata.Pairs(StatusKey, "bypass"))
+			return handler(ctx, req)
+		}
+		if resp := c.get(key); resp != nil {
+			logctx.Logger(ctx).Printf("[CACHE] %s: hit", info.FullMethod)
+			_ = grpc.SetHeader(ctx, metadata.Pairs(StatusKey, "hit"))
+			return resp, nil
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(StatusKey, "miss"))
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if m, ok := resp.(proto.Message); ok {
+			c.put(key, m)
+		}
+		return resp, nil
+	}
+}
+
+// isCacheable reports whether the method is marked NO_SIDE_EFFECTS.
+func (c *Cache) isCacheable(fullMethod string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ok, seen := c.cacheable[fullMethod]; seen {
+		return ok
+	}
+	// "/pkg.Service/Method" -> "pkg.Service.Method"
+	name := protoreflect.FullName(strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1))
+	ok := false
+	if d, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		if md, isMethod := d.(protoreflect.MethodDescriptor); isMethod {
+			opts, _ := md.Options().(*descriptorpb.MethodOptions)
+			ok = opts.GetIdempotencyLevel() == descriptorpb.MethodOptions_NO_SIDE_EFFECTS
+		}
+	}
+	c.cacheable[fullMethod] = ok
+	return ok
+}
+
+// requestKey hashes the method with the deterministic encoding of the
+// request, so equal requests share a key.
+func requestKey(method string, req proto.Message) ([sha256.Size]byte, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(b)
+
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key, nil
+}
+
+// get returns a copy of the live response for key, or nil.
+func (c *Cache) get(key [sha256.Size]byte) proto.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	// handlers and interceptors may modify what they return
+	return proto.Clone(e.resp)
+}
+
+func (c *Cache) put(key [sha256.Size]byte, resp proto.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &entry{key: key, resp: proto.Clone(resp), expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+
+	// the least recently used entries go first
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
@@ -0,0 +1,136 @@
+// Package callctx carries a CallContext proto (tenant, feature flags) in the
+// binary metadata key Key. The client marshals the message and gRPC sends the
+// bytes base64 encoded because the key ends in "-bin"; the server interceptor
+// gets the raw bytes back, unmarshals them and stores the message in the
+// context of the call.
+//
+// Metadata is neither compressed nor streamed: every header of a call has to
+// fit into the HTTP/2 header list limit of the peer (16 KiB by default in
+// grpc-go), so the message is capped at MaxSize on both sides.
+package callctx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	callctxpb "github.com/easyp-tech/course-grpc/pkg/api/callctx/v1"
+)
+
+// Key is the metadata key of the call context. Keys starting with "grpc-"
+// are reserved for gRPC itself, so the key has an "x-" prefix.
+const Key = "x-call-context-bin"
+
+// MaxSize is the largest marshaled call context accepted, in bytes.
+const MaxSize = 1024
+
+// LogKey is the log field holding the tenant.
+const LogKey = "tenant"
+
+type ctxKey struct{}
+
+// NewContext stores cc in ctx and adds its tenant to the log fields.
+func NewContext(ctx context.Context, cc *callctxpb.CallContext) context.Context {
+	ctx = context.WithValue(ctx, ctxKey{}, cc)
+	if t := cc.GetTenant(); t != "" {
+		ctx = logctx.With(ctx, LogKey, t)
+	}
+	return ctx
+}
+
+// FromContext returns the call context the server received.
+func FromContext(ctx context.Context) (*callctxpb.CallContext, bool) {
+	cc, ok := ctx.Value(ctxKey{}).(*callctxpb.CallContext)
+	return cc, ok
+}
+
+// Marshal encodes cc for the metadata, failing when it is over MaxSize.
+func Marshal(cc *callctxpb.CallContext) (string, error) {
+	b, err := proto.Marshal(cc)
+	if err != nil {
+		return "", fmt.Errorf("marshal call context: %w", err)
+	}
+	if len(b) > MaxSize {
+		return "", fmt.Errorf("call context of %d bytes is larger than %d", len(b), MaxSize)
+	}
+	// the raw bytes go into the metadata, gRPC encodes -bin values itself
+	return string(b), nil
+}
+
+// incoming decodes the call context sent by the caller, if any.
+func incoming(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vs := md.Get(Key)
+	if len(vs) == 0 {
+		return ctx, nil
+	}
+	if len(vs[0]) > MaxSize {
+		return ctx, status.Errorf(codes.InvalidArgument, "%s: %d bytes is larger than %d", Key, len(vs[0]), MaxSize)
+	}
+
+	cc := &callctxpb.CallContext{}
+	if err := proto.Unmarshal([]byte(vs[0]), cc); err != nil {
+		return ctx, status.Errorf(codes.InvalidArgument, "%s: %v", Key, err)
+	}
+	ctx = NewContext(ctx, cc)
+	logctx.Logger(ctx).Printf("[CALL CONTEXT] tenant %q, features [%s]", cc.GetTenant(), strings.Join(cc.GetFeatures(), ", "))
+	return ctx, nil
+}
+
+// UnaryServerInterceptor decodes the call context of every unary call. A
+// malformed or oversized one fails the call with InvalidArgument.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := incoming(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor decodes the call context of every stream.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := incoming(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor sends cc with every call.
+func UnaryClientInterceptor(cc *callctxpb.CallContext) (grpc.UnaryClientInterceptor, error) {
+	value, err := Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, Key, value), method, req, reply, conn, opts...)
+	}, nil
+}
+
+// serverStream replaces the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
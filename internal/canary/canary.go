@@ -0,0 +1,181 @@
+// Package canary splits the calls of one channel between a primary and a
+// canary target by percentage, e.g. 10% of the traffic to a new release.
+// The split is a balancer: the resolver hands it the addresses of both
+// targets, marked with their role, and its picker sends a call to the canary
+// with the given probability and to the primary otherwise. A call picked for
+// the canary carries the x-canary header, so the server and its logs can
+// tell the canary traffic apart. When one of the targets has no ready
+// connection, the other one takes all calls.
+//
+// Every call is counted per target when it ends, so a canary that fails
+// more often than the primary shows up in the summary of the client.
+package canary
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// Name is the name of the balancer.
+const Name = "canary_split"
+
+// Key is the header of the calls sent to the canary.
+const Key = "x-canary"
+
+// Roles of the targets.
+const (
+	Primary = "primary"
+	Canary  = "canary"
+)
+
+// ServiceConfig selects the balancer of Register.
+const ServiceConfig = `{"loadBalancingConfig": [{"` + Name + `": {}}]}`
+
+type roleKey struct{}
+
+// Resolver returns a resolver of the primary and canary addresses (host:port)
+// and the target to dial with it.
+func Resolver(primary, canary string) (*manual.Resolver, string) {
+	r := manual.NewBuilderWithScheme("canary")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{
+		{Addr: primary, Attributes: attributes.New(roleKey{}, Primary)},
+		{Addr: canary, Attributes: attributes.New(roleKey{}, Canary)},
+	}})
+	return r, r.Scheme() + ":///" + primary
+}
+
+func roleOf(addr resolver.Address) string {
+	if role, _ := addr.Attributes.Value(roleKey{}).(string); role == Canary {
+		return Canary
+	}
+	return Primary
+}
+
+// Register registers the balancer that sends percent (0 to 100) of the calls
+// to the canary. The returned Stats count the calls of every target.
+func Register(percent float64) *Stats {
+	stats := &Stats{targets: make(map[string]*targetStats)}
+	balancer.Register(base.NewBalancerBuilder(Name, &pickerBuilder{percent: percent, stats: stats}, base.Config{}))
+	return stats
+}
+
+type pickerBuilder struct {
+	percent float64
+	stats   *Stats
+}
+
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &picker{percent: b.percent, stats: b.stats}
+	for sc, sci := range info.ReadySCs {
+		t := target{sc: sc, addr: sci.Address.Addr, role: roleOf(sci.Address)}
+		if t.role == Canary {
+			p.canary = append(p.canary, t)
+		} else {
+			p.primary = append(p.primary, t)
+		}
+	}
+	return p
+}
+
+type target struct {
+	sc   balancer.SubConn
+	addr string
+	role string
+}
+
+type picker struct {
+	percent float64
+	stats   *Stats
+	primary []target
+	canary  []target
+	next    atomic.Uint32
+}
+
+func (p *picker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	targets := p.primary
+	if len(p.canary) > 0 && (len(p.primary) == 0 || rand.Float64()*100 < p.percent) {
+		targets = p.canary
+	}
+	t := targets[int(p.next.Add(1))%len(targets)]
+
+	res := balancer.PickResult{
+		SubConn: t.sc,
+		Done:    func(info balancer.DoneInfo) { p.stats.record(t, info.Err) },
+	}
+	if t.role == Canary {
+		res.Metadata = metadata.Pairs(Key, "true")
+	}
+	return res, nil
+}
+
+type targetStats struct {
+	role   string
+	calls  int
+	errors int
+}
+
+// Stats counts the calls and errors of every target. It is safe for
+// concurrent use.
+type Stats struct {
+	mu      sync.Mutex
+	targets map[string]*targetStats
+}
+
+func (s *Stats) record(t target, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.targets[t.addr]
+	if !ok {
+		st = &targetStats{role: t.role}
+		s.targets[t.addr] = st
+	}
+	st.calls++
+	if err != nil {
+		st.errors++
+	}
+}
+
+// Log prints the share of the calls and the error rate of every target.
+func (s *Stats) Log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	addrs := make([]string, 0, len(s.targets))
+	for addr, st := range s.targets {
+		addrs = append(addrs, addr)
+		total += st.calls
+	}
+	// the primary first
+	sort.Slice(addrs, func(i, j int) bool {
+		return s.targets[addrs[i]].role > s.targets[addrs[j]].role
+	})
+
+	for _, addr := range addrs {
+		st := s.targets[addr]
+		log.Printf("[CANARY] %s %s: %d calls (%s of all), %s errors",
+			st.role, addr, st.calls, percent(st.calls, total), percent(st.errors, st.calls))
+	}
+}
+
+func percent(n, of int) string {
+	if of == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+}
@@ -0,0 +1,86 @@
+// Package chain helps a handler that calls another server while serving a
+// request. The deadline and cancellation of the incoming call need no help:
+// a downstream call made with the incoming context carries the remaining
+// deadline in grpc-timeout and is canceled together with it. Metadata is
+// different. gRPC never turns incoming metadata into outgoing, and copying
+// all of it would leak what belongs to one hop only: credentials
+// (authorization), idempotency keys, proxy headers. So the keys to forward
+// are listed explicitly.
+//
+// The trace and request ids are not in the list: the tracectx and requestid
+// client interceptors send them from the context, as for any other call.
+package chain
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/callctx"
+	"github.com/easyp-tech/course-grpc/internal/i18n"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+)
+
+// DefaultAllow are the keys forwarded by default: the call context with the
+// tenant, and the language of error messages.
+var DefaultAllow = []string{callctx.Key, i18n.Key}
+
+// ParseAllow parses a comma-separated list of metadata keys.
+func ParseAllow(s string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch {
+		case key == "":
+			continue
+		case strings.HasPrefix(key, "grpc-"), strings.HasPrefix(key, ":"):
+			return nil, fmt.Errorf("metadata key %q is reserved by gRPC and can not be forwarded", key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Outgoing returns ctx with the incoming metadata of the keys in allow
+// appended to its outgoing metadata, and the keys it forwarded.
+func Outgoing(ctx context.Context, allow []string) (context.Context, []string) {
+	in, _ := metadata.FromIncomingContext(ctx)
+	var forwarded []string
+	for _, key := range allow {
+		values := in.Get(key)
+		if len(values) == 0 {
+			continue
+		}
+		for _, v := range values {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, v)
+		}
+		forwarded = append(forwarded, key)
+	}
+	slices.Sort(forwarded)
+	return ctx, forwarded
+}
+
+// sentByInterceptors are the keys the client interceptors send from the
+// context.
+var sentByInterceptors = []string{requestid.Key, tracectx.TraceparentKey, tracectx.TracestateKey}
+
+// Dropped returns the incoming metadata keys that are not forwarded, for
+// logging. Keys of the transport and the ones the client interceptors send
+// anyway are left out.
+func Dropped(ctx context.Context, allow []string) []string {
+	in, _ := metadata.FromIncomingContext(ctx)
+	var dropped []string
+	for key := range in {
+		if strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, ":") || key == "content-type" ||
+			slices.Contains(allow, key) || slices.Contains(sentByInterceptors, key) {
+			continue
+		}
+		dropped = append(dropped, key)
+	}
+	slices.Sort(dropped)
+	return dropped
+}
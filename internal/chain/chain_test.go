@@ -0,0 +1,49 @@
+package chain
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParseAllow(t *testing.T) {
+	got, err := ParseAllow(" X-Tenant, ,accept-language")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x-tenant", "accept-language"}; !slices.Equal(got, want) {
+		t.Errorf("ParseAllow = %v, want %v", got, want)
+	}
+	for _, reserved := range []string{"grpc-timeout", ":authority"} {
+		if _, err := ParseAllow(reserved); err == nil {
+			t.Errorf("ParseAllow(%q) accepted a reserved key", reserved)
+		}
+	}
+}
+
+func TestOutgoing(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-tenant", "acme",
+		"accept-language", "ru",
+		"authorization", "Bearer secret",
+		"x-request-id", "r1",
+		"content-type", "application/grpc",
+	))
+	allow := []string{"x-tenant", "accept-language", "x-missing"}
+
+	out, forwarded := Outgoing(ctx, allow)
+	if want := []string{"accept-language", "x-tenant"}; !slices.Equal(forwarded, want) {
+		t.Errorf("forwarded = %v, want %v", forwarded, want)
+	}
+	md, _ := metadata.FromOutgoingContext(out)
+	if md.Get("x-tenant")[0] != "acme" || len(md.Get("authorization")) > 0 {
+		t.Errorf("outgoing metadata = %v, want x-tenant without authorization", md)
+	}
+
+	// the request id goes out through its interceptor, not as a dropped key
+	if got, want := Dropped(ctx, allow), []string{"authorization"}; !slices.Equal(got, want) {
+		t.Errorf("Dropped = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+// Package clientmeta reads the informational headers sent by the course
+// clients (client-timestamp, user-agent) on the server side.
+package clientmeta
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Header keys sent by the clients.
+const (
+	TimestampKey = "client-timestamp"
+	UserAgentKey = "user-agent"
+)
+
+// UnaryServerInterceptor logs the client's user agent and clock skew. With
+// require set, calls without a valid client-timestamp are rejected with
+// InvalidArgument.
+func UnaryServerInterceptor(require bool) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := inspect(ctx, info.FullMethod, require); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(require bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := inspect(ss.Context(), info.FullMethod, require); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func inspect(ctx context.Context, method string, require bool) error {
+	logger := logctx.Logger(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	userAgent := strings.Join(md.Get(UserAgentKey), " ")
+
+	values := md.Get(TimestampKey)
+	if len(values) == 0 {
+		logger.Printf("[CLIENT META] %s: user-agent=%q, no %s header", method, userAgent, TimestampKey)
+		if require {
+			return status.Errorf(codes.InvalidArgument, "missing required header %q", TimestampKey)
+		}
+		return nil
+	}
+
+	sent, err := time.Parse(time.RFC3339Nano, values[0])
+	if err != nil {
+		logger.Printf("[CLIENT META] %s: user-agent=%q, malformed %s %q", method, userAgent, TimestampKey, values[0])
+		if require {
+			return status.Errorf(codes.InvalidArgument, "header %q must be an RFC 3339 timestamp", TimestampKey)
+		}
+		return nil
+	}
+
+	// includes network latency, so small positive values are expected
+	skew := time.Since(sent)
+	logger.Printf("[CLIENT META] %s: user-agent=%q, clock skew %v", method, userAgent, skew.Round(time.Millisecond))
+	return nil
+}
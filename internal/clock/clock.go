@@ -0,0 +1,134 @@
+// Package clock abstracts the passing of time for the stream handlers and
+// the clients. They read the time and pause through a Clock instead of the
+// time package, so a test gives them a Fake: pauses then take no real time
+// and timing-dependent behavior happens exactly when the test advances the
+// clock.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits d or until ctx is done, whichever comes first, and returns
+	// ctx.Err() in the latter case.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fake is a clock that moves only when told to. It is safe for concurrent
+// use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	instant bool
+	waiters []waiter
+}
+
+type waiter struct {
+	at   time.Time
+	wake chan struct{}
+}
+
+// NewFake returns a clock standing at start; Sleep blocks until Advance
+// moves the clock past its end.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// NewInstant returns a clock standing at start whose Sleep moves it ahead
+// by d and returns at once, so code full of pauses runs without waiting.
+func NewInstant(start time.Time) *Fake {
+	return &Fake{now: start, instant: true}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil || d <= 0 {
+		return err
+	}
+	if f.instant {
+		f.Advance(d)
+		return nil
+	}
+
+	f.mu.Lock()
+	w := waiter{at: f.now.Add(d), wake: make(chan struct{})}
+	f.waiters = append(f.waiters, w)
+	f.mu.Unlock()
+
+	select {
+	case <-w.wake:
+		return nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		for i := range f.waiters {
+			if f.waiters[i].wake == w.wake {
+				f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+				break
+			}
+		}
+		f.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Advance moves the clock ahead by d and wakes the sleepers whose time has
+// come.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		close(w.wake)
+	}
+	f.waiters = waiting
+}
+
+// Sleepers returns how many goroutines are blocked in Sleep, so a test can
+// wait for the code under test to reach a pause before it advances.
+func (f *Fake) Sleepers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
@@ -0,0 +1,112 @@
+// Package compression registers the message compressors the course binaries
+// support, gzip from grpc-go and zstd implemented here, and turns a
+// -compression flag value into call options.
+//
+// Importing the package is enough for a server to accept requests in any of
+// the encodings; it answers in the encoding of the request.
+package compression
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Encoding names accepted by CallOptions.
+const (
+	Identity = "identity"
+	Gzip     = gzip.Name
+	Zstd     = "zstd"
+)
+
+func init() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// CallOptions returns the options that make a call compress its messages
+// with name. Identity sends them uncompressed.
+func CallOptions(name string) ([]grpc.CallOption, error) {
+	switch name {
+	case "", Identity:
+		return nil, nil
+	case Gzip, Zstd:
+		return []grpc.CallOption{grpc.UseCompressor(name)}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q, want %s, %s or %s", name, Identity, Gzip, Zstd)
+	}
+}
+
+// zstdCompressor implements encoding.Compressor. Encoders and decoders are
+// expensive to create, so they are pooled the same way grpc-go pools its gzip
+// writers.
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		enc, err = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		enc.Reset(w)
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, ok := c.decoders.Get().(*zstd.Decoder)
+	if !ok {
+		var err error
+		dec, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	} else if err := dec.Reset(r); err != nil {
+		c.decoders.Put(dec)
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	defer w.pool.Put(w.Encoder)
+	return w.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+// Read returns the decoder to the pool once the message is fully read.
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.Decoder == nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.Decoder)
+		r.Decoder = nil
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+// Package connlimit caps how many connections a server holds open, per
+// source IP and in total. The limit is enforced on accept: a connection over
+// the limit is closed right away, before TLS or HTTP/2 spend anything on it,
+// so a single runaway client cannot exhaust the server's file descriptors.
+package connlimit
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+// Rejection reasons, used as the reason label of
+// metrics.ConnectionsRejected.
+const (
+	ReasonPerIP = "per_ip"
+	ReasonTotal = "total"
+)
+
+// Stats is a snapshot of the listener counters.
+type Stats struct {
+	Active        int
+	RejectedPerIP uint64
+	RejectedTotal uint64
+}
+
+// Listener wraps a net.Listener and enforces the limits on Accept.
+type Listener struct {
+	net.Listener
+	perIP int
+	total int
+
+	mu     sync.Mutex
+	active map[string]int
+	stats  Stats
+}
+
+// NewListener limits l to perIP connections from one IP and total
+// connections overall. Zero disables a limit.
+func NewListener(l net.Listener, perIP, total int) *Listener {
+	return &Listener{
+		Listener: l,
+		perIP:    perIP,
+		total:    total,
+		active:   make(map[string]int),
+	}
+}
+
+// Accept returns the next connection within the limits. Connections over the
+// limits are closed and never returned.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := hostOf(c.RemoteAddr())
+		if reason := l.acquire(ip); reason != "" {
+			metrics.ConnectionsRejected.WithLabelValues(reason).Inc()
+			log.Printf("[CONNLIMIT] rejected connection from %s: %s limit reached", c.RemoteAddr(), reason)
+			c.Close()
+			continue
+		}
+
+		metrics.ConnectionsActive.Inc()
+		return &conn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// Stats returns the current counters.
+func (l *Listener) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+// acquire takes a slot for ip and returns the violated limit if there is
+// none left.
+func (l *Listener) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.total > 0 && l.stats.Active >= l.total {
+		l.stats.RejectedTotal++
+		return ReasonTotal
+	}
+	if l.perIP > 0 && l.active[ip] >= l.perIP {
+		l.stats.RejectedPerIP++
+		return ReasonPerIP
+	}
+
+	l.active[ip]++
+	l.stats.Active++
+	return ""
+}
+
+func (l *Listener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active[ip]--
+	if l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+	l.stats.Active--
+	metrics.ConnectionsActive.Dec()
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// conn gives its slot back on the first Close.
+type conn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
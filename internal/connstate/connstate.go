@@ -0,0 +1,96 @@
+// Package connstate follows the connectivity state of a grpc.ClientConn:
+// IDLE, CONNECTING, READY, TRANSIENT_FAILURE and SHUTDOWN. Clients use it to
+// log the transitions and reconnects, the transport events behind them like
+// GOAWAY and failed keepalive pings, the address sets their resolver
+// returns, and to hold back traffic until the connection is up.
+package connstate
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// Backoff configures WaitReady.
+type Backoff struct {
+	// Base is the first wait after a failure, doubled on every next one up
+	// to Max.
+	Base time.Duration
+	Max  time.Duration
+}
+
+// DefaultBackoff returns the backoff used by the course clients.
+func DefaultBackoff() Backoff {
+	return Backoff{Base: 500 * time.Millisecond, Max: 10 * time.Second}
+}
+
+// Watch logs every state change of conn until ctx is done or the
+// connection is closed. It blocks, run it in a goroutine.
+func Watch(ctx context.Context, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	log.Printf("[CONN] %s: %s", conn.Target(), state)
+	connected := state == connectivity.Ready
+
+	for conn.WaitForStateChange(ctx, state) {
+		next := conn.GetState()
+		log.Printf("[CONN] %s: %s -> %s", conn.Target(), state, next)
+		switch next {
+		case connectivity.Shutdown:
+			return
+		case connectivity.Ready:
+			if connected {
+				log.Printf("[CONN] %s: reconnected", conn.Target())
+			}
+			connected = true
+		}
+		state = next
+	}
+}
+
+// WaitReady makes conn connect and blocks until it is READY. Each time the
+// connection lands in TRANSIENT_FAILURE it waits according to b and then
+// asks grpc to reconnect right away instead of after its own backoff.
+func WaitReady(ctx context.Context, conn *grpc.ClientConn, b Backoff) error {
+	conn.Connect()
+
+	delay := b.Base
+	for attempt := 1; ; {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return grpc.ErrClientConnClosing
+		case connectivity.TransientFailure:
+			log.Printf("[CONN] %s: connection attempt %d failed, retrying in %v", conn.Target(), attempt, delay)
+
+			// grpc keeps reconnecting on its own, stop waiting as soon as
+			// one of its attempts succeeds
+			waitCtx, cancel := context.WithTimeout(ctx, delay)
+			changed := conn.WaitForStateChange(waitCtx, state)
+			cancel()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if changed {
+				continue
+			}
+
+			// the state stays TRANSIENT_FAILURE while grpc reconnects, so
+			// check it again instead of waiting for a change
+			attempt++
+			delay = min(2*delay, b.Max)
+			conn.ResetConnectBackoff()
+			continue
+		case connectivity.Idle:
+			conn.Connect()
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package connstate
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+// transportEvents are the parts of grpc's informational log lines that tell
+// why a connection went away.
+var transportEvents = []string{
+	// the server announced it is shutting down or is unhappy with the client
+	"GoAway", "goaway", "GOAWAY",
+	// a keepalive ping was not answered in time
+	"keepalive",
+	// every closed client transport, with the reason
+	"Closing:",
+}
+
+// LogTransportEvents makes grpc's own log report GOAWAY frames, failed
+// keepalive pings and closed connections as [CONN] lines. grpc has no API
+// for these events, it only logs them, so the logger of grpc is replaced:
+// errors are printed as before, of the informational lines only those
+// events are kept. Call it first thing in main, before grpc is used.
+func LogTransportEvents() {
+	grpclog.SetLoggerV2(eventLogger{errors: log.New(os.Stderr, "", log.LstdFlags)})
+}
+
+type eventLogger struct {
+	errors *log.Logger
+}
+
+func (l eventLogger) info(msg string) {
+	// the transport logs only at verbosity 2, see V; client-side lines only
+	if !strings.Contains(msg, "client-transport") {
+		return
+	}
+	for _, e := range transportEvents {
+		if strings.Contains(msg, e) {
+			event(msg)
+			return
+		}
+	}
+}
+
+func event(msg string) {
+	// drop grpc's "[transport] [client-transport 0x...] " prefixes
+	if i := strings.LastIndex(msg, "] "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	log.Printf("[CONN] transport: %s", strings.TrimSpace(msg))
+}
+
+func (l eventLogger) Info(args ...any)                 { l.info(fmt.Sprint(args...)) }
+func (l eventLogger) Infoln(args ...any)               { l.info(fmt.Sprintln(args...)) }
+func (l eventLogger) Infof(format string, args ...any) { l.info(fmt.Sprintf(format, args...)) }
+
+// warnings are dropped like by grpc's default logger
+func (l eventLogger) Warning(...any)          {}
+func (l eventLogger) Warningln(...any)        {}
+func (l eventLogger) Warningf(string, ...any) {}
+
+func (l eventLogger) Error(args ...any) {
+	msg := fmt.Sprint(args...)
+	// grpc reports a GOAWAY for too many pings as an error
+	if strings.Contains(msg, "GoAway") {
+		event(msg)
+		return
+	}
+	l.errors.Print("ERROR: ", msg)
+}
+func (l eventLogger) Errorln(args ...any)               { l.Error(fmt.Sprintln(args...)) }
+func (l eventLogger) Errorf(format string, args ...any) { l.Error(fmt.Sprintf(format, args...)) }
+
+func (l eventLogger) Fatal(args ...any)                 { l.errors.Fatal(args...) }
+func (l eventLogger) Fatalln(args ...any)               { l.errors.Fatalln(args...) }
+func (l eventLogger) Fatalf(format string, args ...any) { l.errors.Fatalf(format, args...) }
+
+// V enables the verbosity the transport logs its connection events at.
+func (l eventLogger) V(level int) bool {
+	return level <= 2
+}
@@ -0,0 +1,112 @@
+package connstate
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// LoggingResolver wraps the resolver builder b: every address set the
+// resolver hands to the balancer is logged as a [RESOLVER] line when it
+// differs from the previous one, and so are resolver errors.
+//
+// A resolver like dns resolves again only when a connection fails, so a
+// new address never shows up while the old ones work. With refresh > 0 it
+// is asked to resolve again every refresh; dns still limits how often it
+// actually queries, see dns.SetMinResolutionInterval.
+func LoggingResolver(b resolver.Builder, refresh time.Duration) resolver.Builder {
+	return &loggingBuilder{Builder: b, refresh: refresh}
+}
+
+type loggingBuilder struct {
+	resolver.Builder
+	refresh time.Duration
+}
+
+func (b *loggingBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	lcc := &loggingConn{ClientConn: cc, target: target.String()}
+	r, err := b.Builder.Build(target, lcc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lr := &loggingResolver{Resolver: r, stop: make(chan struct{})}
+	if b.refresh > 0 {
+		go lr.refreshEvery(b.refresh)
+	}
+	return lr, nil
+}
+
+// loggingConn sits between the resolver and the channel.
+type loggingConn struct {
+	resolver.ClientConn
+	target string
+
+	mu   sync.Mutex
+	last string
+}
+
+func (c *loggingConn) UpdateState(s resolver.State) error {
+	var addrs []string
+	for _, e := range s.Endpoints {
+		for _, a := range e.Addresses {
+			addrs = append(addrs, a.Addr)
+		}
+	}
+	if len(s.Endpoints) == 0 {
+		for _, a := range s.Addresses {
+			addrs = append(addrs, a.Addr)
+		}
+	}
+	slices.Sort(addrs)
+	set := strings.Join(addrs, ", ")
+
+	c.mu.Lock()
+	changed := set != c.last
+	c.last = set
+	c.mu.Unlock()
+	if changed {
+		log.Printf("[RESOLVER] %s: %d addresses: %s", c.target, len(addrs), set)
+	}
+
+	err := c.ClientConn.UpdateState(s)
+	if err != nil {
+		log.Printf("[RESOLVER] %s: update rejected: %v", c.target, err)
+	}
+	return err
+}
+
+func (c *loggingConn) ReportError(err error) {
+	log.Printf("[RESOLVER] %s: %v", c.target, err)
+	c.ClientConn.ReportError(err)
+}
+
+type loggingResolver struct {
+	resolver.Resolver
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func (r *loggingResolver) refreshEvery(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			r.ResolveNow(resolver.ResolveNowOptions{})
+		}
+	}
+}
+
+func (r *loggingResolver) Close() {
+	r.once.Do(func() { close(r.stop) })
+	r.Resolver.Close()
+}
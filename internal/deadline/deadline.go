@@ -0,0 +1,89 @@
+// Package deadline helps to spend the caller's deadline carefully: split it
+// between retry attempts and leave a reserve before calling downstream.
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrExhausted is returned when too little time is left to start a call.
+var ErrExhausted = status.Error(codes.DeadlineExceeded, "deadline budget exhausted")
+
+// Budget is the time left until the deadline of a context. A zero floor
+// means any positive remainder is enough to start an attempt.
+type Budget struct {
+	deadline time.Time
+	bounded  bool
+	floor    time.Duration
+}
+
+// FromContext captures the deadline of ctx. Attempts are refused once less
+// than floor remains.
+func FromContext(ctx context.Context, floor time.Duration) Budget {
+	d, ok := ctx.Deadline()
+	return Budget{deadline: d, bounded: ok, floor: floor}
+}
+
+// Bounded reports whether the budget comes from a real deadline.
+func (b Budget) Bounded() bool {
+	return b.bounded
+}
+
+// Remaining returns the time left; it is never negative. Unbounded budgets
+// report a zero remainder, check Bounded first.
+func (b Budget) Remaining() time.Duration {
+	if !b.bounded {
+		return 0
+	}
+	return max(time.Until(b.deadline), 0)
+}
+
+// Allows reports whether an attempt that first waits for delay may still start.
+func (b Budget) Allows(delay time.Duration) bool {
+	if !b.bounded {
+		return true
+	}
+	left := b.Remaining() - delay
+	return left > 0 && left >= b.floor
+}
+
+// Attempt derives the context for the next attempt out of attemptsLeft: the
+// remaining budget is split evenly, so early attempts can not consume the
+// time reserved for later ones. The last attempt, and a single one, gets
+// everything that is left. A share below the floor is raised to it.
+func (b Budget) Attempt(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc, error) {
+	if !b.bounded {
+		return ctx, func() {}, nil
+	}
+	if !b.Allows(0) {
+		return nil, nil, ErrExhausted
+	}
+
+	share := b.Remaining() / time.Duration(max(attemptsLeft, 1))
+	share = max(share, b.floor)
+
+	ctx, cancel := context.WithTimeout(ctx, share)
+	return ctx, cancel, nil
+}
+
+// Downstream derives the context for a call made while handling a request,
+// keeping reserve of the incoming deadline for the handler's own work.
+func Downstream(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc, error) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	d = d.Add(-reserve)
+	if time.Until(d) <= 0 {
+		return nil, nil, ErrExhausted
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, d)
+	return ctx, cancel, nil
+}
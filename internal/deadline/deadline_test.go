@@ -0,0 +1,104 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		floor        time.Duration
+		attemptsLeft int
+		// want is the timeout of the attempt, 0 for an error
+		want time.Duration
+	}{
+		{name: "single attempt gets everything", timeout: time.Second, attemptsLeft: 1, want: time.Second},
+		{name: "split between attempts", timeout: 3 * time.Second, attemptsLeft: 3, want: time.Second},
+		{name: "no attempts left counts as one", timeout: time.Second, attemptsLeft: 0, want: time.Second},
+		{name: "share raised to the floor", timeout: time.Second, floor: 500 * time.Millisecond, attemptsLeft: 4, want: 500 * time.Millisecond},
+		{name: "floor larger than what remains", timeout: 100 * time.Millisecond, floor: time.Second, attemptsLeft: 1},
+		{name: "exhausted budget", timeout: -time.Second, attemptsLeft: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			attemptCtx, attemptCancel, err := FromContext(ctx, tt.floor).Attempt(ctx, tt.attemptsLeft)
+			if tt.want == 0 {
+				if !errors.Is(err, ErrExhausted) {
+					t.Fatalf("err = %v, want ErrExhausted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer attemptCancel()
+
+			d, ok := attemptCtx.Deadline()
+			if !ok {
+				t.Fatal("attempt has no deadline")
+			}
+			if got := time.Until(d); got > tt.want || got < tt.want-50*time.Millisecond {
+				t.Errorf("attempt timeout = %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttemptUnbounded(t *testing.T) {
+	ctx := context.Background()
+	b := FromContext(ctx, time.Second)
+	if b.Bounded() || !b.Allows(time.Hour) {
+		t.Fatal("a context without deadline must allow any attempt")
+	}
+
+	attemptCtx, cancel, err := b.Attempt(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if _, ok := attemptCtx.Deadline(); ok {
+		t.Error("attempt of an unbounded budget got a deadline")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b := FromContext(ctx, 100*time.Millisecond)
+
+	if !b.Allows(500 * time.Millisecond) {
+		t.Error("a delay leaving more than the floor must be allowed")
+	}
+	if b.Allows(950 * time.Millisecond) {
+		t.Error("a delay leaving less than the floor must not be allowed")
+	}
+	if b.Allows(2 * time.Second) {
+		t.Error("a delay past the deadline must not be allowed")
+	}
+}
+
+func TestDownstream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	down, downCancel, err := Downstream(ctx, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downCancel()
+	d, _ := down.Deadline()
+	if got := time.Until(d); got > 700*time.Millisecond || got < 650*time.Millisecond {
+		t.Errorf("downstream timeout = %v, want about 700ms", got)
+	}
+
+	if _, _, err := Downstream(ctx, 2*time.Second); !errors.Is(err, ErrExhausted) {
+		t.Errorf("reserve above the deadline: err = %v, want ErrExhausted", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Package depcheck checks the dependencies of a server, its storage and the
+// services it calls, and wires the result into readiness. At startup every
+// dependency is checked with retries before the server reports SERVING; a
+// dependency that cannot be reached in time stops the server. Afterwards
+// they are checked in the background and a failing one turns readiness
+// NOT_SERVING until it recovers, so balancers route around a replica that
+// lost its database instead of sending it calls that fail.
+package depcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/easyp-tech/course-grpc/internal/connstate"
+	"github.com/easyp-tech/course-grpc/internal/probes"
+)
+
+// errNotChecked keeps readiness NOT_SERVING until the first check passed.
+var errNotChecked = errors.New("not checked yet")
+
+// Check is one dependency.
+type Check struct {
+	Name string
+	// Ping returns nil while the dependency works.
+	Ping func(ctx context.Context) error
+}
+
+// GRPCHealth checks a gRPC server through the standard health service:
+// service must be SERVING, "" is the server as a whole.
+func GRPCHealth(name string, conn *grpc.ClientConn, service string) Check {
+	client := healthpb.NewHealthClient(conn)
+	return Check{Name: name, Ping: func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if st := resp.GetStatus(); st != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("health status %s", st)
+		}
+		return nil
+	}}
+}
+
+// Checker runs the checks.
+type Checker struct {
+	probes  *probes.Probes
+	checks  []Check
+	timeout time.Duration
+	failing map[string]bool
+}
+
+// New creates a checker that reports to p; every check may take up to
+// timeout. Until the first check of a dependency passed, readiness is
+// NOT_SERVING.
+func New(p *probes.Probes, timeout time.Duration, checks ...Check) *Checker {
+	for _, c := range checks {
+		p.SetDependency(c.Name, errNotChecked)
+	}
+	return &Checker{probes: p, checks: checks, timeout: timeout, failing: make(map[string]bool)}
+}
+
+// Startup checks every dependency until it passes, waiting b between the
+// attempts, and returns an error once ctx is done before all of them did.
+func (c *Checker) Startup(ctx context.Context, b connstate.Backoff) error {
+	for _, check := range c.checks {
+		delay := b.Base
+		for attempt := 1; ; attempt++ {
+			err := c.ping(ctx, check)
+			if err == nil {
+				log.Printf("[DEPS] %s: ok", check.Name)
+				break
+			}
+			log.Printf("[DEPS] %s: attempt %d failed, retrying in %v: %v", check.Name, attempt, delay, err)
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("dependency %s unavailable: %w", check.Name, err)
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, b.Max)
+		}
+	}
+	return nil
+}
+
+// Run checks every dependency each interval until ctx is done. Only
+// changes are logged: a dependency that starts or stops failing.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		for _, check := range c.checks {
+			err := c.ping(ctx, check)
+			if ctx.Err() != nil {
+				return nil
+			}
+			switch {
+			case err != nil && !c.failing[check.Name]:
+				log.Printf("[DEPS] %s: failing: %v", check.Name, err)
+			case err == nil && c.failing[check.Name]:
+				log.Printf("[DEPS] %s: recovered", check.Name)
+			}
+			c.failing[check.Name] = err != nil
+		}
+	}
+}
+
+// ping runs one check and reports its result to readiness.
+func (c *Checker) ping(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := check.Ping(ctx)
+	c.probes.SetDependency(check.Name, err)
+	return err
+}
@@ -0,0 +1,96 @@
+// Package deprecation signals clients that they call an API version that is
+// about to go away. The server answers calls to a deprecated service with a
+// "deprecation: true" header and an HTTP-style warning header naming the
+// replacement; the client interceptor logs the warning once per method.
+package deprecation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Header keys.
+const (
+	DeprecationKey = "deprecation"
+	WarningKey     = "warning"
+)
+
+// Services maps deprecated full service names (api.v1.EchoAPI) to the
+// services replacing them.
+type Services map[string]string
+
+// header returns the header to send for method, nil when its service is not
+// deprecated.
+func (s Services) header(method string) metadata.MD {
+	service, _, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return nil
+	}
+	replacement, ok := s[service]
+	if !ok {
+		return nil
+	}
+
+	// 299 is the "miscellaneous persistent warning" code of RFC 7234
+	warning := fmt.Sprintf(`299 - "%s is deprecated, use %s"`, service, replacement)
+	return metadata.Pairs(DeprecationKey, "true", WarningKey, warning)
+}
+
+// UnaryServerInterceptor adds the deprecation headers to calls of the
+// deprecated services.
+func UnaryServerInterceptor(deprecated Services) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if md := deprecated.header(info.FullMethod); md != nil {
+			if err := grpc.SetHeader(ctx, md); err != nil {
+				logctx.Logger(ctx).Printf("[DEPRECATION] %s: set header: %v", info.FullMethod, err)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(deprecated Services) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if md := deprecated.header(info.FullMethod); md != nil {
+			if err := ss.SetHeader(md); err != nil {
+				logctx.Logger(ss.Context()).Printf("[DEPRECATION] %s: set header: %v", info.FullMethod, err)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientInterceptor logs the warning header of a deprecated method the
+// first time the method is called.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	var warned sync.Map
+
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		var header metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+
+		if warnings := header.Get(WarningKey); len(warnings) > 0 {
+			if _, seen := warned.LoadOrStore(method, struct{}{}); !seen {
+				logctx.Logger(ctx).Printf("[DEPRECATION] %s: %s", method, strings.Join(warnings, "; "))
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,59 @@
+// Package dryrun marks calls that only try a change. A dry run goes through
+// everything a real call does, validation and the business rules included,
+// and answers with the would-be result, but the handler stores nothing. A
+// gateway or a form can check a request this way before sending it for real.
+//
+// A call asks for a dry run with a dry_run field of its request or, for
+// requests without one, with x-dry-run: true in its metadata. The server
+// confirms it in the response header, so a client can tell a dry run from a
+// server that ignored the header and made the change.
+package dryrun
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header asks for a dry run in the request metadata and confirms it in the
+// response header.
+const Header = "x-dry-run"
+
+// dryRunner is a request with a dry_run field.
+type dryRunner interface {
+	GetDryRun() bool
+}
+
+// Requested reports whether the incoming call with req asks for a dry run.
+func Requested(ctx context.Context, req any) bool {
+	if r, ok := req.(dryRunner); ok && r.GetDryRun() {
+		return true
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(Header) {
+		if on, err := strconv.ParseBool(v); err == nil && on {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm tells the client in the response header that the call was a dry
+// run. Handlers call it before they answer.
+func Confirm(ctx context.Context) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs(Header, "true"))
+}
+
+// NewOutgoingContext returns ctx for a call that asks for a dry run.
+func NewOutgoingContext(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, Header, "true")
+}
+
+// Confirmed reports whether the response header of a call confirms a dry
+// run.
+func Confirmed(header metadata.MD) bool {
+	v := header.Get(Header)
+	return len(v) > 0 && v[0] == "true"
+}
@@ -0,0 +1,66 @@
+package echoapi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/inprocess"
+)
+
+func TestChainedEcho(t *testing.T) {
+	client := pbv2.NewEchoAPIClient(newServer(t).Conn())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.ChainedEcho(ctx, &pbv2.ChainedEchoRequest{Message: "hi", Hops: 3, HopDelay: durationpb.New(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetMessage() != "hi" || len(resp.GetHops()) != 4 {
+		t.Fatalf("response %q with %d hops, want hi with 4", resp.GetMessage(), len(resp.GetHops()))
+	}
+	for i, h := range resp.GetHops() {
+		if h.GetHop() != uint32(i) || h.GetServerId() != inprocess.ServerID {
+			t.Errorf("hop %d: %v", i, h)
+		}
+		// every hop spends its delay and the reserve of the call before it
+		if i > 0 && h.GetRemaining().AsDuration() >= resp.GetHops()[i-1].GetRemaining().AsDuration() {
+			t.Errorf("hop %d has %v left, no less than the %v of hop %d", i, h.GetRemaining().AsDuration(), resp.GetHops()[i-1].GetRemaining().AsDuration(), i-1)
+		}
+	}
+}
+
+func TestChainedEchoBudgetExhaustedMidChain(t *testing.T) {
+	client := pbv2.NewEchoAPIClient(newServer(t).Conn())
+	// every hop works 250ms and keeps 20ms for its call: the hops with 5, 4
+	// and 3 hops left fit into 1s, the one with 2 hops left gets about 190ms
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.ChainedEcho(ctx, &pbv2.ChainedEchoRequest{Message: "hi", Hops: 5, HopDelay: durationpb.New(250 * time.Millisecond)})
+	elapsed := time.Since(start)
+
+	st := status.Convert(err)
+	if st.Code() != codes.DeadlineExceeded {
+		t.Fatalf("code = %v, want DeadlineExceeded: %v", st.Code(), err)
+	}
+	// the hop that could not make it says so, and the hops before keep its
+	// code: the client does not just run into its own deadline
+	if !strings.Contains(st.Message(), "chain stopped at "+inprocess.ServerID+" with 2 hops left") {
+		t.Errorf("message %q does not name the hop that stopped", st.Message())
+	}
+	if !strings.HasPrefix(st.Message(), "downstream of "+inprocess.ServerID+" (5 hops left)") {
+		t.Errorf("message %q does not come through the first hop", st.Message())
+	}
+	if elapsed >= 900*time.Millisecond {
+		t.Errorf("the chain stopped after %v, want it to answer before the deadline", elapsed)
+	}
+}
@@ -0,0 +1,149 @@
+package echoapi_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pb "github.com/easyp-tech/course-grpc/pkg/api/v1"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/inprocess"
+)
+
+// maxEchoResponse bounds the message of an Echo response: at most 1024 bytes
+// of the request repeated at most 10 times, with separators.
+const maxEchoResponse = 11 * 1024
+
+// newServer starts the services over bufconn, with the validation of
+// cmd/server, for the whole fuzz run; the logs of the calls are dropped.
+func newServer(tb testing.TB) *inprocess.Server {
+	tb.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+
+	srv, err := inprocess.New()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// checkCode fails on the codes no request may cause: Internal is what the
+// recovery interceptor makes of a panic, Unknown an error of a handler that
+// is not a status.
+func checkCode(t *testing.T, method string, err error) {
+	t.Helper()
+
+	switch code := status.Code(err); code {
+	case codes.Internal, codes.Unknown:
+		t.Fatalf("%s: %v", method, err)
+	}
+}
+
+func FuzzEcho(f *testing.F) {
+	f.Add("hello, world", uint32(0))
+	f.Add("short", uint32(3))
+	f.Add(string(make([]byte, 1024)), uint32(10))
+	f.Add("привет, мир!", uint32(11))
+
+	client := pbv2.NewEchoAPIClient(newServer(f).Conn())
+	f.Fuzz(func(t *testing.T, message string, repeat uint32) {
+		if !utf8.ValidString(message) {
+			// proto3 strings are UTF-8: the client does not send it,
+			// FuzzDecode covers the server
+			t.Skip()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		resp, err := client.Echo(ctx, &pbv2.EchoRequest{Message: message, Repeat: repeat})
+		checkCode(t, "Echo", err)
+		if n := len(resp.GetMessage()); n > maxEchoResponse {
+			t.Fatalf("Echo: %d bytes of response to %d bytes repeated %d times", n, len(message), repeat)
+		}
+	})
+}
+
+func FuzzCreateOrder(f *testing.F) {
+	f.Add("0b4a4d5e-1c2f-4a3b-9d8e-7f6a5b4c3d2e", uint32(1), uint8(1))
+	f.Add("0b4a4d5e-1c2f-4a3b-9d8e-7f6a5b4c3d2e", uint32(11), uint8(3))
+	f.Add("not a uuid", uint32(0), uint8(0))
+	f.Add("", uint32(1), uint8(200))
+
+	conn := newServer(f).Conn()
+	v1, v2 := pb.NewEchoAPIClient(conn), pbv2.NewEchoAPIClient(conn)
+	f.Fuzz(func(t *testing.T, productID string, count uint32, items uint8) {
+		if !utf8.ValidString(productID) {
+			t.Skip()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req := &pb.CreateOrdersRequest{}
+		reqV2 := &pbv2.CreateOrdersRequest{Customer: &pbv2.CreateOrdersRequest_UserId{UserId: productID}}
+		for range items {
+			req.CreateOrder = append(req.CreateOrder, &pb.CreateOrder{ProductId: productID, Count: count})
+			reqV2.Items = append(reqV2.Items, &pbv2.OrderItem{ProductId: productID, Count: count})
+		}
+		_, err := v1.CreateOrder(ctx, req)
+		checkCode(t, "v1 CreateOrder", err)
+		_, err = v2.CreateOrders(ctx, reqV2)
+		checkCode(t, "v2 CreateOrders", err)
+	})
+}
+
+// decoded are the unary methods FuzzDecode sends raw bytes to, with the
+// message the server decodes them into.
+var decoded = map[string]func() proto.Message{
+	"/api.v1.EchoAPI/HelloWorld":       func() proto.Message { return &pb.EchoRequest{} },
+	"/api.v1.EchoAPI/CreateOrder":      func() proto.Message { return &pb.CreateOrdersRequest{} },
+	"/api.v2.EchoAPI/Echo":             func() proto.Message { return &pbv2.EchoRequest{} },
+	"/api.v2.EchoAPI/EchoWithMetadata": func() proto.Message { return &pbv2.EchoWithMetadataRequest{} },
+	"/api.v2.EchoAPI/CreateOrders":     func() proto.Message { return &pbv2.CreateOrdersRequest{} },
+	"/api.v2.EchoAPI/CancelOrder":      func() proto.Message { return &pbv2.CancelOrderRequest{} },
+	"/api.v2.EchoAPI/SaveProfile":      func() proto.Message { return &pbv2.Profile{} },
+	"/api.v2.EchoAPI/GetProfile":       func() proto.Message { return &pbv2.GetProfileRequest{} },
+	"/api.v2.EchoAPI/EchoWithError":    func() proto.Message { return &pbv2.EchoRequest{} },
+	"/api.v1.EchoAPI/WithError":        func() proto.Message { return &pb.EchoRequest{} },
+}
+
+// FuzzDecode sends arbitrary bytes as the request of every method in
+// decoded: the bytes are the unknown fields of an empty message, which
+// proto.Marshal writes out as they are. A request the server cannot decode
+// may fail with any code; one it can must not break the handler.
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "hello, world"))
+	f.Add(protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 1<<40))
+	f.Add([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	f.Add([]byte{0x0a, 0x02, 0xc3, 0x28})
+
+	conn := newServer(f).Conn()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req := &emptypb.Empty{}
+		req.ProtoReflect().SetUnknown(protoreflect.RawFields(data))
+
+		for method, newReq := range decoded {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := conn.Invoke(ctx, method, req, &emptypb.Empty{})
+			cancel()
+			if proto.Unmarshal(data, newReq()) != nil {
+				continue
+			}
+			checkCode(t, method, err)
+		}
+	})
+}
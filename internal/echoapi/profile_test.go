@@ -0,0 +1,53 @@
+package echoapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	nextpb "github.com/easyp-tech/course-grpc/pkg/api/next/v2"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// TestProfileKeepsUnknownFields saves a profile of the next revision on a
+// server that only knows api.v2.Profile and reads it back.
+func TestProfileKeepsUnknownFields(t *testing.T) {
+	conn := newServer(t).Conn()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sent := &nextpb.Profile{
+		Id:      "p1",
+		Name:    "Ivan",
+		Email:   "ivan@example.com",
+		Tags:    []string{"beta", "newsletter"},
+		Address: &nextpb.Address{City: "Moscow", Street: "Tverskaya 1"},
+	}
+	saved := &nextpb.Profile{}
+	if err := conn.Invoke(ctx, pbv2.EchoAPI_SaveProfile_FullMethodName, sent, saved); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	if !proto.Equal(sent, saved) {
+		t.Errorf("SaveProfile returned %v, want %v", saved, sent)
+	}
+
+	loaded := &nextpb.Profile{}
+	if err := conn.Invoke(ctx, pbv2.EchoAPI_GetProfile_FullMethodName, &pbv2.GetProfileRequest{Id: "p1"}, loaded); err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if !proto.Equal(sent, loaded) {
+		t.Errorf("GetProfile returned %v, want %v", loaded, sent)
+	}
+
+	// a client of the current revision gets the new fields as unknown ones
+	current, err := pbv2.NewEchoAPIClient(conn).GetProfile(ctx, &pbv2.GetProfileRequest{Id: "p1"})
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if current.GetName() != "Ivan" || len(current.ProtoReflect().GetUnknown()) == 0 {
+		t.Errorf("api.v2 client got name %q and %d bytes of unknown fields, want Ivan and the new fields",
+			current.GetName(), len(current.ProtoReflect().GetUnknown()))
+	}
+}
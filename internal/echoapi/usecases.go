@@ -0,0 +1,89 @@
+// Package echoapi - EchoAPI v1 и v2: unary методы и заказы сервера курса.
+// Обработчики ничего не знают о листенерах, интерсепторах и флагах:
+// cmd/server регистрирует их на своем gRPC сервере, а пакет inprocess - на
+// сервере в памяти.
+package echoapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/easyp-tech/course-grpc/internal/orders"
+)
+
+// Usecases - операции с заказами, общие для обеих версий API.
+type Usecases interface {
+	CreateOrder(ctx context.Context, productID string, count int) (orders.Order, error)
+	// PreviewOrder проверяет заказ, как CreateOrder, и возвращает его, не
+	// сохраняя
+	PreviewOrder(ctx context.Context, productID string, count int) (orders.Order, error)
+	// ListOrders возвращает до limit заказов, созданных после заказа с
+	// номером after, в порядке создания
+	ListOrders(ctx context.Context, after uint64, limit int) ([]orders.Order, error)
+	// SyncOrder применяет изменение заказа от клиента по last-writer-wins
+	SyncOrder(ctx context.Context, change orders.Change) (orders.Result, error)
+	// TrySyncOrder возвращает то же, что SyncOrder, не применяя изменение
+	TrySyncOrder(ctx context.Context, change orders.Change) (orders.Result, error)
+	// CancelOrder отменяет новый заказ; для заказа в другом статусе
+	// возвращает orders.TransitionError
+	CancelOrder(ctx context.Context, id string) (orders.Order, error)
+	// WatchOrders возвращает создаваемые и изменяемые с этого момента заказы
+	// до вызова stop; канал закрывается, если читатель отстал
+	WatchOrders(ctx context.Context) (events <-chan orders.Event, stop func())
+}
+
+// errTooManyItems - количество в заказе больше maxOrderCount
+var errTooManyItems = errors.New("there are more than one order")
+
+// maxOrderCount - сколько единиц товара можно заказать за раз
+const maxOrderCount = 10
+
+// OrderUsecases - Usecases поверх хранилища заказов.
+type OrderUsecases struct {
+	orders *orders.Store
+}
+
+// NewOrderUsecases создает usecases поверх store.
+func NewOrderUsecases(store *orders.Store) *OrderUsecases {
+	return &OrderUsecases{orders: store}
+}
+
+func (u *OrderUsecases) CreateOrder(ctx context.Context, productID string, count int) (orders.Order, error) {
+	if count > maxOrderCount {
+		return orders.Order{}, errTooManyItems
+	}
+	return u.orders.Create(productID, count), nil
+}
+
+func (u *OrderUsecases) PreviewOrder(ctx context.Context, productID string, count int) (orders.Order, error) {
+	if count > maxOrderCount {
+		return orders.Order{}, errTooManyItems
+	}
+	return u.orders.Preview(productID, count), nil
+}
+
+func (u *OrderUsecases) ListOrders(ctx context.Context, after uint64, limit int) ([]orders.Order, error) {
+	return u.orders.After(after, limit), nil
+}
+
+func (u *OrderUsecases) SyncOrder(ctx context.Context, change orders.Change) (orders.Result, error) {
+	if change.Count > maxOrderCount {
+		return orders.Result{}, errTooManyItems
+	}
+	return u.orders.Apply(change)
+}
+
+func (u *OrderUsecases) TrySyncOrder(ctx context.Context, change orders.Change) (orders.Result, error) {
+	if change.Count > maxOrderCount {
+		return orders.Result{}, errTooManyItems
+	}
+	return u.orders.Try(change)
+}
+
+func (u *OrderUsecases) CancelOrder(ctx context.Context, id string) (orders.Order, error) {
+	return u.orders.Cancel(id, "")
+}
+
+func (u *OrderUsecases) WatchOrders(ctx context.Context) (<-chan orders.Event, func()) {
+	return u.orders.Watch()
+}
@@ -0,0 +1,86 @@
+package echoapi
+
+import (
+	"context"
+	"errors"
+
+	"buf.build/go/protovalidate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/dryrun"
+	"github.com/easyp-tech/course-grpc/internal/i18n"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/storeerr"
+	pb "github.com/easyp-tech/course-grpc/pkg/api/v1"
+)
+
+// V1 - первая версия EchoAPI, устаревшая в пользу V2.
+type V1 struct {
+	pb.UnimplementedEchoAPIServer
+
+	usecases Usecases
+}
+
+// NewV1 создает EchoAPI v1 поверх usecases.
+func NewV1(usecases Usecases) *V1 {
+	return &V1{usecases: usecases}
+}
+
+func (s *V1) HelloWorld(ctx context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
+	if err := protovalidate.Validate(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else {
+		logctx.Logger(ctx).Printf("Validation OK")
+	}
+
+	logctx.Logger(ctx).Printf("Request: %s", req.GetMessage())
+	return &pb.EchoResponse{Message: "pong"}, nil
+}
+
+func (s *V1) CreateOrder(ctx context.Context, req *pb.CreateOrdersRequest) (*pb.CreateOrderResponse, error) {
+	// в api.v1 нет поля dry_run, пробный вызов - только по x-dry-run
+	create := s.usecases.CreateOrder
+	if dryrun.Requested(ctx, req) {
+		create = s.usecases.PreviewOrder
+		dryrun.Confirm(ctx)
+	}
+	for _, createOrder := range req.GetCreateOrder() {
+		_, err := create(ctx, createOrder.ProductId, int(createOrder.Count))
+		if err != nil && !errors.Is(err, errTooManyItems) {
+			// ошибки хранилища переводятся в статусы одинаково во всех методах
+			return nil, storeerr.Status(ctx, err)
+		}
+		if err != nil {
+			// текст для пользователя - на языке из accept-language
+			st := i18n.Status(ctx, codes.FailedPrecondition, i18n.OrderRejected, createOrder.GetProductId())
+			errMsg := &pb.CustomError{Reason: err.Error()}
+
+			var err error
+			// дополняем ее деталями: которые содержат структуру сообщения из proto файла.
+			st, err = st.WithDetails(errMsg)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, st.Err()
+		}
+	}
+
+	return &pb.CreateOrderResponse{}, nil
+}
+
+func (s *V1) WithError(ctx context.Context, in *pb.EchoRequest) (*pb.EchoResponse, error) {
+	// формируем кастомную ошибку
+	st := i18n.Status(ctx, codes.FailedPrecondition, i18n.CustomError)
+	errMsg := &pb.CustomError{Reason: "some reason"}
+
+	var err error
+	// дополняем ее деталями: которые содержат структуру сообщения из proto файла.
+	st, err = st.WithDetails(errMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, st.Err()
+}
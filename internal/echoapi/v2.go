@@ -0,0 +1,714 @@
+package echoapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"buf.build/go/protovalidate"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/easyp-tech/course-grpc/internal/chain"
+	"github.com/easyp-tech/course-grpc/internal/clock"
+	"github.com/easyp-tech/course-grpc/internal/deadline"
+	"github.com/easyp-tech/course-grpc/internal/dryrun"
+	"github.com/easyp-tech/course-grpc/internal/exportbatch"
+	"github.com/easyp-tech/course-grpc/internal/fanout"
+	"github.com/easyp-tech/course-grpc/internal/i18n"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/msgsize"
+	"github.com/easyp-tech/course-grpc/internal/orders"
+	"github.com/easyp-tech/course-grpc/internal/peerinfo"
+	"github.com/easyp-tech/course-grpc/internal/profiles"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/storeerr"
+	"github.com/easyp-tech/course-grpc/internal/streamerr"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// maxImportItems - сколько позиций принимает один вызов ImportOrders: ответ
+// содержит результат каждой, и его размер растет вместе с импортом
+const maxImportItems = 10000
+
+// IllegalTransition - reason в ErrorInfo для смены статуса, недопустимой для
+// заказа, например отмены оплаченного
+const IllegalTransition = "ILLEGAL_STATUS_TRANSITION"
+
+// exportBatch - сколько заказов ExportOrders читает из хранилища за раз:
+// блокировка хранилища не держится, пока заказы уходят клиенту
+const exportBatch = 100
+
+// V2 - вторая версия EchoAPI, работает на том же сервере рядом с первой
+// и использует те же usecases. Запросы проверяет interceptorValidator.
+type V2 struct {
+	pbv2.UnimplementedEchoAPIServer
+
+	usecases Usecases
+	// clock задерживает SlowEcho, в тестах его заменяет clock.Fake
+	clock clock.Clock
+	// profiles хранит профили закодированными, с незнакомыми полями
+	profiles *profiles.Store
+	// chain - следующее звено ChainedEcho, chainForward - ключи метаданных,
+	// которые ему передаются, chainReserve - сколько дедлайна звено
+	// оставляет себе
+	chain        pbv2.EchoAPIClient
+	chainForward []string
+	chainReserve time.Duration
+	// broadcast - серверы, которые вызывает BroadcastEcho
+	broadcast []BroadcastTarget
+	serverID  string
+}
+
+// BroadcastTarget - сервер, Echo которого вызывает BroadcastEcho.
+type BroadcastTarget struct {
+	// Name - адрес сервера, он же в результате сервера
+	Name   string
+	Client pbv2.EchoAPIClient
+}
+
+// NewV2 создает EchoAPI v2 поверх usecases. serverID называет экземпляр в
+// звеньях ChainedEcho; следующего звена нет до вызова WithChain.
+func NewV2(usecases Usecases, serverID string) *V2 {
+	return &V2{
+		usecases: usecases,
+		clock:    clock.Real,
+		profiles: profiles.NewStore(),
+		serverID: serverID,
+	}
+}
+
+// WithChain делает next следующим звеном ChainedEcho: ему передаются ключи
+// метаданных forward, а на каждый вызов звено оставляет себе reserve
+// дедлайна.
+func (s *V2) WithChain(next pbv2.EchoAPIClient, forward []string, reserve time.Duration) *V2 {
+	s.chain, s.chainForward, s.chainReserve = next, forward, reserve
+	return s
+}
+
+// WithBroadcast задает серверы BroadcastEcho. Вызовы к ним передают ключи
+// метаданных и оставляют себе запас дедлайна из WithChain.
+func (s *V2) WithBroadcast(targets []BroadcastTarget) *V2 {
+	s.broadcast = targets
+	return s
+}
+
+// WithClock заменяет часы, которые задерживают SlowEcho и ChainedEcho.
+func (s *V2) WithClock(c clock.Clock) *V2 {
+	s.clock = c
+	return s
+}
+
+func (s *V2) Echo(ctx context.Context, req *pbv2.EchoRequest) (*pbv2.EchoResponse, error) {
+	logctx.Logger(ctx).Printf("Request: %s", req.GetMessage())
+
+	id, _ := requestid.FromContext(ctx)
+
+	repeat := max(1, int(req.GetRepeat()))
+	return &pbv2.EchoResponse{
+		Message:    strings.TrimSpace(strings.Repeat(req.GetMessage()+" ", repeat)),
+		ServerTime: timestamppb.Now(),
+		RequestId:  id,
+	}, nil
+}
+
+func (s *V2) EchoWithError(ctx context.Context, req *pbv2.EchoRequest) (*pbv2.EchoResponse, error) {
+	st, err := i18n.Status(ctx, codes.FailedPrecondition, i18n.CustomError).
+		WithDetails(&pbv2.CustomError{Reason: "some reason", Field: "message"})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, st.Err()
+}
+
+func (s *V2) SlowEcho(ctx context.Context, req *pbv2.SlowEchoRequest) (*pbv2.EchoResponse, error) {
+	logger := logctx.Logger(ctx)
+	delay := req.GetDelay().AsDuration()
+	if d, ok := ctx.Deadline(); ok {
+		logger.Printf("SlowEcho: delay %v, deadline in %v", delay, time.Until(d).Round(time.Millisecond))
+	} else {
+		logger.Printf("SlowEcho: delay %v, no deadline", delay)
+	}
+
+	start := s.clock.Now()
+	if req.GetIgnoreCancellation() {
+		// так делать не надо: вызов уже отменен, а сервер продолжает тратить
+		// ресурсы на ответ, который клиент не получит
+		_ = s.clock.Sleep(context.WithoutCancel(ctx), delay)
+		if ctx.Err() != nil {
+			logger.Printf("SlowEcho: finished after the call ended (%v), the response is dropped", ctx.Err())
+		}
+	} else if err := s.clock.Sleep(ctx, delay); err != nil {
+		// отмена клиентом или дедлайн прерывают ожидание сразу
+		logger.Printf("SlowEcho: stopped after %v: %v", s.clock.Now().Sub(start).Round(time.Millisecond), err)
+		return nil, status.FromContextError(err).Err()
+	}
+
+	id, _ := requestid.FromContext(ctx)
+	return &pbv2.EchoResponse{
+		Message:    req.GetMessage(),
+		ServerTime: timestamppb.Now(),
+		RequestId:  id,
+	}, nil
+}
+
+func (s *V2) EchoWithMetadata(ctx context.Context, req *pbv2.EchoWithMetadataRequest) (*pbv2.EchoWithMetadataResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	resp := &pbv2.EchoWithMetadataResponse{Metadata: make(map[string]*pbv2.MetadataValues, len(md))}
+	for k, vs := range md {
+		values := &pbv2.MetadataValues{}
+		for _, v := range vs {
+			// бинарные значения приходят уже декодированными, отдаем их в base64
+			if strings.HasSuffix(k, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			values.Values = append(values.Values, v)
+		}
+		resp.Metadata[k] = values
+	}
+
+	p := peerinfo.FromContext(ctx)
+	resp.Peer = &pbv2.PeerInfo{
+		Address:       p.Addr,
+		TlsVersion:    p.TLSVersion,
+		CipherSuite:   p.CipherSuite,
+		ClientSubject: p.Subject,
+	}
+
+	return resp, nil
+}
+
+func (s *V2) CreateOrders(ctx context.Context, req *pbv2.CreateOrdersRequest) (*pbv2.CreateOrdersResponse, error) {
+	// пробный вызов проходит все те же проверки, но ничего не сохраняет
+	resp := &pbv2.CreateOrdersResponse{DryRun: dryrun.Requested(ctx, req)}
+	create := s.usecases.CreateOrder
+	if resp.DryRun {
+		create = s.usecases.PreviewOrder
+		dryrun.Confirm(ctx)
+	}
+	for _, item := range req.GetItems() {
+		order, err := create(ctx, item.GetProductId(), int(item.GetCount()))
+		if err != nil && !errors.Is(err, errTooManyItems) {
+			return nil, storeerr.Status(ctx, err)
+		}
+		if err != nil {
+			st, detailsErr := i18n.Status(ctx, codes.FailedPrecondition, i18n.OrderRejected, item.GetProductId()).
+				WithDetails(&pbv2.CustomError{Reason: err.Error(), Field: "items.count"})
+			if detailsErr != nil {
+				return nil, detailsErr
+			}
+			return nil, st.Err()
+		}
+		if resp.DryRun {
+			resp.Orders = append(resp.Orders, orderToProto(order))
+			continue
+		}
+		resp.OrderIds = append(resp.OrderIds, order.ID)
+	}
+
+	return resp, nil
+}
+
+// ImportOrders принимает позиции по одной и проверяет каждую отдельно:
+// невалидная или отклоненная позиция попадает в результаты со своей ошибкой,
+// остальные импортируются. Ошибка всего стрима - только при обрыве или
+// превышении maxImportItems.
+func (s *V2) ImportOrders(stream pbv2.EchoAPI_ImportOrdersServer) error {
+	ctx := stream.Context()
+	resp := &pbv2.ImportOrdersResponse{}
+
+	for index := uint32(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return streamerr.Finish(ctx, "ImportOrders", err)
+		}
+		if index >= maxImportItems {
+			return status.Errorf(codes.ResourceExhausted, "import exceeds %d items", maxImportItems)
+		}
+
+		result := &pbv2.ImportOrderResult{Index: index, Ref: req.GetRef()}
+		if order, err := s.importOrder(ctx, req); err != nil {
+			result.Result = &pbv2.ImportOrderResult_Error{Error: status.Convert(err).Proto()}
+			resp.Failed++
+		} else {
+			result.Result = &pbv2.ImportOrderResult_OrderId{OrderId: order.ID}
+			resp.Imported++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	logctx.Logger(ctx).Printf("ImportOrders: imported %d, failed %d", resp.GetImported(), resp.GetFailed())
+	return stream.SendAndClose(resp)
+}
+
+// importOrder проверяет и создает заказ одной позиции. Ошибки - такие же,
+// как вернул бы unary вызов: InvalidArgument с BadRequest для невалидной
+// позиции, FailedPrecondition с CustomError для отклоненной, ошибки
+// хранилища - по storeerr.
+func (s *V2) importOrder(ctx context.Context, req *pbv2.ImportOrdersRequest) (orders.Order, error) {
+	if err := protovalidate.Validate(req); err != nil {
+		return orders.Order{}, invalidArgument(err)
+	}
+
+	item := req.GetItem()
+	order, err := s.usecases.CreateOrder(ctx, item.GetProductId(), int(item.GetCount()))
+	if err != nil && !errors.Is(err, errTooManyItems) {
+		return orders.Order{}, storeerr.Status(ctx, err)
+	}
+	if err != nil {
+		st, detailsErr := i18n.Status(ctx, codes.FailedPrecondition, i18n.OrderRejected, item.GetProductId()).
+			WithDetails(&pbv2.CustomError{Reason: err.Error(), Field: "item.count"})
+		if detailsErr != nil {
+			return orders.Order{}, detailsErr
+		}
+		return orders.Order{}, st.Err()
+	}
+	return order, nil
+}
+
+// ExportOrders отправляет заказы в порядке создания, пропуская не
+// подходящие под фильтры. Заказы, созданные во время выгрузки, тоже попадают
+// в нее: выгрузка заканчивается, когда новых заказов после последнего
+// отправленного нет. С кодеком payload заказы уходят сжатыми пачками, см.
+// пакет exportbatch.
+func (s *V2) ExportOrders(req *pbv2.ExportOrdersRequest, stream pbv2.EchoAPI_ExportOrdersServer) error {
+	ctx := stream.Context()
+
+	after, err := orders.ParseCursor(req.GetCursor())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cursor: %v", err)
+	}
+	from, to := req.GetCreatedFrom(), req.GetCreatedTo()
+	if from != nil && to != nil && !from.AsTime().Before(to.AsTime()) {
+		return status.Error(codes.InvalidArgument, "created_from must be before created_to")
+	}
+	statuses := make(map[orders.Status]bool, len(req.GetStatuses()))
+	for _, st := range req.GetStatuses() {
+		statuses[orderStatus(st)] = true
+	}
+
+	var batches *exportbatch.Writer
+	if req.GetPayloadCodec() != pbv2.PayloadCodec_PAYLOAD_CODEC_UNSPECIFIED {
+		if batches, err = exportbatch.NewWriter(req.GetPayloadCodec()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	batchSize := int(req.GetBatchSize())
+	if batchSize == 0 {
+		batchSize = exportbatch.DefaultSize
+	}
+	var (
+		pending []*pbv2.Order
+		cursor  string
+	)
+	// flush отправляет накопленные заказы одной пачкой
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		batch, err := batches.Batch(pending)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		pending = pending[:0]
+		return stream.Send(&pbv2.ExportOrdersResponse{Batch: batch, Cursor: cursor})
+	}
+
+	var sent, sentBatches int
+	for {
+		batch, err := s.usecases.ListOrders(ctx, after, exportBatch)
+		if err != nil {
+			return storeerr.Status(ctx, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, o := range batch {
+			after = o.Seq
+			switch {
+			case from != nil && o.CreatedAt.Before(from.AsTime()),
+				to != nil && !o.CreatedAt.Before(to.AsTime()),
+				len(statuses) > 0 && !statuses[o.Status]:
+				continue
+			}
+			sent++
+			if batches == nil {
+				if err := stream.Send(&pbv2.ExportOrdersResponse{Order: orderToProto(o), Cursor: o.Cursor()}); err != nil {
+					return streamerr.Finish(ctx, "ExportOrders", err)
+				}
+				continue
+			}
+			pending, cursor = append(pending, orderToProto(o)), o.Cursor()
+			if len(pending) == batchSize {
+				sentBatches++
+				if err := flush(); err != nil {
+					return streamerr.Finish(ctx, "ExportOrders", err)
+				}
+			}
+		}
+	}
+	if len(pending) > 0 {
+		sentBatches++
+		if err := flush(); err != nil {
+			return streamerr.Finish(ctx, "ExportOrders", err)
+		}
+	}
+
+	if batches != nil {
+		logctx.Logger(ctx).Printf("ExportOrders: sent %d orders in %d %v batches", sent, sentBatches, req.GetPayloadCodec())
+		return nil
+	}
+	logctx.Logger(ctx).Printf("ExportOrders: sent %d orders", sent)
+	return nil
+}
+
+func orderToProto(o orders.Order) *pbv2.Order {
+	return &pbv2.Order{
+		Id:        o.ID,
+		ProductId: o.ProductID,
+		Count:     uint32(o.Count),
+		Status:    orderStatusToProto(o.Status),
+		CreatedAt: timestamppb.New(o.CreatedAt),
+		Version:   o.Version,
+		UpdatedAt: timestamppb.New(o.UpdatedAt),
+	}
+}
+
+func orderStatus(st pbv2.OrderStatus) orders.Status {
+	switch st {
+	case pbv2.OrderStatus_ORDER_STATUS_NEW:
+		return orders.StatusNew
+	case pbv2.OrderStatus_ORDER_STATUS_PAID:
+		return orders.StatusPaid
+	case pbv2.OrderStatus_ORDER_STATUS_CANCELLED:
+		return orders.StatusCancelled
+	}
+	return 0
+}
+
+func orderStatusToProto(st orders.Status) pbv2.OrderStatus {
+	switch st {
+	case orders.StatusNew:
+		return pbv2.OrderStatus_ORDER_STATUS_NEW
+	case orders.StatusPaid:
+		return pbv2.OrderStatus_ORDER_STATUS_PAID
+	case orders.StatusCancelled:
+		return pbv2.OrderStatus_ORDER_STATUS_CANCELLED
+	}
+	return pbv2.OrderStatus_ORDER_STATUS_UNSPECIFIED
+}
+
+// CancelOrder отменяет новый заказ. Заказ в другом статусе не меняется, а
+// вызов завершается FailedPrecondition: повтор не поможет, пока заказ не
+// изменится, а ErrorInfo сообщает клиенту его статус.
+func (s *V2) CancelOrder(ctx context.Context, req *pbv2.CancelOrderRequest) (*pbv2.CancelOrderResponse, error) {
+	order, err := s.usecases.CancelOrder(ctx, req.GetOrderId())
+	var terr *orders.TransitionError
+	if errors.As(err, &terr) {
+		logctx.Logger(ctx).Printf("CancelOrder: %v", err)
+		st, detailsErr := i18n.Status(ctx, codes.FailedPrecondition, i18n.OrderNotCancellable, terr.ID).
+			WithDetails(&errdetails.ErrorInfo{
+				Reason: IllegalTransition,
+				Domain: msgsize.Domain,
+				Metadata: map[string]string{
+					"order_id": terr.ID,
+					"status":   orderStatusToProto(terr.From).String(),
+					"target":   orderStatusToProto(terr.To).String(),
+				},
+			})
+		if detailsErr != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, st.Err()
+	}
+	if err != nil {
+		return nil, storeerr.Status(ctx, fmt.Errorf("cancel %s: %w", req.GetOrderId(), err))
+	}
+	return &pbv2.CancelOrderResponse{Order: orderToProto(order)}, nil
+}
+
+// SyncOrders отвечает на каждое изменение клиента и одновременно присылает
+// ему изменения заказов, сделанные другими клиентами и вызовами. Все
+// отправки идут из одной горутины: стрим не разрешает параллельный Send.
+func (s *V2) SyncOrders(stream pbv2.EchoAPI_SyncOrdersServer) error {
+	logger := logctx.Logger(stream.Context())
+	// origin отличает изменения этого стрима от чужих в WatchOrders
+	origin := uuid.NewString()
+
+	events, stop := s.usecases.WatchOrders(stream.Context())
+	defer stop()
+
+	p, ctx := streams.New(stream.Context())
+	requests := streams.Recv(p, stream, 0)
+
+	var applied, rejected, remote int
+	for {
+		var resp *pbv2.SyncOrdersResponse
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				if err := p.Wait(); err != nil {
+					return streamerr.Finish(ctx, "SyncOrders", err)
+				}
+				logger.Printf("SyncOrders: applied %d, rejected %d, pushed %d remote changes", applied, rejected, remote)
+				return nil
+			}
+			resp = s.syncOrder(ctx, origin, req)
+			if resp.GetEvent() == pbv2.SyncEvent_SYNC_EVENT_APPLIED {
+				applied++
+			} else {
+				rejected++
+			}
+
+		case e, ok := <-events:
+			if !ok {
+				logger.Printf("SyncOrders: fell behind the order changes, closing stream")
+				return status.Error(codes.ResourceExhausted, "too many order changes to keep up with, export the orders and sync again")
+			}
+			if e.Origin == origin {
+				continue
+			}
+			event := pbv2.SyncEvent_SYNC_EVENT_REMOTE
+			if e.Cancelled {
+				event = pbv2.SyncEvent_SYNC_EVENT_CANCELLED
+			}
+			resp = &pbv2.SyncOrdersResponse{Event: event, Order: orderToProto(e.Order)}
+			remote++
+
+		case <-ctx.Done():
+			return streamerr.Finish(ctx, "SyncOrders", p.Wait())
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return streamerr.Finish(ctx, "SyncOrders", err)
+		}
+	}
+}
+
+// syncOrder применяет одно изменение. Отказ не прерывает синхронизацию:
+// клиент получает REJECTED с текущим заказом или с причиной.
+func (s *V2) syncOrder(ctx context.Context, origin string, req *pbv2.SyncOrdersRequest) *pbv2.SyncOrdersResponse {
+	// пробное изменение: ответ такой же, но заказ не меняется
+	dryRun := dryrun.Requested(ctx, req)
+	sync := s.usecases.SyncOrder
+	if dryRun {
+		sync = s.usecases.TrySyncOrder
+	}
+	res, err := sync(ctx, orders.Change{
+		ID:          req.GetOrderId(),
+		BaseVersion: req.GetBaseVersion(),
+		Status:      orderStatus(req.GetStatus()),
+		Count:       int(req.GetCount()),
+		ChangedAt:   req.GetChangedAt().AsTime(),
+		Origin:      origin,
+	})
+	switch {
+	case err != nil && !errors.Is(err, errTooManyItems):
+		// ошибки хранилища - с тем же кодом, что и в остальных методах
+		err = storeerr.Status(ctx, fmt.Errorf("sync %s: %w", req.GetOrderId(), err))
+		return &pbv2.SyncOrdersResponse{
+			Event:  pbv2.SyncEvent_SYNC_EVENT_REJECTED,
+			Error:  status.Convert(err).Proto(),
+			DryRun: dryRun,
+		}
+	case err != nil:
+		st, detailsErr := i18n.Status(ctx, codes.FailedPrecondition, i18n.OrderRejected, req.GetOrderId()).
+			WithDetails(&pbv2.CustomError{Reason: err.Error(), Field: "count"})
+		if detailsErr != nil {
+			st = status.New(codes.FailedPrecondition, err.Error())
+		}
+		return &pbv2.SyncOrdersResponse{Event: pbv2.SyncEvent_SYNC_EVENT_REJECTED, Error: st.Proto(), DryRun: dryRun}
+	}
+
+	if res.Conflict {
+		logctx.Logger(ctx).Printf("SyncOrders: conflict on %s at version %d, applied: %t", req.GetOrderId(), req.GetBaseVersion(), res.Applied)
+	}
+	event := pbv2.SyncEvent_SYNC_EVENT_REJECTED
+	if res.Applied {
+		event = pbv2.SyncEvent_SYNC_EVENT_APPLIED
+	}
+	return &pbv2.SyncOrdersResponse{Event: event, Order: orderToProto(res.Order), Conflict: res.Conflict, DryRun: dryRun}
+}
+
+// invalidArgument переводит ошибку protovalidate в InvalidArgument с
+// нарушением BadRequest на каждое поле
+func invalidArgument(err error) error {
+	var verr *protovalidate.ValidationError
+	if !errors.As(err, &verr) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	br := &errdetails.BadRequest{}
+	for _, v := range verr.Violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       protovalidate.FieldPathString(v.Proto.GetField()),
+			Description: v.Proto.GetMessage(),
+		})
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, "invalid order item").WithDetails(br)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
+
+// SaveProfile сохраняет профиль в том виде, в каком он пришел: proto.Marshal
+// записывает и поля, которых этот сервер не знает (unknown fields), поэтому
+// клиент следующей ревизии получает их обратно.
+func (s *V2) SaveProfile(ctx context.Context, req *pbv2.Profile) (*pbv2.Profile, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encode profile: %v", err)
+	}
+	logctx.Logger(ctx).Printf("SaveProfile: %s, %d bytes, %d of them in fields unknown to this server",
+		req.GetId(), len(data), len(req.ProtoReflect().GetUnknown()))
+	s.profiles.Put(req.GetId(), data)
+
+	return s.loadProfile(req.GetId())
+}
+
+func (s *V2) GetProfile(ctx context.Context, req *pbv2.GetProfileRequest) (*pbv2.Profile, error) {
+	return s.loadProfile(req.GetId())
+}
+
+// loadProfile читает профиль из хранилища. proto.Unmarshal сохраняет
+// незнакомые поля в профиле, а не отбрасывает их: отбросить их можно только
+// явно, через proto.UnmarshalOptions{DiscardUnknown: true}.
+func (s *V2) loadProfile(id string) (*pbv2.Profile, error) {
+	data, ok := s.profiles.Get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "profile %s not found", id)
+	}
+	profile := &pbv2.Profile{}
+	if err := proto.Unmarshal(data, profile); err != nil {
+		return nil, status.Errorf(codes.DataLoss, "decode profile %s: %v", id, err)
+	}
+	return profile, nil
+}
+
+// ChainedEcho отвечает сам при hops == 0, а иначе вызывает ChainedEcho
+// следующего звена с контекстом входящего вызова: остаток дедлайна и отмена
+// уходят дальше сами, метаданные - только из s.chainForward. Звено, которому
+// не хватит дедлайна на свою работу и s.chainReserve для вызова следующего,
+// сразу отвечает DeadlineExceeded и называет себя: когда дедлайн истечет,
+// вызвавший уже не дождется объяснения.
+func (s *V2) ChainedEcho(ctx context.Context, req *pbv2.ChainedEchoRequest) (*pbv2.ChainedEchoResponse, error) {
+	logger := logctx.Logger(ctx)
+	hop := &pbv2.ChainHop{ServerId: s.serverID}
+	if d, ok := ctx.Deadline(); ok {
+		hop.Remaining = durationpb.New(time.Until(d))
+		logger.Printf("ChainedEcho: %d hops left, deadline in %v", req.GetHops(), time.Until(d).Round(time.Millisecond))
+	} else {
+		logger.Printf("ChainedEcho: %d hops left, no deadline", req.GetHops())
+	}
+
+	delay := req.GetHopDelay().AsDuration()
+	reserve := s.chainReserve
+	if req.GetHops() == 0 {
+		reserve = 0
+	}
+	if budget := deadline.FromContext(ctx, reserve); !budget.Allows(delay) {
+		logger.Printf("ChainedEcho: deadline budget exhausted with %d hops left: %v left, %v of work and %v reserved",
+			req.GetHops(), budget.Remaining(), delay, reserve)
+		return nil, status.Errorf(codes.DeadlineExceeded, "chain stopped at %s with %d hops left: %v of the deadline left, the hop needs %v and reserves %v",
+			s.serverID, req.GetHops(), budget.Remaining().Round(time.Millisecond), delay, reserve)
+	}
+	if err := s.clock.Sleep(ctx, delay); err != nil {
+		logger.Printf("ChainedEcho: call ended while working, %d hops left: %v", req.GetHops(), err)
+		st := status.FromContextError(err)
+		return nil, status.Errorf(st.Code(), "chain stopped at %s with %d hops left: %v while working", s.serverID, req.GetHops(), err)
+	}
+	if req.GetHops() == 0 {
+		return &pbv2.ChainedEchoResponse{Message: req.GetMessage(), Hops: []*pbv2.ChainHop{hop}}, nil
+	}
+	if s.chain == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "chain stopped at %s with %d hops left: no next hop configured", s.serverID, req.GetHops())
+	}
+
+	callCtx, cancel, err := deadline.Downstream(ctx, s.chainReserve)
+	if err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "chain stopped at %s with %d hops left: %v reserved, nothing left for the call",
+			s.serverID, req.GetHops(), s.chainReserve)
+	}
+	defer cancel()
+	callCtx, hop.ForwardedMetadata = chain.Outgoing(callCtx, s.chainForward)
+	if dropped := chain.Dropped(ctx, s.chainForward); len(dropped) > 0 {
+		logger.Printf("ChainedEcho: not forwarding metadata %v", dropped)
+	}
+
+	resp, err := s.chain.ChainedEcho(callCtx, &pbv2.ChainedEchoRequest{
+		Message:  req.GetMessage(),
+		Hops:     req.GetHops() - 1,
+		HopDelay: req.GetHopDelay(),
+	})
+	if err != nil {
+		// код ошибки звена сохраняется: клиент видит DeadlineExceeded
+		// следующего звена, а не Unknown
+		st := status.Convert(err)
+		return nil, status.Errorf(st.Code(), "downstream of %s (%d hops left): %s", s.serverID, req.GetHops(), st.Message())
+	}
+	resp.Hops = append([]*pbv2.ChainHop{hop}, resp.GetHops()...)
+	for i, h := range resp.GetHops() {
+		h.Hop = uint32(i)
+	}
+	return resp, nil
+}
+
+// BroadcastEcho вызывает Echo всех серверов из WithBroadcast одновременно и
+// отвечает результатом каждого: ошибка сервера попадает в его результат, а
+// сам вызов завершается ошибкой, только если серверов нет или его
+// собственный дедлайн истек во время сбора: тогда ответа уже никто не ждет.
+func (s *V2) BroadcastEcho(ctx context.Context, req *pbv2.BroadcastEchoRequest) (*pbv2.BroadcastEchoResponse, error) {
+	logger := logctx.Logger(ctx)
+	if len(s.broadcast) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s has no broadcast targets configured", s.serverID)
+	}
+
+	callCtx, cancel, err := deadline.Downstream(ctx, s.chainReserve)
+	if err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "%v reserved, nothing left for the broadcast", s.chainReserve)
+	}
+	defer cancel()
+	callCtx, _ = chain.Outgoing(callCtx, s.chainForward)
+
+	results := fanout.Gather(callCtx, len(s.broadcast), req.GetTargetTimeout().AsDuration(),
+		func(ctx context.Context, i int) (*pbv2.EchoResponse, error) {
+			return s.broadcast[i].Client.Echo(ctx, &pbv2.EchoRequest{Message: req.GetMessage()})
+		})
+	if err := ctx.Err(); err != nil {
+		logger.Printf("BroadcastEcho: call ended while gathering: %v", err)
+		return nil, status.FromContextError(err).Err()
+	}
+
+	resp := &pbv2.BroadcastEchoResponse{}
+	for i, r := range results {
+		result := &pbv2.BroadcastResult{Target: s.broadcast[i].Name, Latency: durationpb.New(r.Latency)}
+		if r.Err != nil {
+			resp.Failed++
+			st := status.Convert(r.Err)
+			logger.Printf("[BROADCAST] %s: %s after %v: %s", result.Target, st.Code(), r.Latency.Round(time.Millisecond), st.Message())
+			result.Result = &pbv2.BroadcastResult_Error{Error: st.Proto()}
+		} else {
+			resp.Succeeded++
+			result.Result = &pbv2.BroadcastResult_Response{Response: r.Value}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	logger.Printf("BroadcastEcho: %d of %d targets answered", resp.Succeeded, len(results))
+	return resp, nil
+}
@@ -0,0 +1,646 @@
+// Package echostream implements the streaming EchoService from
+// api/stream/v1, shared by cmd/stream and cmd/server.
+package echostream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/backpressure"
+	"github.com/easyp-tech/course-grpc/internal/clock"
+	"github.com/easyp-tech/course-grpc/internal/journal"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/logsample"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/internal/msgtrace"
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+	"github.com/easyp-tech/course-grpc/internal/rejoin"
+	"github.com/easyp-tech/course-grpc/internal/slowconsumer"
+	"github.com/easyp-tech/course-grpc/internal/streamerr"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+const (
+	// DefaultMsgRate and DefaultMsgBurst bound how many messages per second a
+	// single peer may push into the streaming handlers.
+	DefaultMsgRate  = 20
+	DefaultMsgBurst = 40
+	// maxThrottleStrikes is how many throttled messages in a row are tolerated
+	// before the stream is closed with ResourceExhausted.
+	maxThrottleStrikes = 20
+	// throttleRetryDelay is advised to throttled clients via RetryInfo.
+	throttleRetryDelay = 2 * time.Second
+	// asyncWorkers is how many messages of one async stream are processed in
+	// parallel.
+	asyncWorkers = 4
+	// ackTimeout is how long a reliable response waits for its ack before it
+	// is delivered again, maxDeliveries how often it is sent at most.
+	ackTimeout    = time.Second
+	maxDeliveries = 5
+	// maxBacklog bounds the journaled responses a rejoining session gets on
+	// top of its unacknowledged ones.
+	maxBacklog = 1000
+	// maxClientStreamMessages bounds one client stream; the summary response
+	// lists at most summaryMessages of them, each cut to summaryMessageLen
+	// bytes, so its size does not grow with what the client sends.
+	maxClientStreamMessages = 10000
+	summaryMessages         = 10
+	summaryMessageLen       = 256
+	// slowSendThreshold is how long a Send of a server stream may block
+	// before the client counts as a slow consumer.
+	slowSendThreshold = 2 * time.Second
+)
+
+var _ stream.EchoServiceServer = &API{}
+
+// Handlers names the handlers that log a line per message, see
+// WithLogSampling.
+var Handlers = []string{
+	"EchoClientStream",
+	"EchoServerStream",
+	"EchoBidirectionalStreamSync",
+	"EchoBidirectionalStreamAsync",
+	"EchoBidirectionalStreamReliable",
+	"EchoPull",
+}
+
+type API struct {
+	stream.UnimplementedEchoServiceServer
+
+	limiter *ratelimit.Limiter
+	journal *journal.Journal
+	clock   clock.Clock
+	// sessions let EchoBidirectionalStreamReliable clients rejoin after a
+	// reconnect, nil issues no tokens
+	sessions *rejoin.Store[*stream.EchoResponse]
+	// backpressure samples the queues of EchoBidirectionalStreamAsync, nil
+	// leaves them unwatched
+	backpressure *backpressure.Watcher
+	// heartbeat is how long EchoReplay waits for a journaled message before
+	// it sends a heartbeat, 0 sends none
+	heartbeat time.Duration
+	// samplers thin out the per-message lines of the handlers, by name; a
+	// handler without one logs every message
+	samplers map[string]*logsample.Sampler
+}
+
+// throttledError builds a ResourceExhausted status that tells well-behaved
+// clients when to come back.
+func throttledError(msg string) error {
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(throttleRetryDelay),
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// received is a request moving through a bidi pipeline: its sequence number
+// and the time it was read from the stream and, once produced, its reply.
+type received struct {
+	req       *stream.EchoRequest
+	seq       uint64
+	at        time.Time
+	reply     *stream.EchoResponse
+	throttled bool
+}
+
+// NewAPI creates the streaming handlers. A nil limiter disables throttling,
+// a nil journal disables EchoReplay.
+func NewAPI(limiter *ratelimit.Limiter, j *journal.Journal) *API {
+	return &API{limiter: limiter, journal: j, clock: clock.Real}
+}
+
+// WithClock makes the handlers pause and measure latency with c instead of
+// the wall clock and returns a.
+func (a *API) WithClock(c clock.Clock) *API {
+	a.clock = c
+	return a
+}
+
+// WithLogSampling makes the handlers log their per-message lines through
+// samplers, keyed by the names in Handlers, and returns a.
+func (a *API) WithLogSampling(samplers map[string]*logsample.Sampler) *API {
+	a.samplers = samplers
+	return a
+}
+
+// WithSessions issues session tokens on EchoBidirectionalStreamReliable and
+// keeps every session for ttl after its stream ends, see package rejoin, and
+// returns a.
+func (a *API) WithSessions(ttl time.Duration) *API {
+	var last func() uint64
+	if a.journal != nil {
+		last = a.journal.Last
+	}
+	a.sessions = rejoin.NewStore(ttl, func() *streams.Outbox[*stream.EchoResponse] {
+		return streams.NewOutbox[*stream.EchoResponse](ackTimeout, maxDeliveries)
+	}, last)
+	return a
+}
+
+// WithHeartbeat makes EchoReplay send a heartbeat response whenever the
+// journal stays quiet for interval, so a following client tells a quiet
+// journal from a wedged stream; 0 sends none.
+func (a *API) WithHeartbeat(interval time.Duration) *API {
+	a.heartbeat = interval
+	return a
+}
+
+// WithBackpressure reports how full the queues of the async bidi streams are
+// to w and returns a.
+func (a *API) WithBackpressure(w *backpressure.Watcher) *API {
+	a.backpressure = w
+	return a
+}
+
+// record appends a reply of the bidi handlers to the journal read by
+// EchoReplay.
+func (a *API) record(ctx context.Context, reply *stream.EchoResponse) {
+	if a.journal == nil {
+		return
+	}
+
+	data, err := proto.Marshal(reply)
+	if err == nil {
+		_, err = a.journal.Append(data)
+	}
+	if err != nil {
+		logctx.Logger(ctx).Printf("Failed to journal response: %v", err)
+	}
+}
+
+// admit checks one incoming message against the per-peer limiter, or the
+// key the limiter counts callers under. It returns false when the message
+// has to be skipped and an error once the peer keeps exceeding the limit and
+// the stream should be closed.
+func (a *API) admit(ctx context.Context, strikes *int) (bool, error) {
+	if a.limiter == nil {
+		return true, nil
+	}
+	key := a.limiter.Key(ctx)
+	if a.limiter.Allow(key) {
+		*strikes = 0
+		return true, nil
+	}
+
+	*strikes++
+	if *strikes > maxThrottleStrikes {
+		logger := logctx.Logger(ctx)
+		logger.Printf("%s exceeded message rate limit, closing stream", key)
+		return false, throttledError(fmt.Sprintf("%s exceeded message rate limit", key))
+	}
+	return false, nil
+}
+
+// EchoClientStream handles client streaming - receives multiple messages from client, returns one response
+func (a *API) EchoClientStream(streamServer stream.EchoService_EchoClientStreamServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	logger.Println("EchoClientStream: Starting client stream")
+	sampler := a.samplers["EchoClientStream"]
+
+	var messages []string
+	var received, strikes, throttled int
+
+	for {
+		req, err := streamServer.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return streamerr.Finish(streamServer.Context(), "EchoClientStream", err)
+		}
+
+		ok, err := a.admit(streamServer.Context(), &strikes)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			throttled++
+			sampler.Printf(logger, "EchoClientStream: Throttled message: %s", req.Message)
+			continue
+		}
+
+		received++
+		if received > maxClientStreamMessages {
+			return status.Errorf(codes.ResourceExhausted, "client stream exceeds %d messages", maxClientStreamMessages)
+		}
+		sampler.Printf(logger, "EchoClientStream: Received message: %s", truncate(req.Message, summaryMessageLen))
+		if len(messages) < summaryMessages {
+			messages = append(messages, truncate(req.Message, summaryMessageLen))
+		}
+	}
+
+	response := &stream.EchoResponse{
+		Message: fmt.Sprintf("Received %d messages: %v", received, messages),
+	}
+	if more := received - len(messages); more > 0 {
+		response.Message += fmt.Sprintf(" and %d more", more)
+	}
+	if throttled > 0 {
+		response.Message += fmt.Sprintf(" (%d throttled)", throttled)
+	}
+
+	logger.Printf("EchoClientStream: Sending response: %s", response.Message)
+	return streamServer.SendAndClose(response)
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+// EchoServerStream handles server streaming - receives a message and sends back a stream of responses.
+func (a *API) EchoServerStream(req *stream.EchoRequest, streamServer stream.EchoService_EchoServerStreamServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	logger.Printf("EchoServerStream: Received message: %s", req.Message)
+
+	if a.limiter != nil && !a.limiter.Allow(a.limiter.Key(streamServer.Context())) {
+		return throttledError("too many stream requests, slow down")
+	}
+
+	// a client that stops reading misses responses instead of holding the
+	// handler; it is told how many once it reads again
+	method, _ := grpc.MethodFromServerStream(streamServer)
+	sender := slowconsumer.NewSender(streamServer.Context(), streamServer, method, slowSendThreshold, slowconsumer.Summarize,
+		func(skipped int) *stream.EchoResponse {
+			return &stream.EchoResponse{Message: fmt.Sprintf("Skipped %d responses, client reads too slowly", skipped)}
+		})
+
+	for i := 1; i <= 5; i++ {
+		response := &stream.EchoResponse{
+			Message: fmt.Sprintf("Echo #%d: %s", i, req.Message),
+		}
+
+		a.samplers["EchoServerStream"].Printf(logger, "EchoServerStream: Sending response #%d: %s", i, response.Message)
+
+		if err := sender.Send(response); err != nil {
+			return streamerr.Finish(streamServer.Context(), "EchoServerStream", err)
+		}
+
+		if err := a.clock.Sleep(streamServer.Context(), 100*time.Millisecond); err != nil {
+			return streamerr.Finish(streamServer.Context(), "EchoServerStream", err)
+		}
+	}
+	if err := sender.Flush(); err != nil {
+		return streamerr.Finish(streamServer.Context(), "EchoServerStream", err)
+	}
+
+	logger.Println("EchoServerStream: Finished sending responses")
+	return nil
+}
+
+// EchoBidirectionalStreamSync handles bidirectional streaming with synchronous processing.
+// It is built from pkg/streams stages connected by unbuffered channels, so
+// every message is answered before the next one is taken from the stream.
+func (a *API) EchoBidirectionalStreamSync(streamServer stream.EchoService_EchoBidirectionalStreamSyncServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	logger.Println("EchoBidirectionalStreamSync: Starting bidirectional stream (sync)")
+
+	method, _ := grpc.MethodFromServerStream(streamServer)
+	latency := metrics.StreamMessageLatency.WithLabelValues(method)
+	var strikes int
+
+	p, _ := streams.New(streamServer.Context())
+	requests := streams.Recv(p, streamServer, 0)
+	admitted := streams.Map(p, requests, a.admitStage("EchoBidirectionalStreamSync", &strikes))
+	replies := streams.Map(p, admitted, func(_ context.Context, in received) (received, error) {
+		if in.reply == nil {
+			in.reply = &stream.EchoResponse{
+				Message:        fmt.Sprintf("Sync Echo: %s", in.req.Message),
+				ConversationId: in.req.GetConversationId(),
+			}
+		}
+		return in, nil
+	})
+	streams.Each(p, replies, a.sendStage(streamServer, latency, "EchoBidirectionalStreamSync"))
+
+	if err := p.Wait(); err != nil {
+		return streamerr.Finish(streamServer.Context(), "EchoBidirectionalStreamSync", err)
+	}
+	logger.Println("EchoBidirectionalStreamSync: Client closed connection")
+	return nil
+}
+
+// EchoBidirectionalStreamAsync handles bidirectional streaming with asynchronous processing.
+// Admitted messages wait in a queue and are processed by several workers in
+// parallel, so the client may keep sending without waiting for responses.
+// Responses of one conversation_id keep the order of their requests, across
+// conversations they may overtake each other.
+// func (a *API) EchoBidirectionalStreamAsync(streamServer grpc.BidiStreamingServer[stream.EchoRequest, stream.EchoResponse]) error {
+func (a *API) EchoBidirectionalStreamAsync(streamServer stream.EchoService_EchoBidirectionalStreamAsyncServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	logger.Println("EchoBidirectionalStreamAsync: Starting bidirectional stream (async)")
+
+	method, _ := grpc.MethodFromServerStream(streamServer)
+	latency := metrics.StreamMessageLatency.WithLabelValues(method)
+	var strikes int
+
+	p, ctx := streams.New(streamServer.Context())
+	requests := streams.Recv(p, streamServer, 0)
+	admitted := streams.Map(p, requests, a.admitStage("EchoBidirectionalStreamAsync", &strikes))
+	queued := streams.Buffer(p, admitted, 10)
+	conversation := func(in received) string { return in.req.GetConversationId() }
+	replies := streams.MapByKey(p, queued, asyncWorkers, conversation, func(ctx context.Context, in received) (received, error) {
+		if in.reply != nil {
+			return in, nil
+		}
+		msgtrace.Dequeued(ctx, in.seq, a.clock.Now().Sub(in.at))
+
+		if err := a.clock.Sleep(ctx, 200*time.Millisecond); err != nil {
+			return in, err
+		}
+
+		in.reply = &stream.EchoResponse{
+			Message:        fmt.Sprintf("Async Echo (processed): %s", in.req.Message),
+			ConversationId: in.req.GetConversationId(),
+		}
+		return in, nil
+	})
+	streams.Each(p, replies, a.sendStage(streamServer, latency, "EchoBidirectionalStreamAsync"))
+	if a.backpressure != nil {
+		a.backpressure.Watch(ctx, method, p.Queues)
+	}
+
+	if err := p.Wait(); err != nil {
+		return streamerr.Finish(streamServer.Context(), "EchoBidirectionalStreamAsync", err)
+	}
+	logger.Println("EchoBidirectionalStreamAsync: Stream finished")
+	return nil
+}
+
+// EchoBidirectionalStreamReliable handles bidirectional streaming with
+// at-least-once delivery. Every response carries a delivery_id and is kept in
+// an outbox until the client acknowledges it in ack_ids; responses without an
+// ack after ackTimeout are sent again under the same id, so the client has to
+// drop duplicates. The client half-closes only after it has acked everything.
+//
+// With sessions the outbox belongs to the session of the stream: a client
+// that reconnects with its token first gets the responses it has not acked
+// and the ones journaled for its conversations while it was away.
+func (a *API) EchoBidirectionalStreamReliable(streamServer stream.EchoService_EchoBidirectionalStreamReliableServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	logger.Println("EchoBidirectionalStreamReliable: Starting bidirectional stream (reliable)")
+
+	outbox := streams.NewOutbox[*stream.EchoResponse](ackTimeout, maxDeliveries)
+	sampler := a.samplers["EchoBidirectionalStreamReliable"]
+	var strikes int
+
+	ctx := streamServer.Context()
+	var sess *rejoin.Session[*stream.EchoResponse]
+	if a.sessions != nil {
+		att, attachedCtx := a.sessions.Attach(ctx)
+		defer att.Leave()
+		sess, ctx, outbox = att.Session, attachedCtx, att.Session.Outbox()
+
+		backlog := a.backlog(att)
+		header := metadata.Pairs(rejoin.TokenHeader, sess.Token(), rejoin.BacklogHeader, strconv.Itoa(len(backlog)))
+		if err := streamServer.SendHeader(header); err != nil {
+			return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", err)
+		}
+		if att.Rejoined {
+			logger.Printf("EchoBidirectionalStreamReliable: Rejoined session in conversations %v, %d responses in backlog",
+				sess.Conversations(), len(backlog))
+		}
+		for _, reply := range backlog {
+			if err := streamServer.Send(reply); err != nil {
+				return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", err)
+			}
+		}
+	}
+
+	p, ctx := streams.New(ctx)
+	requests := streams.Recv(p, streamServer, 0)
+
+	// all sends happen on this goroutine, the redelivery check runs on a timer
+	ticks := a.tick(ctx, ackTimeout/2)
+
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				if err := p.Wait(); err != nil {
+					return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", err)
+				}
+				if n := outbox.Len(); n > 0 {
+					logger.Printf("EchoBidirectionalStreamReliable: Client closed with %d unacknowledged responses", n)
+				}
+				logger.Println("EchoBidirectionalStreamReliable: Client closed connection")
+				return nil
+			}
+
+			if acked := outbox.Ack(req.GetAckIds()...); acked > 0 {
+				sampler.Printf(logger, "EchoBidirectionalStreamReliable: Acknowledged %v, %d pending", req.GetAckIds(), outbox.Len())
+			}
+			if req.GetMessage() == "" {
+				continue
+			}
+			if sess != nil {
+				sess.Join(req.GetConversationId())
+			}
+
+			reply := &stream.EchoResponse{
+				Message:        fmt.Sprintf("Reliable Echo: %s", req.Message),
+				ConversationId: req.GetConversationId(),
+			}
+			ok, err := a.admit(ctx, &strikes)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				reply.Message = fmt.Sprintf("Throttled: %s", req.Message)
+			}
+
+			reply.DeliveryId = outbox.AddAt(reply, a.clock.Now())
+			sampler.Printf(logger, "EchoBidirectionalStreamReliable: Received message: %s", req.Message)
+			if err := streamServer.Send(reply); err != nil {
+				return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", err)
+			}
+			if ok {
+				a.record(ctx, reply)
+			}
+
+		case now := <-ticks:
+			due, err := outbox.Due(now)
+			if err != nil {
+				logger.Printf("EchoBidirectionalStreamReliable: %v, closing stream", err)
+				return status.Error(codes.DeadlineExceeded, err.Error())
+			}
+			for _, d := range due {
+				sampler.Printf(logger, "EchoBidirectionalStreamReliable: Redelivering %d (attempt %d)", d.ID, d.Attempt)
+				if err := streamServer.Send(d.Value); err != nil {
+					return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", err)
+				}
+			}
+
+		case <-ctx.Done():
+			if errors.Is(context.Cause(ctx), rejoin.ErrTakenOver) {
+				logger.Println("EchoBidirectionalStreamReliable: Session rejoined by another stream, closing this one")
+				return status.Error(codes.Aborted, rejoin.ErrTakenOver.Error())
+			}
+			return streamerr.Finish(ctx, "EchoBidirectionalStreamReliable", p.Wait())
+		}
+	}
+}
+
+// tick sends the time of a.clock every d until ctx is done, a ticker that
+// follows a fake clock.
+func (a *API) tick(ctx context.Context, d time.Duration) <-chan time.Time {
+	ticks := make(chan time.Time)
+	go func() {
+		for a.clock.Sleep(ctx, d) == nil {
+			select {
+			case ticks <- a.clock.Now():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ticks
+}
+
+// backlog returns what a stream attaching to a session gets before anything
+// else: the responses still waiting for an ack and, on a rejoin, the
+// responses journaled for the conversations of the session since it was
+// left, added to the outbox.
+func (a *API) backlog(att *rejoin.Attachment[*stream.EchoResponse]) []*stream.EchoResponse {
+	sess := att.Session
+	var out []*stream.EchoResponse
+	for _, d := range sess.Outbox().Restart(a.clock.Now()) {
+		out = append(out, d.Value)
+	}
+	if !att.Rejoined || a.journal == nil {
+		return out
+	}
+
+	from := sess.Offset() + 1
+	for len(out) < maxBacklog {
+		entries := a.journal.Read(from, maxBacklog)
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			from = e.Offset + 1
+			resp := &stream.EchoResponse{}
+			if proto.Unmarshal(e.Data, resp) != nil || !sess.Member(resp.GetConversationId()) {
+				continue
+			}
+			resp.DeliveryId = sess.Outbox().AddAt(resp, a.clock.Now())
+			out = append(out, resp)
+			if len(out) == maxBacklog {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// EchoReplay streams the journal of echoed messages starting at from_offset
+// and then follows it live. Every response carries its offset, so a client
+// that loses the stream resumes where it stopped instead of starting over.
+func (a *API) EchoReplay(req *stream.ReplayRequest, streamServer stream.EchoService_EchoReplayServer) error {
+	logger := logctx.Logger(streamServer.Context())
+
+	if a.journal == nil {
+		return status.Error(codes.FailedPrecondition, "message journal is disabled")
+	}
+	if last := a.journal.Last(); req.GetFromOffset() > last+1 {
+		return status.Errorf(codes.OutOfRange, "from_offset %d is past the end of the journal (%d)", req.GetFromOffset(), last)
+	}
+
+	logger.Printf("EchoReplay: Replaying from offset %d, journal ends at %d", req.GetFromOffset(), a.journal.Last())
+
+	// skipping entries would break resuming by offset: a client that stops
+	// reading loses the stream and resumes once it reads again
+	method, _ := grpc.MethodFromServerStream(streamServer)
+	sender := slowconsumer.NewSender[stream.EchoResponse](streamServer.Context(), streamServer, method, slowSendThreshold, slowconsumer.Terminate, nil)
+
+	err := a.journal.FollowIdle(streamServer.Context(), req.GetFromOffset(), func(e journal.Entry) error {
+		resp := &stream.EchoResponse{}
+		if err := proto.Unmarshal(e.Data, resp); err != nil {
+			return status.Errorf(codes.DataLoss, "journal entry %d: %v", e.Offset, err)
+		}
+		resp.Offset = e.Offset
+		return sender.Send(resp)
+	}, a.heartbeat, func() error {
+		return sender.Send(&stream.EchoResponse{Heartbeat: true})
+	})
+	if status.Code(err) == codes.DataLoss {
+		logger.Printf("EchoReplay: %v", err)
+		return err
+	}
+
+	// the subscriber leaving is the usual end of a replay
+	return streamerr.Finish(streamServer.Context(), "EchoReplay", err)
+}
+
+// admitStage returns the pipeline stage that stamps every request with its
+// sequence number and arrival time and applies the per-peer limiter.
+// Throttled requests leave the stage with a ready notice as their reply.
+func (a *API) admitStage(name string, strikes *int) func(context.Context, *stream.EchoRequest) (received, error) {
+	var seq uint64
+	return func(ctx context.Context, req *stream.EchoRequest) (received, error) {
+		logger := logctx.Logger(ctx)
+		seq++
+		in := received{req: req, seq: seq, at: a.clock.Now()}
+
+		ok, err := a.admit(ctx, strikes)
+		if err != nil {
+			return in, err
+		}
+		if !ok {
+			in.reply = &stream.EchoResponse{
+				Message:        fmt.Sprintf("Throttled: %s", req.Message),
+				ConversationId: req.GetConversationId(),
+			}
+			in.throttled = true
+			return in, nil
+		}
+
+		a.samplers[name].Printf(logger, "%s: Received message: %s", name, req.Message)
+		return in, nil
+	}
+}
+
+// sendStage returns the pipeline sink that writes replies to the stream,
+// journals them and records how long each admitted message took from Recv to
+// Send.
+func (a *API) sendStage(s streams.Sender[stream.EchoResponse], latency prometheus.Observer, name string) func(context.Context, received) error {
+	return func(ctx context.Context, in received) error {
+		logger := logctx.Logger(ctx)
+		if err := s.Send(in.reply); err != nil {
+			logger.Printf("%s: Error sending response: %v", name, err)
+			return err
+		}
+		if in.throttled {
+			return nil
+		}
+
+		latency.Observe(a.clock.Now().Sub(in.at).Seconds())
+		a.record(ctx, in.reply)
+		a.samplers[name].Printf(logger, "%s: Sent response: %s", name, in.reply.Message)
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package echostream_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/inprocess"
+)
+
+// maxSummary bounds the response of EchoClientStream: the counters and ten
+// messages of at most 256 bytes each, whatever the client sent.
+const maxSummary = 4096
+
+// newServer starts the services over bufconn for the whole fuzz run; the
+// logs of the calls are dropped.
+func newServer(tb testing.TB) *inprocess.Server {
+	tb.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+
+	srv, err := inprocess.New()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// FuzzEchoClientStream sends the parts of data between zero bytes as the
+// messages of a client stream, every part repeat times, and checks that the
+// summary does not grow with them.
+func FuzzEchoClientStream(f *testing.F) {
+	f.Add([]byte("hello\x00world"), uint8(1))
+	f.Add([]byte(strings.Repeat("x", 1000)), uint8(20))
+	f.Add([]byte("\xff\xfe\x00привет"), uint8(3))
+	f.Add([]byte{}, uint8(0))
+
+	client := stream.NewEchoServiceClient(newServer(f).Conn())
+	f.Fuzz(func(t *testing.T, data []byte, repeat uint8) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		s, err := client.EchoClientStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range repeat {
+			for _, part := range bytes.Split(data, []byte{0}) {
+				// proto3 strings are UTF-8, the client refuses to send others
+				msg := strings.ToValidUTF8(string(part), "�")
+				if err := s.Send(&stream.EchoRequest{Message: msg}); err != nil {
+					break
+				}
+			}
+		}
+		resp, err := s.CloseAndRecv()
+		switch status.Code(err) {
+		case codes.Internal, codes.Unknown:
+			t.Fatalf("EchoClientStream: %v", err)
+		}
+		if n := len(resp.GetMessage()); n > maxSummary {
+			t.Fatalf("EchoClientStream: %d bytes of summary for %d bytes sent %d times", n, len(data), repeat)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package echostream
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/streamerr"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// maxPullCredits bounds the credits a client may hold at once: the server
+// could otherwise be asked to queue an unbounded stream into the HTTP/2
+// window, which is exactly what credits are meant to prevent.
+const maxPullCredits = 1000
+
+// EchoPull is a server stream under application-level flow control. HTTP/2
+// flow control only stops the server once the client's receive window is
+// full, so a slow client still gets everything the window holds; here the
+// client says how many responses it can take, and the server sends exactly
+// that many and waits for more credits.
+//
+// The first request carries the message and the total count of responses,
+// every request may grant credits. The stream ends once count responses are
+// sent, or once the client half-closed and its credits are used up.
+func (a *API) EchoPull(streamServer stream.EchoService_EchoPullServer) error {
+	logger := logctx.Logger(streamServer.Context())
+	sampler := a.samplers["EchoPull"]
+
+	if a.limiter != nil && !a.limiter.Allow(a.limiter.Key(streamServer.Context())) {
+		return throttledError("too many stream requests, slow down")
+	}
+
+	p, ctx := streams.New(streamServer.Context())
+	requests := streams.Recv(p, streamServer, 0)
+
+	var first *stream.PullRequest
+	select {
+	case first = <-requests:
+	case <-ctx.Done():
+	}
+	if first == nil {
+		return streamerr.Finish(ctx, "EchoPull", p.Wait())
+	}
+	if first.GetMessage() == "" {
+		return status.Error(codes.InvalidArgument, "the first request needs a message")
+	}
+	count := first.GetCount()
+	logger.Printf("EchoPull: Received message: %s, %d responses, %d credits", first.GetMessage(), count, first.GetCredits())
+
+	var credits, sent, pauses uint64
+	// grant adds the credits of req; a nil req means the client half-closed
+	// and grants nothing more
+	grant := func(req *stream.PullRequest) error {
+		if req == nil {
+			requests = nil
+			return p.Wait()
+		}
+		credits += uint64(req.GetCredits())
+		if credits > maxPullCredits {
+			return status.Errorf(codes.InvalidArgument, "%d credits outstanding, at most %d allowed", credits, maxPullCredits)
+		}
+		return nil
+	}
+	if err := grant(first); err != nil {
+		return streamerr.Finish(ctx, "EchoPull", err)
+	}
+
+	for count == 0 || sent < uint64(count) {
+		if credits == 0 {
+			if requests == nil {
+				break
+			}
+			pauses++
+			sampler.Printf(logger, "EchoPull: Paused after %d responses, waiting for credits", sent)
+			select {
+			case req := <-requests:
+				if err := grant(req); err != nil {
+					return streamerr.Finish(ctx, "EchoPull", err)
+				}
+			case <-ctx.Done():
+				return streamerr.Finish(ctx, "EchoPull", p.Wait())
+			}
+			continue
+		}
+
+		// credits granted meanwhile are taken without waiting for them
+		select {
+		case req := <-requests:
+			if err := grant(req); err != nil {
+				return streamerr.Finish(ctx, "EchoPull", err)
+			}
+		default:
+		}
+
+		sent++
+		credits--
+		response := &stream.EchoResponse{Message: fmt.Sprintf("Pull Echo #%d: %s", sent, first.GetMessage())}
+		sampler.Printf(logger, "EchoPull: Sending response #%d, %d credits left", sent, credits)
+		if err := streamServer.Send(response); err != nil {
+			return streamerr.Finish(ctx, "EchoPull", err)
+		}
+	}
+
+	logger.Printf("EchoPull: Sent %d responses, paused %d times, %d credits unused", sent, pauses, credits)
+	return nil
+}
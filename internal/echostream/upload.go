@@ -0,0 +1,99 @@
+package echostream
+
+import (
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/streamerr"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/chunk"
+)
+
+const (
+	// maxUploadChunk keeps every chunk well below the 4 MiB message limit of
+	// grpc, maxUploadSize bounds a whole file.
+	maxUploadChunk = 1 << 20
+	maxUploadSize  = 64 << 20
+	// uploadAckBytes is how much has to arrive between two progress acks.
+	uploadAckBytes = 256 << 10
+)
+
+// UploadFile receives a file in chunks and verifies its size and checksum.
+// Every uploadAckBytes the client gets an ack of what has arrived so far.
+// The file is not stored, the final response tells what arrived.
+func (a *API) UploadFile(streamServer stream.EchoService_UploadFileServer) error {
+	ctx := streamServer.Context()
+	logger := logctx.Logger(ctx)
+
+	var name string
+	var acked int64
+	assembler := chunk.NewAssembler(io.Discard, maxUploadChunk, maxUploadSize)
+	for {
+		req, err := streamServer.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return streamerr.Finish(ctx, "UploadFile", err)
+		}
+
+		if assembler.Chunks() == 0 {
+			name = req.GetName()
+			logger.Printf("UploadFile: Receiving %q, %d bytes announced", name, req.GetTotalSize())
+		}
+		err = assembler.Add(chunk.Chunk{
+			Offset:    req.GetOffset(),
+			Data:      req.GetData(),
+			TotalSize: req.GetTotalSize(),
+			Last:      req.GetLast(),
+			Checksum:  req.GetSha256(),
+		})
+		if err != nil {
+			logger.Printf("UploadFile: %q rejected: %v", name, err)
+			return uploadError(err)
+		}
+
+		if !req.GetLast() && assembler.Size()-acked >= uploadAckBytes {
+			acked = assembler.Size()
+			err := streamServer.Send(&stream.UploadFileResponse{
+				Size:   acked,
+				Chunks: uint32(assembler.Chunks()),
+			})
+			if err != nil {
+				return streamerr.Finish(ctx, "UploadFile", err)
+			}
+		}
+	}
+
+	sum, err := assembler.Close()
+	if err != nil {
+		logger.Printf("UploadFile: %q rejected: %v", name, err)
+		return uploadError(err)
+	}
+
+	logger.Printf("UploadFile: Received %q, %d bytes in %d chunks", name, assembler.Size(), assembler.Chunks())
+	err = streamServer.Send(&stream.UploadFileResponse{
+		Name:     name,
+		Size:     assembler.Size(),
+		Sha256:   sum,
+		Chunks:   uint32(assembler.Chunks()),
+		Complete: true,
+	})
+	return streamerr.Finish(ctx, "UploadFile", err)
+}
+
+// uploadError maps the errors of the assembler to status codes.
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, chunk.ErrTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, chunk.ErrChecksum), errors.Is(err, chunk.ErrSizeMismatch):
+		return status.Error(codes.DataLoss, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
@@ -0,0 +1,157 @@
+// Package exportbatch packs the orders of ExportOrders into compressed
+// batches and unpacks them. Message compression of gRPC compresses every
+// message on its own, and a single order is too small to compress well;
+// a batch of orders compresses as one payload, so the repeated field names
+// and values across the orders are encoded once. The price is latency: an
+// order reaches the client only with its whole batch.
+//
+// The codec is named in the first batch of an export only; the following
+// batches use the same one.
+package exportbatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/internal/compression"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// DefaultSize is the number of orders per batch when the request sets none.
+const DefaultSize = 100
+
+// compressorName returns the grpc-go compressor of codec, "" for identity.
+func compressorName(codec pbv2.PayloadCodec) (string, error) {
+	switch codec {
+	case pbv2.PayloadCodec_PAYLOAD_CODEC_IDENTITY:
+		return "", nil
+	case pbv2.PayloadCodec_PAYLOAD_CODEC_GZIP:
+		return compression.Gzip, nil
+	case pbv2.PayloadCodec_PAYLOAD_CODEC_ZSTD:
+		return compression.Zstd, nil
+	}
+	return "", fmt.Errorf("unknown payload codec %v", codec)
+}
+
+// ParseCodec returns the codec called identity, gzip or zstd.
+func ParseCodec(name string) (pbv2.PayloadCodec, error) {
+	switch name {
+	case compression.Identity:
+		return pbv2.PayloadCodec_PAYLOAD_CODEC_IDENTITY, nil
+	case compression.Gzip:
+		return pbv2.PayloadCodec_PAYLOAD_CODEC_GZIP, nil
+	case compression.Zstd:
+		return pbv2.PayloadCodec_PAYLOAD_CODEC_ZSTD, nil
+	}
+	return 0, fmt.Errorf("unknown payload codec %q, want %s, %s or %s", name, compression.Identity, compression.Gzip, compression.Zstd)
+}
+
+// Writer packs the batches of one export.
+type Writer struct {
+	codec pbv2.PayloadCodec
+	name  string
+	first bool
+}
+
+// NewWriter creates a writer compressing with codec.
+func NewWriter(codec pbv2.PayloadCodec) (*Writer, error) {
+	name, err := compressorName(codec)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{codec: codec, name: name, first: true}, nil
+}
+
+// Batch packs orders into a batch.
+func (w *Writer) Batch(orders []*pbv2.Order) (*pbv2.ExportOrdersBatch, error) {
+	data, err := proto.Marshal(&pbv2.ExportOrdersBatchPayload{Orders: orders})
+	if err != nil {
+		return nil, fmt.Errorf("encode batch: %w", err)
+	}
+	if w.name != "" {
+		var buf bytes.Buffer
+		zw, err := encoding.GetCompressor(w.name).Compress(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("compress batch: %w", err)
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, fmt.Errorf("compress batch: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("compress batch: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	batch := &pbv2.ExportOrdersBatch{Payload: data, Count: uint32(len(orders))}
+	if w.first {
+		batch.Codec = w.codec
+		w.first = false
+	}
+	return batch, nil
+}
+
+// Reader returns the orders of the responses of one export, batched or
+// not, so the caller handles both the same way.
+type Reader struct {
+	name string
+	// codec is set once the first batch named it
+	codec pbv2.PayloadCodec
+	// maxSize bounds a decompressed payload
+	maxSize int
+}
+
+// NewReader creates a reader refusing payloads that decompress to more than
+// maxSize bytes, so a small batch can not blow up in memory.
+func NewReader(maxSize int) *Reader {
+	return &Reader{maxSize: maxSize}
+}
+
+// Orders returns the orders of resp: its order or the orders of its batch.
+func (r *Reader) Orders(resp *pbv2.ExportOrdersResponse) ([]*pbv2.Order, error) {
+	batch := resp.GetBatch()
+	if batch == nil {
+		if resp.GetOrder() == nil {
+			return nil, nil
+		}
+		return []*pbv2.Order{resp.GetOrder()}, nil
+	}
+
+	if r.codec == pbv2.PayloadCodec_PAYLOAD_CODEC_UNSPECIFIED {
+		name, err := compressorName(batch.GetCodec())
+		if err != nil {
+			return nil, fmt.Errorf("first batch: %w", err)
+		}
+		r.codec, r.name = batch.GetCodec(), name
+	} else if c := batch.GetCodec(); c != pbv2.PayloadCodec_PAYLOAD_CODEC_UNSPECIFIED && c != r.codec {
+		return nil, fmt.Errorf("batch switched codec from %v to %v", r.codec, c)
+	}
+
+	data := batch.GetPayload()
+	if r.name != "" {
+		zr, err := encoding.GetCompressor(r.name).Decompress(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompress batch: %w", err)
+		}
+		if data, err = io.ReadAll(io.LimitReader(zr, int64(r.maxSize)+1)); err != nil {
+			return nil, fmt.Errorf("decompress batch: %w", err)
+		}
+	}
+	if len(data) > r.maxSize {
+		return nil, fmt.Errorf("batch decompresses to more than %d bytes", r.maxSize)
+	}
+
+	var payload pbv2.ExportOrdersBatchPayload
+	if err := proto.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("decode batch: %w", err)
+	}
+	if len(payload.GetOrders()) != int(batch.GetCount()) {
+		return nil, errors.New("batch count does not match its orders")
+	}
+	return payload.GetOrders(), nil
+}
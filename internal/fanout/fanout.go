@@ -0,0 +1,48 @@
+// Package fanout scatters a call over several targets and gathers the
+// results. Every target gets its own context derived from the one of the
+// incoming call: the deadline and cancellation of the caller reach all of
+// them, while a per-target timeout bounds a slow target without touching
+// the others. A failed target neither cancels the rest nor fails the
+// gather: its error is part of its result, and the caller decides what a
+// partial answer is worth.
+package fanout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of the call to one target.
+type Result[T any] struct {
+	Value T
+	Err   error
+	// Latency is how long the call to the target took.
+	Latency time.Duration
+}
+
+// Gather calls call for the targets 0..n-1 concurrently and returns their
+// results in the order of the targets once all calls have returned. Each
+// call gets a context derived from ctx, limited to timeout when it is
+// positive.
+func Gather[T any](ctx context.Context, n int, timeout time.Duration, call func(ctx context.Context, target int) (T, error)) []Result[T] {
+	results := make([]Result[T], n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			callCtx, cancel := ctx, context.CancelFunc(func() {})
+			if timeout > 0 {
+				callCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+
+			start := time.Now()
+			v, err := call(callCtx, i)
+			results[i] = Result[T]{Value: v, Err: err, Latency: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+	return results
+}
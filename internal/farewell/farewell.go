@@ -0,0 +1,100 @@
+// Package farewell ends the long-lived streams of a server that is shutting
+// down. GracefulStop waits for open streams to finish, but a subscription or
+// a chat stream never finishes on its own: it is cut off when the shutdown
+// deadline passes and the client sees a reset connection. Instead, once the
+// server starts draining, every open server and bidi stream is closed with
+// Unavailable and a RetryInfo detail telling the client to reconnect
+// elsewhere, and new ones are rejected the same way.
+package farewell
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/streamstop"
+)
+
+// Message is the status message streams are closed with.
+const Message = "server is shutting down, reconnect"
+
+var errDraining = errors.New(Message)
+
+// Notifier tracks the open streams. It is safe for concurrent use.
+type Notifier struct {
+	retryDelay time.Duration
+
+	once     sync.Once
+	draining chan struct{}
+	streams  sync.WaitGroup
+}
+
+// New creates a notifier; retryDelay is advised to the clients of the
+// closed streams.
+func New(retryDelay time.Duration) *Notifier {
+	return &Notifier{retryDelay: retryDelay, draining: make(chan struct{})}
+}
+
+func (n *Notifier) err() error {
+	st, err := status.New(codes.Unavailable, Message).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(n.retryDelay),
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, Message)
+	}
+	return st.Err()
+}
+
+// Drain closes every open server and bidi stream and waits, at most until
+// ctx is done, for their handlers to return. Register it with the graceful
+// group so it runs before the server is stopped.
+func (n *Notifier) Drain(ctx context.Context) error {
+	n.once.Do(func() { close(n.draining) })
+
+	done := make(chan struct{})
+	go func() {
+		n.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StreamServerInterceptor closes server and bidi streams once Drain is
+// called. Client streams end on their own and are left to finish. The
+// handler runs under streamstop.Run: its context is canceled, and the
+// stream is closed once it returns or streamstop.Grace passes.
+func (n *Notifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !info.IsServerStream {
+			return handler(srv, ss)
+		}
+		select {
+		case <-n.draining:
+			return n.err()
+		default:
+		}
+
+		n.streams.Add(1)
+		defer n.streams.Done()
+
+		stopped, err := streamstop.Run(srv, ss, handler, n.draining, errDraining)
+		if !stopped {
+			return err
+		}
+		logctx.Logger(ss.Context()).Printf("[FAREWELL] %s: closing stream, server is shutting down", info.FullMethod)
+		return n.err()
+	}
+}
@@ -0,0 +1,69 @@
+// Package faults injects delays and errors into calls on purpose, to show
+// how clients, retries and deadlines behave when the server misbehaves.
+package faults
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Config says what goes wrong. The zero Config injects nothing.
+type Config struct {
+	// Delay is added before every call is handled.
+	Delay time.Duration
+	// ErrorRate is the share of calls, from 0 to 1, that fail with Code
+	// instead of reaching the handler.
+	ErrorRate float64
+	Code      codes.Code
+}
+
+// ParseCode parses a status code name like "UNAVAILABLE".
+func ParseCode(name string) (codes.Code, error) {
+	var c codes.Code
+	err := c.UnmarshalJSON([]byte(`"` + name + `"`))
+	return c, err
+}
+
+// UnaryServerInterceptor injects the faults of cfg into unary calls.
+func UnaryServerInterceptor(cfg Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := cfg.inject(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor injects the faults of cfg when a stream starts.
+func StreamServerInterceptor(cfg Config) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := cfg.inject(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (cfg Config) inject(ctx context.Context, method string) error {
+	if cfg.Delay > 0 {
+		t := time.NewTimer(cfg.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if cfg.ErrorRate > 0 && rand.Float64() < cfg.ErrorRate {
+		logctx.Logger(ctx).Printf("[FAULTS] %s: injected %s", method, cfg.Code)
+		return status.Errorf(cfg.Code, "injected fault")
+	}
+	return nil
+}
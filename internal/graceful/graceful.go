@@ -0,0 +1,221 @@
+// Package graceful runs the components of a command (gRPC servers, HTTP
+// endpoints, client loops) as one group and shuts them down in order.
+//
+// The first component to return, or SIGINT/SIGTERM, ends the group: every
+// component is then stopped in reverse order of registration within a shared
+// deadline, so later components (e.g. a readiness flip) are stopped before
+// the servers they depend on.
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type unit struct {
+	name string
+	run  func() error
+	stop func(ctx context.Context) error
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// Phase is the shutdown of one component.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	// Err is the error of its stop, nil when it stopped cleanly.
+	Err error
+}
+
+// Group is a set of components with a common lifecycle.
+type Group struct {
+	timeout    time.Duration
+	units      []unit
+	onShutdown []func(ctx context.Context)
+	phases     []Phase
+}
+
+// New creates a group whose shutdown must complete within timeout.
+func New(timeout time.Duration) *Group {
+	return &Group{timeout: timeout}
+}
+
+// Add registers a component. run blocks for the life of the component, stop
+// asks it to return. Either may be nil: a component without run is a pure
+// shutdown hook, one without stop is expected to return on its own.
+func (g *Group) Add(name string, run func() error, stop func(ctx context.Context) error) {
+	g.units = append(g.units, unit{name: name, run: run, stop: stop})
+}
+
+// AddContext registers a component driven by a context, which is cancelled
+// when the group shuts down. Stopping waits for fn to return.
+func (g *Group) AddContext(name string, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	g.Add(name,
+		func() error {
+			defer close(done)
+			return fn(ctx)
+		},
+		func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	)
+}
+
+// AddGRPCServer serves s on l. On shutdown in-flight RPCs are given the rest
+// of the deadline to finish before the server is stopped forcefully.
+func (g *Group) AddGRPCServer(name string, s *grpc.Server, l net.Listener) {
+	g.Add(name,
+		func() error {
+			log.Printf("%s listening on %s", name, l.Addr())
+			return s.Serve(l)
+		},
+		func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				s.GracefulStop()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				s.Stop()
+				return fmt.Errorf("graceful stop: %w", ctx.Err())
+			}
+		},
+	)
+}
+
+// AddHTTPServer runs srv until shutdown.
+func (g *Group) AddHTTPServer(name string, srv *http.Server) {
+	g.Add(name,
+		func() error {
+			log.Printf("%s listening on %s", name, srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		srv.Shutdown,
+	)
+}
+
+// AddHTTPListener runs srv on l until shutdown, for a listener created
+// elsewhere, e.g. inherited from another process.
+func (g *Group) AddHTTPListener(name string, srv *http.Server, l net.Listener) {
+	g.Add(name,
+		func() error {
+			log.Printf("%s listening on %s", name, l.Addr())
+			if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		srv.Shutdown,
+	)
+}
+
+// OnShutdown registers fn to be called when the group starts shutting down,
+// before any component is stopped. ctx is done once the shutdown deadline
+// passes.
+func (g *Group) OnShutdown(fn func(ctx context.Context)) {
+	g.onShutdown = append(g.onShutdown, fn)
+}
+
+// Phases returns the shutdown of every component with a stop, in the order
+// they were stopped. It is complete once Run has returned.
+func (g *Group) Phases() []Phase {
+	return g.phases
+}
+
+// Run starts all components and blocks until the group is over. It returns
+// the error of the component that ended the group, if any.
+func (g *Group) Run(ctx context.Context) error {
+	ctx, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	results := make(chan result, len(g.units))
+	running := 0
+	for _, u := range g.units {
+		if u.run == nil {
+			continue
+		}
+		running++
+		go func() {
+			results <- result{name: u.name, err: u.run()}
+		}()
+	}
+
+	var first error
+	select {
+	case <-ctx.Done():
+		log.Println("Shutdown signal received")
+	case r := <-results:
+		running--
+		first = r.err
+		if r.err != nil {
+			log.Printf("%s failed: %v", r.name, r.err)
+		} else {
+			log.Printf("%s finished", r.name)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	for _, fn := range g.onShutdown {
+		fn(shutdownCtx)
+	}
+
+	for i := len(g.units) - 1; i >= 0; i-- {
+		u := g.units[i]
+		if u.stop == nil {
+			continue
+		}
+
+		start := time.Now()
+		err := u.stop(shutdownCtx)
+		g.phases = append(g.phases, Phase{Name: u.name, Duration: time.Since(start), Err: err})
+		if err != nil {
+			log.Printf("Stopping %s: %v", u.name, err)
+		} else {
+			log.Printf("Stopped %s in %v", u.name, time.Since(start).Round(time.Millisecond))
+		}
+	}
+
+	for ; running > 0; running-- {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				log.Printf("%s returned: %v", r.name, r.err)
+			}
+		case <-shutdownCtx.Done():
+			log.Printf("Shutdown deadline exceeded, %d components still running", running)
+			return errors.Join(first, shutdownCtx.Err())
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,246 @@
+// Package handoff restarts a server without refusing a single connection.
+// On SIGUSR2 the running process starts a new copy of its binary with the
+// same arguments and passes it its listening sockets as inherited file
+// descriptors. The new process serves on them as soon as it is up and tells
+// the old one so over a pipe; only then the old process shuts down
+// gracefully: it stops accepting, lets the calls in flight finish and sends
+// GOAWAY, and the clients reconnect to the new process on the same socket.
+// Connections that arrive in between wait in the accept queue of the shared
+// socket, which stays open the whole time.
+//
+// When the new process fails to start, the old one keeps serving.
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables of the new process.
+const (
+	// EnvListeners lists the inherited listeners as name=fd pairs.
+	EnvListeners = "HANDOFF_LISTENERS"
+	// EnvReady is the fd of the pipe the new process reports readiness on.
+	EnvReady = "HANDOFF_READY_FD"
+)
+
+// filer is a listener whose socket can be passed to another process, like
+// *net.TCPListener.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Handoff keeps the listeners of a server to pass them on at a restart. It
+// is safe for concurrent use.
+type Handoff struct {
+	inherited map[string]net.Listener
+	ready     *os.File
+
+	mu        sync.Mutex
+	names     []string
+	listeners map[string]net.Listener
+}
+
+// New takes the listeners the process inherited from the one it replaces,
+// if any.
+func New() (*Handoff, error) {
+	h := &Handoff{
+		inherited: make(map[string]net.Listener),
+		listeners: make(map[string]net.Listener),
+	}
+	spec := os.Getenv(EnvListeners)
+	readyFD := os.Getenv(EnvReady)
+	// the variables describe this process only, not the ones it starts
+	os.Unsetenv(EnvListeners)
+	os.Unsetenv(EnvReady)
+	if spec == "" {
+		return h, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, fdSpec, _ := strings.Cut(pair, "=")
+		fd, err := strconv.Atoi(fdSpec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad pair %q", EnvListeners, pair)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener duplicates the descriptor
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", name, err)
+		}
+		h.inherited[name] = l
+	}
+	if fd, err := strconv.Atoi(readyFD); err == nil {
+		h.ready = os.NewFile(uintptr(fd), "handoff ready")
+	}
+	return h, nil
+}
+
+// Listen returns the listener called name inherited from the previous
+// process, or the one listen creates. Either way it is passed on at the
+// next restart, so listen must return a listener whose socket can be
+// passed, e.g. a *net.TCPListener, not a wrapper around it.
+func (h *Handoff) Listen(name string, listen func() (net.Listener, error)) (net.Listener, error) {
+	l, ok := h.inherited[name]
+	if ok {
+		delete(h.inherited, name)
+		log.Printf("[HANDOFF] %s: inherited listener on %s", name, l.Addr())
+	} else {
+		var err error
+		if l, err = listen(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := l.(filer); !ok {
+		l.Close()
+		return nil, fmt.Errorf("listener %s: %T can not be passed to another process", name, l)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = append(h.names, name)
+	h.listeners[name] = l
+	return l, nil
+}
+
+// Ready tells the previous process that this one serves, so it may stop.
+// It does nothing in a process that did not take over from another one.
+func (h *Handoff) Ready() {
+	if h.ready == nil {
+		return
+	}
+	// inherited listeners nobody asked for are closed: the old process
+	// listened on something this one does not
+	for name, l := range h.inherited {
+		log.Printf("[HANDOFF] %s: inherited listener on %s is not used, closing it", name, l.Addr())
+		l.Close()
+	}
+	if _, err := h.ready.Write([]byte{1}); err != nil {
+		log.Printf("[HANDOFF] report readiness: %v", err)
+	}
+	h.ready.Close()
+	h.ready = nil
+}
+
+// Restart starts a new process of the same binary with the same arguments
+// and the listeners of h, and waits until it reports that it is ready. The
+// caller then shuts the current process down. An error means the new
+// process did not come up, and the current one should keep serving.
+func (h *Handoff) Restart(ctx context.Context) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	h.mu.Lock()
+	var files []*os.File
+	var pairs []string
+	for _, name := range h.names {
+		f, err := h.listeners[name].(filer).File()
+		if err != nil {
+			h.mu.Unlock()
+			closeAll(files)
+			return fmt.Errorf("listener %s: %w", name, err)
+		}
+		// ExtraFiles become the descriptors 3, 4, ... of the new process
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, 3+len(files)))
+		files = append(files, f)
+	}
+	h.mu.Unlock()
+	defer closeAll(files)
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		EnvListeners+"="+strings.Join(pairs, ","),
+		EnvReady+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	// only the new process writes to the pipe now: when it exits, the read
+	// below ends
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("start %s: %w", exe, err)
+	}
+	pid := cmd.Process.Pid
+	log.Printf("[HANDOFF] started pid %d with %s, waiting until it is ready", pid, strings.Join(pairs, ","))
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := readyR.Read(b[:])
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if errors.Is(err, io.EOF) {
+			err = errors.New("exited before it was ready")
+		}
+		return fmt.Errorf("new process %d: %w", pid, err)
+	}
+	// the new process outlives this one
+	_ = cmd.Process.Release()
+	log.Printf("[HANDOFF] pid %d is ready, handing over", pid)
+	return nil
+}
+
+// Run restarts the process on SIGUSR2, giving the new process timeout to
+// get ready, and returns once it took over or when ctx is done. A failed
+// restart is logged and the process keeps serving. Run fits
+// graceful.Group.AddContext: when it returns, the group shuts down.
+func (h *Handoff) Run(ctx context.Context, timeout time.Duration) error {
+	if len(signals) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sig:
+		}
+		restartCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := h.Restart(restartCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		log.Printf("[HANDOFF] restart failed, still serving: %v", err)
+	}
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
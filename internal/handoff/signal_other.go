@@ -0,0 +1,17 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"errors"
+	"os"
+)
+
+// signals ask the process to restart: there is no SIGUSR2 here, and
+// inherited sockets are not supported either.
+var signals []os.Signal
+
+// Signal asks the server process pid to restart.
+func Signal(int) error {
+	return errors.New("restart by signal is not supported on this platform")
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package handoff
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals ask the process to restart.
+var signals = []os.Signal{syscall.SIGUSR2}
+
+// Signal asks the server process pid to restart.
+func Signal(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
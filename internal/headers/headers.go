@@ -0,0 +1,110 @@
+// Package headers lets the course clients attach arbitrary metadata to every
+// call from repeated -H "key: value" command line flags.
+package headers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Flag is a flag.Value collecting "key: value" pairs. Values of keys ending
+// in "-bin" are base64 encoded on the command line and decoded here, since
+// gRPC carries binary metadata as raw bytes and encodes it on the wire itself.
+type Flag struct {
+	md metadata.MD
+}
+
+func (f *Flag) String() string {
+	if f == nil || len(f.md) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(f.md))
+	for k, vs := range f.md {
+		for _, v := range vs {
+			pairs = append(pairs, k+": "+v)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (f *Flag) Set(s string) error {
+	key, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("header %q: expected \"key: value\"", s)
+	}
+
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+	if key == "" {
+		return fmt.Errorf("header %q: empty key", s)
+	}
+
+	if strings.HasSuffix(key, "-bin") {
+		raw, err := decodeBase64(value)
+		if err != nil {
+			return fmt.Errorf("header %q: binary value must be base64: %w", key, err)
+		}
+		value = string(raw)
+	}
+
+	if f.md == nil {
+		f.md = metadata.MD{}
+	}
+	f.md.Append(key, value)
+	return nil
+}
+
+// MD returns the collected metadata.
+func (f *Flag) MD() metadata.MD {
+	return f.md
+}
+
+// UnaryClientInterceptor adds md to the outgoing metadata of every call.
+func UnaryClientInterceptor(md metadata.MD) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(appendMD(ctx, md), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor adds md to the outgoing metadata of every stream.
+func StreamClientInterceptor(md metadata.MD) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(appendMD(ctx, md), desc, cc, method, opts...)
+	}
+}
+
+func appendMD(ctx context.Context, md metadata.MD) context.Context {
+	for k, vs := range md {
+		for _, v := range vs {
+			ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+		}
+	}
+	return ctx
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	if raw, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return raw, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,121 @@
+// Package i18n translates the error messages of the course servers. The
+// language is picked from the accept-language metadata of the call, the
+// translated text travels next to the English status message as an
+// errdetails.LocalizedMessage, so the client can show it to a user while
+// logs and code keep matching on the stable English message.
+package i18n
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Key is the metadata key the client lists its preferred languages in, in
+// the format of the HTTP Accept-Language header: "ru-RU, ru;q=0.9, en;q=0.5".
+const Key = "accept-language"
+
+// Message identifies a text of the catalog.
+type Message string
+
+// Messages of the catalog.
+const (
+	CustomError         Message = "custom_error"
+	OrderRejected       Message = "order_rejected"
+	OrderNotCancellable Message = "order_not_cancellable"
+)
+
+// supported languages, the first one is the fallback and the language of
+// status messages
+var supported = []language.Tag{language.English, language.Russian}
+
+var matcher = language.NewMatcher(supported)
+
+// catalog holds a format string per message and language.
+var catalog = map[Message]map[language.Tag]string{
+	CustomError: {
+		language.English: "Custom error",
+		language.Russian: "Пользовательская ошибка",
+	},
+	OrderRejected: {
+		language.English: "Order for product %s was rejected",
+		language.Russian: "Заказ товара %s отклонен",
+	},
+	OrderNotCancellable: {
+		language.English: "Order %s cannot be cancelled",
+		language.Russian: "Заказ %s нельзя отменить",
+	},
+}
+
+// Locale returns the supported language that fits the accept-language of
+// the incoming call best, English if the caller sent none.
+func Locale(ctx context.Context) language.Tag {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var prefs []language.Tag
+	for _, v := range md.Get(Key) {
+		tags, _, err := language.ParseAcceptLanguage(v)
+		if err != nil {
+			continue
+		}
+		prefs = append(prefs, tags...)
+	}
+	_, i, _ := matcher.Match(prefs...)
+	return supported[i]
+}
+
+// Text formats msg with args in lang, in English if the catalog has no
+// translation.
+func Text(lang language.Tag, msg Message, args ...any) string {
+	texts := catalog[msg]
+	format, ok := texts[lang]
+	if !ok {
+		format, ok = texts[supported[0]]
+	}
+	if !ok {
+		format = string(msg)
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Status creates a status with the English text of msg as its message and
+// the text in the language of the caller as a LocalizedMessage detail.
+// Further details are added by the caller:
+//
+//	st := i18n.Status(ctx, codes.FailedPrecondition, i18n.CustomError)
+//	st, err = st.WithDetails(&pb.CustomError{Reason: "some reason"})
+func Status(ctx context.Context, code codes.Code, msg Message, args ...any) *status.Status {
+	st := status.New(code, Text(supported[0], msg, args...))
+	lang := Locale(ctx)
+	withLocalized, err := st.WithDetails(&errdetails.LocalizedMessage{
+		Locale:  lang.String(),
+		Message: Text(lang, msg, args...),
+	})
+	if err != nil {
+		return st
+	}
+	return withLocalized
+}
+
+// FromError returns the localized message attached to a status error.
+func FromError(err error) (*errdetails.LocalizedMessage, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if lm, ok := d.(*errdetails.LocalizedMessage); ok {
+			return lm, true
+		}
+	}
+	return nil, false
+}
+
+// Display returns the text of err for a user: the localized message if the
+// server attached one, the status message otherwise.
+func Display(err error) string {
+	if lm, ok := FromError(err); ok {
+		return lm.GetMessage()
+	}
+	return status.Convert(err).Message()
+}
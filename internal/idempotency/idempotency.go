@@ -0,0 +1,188 @@
+// Package idempotency tells which calls are safe to repeat. A method is safe
+// when its proto marks it with idempotency_level NO_SIDE_EFFECTS or
+// IDEMPOTENT. A call of any other method, like creating an order, is safe
+// only with an idempotency key: the server runs the first call with a key and
+// answers the repeated ones with its result, so a retry after a lost response
+// does not create a second order. A key is unique per caller only: the
+// results are stored per caller, so another caller sending the same key runs
+// its own call instead of reading the response of someone else.
+package idempotency
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/easyp-tech/course-grpc/internal/dryrun"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// KeyHeader carries the idempotency key of a call.
+const KeyHeader = "idempotency-key"
+
+// levels caches the level of every method looked up in the descriptors.
+var levels sync.Map
+
+// Level returns the idempotency level of fullMethod from its proto,
+// IDEMPOTENCY_UNKNOWN for a method without one or not in the registry.
+func Level(fullMethod string) descriptorpb.MethodOptions_IdempotencyLevel {
+	if l, ok := levels.Load(fullMethod); ok {
+		return l.(descriptorpb.MethodOptions_IdempotencyLevel)
+	}
+
+	// "/pkg.Service/Method" -> "pkg.Service.Method"
+	name := protoreflect.FullName(strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1))
+	level := descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN
+	if d, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		if md, isMethod := d.(protoreflect.MethodDescriptor); isMethod {
+			opts, _ := md.Options().(*descriptorpb.MethodOptions)
+			level = opts.GetIdempotencyLevel()
+		}
+	}
+	levels.Store(fullMethod, level)
+	return level
+}
+
+// WithKey returns ctx with a new idempotency key for the outgoing call.
+// Every attempt of the call sends the same key.
+func WithKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, KeyHeader, uuid.NewString())
+}
+
+// Retryable reports whether an outgoing call of fullMethod with ctx may be
+// repeated: the method is idempotent or the call carries a key.
+func Retryable(ctx context.Context, fullMethod string) bool {
+	if Level(fullMethod) != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN {
+		return true
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(KeyHeader)) > 0
+}
+
+// Keys remembers the results of calls with idempotency keys.
+type Keys struct {
+	ttl    time.Duration
+	caller func(context.Context) string
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a call with a key, running until done is closed.
+type call struct {
+	done    chan struct{}
+	resp    any
+	err     error
+	expires time.Time
+}
+
+// NewKeys creates a store that keeps every result for ttl, per peer IP.
+func NewKeys(ttl time.Duration) *Keys {
+	return &Keys{ttl: ttl, caller: peerHost, calls: make(map[string]*call)}
+}
+
+// WithCaller makes the store keep the results per caller(ctx) instead of
+// per peer IP, e.g. per subject of the credentials, and returns k.
+func (k *Keys) WithCaller(caller func(context.Context) string) *Keys {
+	k.caller = caller
+	return k
+}
+
+// peerHost is the IP of the peer of ctx.
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// UnaryServerInterceptor runs a call of a method that is not idempotent once
+// per key. A repeated call waits for the first one if it is still running
+// and gets its response. A failed call is forgotten, so its retry runs the
+// handler again, as is one whose handler panicked: the calls waiting for it
+// get Internal. Calls without a key, of idempotent methods and dry runs
+// pass through: a dry run must not answer the real call with the same key.
+func (k *Keys) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		keys := md.Get(KeyHeader)
+		if len(keys) == 0 || Level(info.FullMethod) != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN || dryrun.Requested(ctx, req) {
+			return handler(ctx, req)
+		}
+		key := k.caller(ctx) + "\x00" + info.FullMethod + "\x00" + keys[0]
+
+		c, first := k.start(key)
+		if !first {
+			logctx.Logger(ctx).Printf("[IDEMPOTENCY] %s: repeated call with key %s, answering with the result of the first one", info.FullMethod, keys[0])
+			select {
+			case <-c.done:
+				return c.resp, c.err
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		}
+
+		panicked := true
+		defer func() {
+			if panicked {
+				// the panic goes on to the recovery interceptor
+				c.resp, c.err = nil, status.Error(codes.Internal, "internal server error")
+			}
+			k.finish(key, c)
+		}()
+		c.resp, c.err = handler(ctx, req)
+		panicked = false
+		return c.resp, c.err
+	}
+}
+
+// start returns the call under key and whether it was just created.
+func (k *Keys) start(key string) (*call, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if c, ok := k.calls[key]; ok && (c.expires.IsZero() || now.Before(c.expires)) {
+		return c, false
+	}
+	for key, c := range k.calls {
+		if !c.expires.IsZero() && !now.Before(c.expires) {
+			delete(k.calls, key)
+		}
+	}
+
+	c := &call{done: make(chan struct{})}
+	k.calls[key] = c
+	return c, true
+}
+
+// finish keeps the result of a successful call for ttl and forgets a failed
+// one; the calls waiting for it get the result either way.
+func (k *Keys) finish(key string, c *call) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if c.err != nil {
+		delete(k.calls, key)
+	} else {
+		c.expires = time.Now().Add(k.ttl)
+	}
+	close(c.done)
+}
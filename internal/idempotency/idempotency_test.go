@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// info is a method without idempotency level, so calls with a key run once.
+var info = &grpc.UnaryServerInfo{FullMethod: "/test.Service/Create"}
+
+func withKey(key string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(KeyHeader, key))
+}
+
+func TestRepeatedCallGetsFirstResult(t *testing.T) {
+	interceptor := NewKeys(time.Minute).UnaryServerInterceptor()
+	calls := 0
+	handler := func(context.Context, any) (any, error) {
+		calls++
+		return calls, nil
+	}
+
+	for range 2 {
+		resp, err := interceptor(withKey("k"), nil, info, handler)
+		if err != nil || resp != 1 {
+			t.Fatalf("call = %v, %v; want 1, nil", resp, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestPanicReleasesKey(t *testing.T) {
+	interceptor := NewKeys(time.Minute).UnaryServerInterceptor()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicking := func(context.Context, any) (any, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+
+	go func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic of the handler did not reach the interceptor chain")
+			}
+		}()
+		interceptor(withKey("k"), nil, info, panicking)
+	}()
+	<-started
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err := interceptor(withKey("k"), nil, info, func(context.Context, any) (any, error) {
+			return nil, status.Error(codes.Aborted, "ran instead of waiting")
+		})
+		waited <- err
+	}()
+	// let the second call start waiting for the first one
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-waited:
+		if status.Code(err) != codes.Internal {
+			t.Errorf("waiting call: err = %v, want Internal", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call waiting for a panicked one is blocked")
+	}
+
+	// the key is forgotten: a retry runs the handler again
+	resp, err := interceptor(withKey("k"), nil, info, func(context.Context, any) (any, error) { return "ok", nil })
+	if err != nil || resp != "ok" {
+		t.Errorf("retry = %v, %v; want ok, nil", resp, err)
+	}
+}
@@ -0,0 +1,311 @@
+// Package journal is an append-only message log with offsets. Subscribers
+// that lose their stream reconnect with the last offset they have seen and
+// catch up from the journal before following new messages live.
+//
+// Entries are kept in memory, the newest DefaultRetention of them (see
+// WithRetention): older ones are dropped, so a journal of a long-running
+// server does not grow without bound. With a file the journal also survives
+// restarts: every entry is appended as a uvarint length followed by the
+// payload, and the file is read back on Open, keeping the offsets it had
+// and the retained entries only. The file itself is not compacted. A record
+// cut short by a crash is dropped; a record longer than MaxRecord means the
+// file is not a journal or is corrupted, and Open fails instead of
+// allocating what the length claims.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetention is how many of the newest entries a journal keeps.
+	DefaultRetention = 100_000
+	// MaxRecord is the largest entry a journal appends and loads, the
+	// default largest message of grpc-go.
+	MaxRecord = 4 << 20
+)
+
+// ErrTooLarge is returned by Append for data over MaxRecord.
+var ErrTooLarge = errors.New("journal record too large")
+
+// Entry is one journaled message. Offsets start at 1 and have no gaps.
+type Entry struct {
+	Offset uint64
+	Data   []byte
+}
+
+// Journal is safe for concurrent use.
+type Journal struct {
+	retain int
+
+	mu sync.RWMutex
+	// entries are the retained entries, the first of them at offset first
+	entries [][]byte
+	first   uint64
+	file    *os.File
+	// changed is closed and replaced on every append
+	changed chan struct{}
+}
+
+// Option configures a Journal.
+type Option func(*Journal)
+
+// WithRetention keeps the newest n entries instead of DefaultRetention.
+func WithRetention(n int) Option {
+	return func(j *Journal) {
+		j.retain = max(n, 1)
+	}
+}
+
+// Open loads the journal stored at path, creating the file if needed. An
+// empty path keeps the journal in memory only.
+func Open(path string, opts ...Option) (*Journal, error) {
+	j := &Journal{retain: DefaultRetention, first: 1, changed: make(chan struct{})}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if path == "" {
+		return j, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+
+	size, err := j.load(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// drop a partial record left by a crash, new entries go after the last
+	// complete one
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("truncate journal: %w", err)
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("seek journal: %w", err)
+	}
+
+	j.file = f
+	return j, nil
+}
+
+// load reads all complete records and returns the size they take up.
+func (j *Journal) load(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	var size int64
+
+	for {
+		n, err := binary.ReadUvarint(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return size, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read journal: %w", err)
+		}
+		if n > MaxRecord {
+			return 0, fmt.Errorf("read journal: record at byte %d claims %d bytes, more than %d: corrupted or not a journal", size, n, MaxRecord)
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				return size, nil
+			}
+			return 0, fmt.Errorf("read journal: %w", err)
+		}
+
+		j.add(data)
+		size += int64(uvarintLen(n)) + int64(n)
+	}
+}
+
+// add keeps data as the newest entry and drops the entries over the
+// retention. j.mu is held.
+func (j *Journal) add(data []byte) {
+	j.entries = append(j.entries, data)
+	if drop := len(j.entries) - j.retain; drop > 0 {
+		// the array stays until append moves the entries, its data does not
+		clear(j.entries[:drop])
+		j.entries = j.entries[drop:]
+		j.first += uint64(drop)
+	}
+}
+
+func uvarintLen(n uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], n)
+}
+
+// Append adds data to the journal and returns its offset. Data over
+// MaxRecord is refused with ErrTooLarge.
+func (j *Journal) Append(data []byte) (uint64, error) {
+	if len(data) > MaxRecord {
+		return 0, fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(data), MaxRecord)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file != nil {
+		record := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(data)), uint64(len(data)))
+		record = append(record, data...)
+		if _, err := j.file.Write(record); err != nil {
+			return 0, fmt.Errorf("append to journal: %w", err)
+		}
+	}
+
+	j.add(data)
+	close(j.changed)
+	j.changed = make(chan struct{})
+
+	return j.last(), nil
+}
+
+// Read returns up to max entries starting at offset from. An offset before
+// the oldest retained entry, 0 among them, is read from the oldest one: the
+// offsets of the result tell what was dropped.
+func (j *Journal) Read(from uint64, max int) []Entry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.read(from, max)
+}
+
+func (j *Journal) read(from uint64, max int) []Entry {
+	from = j.clamp(from)
+	if from > j.last() {
+		return nil
+	}
+
+	tail := j.entries[from-j.first:]
+	if len(tail) > max {
+		tail = tail[:max]
+	}
+
+	out := make([]Entry, len(tail))
+	for i, data := range tail {
+		out[i] = Entry{Offset: from + uint64(i), Data: data}
+	}
+	return out
+}
+
+// clamp returns from, or the offset of the oldest entry for an offset before
+// it. j.mu is held.
+func (j *Journal) clamp(from uint64) uint64 {
+	return max(from, j.first)
+}
+
+func (j *Journal) last() uint64 {
+	return j.first + uint64(len(j.entries)) - 1
+}
+
+// Last returns the offset of the newest entry, 0 for an empty journal.
+func (j *Journal) Last() uint64 {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.last()
+}
+
+// Follow calls fn for every entry from offset from on: first the ones already
+// journaled, then new ones as they are appended. It returns when ctx is done
+// or fn fails.
+func (j *Journal) Follow(ctx context.Context, from uint64, fn func(Entry) error) error {
+	return j.FollowIdle(ctx, from, fn, 0, nil)
+}
+
+// FollowIdle is Follow that also calls idle, on the goroutine of fn, every
+// interval it waits for new entries; an interval of 0 never calls it. It
+// returns when idle fails, too.
+func (j *Journal) FollowIdle(ctx context.Context, from uint64, fn func(Entry) error, interval time.Duration, idle func() error) error {
+	const page = 100
+
+	if from == 0 {
+		from = 1
+	}
+	for {
+		// take the channel together with the entries, so an append right
+		// after the read still wakes us up
+		j.mu.RLock()
+		entries := j.read(from, page)
+		changed := j.changed
+		j.mu.RUnlock()
+
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+			from = e.Offset + 1
+		}
+		if len(entries) == page {
+			continue
+		}
+
+		if err := j.wait(ctx, changed, interval, idle); err != nil {
+			return err
+		}
+	}
+}
+
+// wait blocks until changed is closed or ctx is done, calling idle every
+// interval meanwhile.
+func (j *Journal) wait(ctx context.Context, changed <-chan struct{}, interval time.Duration, idle func() error) error {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-changed:
+			return nil
+		case <-tick:
+			if err := idle(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Ping checks that the journal can still be written: the file is flushed
+// to disk. A journal kept in memory always can.
+func (j *Journal) Ping() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
@@ -0,0 +1,90 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func offsets(entries []Entry) []uint64 {
+	var got []uint64
+	for _, e := range entries {
+		got = append(got, e.Offset)
+	}
+	return got
+}
+
+func TestRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := Open(path, WithRetention(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		off, err := j.Append([]byte{byte('a' + i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != uint64(i+1) {
+			t.Fatalf("Append #%d returned offset %d", i+1, off)
+		}
+	}
+
+	// reads before the oldest retained entry start at it
+	for _, from := range []uint64{0, 1, 3} {
+		got := j.Read(from, 10)
+		if len(got) != 3 || got[0].Offset != 3 || string(got[0].Data) != "c" || got[2].Offset != 5 {
+			t.Errorf("Read(%d) = %v, want offsets 3 to 5", from, offsets(got))
+		}
+	}
+	if got := j.Read(6, 10); len(got) != 0 {
+		t.Errorf("Read(6) = %v, want nothing", offsets(got))
+	}
+	if got := j.Last(); got != 5 {
+		t.Errorf("Last = %d, want 5", got)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file keeps every record, so the offsets survive a restart
+	j, err = Open(path, WithRetention(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if got := j.Read(0, 10); len(got) != 2 || got[0].Offset != 4 || string(got[1].Data) != "e" {
+		t.Errorf("Read after reopen = %v, want offsets 4 and 5", offsets(got))
+	}
+	if off, err := j.Append([]byte("f")); err != nil || off != 6 {
+		t.Errorf("Append after reopen = %d, %v, want offset 6", off, err)
+	}
+}
+
+func TestMaxRecord(t *testing.T) {
+	j, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Append(make([]byte, MaxRecord+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Append over MaxRecord: %v, want ErrTooLarge", err)
+	}
+	if got := j.Last(); got != 0 {
+		t.Errorf("Last = %d after a refused append, want 0", got)
+	}
+
+	// a length from a corrupted file is not trusted with an allocation
+	path := filepath.Join(t.TempDir(), "journal")
+	record := binary.AppendUvarint(nil, 1)
+	record = append(record, 'a')
+	record = binary.AppendUvarint(record, 1<<40)
+	if err := os.WriteFile(path, record, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := Open(path); err == nil {
+		j.Close()
+		t.Fatal("Open accepted a record of 1 TiB")
+	}
+}
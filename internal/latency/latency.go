@@ -0,0 +1,182 @@
+// Package latency aggregates call durations per method on the client and
+// prints count, error rate and percentiles when the client exits, so a quick
+// look at latencies does not need Prometheus. A long-running client can also
+// print a digest of the last interval periodically with Run.
+package latency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Summary collects the durations of the calls of every method.
+type Summary struct {
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+type methodStats struct {
+	durations []time.Duration
+	errors    int
+
+	// calls since the last periodic digest
+	window []time.Duration
+	codes  map[codes.Code]int
+}
+
+// NewSummary creates an empty Summary.
+func NewSummary() *Summary {
+	return &Summary{methods: make(map[string]*methodStats)}
+}
+
+// Record adds one call of method that took d and ended with err. io.EOF,
+// the normal end of a stream, does not count as an error.
+func (s *Summary) Record(method string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.methods[method]
+	if !ok {
+		st = &methodStats{codes: make(map[codes.Code]int)}
+		s.methods[method] = st
+	}
+	st.durations = append(st.durations, d)
+	st.window = append(st.window, d)
+	code := codes.OK
+	if err != nil && !errors.Is(err, io.EOF) {
+		st.errors++
+		code = status.Code(err)
+	}
+	st.codes[code]++
+}
+
+// StreamClientInterceptor records every stream from its start until it
+// ends: at the final error of server streams, at the single response
+// otherwise.
+func (s *Summary) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			s.Record(method, time.Since(start), err)
+			return nil, err
+		}
+		return &timedStream{ClientStream: cs, summary: s, method: method, start: start, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+// Log prints the calls, error rate and p50/p95/p99 of every method.
+func (s *Summary) Log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	methods := make([]string, 0, len(s.methods))
+	for m := range s.methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	for _, m := range methods {
+		st := s.methods[m]
+		sorted := slices.Clone(st.durations)
+		slices.Sort(sorted)
+		log.Printf("[LATENCY] %s: %d calls, %.1f%% errors, p50 %v, p95 %v, p99 %v",
+			m, len(sorted), 100*float64(st.errors)/float64(len(sorted)),
+			percentile(sorted, 50), percentile(sorted, 95), percentile(sorted, 99))
+	}
+}
+
+// Run prints a digest of the calls of every method every interval until ctx
+// is done: count, p50/p95 and status codes of the calls since the previous
+// digest. Methods without calls in the interval are left out.
+func (s *Summary) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			s.logWindow(interval)
+		}
+	}
+}
+
+func (s *Summary) logWindow(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	methods := make([]string, 0, len(s.methods))
+	for m, st := range s.methods {
+		if len(st.window) > 0 {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+
+	for _, m := range methods {
+		st := s.methods[m]
+		slices.Sort(st.window)
+
+		codeNames := make([]string, 0, len(st.codes))
+		for c, n := range st.codes {
+			codeNames = append(codeNames, fmt.Sprintf("%s=%d", c, n))
+		}
+		sort.Strings(codeNames)
+
+		log.Printf("[LATENCY %v] %s: %d calls, p50 %v, p95 %v, codes %s",
+			interval, m, len(st.window), percentile(st.window, 50), percentile(st.window, 95),
+			strings.Join(codeNames, " "))
+
+		st.window = st.window[:0]
+		clear(st.codes)
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
+
+// timedStream records the stream once when it is finished.
+type timedStream struct {
+	grpc.ClientStream
+
+	summary       *Summary
+	method        string
+	start         time.Time
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *timedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(func() { s.summary.Record(s.method, time.Since(s.start), err) })
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+// Package lifetime caps how long a server or bidi stream stays open. A
+// subscription or a chat stream has no end of its own, so it holds its
+// connection, its handler goroutine and whatever state the handler keeps
+// for as long as the client likes, and it pins the client to one instance
+// long after new instances come up. Past its maximum duration a stream is
+// closed with DeadlineExceeded and an ErrorInfo detail saying why, and the
+// client opens a new one, possibly on another instance.
+package lifetime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/msgsize"
+	"github.com/easyp-tech/course-grpc/internal/streamstop"
+)
+
+// Reason is the ErrorInfo reason of the closed streams.
+const Reason = "STREAM_MAX_DURATION"
+
+var errExpired = errors.New("stream exceeded its maximum duration")
+
+// Limits are the maximum durations of streams per method.
+type Limits struct {
+	def     time.Duration
+	methods map[string]time.Duration
+}
+
+// New creates limits of methods[full method name], def for the other
+// methods; 0 leaves the streams of a method open however long they last.
+func New(def time.Duration, methods map[string]time.Duration) *Limits {
+	return &Limits{def: def, methods: methods}
+}
+
+// Max returns the maximum duration of the streams of method, 0 for none.
+func (l *Limits) Max(method string) time.Duration {
+	if d, ok := l.methods[method]; ok {
+		return d
+	}
+	return l.def
+}
+
+func expired(method string, limit time.Duration) error {
+	msg := fmt.Sprintf("stream exceeded its maximum duration of %v, open a new one", limit)
+	st, err := status.New(codes.DeadlineExceeded, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: Reason,
+		Domain: msgsize.Domain,
+		Metadata: map[string]string{
+			"method":       method,
+			"max_duration": limit.String(),
+		},
+	})
+	if err != nil {
+		return status.Error(codes.DeadlineExceeded, msg)
+	}
+	return st.Err()
+}
+
+// StreamServerInterceptor closes server and bidi streams that outlive the
+// maximum duration of their method. Client streams end with the client's
+// last message and are left alone. As in package farewell, the handler runs
+// under streamstop.Run.
+func (l *Limits) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		limit := l.Max(info.FullMethod)
+		if !info.IsServerStream || limit <= 0 {
+			return handler(srv, ss)
+		}
+
+		timeout, cancel := context.WithTimeout(context.Background(), limit)
+		defer cancel()
+
+		stopped, err := streamstop.Run(srv, ss, handler, timeout.Done(), errExpired)
+		if !stopped {
+			return err
+		}
+		logctx.Logger(ss.Context()).Printf("[LIFETIME] %s: closing stream open for %v", info.FullMethod, limit)
+		return expired(info.FullMethod, limit)
+	}
+}
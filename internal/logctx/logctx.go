@@ -0,0 +1,53 @@
+// Package logctx carries per-call log fields (trace id, request id, ...) in a
+// context and prefixes log lines with them, so the lines of one call can be
+// found across client, proxy and server logs.
+package logctx
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+type field struct {
+	key, value string
+}
+
+type ctxKey struct{}
+
+// With returns a context whose logger also prints key=value. Setting a key
+// again replaces its value.
+func With(ctx context.Context, key, value string) context.Context {
+	parent, _ := ctx.Value(ctxKey{}).([]field)
+
+	fields := make([]field, 0, len(parent)+1)
+	for _, f := range parent {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	fields = append(fields, field{key: key, value: value})
+
+	return context.WithValue(ctx, ctxKey{}, fields)
+}
+
+// Logger returns a logger writing to the standard logger's output with the
+// fields of ctx in front of every message. Without fields it is the standard
+// logger itself.
+func Logger(ctx context.Context) *log.Logger {
+	fields, _ := ctx.Value(ctxKey{}).([]field)
+	if len(fields) == 0 {
+		return log.Default()
+	}
+
+	var b strings.Builder
+	b.WriteString(log.Prefix())
+	for _, f := range fields {
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		b.WriteString(f.value)
+		b.WriteByte(' ')
+	}
+
+	return log.New(log.Writer(), b.String(), log.Flags()|log.Lmsgprefix)
+}
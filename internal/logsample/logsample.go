@@ -0,0 +1,134 @@
+// Package logsample thins out the per-message log lines of the stream
+// handlers. Under load a line for every received and sent message costs more
+// than the echo itself and buries everything else in the log, so a handler
+// may log only one message in Every and at most PerSecond lines a second.
+// The first line written after suppressed ones tells how many were left out.
+// Errors and the start and end of a stream are not sampled: the handlers log
+// them directly.
+package logsample
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+)
+
+// All is the Flag key that configures every handler without a config of
+// its own.
+const All = "*"
+
+// Config is the sampling of one handler. The zero value logs every line.
+type Config struct {
+	// Every logs one line in Every, 0 and 1 log all.
+	Every int
+	// PerSecond caps the lines per second, 0 for no cap.
+	PerSecond float64
+}
+
+func (c Config) String() string {
+	s := strconv.Itoa(max(c.Every, 1))
+	if c.PerSecond > 0 {
+		s += ":" + strconv.FormatFloat(c.PerSecond, 'f', -1, 64)
+	}
+	return s
+}
+
+// Sampler decides which lines of a handler are logged. One sampler is
+// shared by all streams of the handler, so the cap holds however many
+// streams are open. It is safe for concurrent use; a nil Sampler logs every
+// line.
+type Sampler struct {
+	every      uint64
+	limiter    *ratelimit.Limiter
+	seen       atomic.Uint64
+	suppressed atomic.Uint64
+}
+
+// New creates a sampler for cfg.
+func New(cfg Config) *Sampler {
+	s := &Sampler{every: uint64(max(cfg.Every, 1))}
+	if cfg.PerSecond > 0 {
+		s.limiter = ratelimit.New(cfg.PerSecond, max(int(cfg.PerSecond), 1))
+	}
+	return s
+}
+
+// Printf logs the line to logger if the sampler lets it through.
+func (s *Sampler) Printf(logger *log.Logger, format string, args ...any) {
+	if s == nil {
+		logger.Printf(format, args...)
+		return
+	}
+	if (s.seen.Add(1)-1)%s.every != 0 || (s.limiter != nil && !s.limiter.Allow("")) {
+		s.suppressed.Add(1)
+		return
+	}
+
+	line := fmt.Sprintf(format, args...)
+	if n := s.suppressed.Swap(0); n > 0 {
+		line += fmt.Sprintf(" (%d lines suppressed before)", n)
+	}
+	logger.Print(line)
+}
+
+// Flag configures the sampling per handler as repeated name=every[:per_second]
+// values, e.g. "*=100:20" for all handlers and "EchoServerStream=1" to keep
+// every line of one of them.
+type Flag map[string]Config
+
+func (f Flag) String() string {
+	parts := make([]string, 0, len(f))
+	for name, c := range f {
+		parts = append(parts, name+"="+c.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f Flag) Set(value string) error {
+	name, spec, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want handler=every[:per_second], got %q", value)
+	}
+	everySpec, rateSpec, hasRate := strings.Cut(spec, ":")
+
+	var c Config
+	var err error
+	if c.Every, err = strconv.Atoi(everySpec); err != nil || c.Every < 1 {
+		return fmt.Errorf("every of %s must be a positive integer, got %q", name, everySpec)
+	}
+	if hasRate {
+		if c.PerSecond, err = strconv.ParseFloat(rateSpec, 64); err != nil || c.PerSecond < 0 {
+			return fmt.Errorf("per_second of %s must be a non-negative number, got %q", name, rateSpec)
+		}
+	}
+	f[name] = c
+	return nil
+}
+
+// Samplers returns a sampler for every handler in names: its own config
+// from f, else the one for All, else nil, which logs every line. A config
+// for a handler not in names is an error, so a typo does not go unnoticed.
+func (f Flag) Samplers(names ...string) (map[string]*Sampler, error) {
+	for name := range f {
+		if name != All && !slices.Contains(names, name) {
+			return nil, fmt.Errorf("log sampling for unknown handler %q, known: %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	samplers := make(map[string]*Sampler, len(names))
+	for _, name := range names {
+		c, ok := f[name]
+		if !ok {
+			c, ok = f[All]
+		}
+		if ok {
+			samplers[name] = New(c)
+		}
+	}
+	return samplers, nil
+}
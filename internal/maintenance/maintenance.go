@@ -0,0 +1,152 @@
+// Package maintenance switches a server into maintenance mode at run time.
+// While it is on, readiness reports NOT_SERVING and new calls are rejected
+// with Unavailable and a RetryInfo detail, so well-behaved clients back off
+// and retry later. Calls that started before keep running until they end.
+package maintenance
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/probes"
+)
+
+// Status is the current mode.
+type Status struct {
+	Enabled bool
+	Reason  string
+	// Since is when the mode was last switched.
+	Since time.Time
+	// InFlight counts the calls that are still running.
+	InFlight int64
+}
+
+// Mode is safe for concurrent use.
+type Mode struct {
+	probes     *probes.Probes
+	retryDelay time.Duration
+	exempt     map[string]struct{}
+	inFlight   atomic.Int64
+
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+// New creates a mode that is off. retryDelay is advised to rejected
+// clients; calls to the exempt services (admin, health, reflection) are
+// never rejected.
+func New(p *probes.Probes, retryDelay time.Duration, exempt ...string) *Mode {
+	m := &Mode{
+		probes:     p,
+		retryDelay: retryDelay,
+		exempt:     make(map[string]struct{}, len(exempt)),
+		since:      time.Now(),
+	}
+	for _, s := range exempt {
+		m.exempt[s] = struct{}{}
+	}
+	return m
+}
+
+// Set turns the mode on or off and returns the new status.
+func (m *Mode) Set(ctx context.Context, enabled bool, reason string) Status {
+	m.mu.Lock()
+	changed := m.enabled != enabled
+	if changed {
+		m.enabled = enabled
+		m.since = time.Now()
+	}
+	m.reason = reason
+	m.mu.Unlock()
+
+	if changed {
+		if enabled {
+			logctx.Logger(ctx).Printf("[MAINTENANCE] on: %s", reason)
+		} else {
+			logctx.Logger(ctx).Printf("[MAINTENANCE] off")
+		}
+		m.probes.SetMaintenance(enabled)
+	}
+	return m.Status()
+}
+
+// Status returns the current mode.
+func (m *Mode) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return Status{
+		Enabled:  m.enabled,
+		Reason:   m.reason,
+		Since:    m.since,
+		InFlight: m.inFlight.Load(),
+	}
+}
+
+// admit returns an Unavailable error for calls that must be rejected.
+func (m *Mode) admit(method string) error {
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if _, ok := m.exempt[service]; ok {
+		return nil
+	}
+
+	m.mu.RLock()
+	enabled, reason := m.enabled, m.reason
+	m.mu.RUnlock()
+	if !enabled {
+		return nil
+	}
+
+	msg := "server is in maintenance"
+	if reason != "" {
+		msg += ": " + reason
+	}
+	st, err := status.New(codes.Unavailable, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(m.retryDelay),
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, msg)
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor rejects new calls while the mode is on.
+func (m *Mode) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := m.admit(info.FullMethod); err != nil {
+			return nil, err
+		}
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects new streams while the mode is on; open
+// streams are left to finish.
+func (m *Mode) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := m.admit(info.FullMethod); err != nil {
+			return err
+		}
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		return handler(srv, ss)
+	}
+}
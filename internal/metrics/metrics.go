@@ -0,0 +1,199 @@
+// Package metrics holds the Prometheus collectors shared by the course
+// servers and the HTTP endpoint that exposes them.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// StreamMessageLatency measures how long a single stream message spends in a
+// handler: from the moment it is received until its response is sent.
+var StreamMessageLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "course_grpc",
+	Subsystem: "stream",
+	Name:      "message_latency_seconds",
+	Help:      "Time between receiving a stream message and sending the corresponding response.",
+	Buckets:   []float64{.0005, .001, .005, .01, .025, .05, .1, .2, .3, .5, 1, 2.5},
+}, []string{"method"})
+
+// ConnectionsActive is the number of open connections accepted by a
+// connlimit listener.
+var ConnectionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "course_grpc",
+	Subsystem: "listener",
+	Name:      "connections_active",
+	Help:      "Connections currently open on the gRPC listener.",
+})
+
+// ConnectionsRejected counts connections closed on accept because a
+// connection limit was reached.
+var ConnectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "listener",
+	Name:      "connections_rejected_total",
+	Help:      "Connections closed on accept because of a per-IP or total limit.",
+}, []string{"reason"})
+
+// SlowConsumers counts server streams whose client stopped reading, by
+// what happened to the stream: "summarized" or "terminated".
+var SlowConsumers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "stream",
+	Name:      "slow_consumers_total",
+	Help:      "Server streams on which a Send blocked longer than the slow consumer threshold.",
+}, []string{"method", "action"})
+
+// AdmissionInFlight is the number of calls admitted by the admission
+// interceptor and still running.
+var AdmissionInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "course_grpc",
+	Subsystem: "admission",
+	Name:      "in_flight",
+	Help:      "Calls currently running under admission control.",
+})
+
+// AdmissionQueued is the number of calls waiting for a free slot, by
+// priority: "high", "normal" or "low".
+var AdmissionQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "course_grpc",
+	Subsystem: "admission",
+	Name:      "queued",
+	Help:      "Calls currently waiting for admission.",
+}, []string{"priority"})
+
+// AdmissionRejected counts calls rejected by admission control, by reason:
+// "queue_full", "timeout" or "displaced" (by a call of a higher priority),
+// and by priority.
+var AdmissionRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "admission",
+	Name:      "rejected_total",
+	Help:      "Calls rejected because the server was at its concurrency limit.",
+}, []string{"reason", "priority"})
+
+// AdmissionLatency measures admitted calls by priority, from their arrival
+// at the admission interceptor to the end of the handler, so the time in
+// the queue is included.
+var AdmissionLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "course_grpc",
+	Subsystem: "admission",
+	Name:      "call_duration_seconds",
+	Help:      "Time from the arrival of an admitted call to the end of its handler, queueing included.",
+	Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"priority"})
+
+// ClientRetries counts the extra attempts of client calls, by method and
+// kind: "transparent", "policy" or "application", see package attempts.
+var ClientRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "client",
+	Name:      "retries_total",
+	Help:      "Extra attempts client calls needed beyond the first one.",
+}, []string{"method", "kind"})
+
+// QuotaExceeded counts calls rejected because their user ran out of quota,
+// by method. Users are not a label: there are too many of them.
+var QuotaExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "quota",
+	Name:      "exceeded_total",
+	Help:      "Calls rejected because the calling user exhausted the quota of the method.",
+}, []string{"method"})
+
+// DeadlineRemaining measures how much of the deadline of a call was left when
+// its handler finished. Calls without a deadline are not observed.
+var DeadlineRemaining = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "course_grpc",
+	Subsystem: "server",
+	Name:      "deadline_remaining_seconds",
+	Help:      "Time left until the client deadline when the handler finished.",
+	Buckets:   []float64{0, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+}, []string{"method"})
+
+// DeadlineExceeded counts the DeadlineExceeded errors the server returned, by
+// origin: "client", "downstream" or "server".
+var DeadlineExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "server",
+	Name:      "deadline_exceeded_total",
+	Help:      "DeadlineExceeded errors returned by handlers, by what ran out of time.",
+}, []string{"method", "origin"})
+
+// StreamQueueDepth is the number of values waiting in the queues of stream
+// pipelines, summed over the open streams, by method and queue, the name of
+// a streams.Queue.
+var StreamQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "course_grpc",
+	Subsystem: "stream",
+	Name:      "queue_depth",
+	Help:      "Values waiting in the queues of open streams.",
+}, []string{"method", "queue"})
+
+// StreamQueueCapacity is the capacity of the same queues; depth divided by
+// capacity is how full they are.
+var StreamQueueCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "course_grpc",
+	Subsystem: "stream",
+	Name:      "queue_capacity",
+	Help:      "Capacity of the queues of open streams.",
+}, []string{"method", "queue"})
+
+// StreamQueueSaturated counts the times a queue of a stream stayed near its
+// capacity for the hold time of the backpressure watcher.
+var StreamQueueSaturated = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "stream",
+	Name:      "queue_saturated_total",
+	Help:      "Queues of streams that stayed near capacity, each time they did.",
+}, []string{"method", "queue"})
+
+// TenantCalls counts the calls of every tenant by status code, see package
+// tenant for the values of the tenant label.
+var TenantCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "tenant",
+	Name:      "calls_total",
+	Help:      "Calls and streams of every tenant, by status code.",
+}, []string{"tenant", "code"})
+
+// TenantRejected counts the calls of every tenant rejected with
+// ResourceExhausted, by reason: "quota" or "throttled".
+var TenantRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "tenant",
+	Name:      "rejected_total",
+	Help:      "Calls of every tenant rejected by its quotas or limits.",
+}, []string{"tenant", "reason"})
+
+// MessageWireSize measures the messages on the wire, compressed and framed,
+// by side ("client" or "server"), method and direction ("request" or
+// "response"), see package wiresize.
+var MessageWireSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "course_grpc",
+	Subsystem: "wire",
+	Name:      "message_size_bytes",
+	Help:      "Size of the messages on the wire, compression and gRPC framing included.",
+	Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+}, []string{"side", "method", "direction"})
+
+// MessagesOverSoftLimit counts the messages larger than the soft limit of
+// package wiresize, by side, method and direction.
+var MessagesOverSoftLimit = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "course_grpc",
+	Subsystem: "wire",
+	Name:      "messages_over_soft_limit_total",
+	Help:      "Messages whose encoded size exceeded the soft limit, well before the hard message size limits.",
+}, []string{"side", "method", "direction"})
+
+// NewServer returns an HTTP server exposing the default registry on addr
+// under /metrics. The caller owns its lifecycle.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
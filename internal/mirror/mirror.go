@@ -0,0 +1,260 @@
+// Package mirror copies a share of the calls to a shadow target, e.g. a
+// server with a new version of the API next to the one that serves the
+// traffic. The shadow gets the same method, message and metadata as the
+// primary call, but its responses are discarded: the caller always gets the
+// answer of the primary target. A shadow call that fails, or ends with
+// another code than the primary one, is logged, which is the point of the
+// exercise: the new version meets real traffic before it serves it.
+//
+// Shadow calls never slow the primary ones down: they run in the background
+// within a timeout of their own, and when too many of them are in flight a
+// call is not mirrored at all.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Key marks the shadow calls, so the shadow target can tell them from
+// calls of its own clients.
+const Key = "x-shadow"
+
+// maxInFlight bounds the shadow calls in flight; over it calls are not
+// mirrored.
+const maxInFlight = 64
+
+// streamBuffer is how many messages a shadow stream may fall behind the
+// primary one before it is given up.
+const streamBuffer = 32
+
+// Mirror sends a copy of a share of the calls over conn. It is safe for
+// concurrent use.
+type Mirror struct {
+	conn    *grpc.ClientConn
+	percent float64
+	timeout time.Duration
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a mirror of percent (0 to 100) of the calls to the target of
+// conn, each shadow call within timeout.
+func New(conn *grpc.ClientConn, percent float64, timeout time.Duration) *Mirror {
+	return &Mirror{
+		conn:    conn,
+		percent: percent,
+		timeout: timeout,
+		slots:   make(chan struct{}, maxInFlight),
+	}
+}
+
+// acquire picks the calls to mirror. ok is false for a call that is not
+// mirrored; otherwise release must be called when its shadow ends. A nil
+// Mirror mirrors nothing.
+func (m *Mirror) acquire() (release func(), ok bool) {
+	if m == nil || rand.Float64()*100 >= m.percent {
+		return nil, false
+	}
+	select {
+	case m.slots <- struct{}{}:
+		m.wg.Add(1)
+		return func() { <-m.slots; m.wg.Done() }, true
+	default:
+		return nil, false
+	}
+}
+
+// Wait waits for the shadow calls in flight, e.g. before a client exits.
+func (m *Mirror) Wait() {
+	m.wg.Wait()
+}
+
+// shadowContext keeps the values and the outgoing metadata of ctx, but not
+// its deadline and cancellation: the shadow call outlives the primary one.
+func (m *Mirror) shadowContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.timeout)
+	return metadata.AppendToOutgoingContext(ctx, Key, "1"), cancel
+}
+
+// UnaryClientInterceptor mirrors unary calls. The shadow is sent once the
+// primary call has ended, so both end codes can be compared, and without
+// the call options of the primary call: they may point at its header and
+// trailer. The shadow gets a copy of the request: the caller owns req again
+// as soon as the interceptor returns.
+func (m *Mirror) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		release, ok := m.acquire()
+		if !ok {
+			return err
+		}
+
+		req = clone(req)
+		go func() {
+			defer release()
+			shadowCtx, cancel := m.shadowContext(ctx)
+			defer cancel()
+
+			// any message decodes into Empty as unknown fields
+			shadowErr := m.conn.Invoke(shadowCtx, method, req, &emptypb.Empty{})
+			report(ctx, method, err, shadowErr)
+		}()
+		return err
+	}
+}
+
+// Stream is the shadow of one stream call. A nil Stream is a call that is
+// not mirrored; all its methods do nothing.
+type Stream struct {
+	method string
+	logCtx context.Context
+	cancel context.CancelFunc
+	done   chan error
+
+	mu     sync.Mutex
+	msgs   chan any
+	closed bool
+	behind bool
+}
+
+// NewStream starts the shadow of a stream call of method with the metadata
+// of ctx, nil if the call is not mirrored. newMsg creates the messages the
+// shadow responses are received into, opts are the call options of the
+// shadow stream, e.g. its codec. The caller passes the messages it sends on
+// the primary stream to Send and ends the shadow with Finish.
+func (m *Mirror) NewStream(ctx context.Context, method string, newMsg func() any, opts ...grpc.CallOption) *Stream {
+	release, ok := m.acquire()
+	if !ok {
+		return nil
+	}
+	shadowCtx, cancel := m.shadowContext(ctx)
+	s := &Stream{
+		method: method,
+		logCtx: ctx,
+		cancel: cancel,
+		done:   make(chan error, 1),
+		msgs:   make(chan any, streamBuffer),
+	}
+
+	go func() {
+		defer release()
+		defer cancel()
+		err := s.run(shadowCtx, m.conn, newMsg, opts)
+		s.mu.Lock()
+		if s.behind {
+			err = status.Errorf(codes.Aborted, "shadow fell %d messages behind and was given up", streamBuffer)
+		}
+		s.mu.Unlock()
+		s.done <- err
+	}()
+	return s
+}
+
+func (s *Stream) run(ctx context.Context, conn *grpc.ClientConn, newMsg func() any, opts []grpc.CallOption) error {
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, s.method, opts...)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range s.msgs {
+			if err := cs.SendMsg(msg); err != nil {
+				// the status comes with RecvMsg
+				return
+			}
+		}
+		_ = cs.CloseSend()
+	}()
+
+	for {
+		if err := cs.RecvMsg(newMsg()); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Send copies a message of the primary stream to the shadow. It never
+// blocks: a shadow that fell too far behind is given up.
+func (s *Stream) Send(msg any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.msgs <- clone(msg):
+	default:
+		s.behind, s.closed = true, true
+		close(s.msgs)
+		s.cancel()
+	}
+}
+
+// CloseSend tells the shadow that the primary stream sent everything.
+func (s *Stream) CloseSend() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.msgs)
+	}
+}
+
+// Finish compares the end of the shadow with primaryErr, the end of the
+// primary stream, once the shadow is over. It does not wait for it.
+func (s *Stream) Finish(primaryErr error) {
+	if s == nil {
+		return
+	}
+	s.CloseSend()
+	go func() {
+		report(s.logCtx, s.method, primaryErr, <-s.done)
+	}()
+}
+
+// report logs a shadow call that failed or ended unlike the primary one.
+// clone copies a proto message for a shadow call that sends it after the
+// caller may have reused it. Other messages are passed as they are.
+func clone(msg any) any {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Clone(m)
+	}
+	return msg
+}
+
+func report(ctx context.Context, method string, primaryErr, shadowErr error) {
+	primary, shadow := status.Code(primaryErr), status.Code(shadowErr)
+	switch {
+	case primary == shadow:
+		return
+	case shadow == codes.OK:
+		logctx.Logger(ctx).Printf("[MIRROR] %s: primary failed with %s, shadow succeeded", method, primary)
+	case primary == codes.OK:
+		logctx.Logger(ctx).Printf("[MIRROR] %s: shadow failed: %v", method, shadowErr)
+	default:
+		logctx.Logger(ctx).Printf("[MIRROR] %s: primary failed with %s, shadow: %v", method, primary, shadowErr)
+	}
+}
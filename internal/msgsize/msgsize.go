@@ -0,0 +1,162 @@
+// Package msgsize enforces limits on the size of the messages a server
+// receives and sends. grpc has such limits itself (grpc.MaxRecvMsgSize and
+// grpc.MaxSendMsgSize), but a request over its limit is rejected before any
+// interceptor runs, with a ResourceExhausted status whose message is the
+// only hint, and a response over its limit fails the call the same way after
+// the handler did all the work. The interceptors here check the configured
+// limits themselves and reject the message with ResourceExhausted and an
+// ErrorInfo detail that names the method, the direction, the size and the
+// limit, so a client can tell an oversized message from an exhausted quota.
+package msgsize
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+const (
+	// Reason is the ErrorInfo reason of rejected messages.
+	Reason = "MESSAGE_TOO_LARGE"
+	// Domain is the ErrorInfo domain of rejected messages.
+	Domain = "course-grpc.easyp.tech"
+
+	// DefaultMax is the default of both limits, the receive limit of grpc.
+	DefaultMax = 4 * 1024 * 1024
+
+	// transportFactor is how much larger than the receive limit a message may
+	// be for grpc to still decode it, so the interceptors get to reject it
+	// with details. Larger messages are rejected by grpc itself.
+	transportFactor = 4
+)
+
+// Limits are the largest messages a server receives and sends, in bytes of
+// the encoded message before compression.
+type Limits struct {
+	MaxRecv int
+	MaxSend int
+}
+
+// ServerOptions sets the grpc limits that back the interceptors: the receive
+// limit is transportFactor times MaxRecv, so a request a bit over the limit
+// is decoded and rejected by the interceptors, and only a request far over
+// it gets the plain grpc error. The send limit equals MaxSend; the
+// interceptors check responses before grpc does. A limit of 0 leaves grpc's
+// default in place and is not checked by the interceptors.
+func (l Limits) ServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if l.MaxRecv > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(l.MaxRecv*transportFactor))
+	}
+	if l.MaxSend > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(l.MaxSend))
+	}
+	return opts
+}
+
+// String describes the limits for the startup log.
+func (l Limits) String() string {
+	return fmt.Sprintf("receive %d bytes (grpc cuts off at %d), send %d bytes", l.MaxRecv, l.MaxRecv*transportFactor, l.MaxSend)
+}
+
+// UnaryServerInterceptor rejects requests over MaxRecv before the handler
+// runs and responses over MaxSend instead of sending them.
+func (l Limits) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := check(ctx, info.FullMethod, "request", req, l.MaxRecv); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if err := check(ctx, info.FullMethod, "response", resp, l.MaxSend); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor checks every message of a stream: RecvMsg fails
+// for a message over MaxRecv, SendMsg for one over MaxSend. A message so
+// large grpc rejects it itself fails RecvMsg with details as well.
+func (l Limits) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, method: info.FullMethod, limits: l})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	method string
+	limits Limits
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		// the message of grpc's own error is the only place the sizes are in
+		if st, ok := status.FromError(err); ok && st.Code() == codes.ResourceExhausted &&
+			strings.Contains(st.Message(), "larger than max") {
+			logctx.Logger(s.Context()).Printf("[MSGSIZE] %s: %s", s.method, st.Message())
+			return tooLarge(s.method, "request", -1, s.limits.MaxRecv*transportFactor)
+		}
+		return err
+	}
+	return check(s.Context(), s.method, "request", m, s.limits.MaxRecv)
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	if err := check(s.Context(), s.method, "response", m, s.limits.MaxSend); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// check returns the error for a message m over limit; limit 0 disables it.
+func check(ctx context.Context, method, direction string, m interface{}, limit int) error {
+	msg, ok := m.(proto.Message)
+	if !ok || limit <= 0 {
+		return nil
+	}
+	size := proto.Size(msg)
+	if size <= limit {
+		return nil
+	}
+	logctx.Logger(ctx).Printf("[MSGSIZE] %s: %s of %d bytes over the limit of %d", method, direction, size, limit)
+	return tooLarge(method, direction, size, limit)
+}
+
+// tooLarge builds the ResourceExhausted status; size -1 means unknown.
+func tooLarge(method, direction string, size, limit int) error {
+	msg := fmt.Sprintf("%s is larger than the limit of %d bytes", direction, limit)
+	metadata := map[string]string{
+		"method":    method,
+		"direction": direction,
+		"limit":     strconv.Itoa(limit),
+	}
+	if size >= 0 {
+		msg = fmt.Sprintf("%s of %d bytes is larger than the limit of %d bytes", direction, size, limit)
+		metadata["size"] = strconv.Itoa(size)
+	}
+
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   Reason,
+		Domain:   Domain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,191 @@
+// Package msgtrace records the messages of streams as events of their
+// OpenTelemetry spans. A span of a unary call tells how long the call took;
+// a stream lives for minutes and carries thousands of messages, and its
+// span alone does not tell which of them were slow or where they waited.
+// Here every message received and sent becomes an event of the span of the
+// stream, with its sequence number and size, and handlers add events of
+// their own, like the time a message waited in a queue before a worker took
+// it:
+//
+//	+1.204s message RECEIVED #3 17 bytes
+//	+1.606s message.dequeued #3 waited 402.0ms
+//	+1.807s message SENT #3 38 bytes
+//
+// The spans continue the trace of package tracectx. Without a tracer
+// provider installed, e.g. with LogSpans, the spans are not recorded and
+// cost nothing.
+package msgtrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+)
+
+const tracerName = "github.com/easyp-tech/course-grpc/internal/msgtrace"
+
+// Attributes of the message events, the ones of the OpenTelemetry semantic
+// conventions for RPC where they exist.
+const (
+	// EventMessage is a message received or sent.
+	EventMessage = "message"
+	// EventDequeued is a message taken from a queue of the handler.
+	EventDequeued = "message.dequeued"
+
+	typeKey      = attribute.Key("message.type")
+	idKey        = attribute.Key("message.id")
+	sizeKey      = attribute.Key("message.uncompressed_size")
+	queueWaitKey = attribute.Key("message.queue_wait_ms")
+)
+
+// StreamServerInterceptor starts a span for every stream, a child of the
+// span of tracectx, and adds an event for every message received and sent.
+// It goes after tracectx.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if t, ok := tracectx.FromContext(ctx); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    t.TraceID,
+				SpanID:     t.SpanID,
+				TraceFlags: trace.TraceFlags(t.Flags),
+				Remote:     true,
+			}))
+		}
+		ctx, span := otel.Tracer(tracerName).Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("rpc.system", "grpc")),
+		)
+		defer span.End()
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx, span: span})
+		if err != nil {
+			st := status.Convert(err)
+			span.SetAttributes(attribute.String("rpc.grpc.status_code", st.Code().String()))
+			span.SetStatus(otelcodes.Error, st.Message())
+		}
+		return err
+	}
+}
+
+// Dequeued adds an event to the span in ctx: message seq waited for wait in
+// a queue of the handler.
+func Dequeued(ctx context.Context, seq uint64, wait time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent(EventDequeued, trace.WithAttributes(
+		idKey.Int64(int64(seq)),
+		queueWaitKey.Float64(float64(wait)/float64(time.Millisecond)),
+	))
+}
+
+// serverStream adds the message events to the span of the stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	span trace.Span
+
+	received atomic.Uint64
+	sent     atomic.Uint64
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.event("RECEIVED", s.received.Add(1), m)
+	return nil
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	s.event("SENT", s.sent.Add(1), m)
+	return nil
+}
+
+func (s *serverStream) event(kind string, seq uint64, m any) {
+	if !s.span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{typeKey.String(kind), idKey.Int64(int64(seq))}
+	if msg, ok := m.(proto.Message); ok {
+		attrs = append(attrs, sizeKey.Int(proto.Size(msg)))
+	}
+	s.span.AddEvent(EventMessage, trace.WithAttributes(attrs...))
+}
+
+// LogSpans installs a tracer provider that writes every span to the log
+// with its events, for servers without a tracing backend. The caller shuts
+// the provider down on exit.
+func LogSpans() *sdktrace.TracerProvider {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(logExporter{}))
+	otel.SetTracerProvider(tp)
+	return tp
+}
+
+// logExporter writes spans to the log.
+type logExporter struct{}
+
+func (logExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, s := range spans {
+		start := s.StartTime()
+		log.Printf("%s=%s [SPAN] %s: %v, %d events, %s",
+			tracectx.LogKey, s.SpanContext().TraceID(), s.Name(),
+			s.EndTime().Sub(start).Round(time.Millisecond), len(s.Events()), describeStatus(s))
+		for _, e := range s.Events() {
+			log.Printf("%s=%s [SPAN]   +%v %s", tracectx.LogKey, s.SpanContext().TraceID(),
+				e.Time.Sub(start).Round(time.Millisecond), describeEvent(e))
+		}
+		if n := s.DroppedEvents(); n > 0 {
+			log.Printf("%s=%s [SPAN]   %d more events dropped", tracectx.LogKey, s.SpanContext().TraceID(), n)
+		}
+	}
+	return nil
+}
+
+func (logExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func describeStatus(s sdktrace.ReadOnlySpan) string {
+	if s.Status().Code == otelcodes.Error {
+		return "error: " + s.Status().Description
+	}
+	return "ok"
+}
+
+func describeEvent(e sdktrace.Event) string {
+	attrs := make(map[attribute.Key]attribute.Value, len(e.Attributes))
+	for _, kv := range e.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	switch e.Name {
+	case EventMessage:
+		return fmt.Sprintf("message %s #%d %d bytes", attrs[typeKey].AsString(), attrs[idKey].AsInt64(), attrs[sizeKey].AsInt64())
+	case EventDequeued:
+		return fmt.Sprintf("message.dequeued #%d waited %.1fms", attrs[idKey].AsInt64(), attrs[queueWaitKey].AsFloat64())
+	}
+	return fmt.Sprintf("%s %v", e.Name, e.Attributes)
+}
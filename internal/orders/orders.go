@@ -0,0 +1,308 @@
+// Package orders keeps the orders created through EchoAPI. The store lives
+// in memory: it is enough for the lessons that create, import and list
+// orders, and is empty after every restart.
+package orders
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/easyp-tech/course-grpc/internal/storeerr"
+)
+
+// Status is the state of an order.
+type Status int
+
+const (
+	StatusNew Status = iota + 1
+	StatusPaid
+	StatusCancelled
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusNew:
+		return "new"
+	case StatusPaid:
+		return "paid"
+	case StatusCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrBadCursor is returned for a cursor that was not made by Cursor.
+	ErrBadCursor = errors.New("malformed cursor")
+	// ErrNotFound is returned for a change of an unknown order.
+	ErrNotFound = fmt.Errorf("order %w", storeerr.ErrNotFound)
+)
+
+// TransitionError is returned for a change of status the order cannot make
+// in its current status.
+type TransitionError struct {
+	ID       string
+	From, To Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order %s cannot go from %s to %s", e.ID, e.From, e.To)
+}
+
+// watchBuffer is how many events a watcher may lag behind before it is
+// dropped.
+const watchBuffer = 64
+
+// Order is a stored order.
+type Order struct {
+	ID        string
+	ProductID string
+	Count     int
+	Status    Status
+	CreatedAt time.Time
+	// Version grows by one with every applied change, from 1 at creation.
+	Version uint64
+	// UpdatedAt is when the last applied change was made by its writer.
+	UpdatedAt time.Time
+	// Seq numbers the orders in the order they were created, from 1. It
+	// never changes, so listing by Seq is stable.
+	Seq uint64
+}
+
+// Cursor returns an opaque position right after o: a listing resumed from
+// it continues with the next order.
+func (o Order) Cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(o.Seq, 10)))
+}
+
+// ParseCursor returns the Seq a cursor points after; "" is the start.
+func ParseCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrBadCursor
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrBadCursor
+	}
+	return seq, nil
+}
+
+// Change sets the status and count of an order. It was made by its writer
+// at ChangedAt to the order at BaseVersion.
+type Change struct {
+	ID          string
+	BaseVersion uint64
+	Status      Status
+	Count       int
+	ChangedAt   time.Time
+	// Origin names the writer, so it can skip its own changes in Watch.
+	Origin string
+}
+
+// Result tells what became of a change.
+type Result struct {
+	// Order is the order after the change, or the order that won over it.
+	Order   Order
+	Applied bool
+	// Conflict reports that the order changed since BaseVersion; the newer
+	// of the two changes by ChangedAt won.
+	Conflict bool
+}
+
+// Event is a created or changed order.
+type Event struct {
+	Order  Order
+	Origin string
+	// Cancelled marks the cancellation of the order by Cancel.
+	Cancelled bool
+}
+
+// Store is safe for concurrent use.
+type Store struct {
+	mu sync.RWMutex
+	// orders is sorted by Seq: orders are only appended
+	orders   []Order
+	byID     map[string]int
+	watchers map[chan Event]struct{}
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{byID: make(map[string]int), watchers: make(map[chan Event]struct{})}
+}
+
+// Create stores a new order for count of productID and returns it with its
+// id and creation time.
+func (s *Store) Create(productID string, count int) Order {
+	o := s.Preview(productID, count)
+	o.ID = uuid.NewString()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o.Seq = uint64(len(s.orders)) + 1
+	s.byID[o.ID] = len(s.orders)
+	s.orders = append(s.orders, o)
+	s.notify(Event{Order: o})
+	return o
+}
+
+// Preview returns the order Create would store for count of productID,
+// without an id and without storing it.
+func (s *Store) Preview(productID string, count int) Order {
+	now := time.Now()
+	return Order{
+		ProductID: productID,
+		Count:     count,
+		Status:    StatusNew,
+		CreatedAt: now,
+		Version:   1,
+		UpdatedAt: now,
+	}
+}
+
+// Apply applies c by last-writer-wins: a change to the current version is
+// applied, a change to an older one only if it was made later than the
+// change that superseded its base.
+func (s *Store) Apply(c Change) (Result, error) {
+	return s.apply(c, true)
+}
+
+// Try returns what Apply would make of c without changing the order or
+// telling the watchers.
+func (s *Store) Try(c Change) (Result, error) {
+	return s.apply(c, false)
+}
+
+func (s *Store) apply(c Change, commit bool) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[c.ID]
+	if !ok {
+		return Result{}, ErrNotFound
+	}
+	o := &s.orders[i]
+
+	res := Result{Conflict: c.BaseVersion != o.Version}
+	if res.Conflict && !c.ChangedAt.After(o.UpdatedAt) {
+		res.Order = *o
+		return res, nil
+	}
+	if !commit {
+		// the change goes to a copy
+		copied := *o
+		o = &copied
+	}
+
+	o.Status = c.Status
+	o.Count = c.Count
+	o.Version++
+	o.UpdatedAt = c.ChangedAt
+	res.Order = *o
+	res.Applied = true
+	if commit {
+		s.notify(Event{Order: *o, Origin: c.Origin})
+	}
+	return res, nil
+}
+
+// Cancel cancels the order with id, which only a new order can be: a paid
+// or cancelled one gets a TransitionError. The watchers get the cancelled
+// order as an event with Cancelled set, so whoever acted on the order
+// learns that it is off.
+func (s *Store) Cancel(id, origin string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return Order{}, ErrNotFound
+	}
+	o := &s.orders[i]
+	if o.Status != StatusNew {
+		return *o, &TransitionError{ID: id, From: o.Status, To: StatusCancelled}
+	}
+
+	o.Status = StatusCancelled
+	o.Version++
+	o.UpdatedAt = time.Now()
+	s.notify(Event{Order: *o, Origin: origin, Cancelled: true})
+	return *o, nil
+}
+
+// Watch returns the orders created or changed from now on. A watcher that
+// lags more than watchBuffer events behind is dropped: its channel is closed
+// and it has to read the orders anew. stop ends the watch.
+func (s *Store) Watch() (events <-chan Event, stop func()) {
+	ch := make(chan Event, watchBuffer)
+
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.watchers[ch]; ok {
+			delete(s.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify must be called with s.mu locked.
+func (s *Store) notify(e Event) {
+	for ch := range s.watchers {
+		select {
+		case ch <- e:
+		default:
+			delete(s.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Get returns the order with id.
+func (s *Store) Get(id string) (Order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return Order{}, false
+	}
+	return s.orders[i], true
+}
+
+// After returns up to limit orders that come after Seq after, in the order
+// they were created. Listing page by page from the Seq of the last returned
+// order sees every order once, including the ones created meanwhile.
+func (s *Store) After(after uint64, limit int) []Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.Search(len(s.orders), func(i int) bool { return s.orders[i].Seq > after })
+	end := min(i+limit, len(s.orders))
+	return append([]Order(nil), s.orders[i:end]...)
+}
+
+// Len returns the number of stored orders.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.orders)
+}
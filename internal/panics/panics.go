@@ -0,0 +1,57 @@
+// Package panics turns panics in RPC handlers into codes.Internal errors
+// instead of crashing the whole server.
+package panics
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Handler logs the panic with its stack trace and returns the status sent to
+// the client. The stack stays in the server log only. A *Panic is logged
+// with the stack of the goroutine it happened on.
+func Handler(ctx context.Context, p any) error {
+	if pp, ok := p.(*Panic); ok {
+		logctx.Logger(ctx).Printf("[PANIC] %v\n%s", pp.Value, pp.Stack)
+	} else {
+		logctx.Logger(ctx).Printf("[PANIC] %v\n%s", p, debug.Stack())
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// Panic is a panic recovered on a goroutine of a handler and raised again on
+// the goroutine of the interceptors, where the recovery interceptor sees it.
+type Panic struct {
+	Value any
+	// Stack is the stack of the goroutine that panicked.
+	Stack []byte
+}
+
+// Recovered wraps p, just recovered, with the stack of the current
+// goroutine. Call it in the deferred function that recovered p.
+func Recovered(p any) *Panic {
+	return &Panic{Value: p, Stack: debug.Stack()}
+}
+
+func (p *Panic) String() string {
+	return fmt.Sprint(p.Value)
+}
+
+// UnaryServerInterceptor recovers panics of unary handlers.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return recovery.UnaryServerInterceptor(recovery.WithRecoveryHandlerContext(Handler))
+}
+
+// StreamServerInterceptor recovers panics of stream handlers. Goroutines
+// started by a handler need their own recovery, see pkg/streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return recovery.StreamServerInterceptor(recovery.WithRecoveryHandlerContext(Handler))
+}
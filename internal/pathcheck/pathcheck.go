@@ -0,0 +1,214 @@
+// Package pathcheck pings a backend over two paths, directly and through a
+// proxy (nginx, HAProxy, a Go proxy), and tells which hop is broken. A call
+// failing through a proxy alone says little: the proxy may be down, may
+// have lost the backend, or may be fine while the backend itself fails.
+// Pinging both paths side by side tells these apart and shows the latency
+// the proxy adds.
+//
+// A ping is a health check of the whole server. The x-server-id trailer of
+// a reply (package servertiming) proves that it came from a backend and
+// names the instance, so a reply of the proxy itself is recognized too.
+package pathcheck
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/servertiming"
+)
+
+// Path names.
+const (
+	Direct = "direct"
+	Proxy  = "proxy"
+)
+
+// Result sums up the pings over one path.
+type Result struct {
+	Path   string
+	Target string
+	Sent   int
+	// Latencies of the successful pings.
+	Latencies []time.Duration
+	// ServerIDs counts the replies per backend instance.
+	ServerIDs map[string]int
+	// Errors counts the failures per cause, LastErr is the latest failure.
+	Errors  map[string]int
+	LastErr error
+}
+
+// OK is the number of successful pings.
+func (r *Result) OK() int {
+	return len(r.Latencies)
+}
+
+// Median returns the median latency of the successful pings.
+func (r *Result) Median() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(r.Latencies)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// String is a one line summary of r.
+func (r *Result) String() string {
+	s := fmt.Sprintf("%s %s: %d/%d ok", r.Path, r.Target, r.OK(), r.Sent)
+	if r.OK() > 0 {
+		s += fmt.Sprintf(", median %v", r.Median().Round(10*time.Microsecond))
+	}
+	if len(r.ServerIDs) > 0 {
+		ids := make([]string, 0, len(r.ServerIDs))
+		for id := range r.ServerIDs {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		s += ", served by " + strings.Join(ids, ", ")
+	}
+	causes := make([]string, 0, len(r.Errors))
+	for c := range r.Errors {
+		causes = append(causes, c)
+	}
+	slices.Sort(causes)
+	for _, c := range causes {
+		s += fmt.Sprintf(", %d x %s", r.Errors[c], c)
+	}
+	return s
+}
+
+// Ping checks the health of the server behind conn count times, every
+// interval, each ping within timeout.
+func Ping(ctx context.Context, path, target string, conn *grpc.ClientConn, count int, interval, timeout time.Duration) *Result {
+	r := &Result{Path: path, Target: target, ServerIDs: make(map[string]int), Errors: make(map[string]int)}
+	client := healthpb.NewHealthClient(conn)
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return r
+			case <-time.After(interval):
+			}
+		}
+		r.Sent++
+		latency, serverID, err := ping(ctx, client, timeout)
+		if serverID != "" {
+			r.ServerIDs[serverID]++
+		}
+		if err != nil {
+			r.Errors[Cause(err, serverID != "")]++
+			r.LastErr = err
+			continue
+		}
+		r.Latencies = append(r.Latencies, latency)
+	}
+	return r
+}
+
+func ping(ctx context.Context, client healthpb.HealthClient, timeout time.Duration) (time.Duration, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var trailer metadata.MD
+	start := time.Now()
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	latency := time.Since(start)
+
+	var serverID string
+	if ids := trailer.Get(servertiming.ServerIDKey); len(ids) > 0 {
+		serverID = ids[0]
+	}
+	if err == nil && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		err = status.Errorf(codes.Unavailable, "server is %s", resp.GetStatus())
+	}
+	return latency, serverID, err
+}
+
+// Cause names the kind of a failed ping. fromBackend tells that the reply
+// carried the id of a backend instance.
+func Cause(err error, fromBackend bool) string {
+	st := status.Convert(err)
+	msg := st.Message()
+	switch {
+	case fromBackend:
+		return fmt.Sprintf("backend answered %s", st.Code())
+	case strings.Contains(msg, "unexpected HTTP status code"):
+		// the proxy answered with an HTTP error page instead of gRPC, e.g.
+		// nginx with 502 when it cannot reach the backend
+		if _, code, ok := strings.Cut(msg, "received from server: "); ok {
+			code, _, _ = strings.Cut(code, ";")
+			return "HTTP " + code
+		}
+		return "non-gRPC HTTP response"
+	case strings.Contains(msg, "connection refused"):
+		return "connection refused"
+	case strings.Contains(msg, "no such host"):
+		return "unknown host"
+	case st.Code() == codes.DeadlineExceeded:
+		return "timeout"
+	}
+	return st.Code().String()
+}
+
+// Diagnose compares the two paths and tells which hop is broken. ok is
+// false when the backend cannot be reached over the proxy.
+func Diagnose(direct, proxied *Result) (verdict string, ok bool) {
+	directOK, proxyOK := direct.OK() > 0, proxied.OK() > 0
+	switch {
+	case directOK && proxyOK:
+		added := proxied.Median() - direct.Median()
+		verdict = fmt.Sprintf("both paths work, the proxy adds %v to the median", added.Round(10*time.Microsecond))
+		if proxied.OK() < proxied.Sent {
+			verdict += fmt.Sprintf("; %d of %d pings through the proxy failed, last: %v", proxied.Sent-proxied.OK(), proxied.Sent, proxied.LastErr)
+		}
+		if stray := strayInstances(direct, proxied); len(stray) > 0 {
+			verdict += "; the proxy also routes to " + strings.Join(stray, ", ")
+		}
+		return verdict, proxied.OK() == proxied.Sent
+	case directOK:
+		if len(proxied.ServerIDs) > 0 {
+			return fmt.Sprintf("the backend works directly but fails through the proxy: %v", proxied.LastErr), false
+		}
+		return fmt.Sprintf("proxy hop is broken: the backend answers directly, the proxy %s (%v)",
+			proxyFailure(proxied), proxied.LastErr), false
+	case proxyOK:
+		return fmt.Sprintf("direct path is broken (%v), the proxy still reaches the backend", direct.LastErr), true
+	}
+	return fmt.Sprintf("backend is broken: it fails directly (%v) and through the proxy (%v)", direct.LastErr, proxied.LastErr), false
+}
+
+// proxyFailure explains what the failed pings through the proxy mean.
+func proxyFailure(r *Result) string {
+	cause := Cause(r.LastErr, false)
+	switch {
+	case strings.HasPrefix(cause, "HTTP "), cause == "non-gRPC HTTP response":
+		return fmt.Sprintf("is up but cannot reach the backend or does not speak gRPC to it (%s)", cause)
+	case cause == "connection refused", cause == "unknown host":
+		return fmt.Sprintf("is not reachable at %s (%s)", r.Target, cause)
+	case cause == "timeout":
+		return "does not answer in time"
+	}
+	return "fails with " + cause
+}
+
+// strayInstances returns the instances seen through the proxy only.
+func strayInstances(direct, proxied *Result) []string {
+	var ids []string
+	for id := range proxied.ServerIDs {
+		if _, ok := direct.ServerIDs[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,119 @@
+// Package peerinfo describes who is on the other end of a call: the client
+// address, the negotiated TLS version and cipher suite and the subject of a
+// verified client certificate. The server interceptors log it once per call
+// and add it to the log fields of everything that runs after them.
+package peerinfo
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Log field keys.
+const (
+	AddrKey    = "peer"
+	TLSKey     = "tls"
+	SubjectKey = "subject"
+)
+
+// Info is the peer of a call.
+type Info struct {
+	Addr string
+	// TLSVersion and CipherSuite are empty on plaintext connections.
+	TLSVersion  string
+	CipherSuite string
+	// Subject is the subject of the verified client certificate, empty
+	// without mTLS.
+	Subject string
+}
+
+// FromContext reads the peer of the call in ctx.
+func FromContext(ctx context.Context) Info {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Info{}
+	}
+
+	info := Info{}
+	if p.Addr != nil {
+		info.Addr = p.Addr.String()
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return info
+	}
+	state := tlsInfo.State
+	info.TLSVersion = tls.VersionName(state.Version)
+	info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		info.Subject = state.VerifiedChains[0][0].Subject.String()
+	}
+	return info
+}
+
+// String formats the info for a log line.
+func (i Info) String() string {
+	if i.TLSVersion == "" {
+		return fmt.Sprintf("%s, plaintext", i.Addr)
+	}
+	if i.Subject == "" {
+		return fmt.Sprintf("%s, %s %s, no client certificate", i.Addr, i.TLSVersion, i.CipherSuite)
+	}
+	return fmt.Sprintf("%s, %s %s, client %q", i.Addr, i.TLSVersion, i.CipherSuite, i.Subject)
+}
+
+// NewContext adds the peer to the log fields of ctx.
+func NewContext(ctx context.Context, info Info) context.Context {
+	ctx = logctx.With(ctx, AddrKey, info.Addr)
+	if info.TLSVersion != "" {
+		ctx = logctx.With(ctx, TLSKey, strings.ReplaceAll(info.TLSVersion, " ", ""))
+	}
+	if info.Subject != "" {
+		ctx = logctx.With(ctx, SubjectKey, strconv.Quote(info.Subject))
+	}
+	return ctx
+}
+
+// UnaryServerInterceptor logs the peer of every call and labels the call's
+// log lines with it.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(incoming(ctx, info.FullMethod), req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: incoming(ss.Context(), info.FullMethod)})
+	}
+}
+
+func incoming(ctx context.Context, method string) context.Context {
+	info := FromContext(ctx)
+	ctx = NewContext(ctx, info)
+	logctx.Logger(ctx).Printf("[PEER] %s from %s", method, info)
+	return ctx
+}
+
+// serverStream replaces the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
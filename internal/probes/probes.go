@@ -0,0 +1,150 @@
+// Package probes maps Kubernetes-style liveness and readiness probes onto
+// the standard gRPC health service.
+package probes
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Service names checked by the liveness and readiness probes.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+)
+
+// Probes keeps liveness SERVING for the whole life of the process and flips
+// readiness (together with the application services) while the server starts
+// up and drains. Readiness is SERVING only once the server has started, while
+// it is not draining nor in maintenance and none of its dependencies fails:
+// each of them is a flag of its own, so turning one off does not override
+// another.
+type Probes struct {
+	health   *health.Server
+	services []string
+
+	mu          sync.Mutex
+	started     bool
+	draining    bool
+	maintenance bool
+	failing     map[string]error
+	serving     bool
+}
+
+// New marks liveness as SERVING and readiness plus services as NOT_SERVING
+// until Ready is called.
+func New(hs *health.Server, services ...string) *Probes {
+	p := &Probes{
+		health:   hs,
+		services: append([]string{Readiness}, services...),
+		failing:  make(map[string]error),
+	}
+
+	hs.SetServingStatus(Liveness, healthpb.HealthCheckResponse_SERVING)
+	p.set(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return p
+}
+
+// Ready marks the startup as finished: the server accepts traffic unless
+// it drains, is in maintenance or a dependency fails.
+func (p *Probes) Ready() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started = true
+	p.update()
+}
+
+// SetMaintenance records whether the server is in maintenance: while it is,
+// readiness is NOT_SERVING. Leaving maintenance does not make a server
+// ready that has not started or drains.
+func (p *Probes) SetMaintenance(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maintenance = on
+	p.update()
+}
+
+// notServing asks load balancers to stop sending traffic for good: the
+// server drains.
+func (p *Probes) notServing() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.draining = true
+	p.update()
+}
+
+// SetDependency records the result of the last check of the dependency
+// name: while err is not nil readiness is NOT_SERVING, even after Ready.
+func (p *Probes) SetDependency(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.failing[name] = err
+	} else {
+		delete(p.failing, name)
+	}
+	p.update()
+}
+
+// update sets the status the state calls for when it changes. p.mu is held.
+func (p *Probes) update() {
+	serving := p.started && !p.draining && !p.maintenance && len(p.failing) == 0
+	if serving == p.serving {
+		return
+	}
+	p.serving = serving
+
+	if serving {
+		log.Println("Readiness: SERVING")
+		p.set(healthpb.HealthCheckResponse_SERVING)
+		return
+	}
+	switch {
+	case p.draining:
+		log.Println("Readiness: NOT_SERVING, draining")
+	case p.maintenance:
+		log.Println("Readiness: NOT_SERVING, maintenance")
+	case len(p.failing) > 0:
+		names := make([]string, 0, len(p.failing))
+		for name := range p.failing {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		log.Printf("Readiness: NOT_SERVING, failing dependencies: %s", strings.Join(names, ", "))
+	default:
+		log.Println("Readiness: NOT_SERVING")
+	}
+	p.set(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Drain is a shutdown hook: it flips readiness to NOT_SERVING and waits delay
+// so balancers take the server out of rotation before it stops.
+func (p *Probes) Drain(delay time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		p.notServing()
+		select {
+		case <-time.After(delay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *Probes) set(st healthpb.HealthCheckResponse_ServingStatus) {
+	for _, svc := range p.services {
+		p.health.SetServingStatus(svc, st)
+	}
+}
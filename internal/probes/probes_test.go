@@ -0,0 +1,87 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func newProbes(t *testing.T) (*Probes, func() bool) {
+	t.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	hs := health.NewServer()
+	serving := func() bool {
+		resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: Readiness})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	}
+	return New(hs, "svc"), serving
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name string
+		// steps change the state of the probes in order
+		steps []func(p *Probes)
+		want  bool
+	}{
+		{name: "starting", want: false},
+		{name: "started", steps: []func(*Probes){(*Probes).Ready}, want: true},
+		{
+			name:  "maintenance off before startup finished",
+			steps: []func(*Probes){on, off},
+			want:  false,
+		},
+		{
+			name:  "maintenance",
+			steps: []func(*Probes){(*Probes).Ready, on},
+			want:  false,
+		},
+		{
+			name:  "maintenance over",
+			steps: []func(*Probes){(*Probes).Ready, on, off},
+			want:  true,
+		},
+		{
+			name:  "maintenance off while draining",
+			steps: []func(*Probes){(*Probes).Ready, on, (*Probes).notServing, off},
+			want:  false,
+		},
+		{
+			name:  "maintenance off with a failing dependency",
+			steps: []func(*Probes){(*Probes).Ready, on, fail, off},
+			want:  false,
+		},
+		{
+			name:  "draining",
+			steps: []func(*Probes){(*Probes).Ready, (*Probes).notServing, (*Probes).Ready},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, serving := newProbes(t)
+			for _, step := range tt.steps {
+				step(p)
+			}
+			if got := serving(); got != tt.want {
+				t.Errorf("readiness SERVING = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func on(p *Probes)   { p.SetMaintenance(true) }
+func off(p *Probes)  { p.SetMaintenance(false) }
+func fail(p *Probes) { p.SetDependency("db", errors.New("down")) }
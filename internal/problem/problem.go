@@ -0,0 +1,145 @@
+// Package problem turns the gRPC errors of the REST gateway into RFC 7807
+// problem details. The default gateway body is a google.rpc.Status with the
+// details as Any, which HTTP clients have to know the proto types to read; a
+// problem+json body carries the same information as plain members:
+//
+//	HTTP/1.1 400 Bad Request
+//	Content-Type: application/problem+json
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Bad Request",
+//	  "status": 400,
+//	  "detail": "validation error: ...",
+//	  "instance": "/api.v2.EchoAPI/CreateOrders",
+//	  "code": "InvalidArgument",
+//	  "invalid_params": [{"name": "items[0].count", "reason": "value is required"}],
+//	  "request_id": "38d7b42c-5511-4522-8764-9bd6c6d1c2b5"
+//	}
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"buf.build/go/protovalidate"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Details is an RFC 7807 problem. The members after Instance are extensions
+// filled from the status and its details.
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Code is the gRPC code name, e.g. "InvalidArgument".
+	Code string `json:"code"`
+	// Reason, Domain and Metadata come from google.rpc.ErrorInfo.
+	Reason   string            `json:"reason,omitempty"`
+	Domain   string            `json:"domain,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// InvalidParams lists the violations of google.rpc.BadRequest or of
+	// buf.validate.Violations, which the validation interceptor attaches.
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+	// RetryAfter is google.rpc.RetryInfo in seconds, also sent as the
+	// Retry-After header.
+	RetryAfter int    `json:"retry_after,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	// Message is the status message when Detail holds its translation from
+	// google.rpc.LocalizedMessage.
+	Message string `json:"message,omitempty"`
+}
+
+// InvalidParam is one invalid field of a request.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// FromStatus builds the problem of st for a request to instance.
+func FromStatus(st *status.Status, instance string) *Details {
+	httpStatus := runtime.HTTPStatusFromCode(st.Code())
+	p := &Details{
+		Type:     "about:blank",
+		Title:    http.StatusText(httpStatus),
+		Status:   httpStatus,
+		Detail:   st.Message(),
+		Instance: instance,
+		Code:     st.Code().String(),
+	}
+
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			p.Reason, p.Domain, p.Metadata = d.GetReason(), d.GetDomain(), d.GetMetadata()
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				p.InvalidParams = append(p.InvalidParams, InvalidParam{Name: v.GetField(), Reason: v.GetDescription()})
+			}
+		case *validate.Violations:
+			for _, v := range d.GetViolations() {
+				p.InvalidParams = append(p.InvalidParams, InvalidParam{Name: protovalidate.FieldPathString(v.GetField()), Reason: v.GetMessage()})
+			}
+		case *errdetails.RetryInfo:
+			// Retry-After has whole seconds, round up so the client does not come back too early
+			p.RetryAfter = int(math.Ceil(d.GetRetryDelay().AsDuration().Seconds()))
+		case *errdetails.RequestInfo:
+			p.RequestID = d.GetRequestId()
+		case *errdetails.LocalizedMessage:
+			p.Message, p.Detail = st.Message(), d.GetMessage()
+		}
+	}
+	return p
+}
+
+// ErrorHandler writes gateway errors as problem details; use it with
+// runtime.WithErrorHandler.
+func ErrorHandler(_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	// routing errors of the gateway carry their HTTP status along
+	var httpErr *runtime.HTTPStatusError
+	if errors.As(err, &httpErr) {
+		err = httpErr.Err
+	}
+	st := status.Convert(err)
+	p := FromStatus(st, r.URL.Path)
+	if httpErr != nil {
+		p.Status, p.Title = httpErr.HTTPStatus, http.StatusText(httpErr.HTTPStatus)
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("problem: marshal %v: %v", st, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Trailer")
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Type", ContentType)
+	if p.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(p.RetryAfter))
+	}
+	if st.Code() == codes.Unauthenticated {
+		h.Set("WWW-Authenticate", st.Message())
+	}
+	w.WriteHeader(p.Status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("problem: write response: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Package profiles stores profiles as their protobuf encoding, the way a
+// blob column or a key-value store would. Storing the bytes the server
+// received, rather than copying the fields it knows into a struct of its
+// own, keeps the fields of newer clients the server does not know yet: they
+// come back unchanged when the profile is read.
+package profiles
+
+import (
+	"slices"
+	"sync"
+)
+
+// Store is safe for concurrent use.
+type Store struct {
+	mu   sync.RWMutex
+	byID map[string][]byte
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{byID: make(map[string][]byte)}
+}
+
+// Put stores the encoded profile under id, replacing an earlier one.
+func (s *Store) Put(id string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byID[id] = slices.Clone(data)
+}
+
+// Get returns the encoded profile stored under id.
+func (s *Store) Get(id string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.byID[id]
+	return slices.Clone(data), ok
+}
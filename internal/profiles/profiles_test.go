@@ -0,0 +1,60 @@
+package profiles
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	nextpb "github.com/easyp-tech/course-grpc/pkg/api/next/v2"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+// TestUnknownFieldsSurvive stores a profile of the next revision the way
+// SaveProfile does, through api.v2.Profile, which does not know its new
+// fields.
+func TestUnknownFieldsSurvive(t *testing.T) {
+	sent := &nextpb.Profile{Id: "p1", Name: "Ivan", Email: "ivan@example.com", Tags: []string{"beta"}}
+	wire, err := proto.Marshal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := &pbv2.Profile{}
+	if err := proto.Unmarshal(wire, received); err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore()
+	s.Put(received.GetId(), data)
+
+	stored, ok := s.Get("p1")
+	if !ok {
+		t.Fatal("profile p1 not found")
+	}
+	got := &nextpb.Profile{}
+	if err := proto.Unmarshal(stored, got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(sent, got) {
+		t.Errorf("stored profile = %v, want %v", got, sent)
+	}
+}
+
+func TestStoreCopies(t *testing.T) {
+	s := NewStore()
+	data := []byte("profile")
+	s.Put("p1", data)
+	data[0] = 'X'
+
+	got, _ := s.Get("p1")
+	if string(got) != "profile" {
+		t.Fatalf("Get = %q after the caller changed what it put", got)
+	}
+	got[0] = 'X'
+	if again, _ := s.Get("p1"); string(again) != "profile" {
+		t.Errorf("Get = %q after the caller changed what it got", again)
+	}
+}
@@ -0,0 +1,151 @@
+// Package progress reports how far a client stream has got: messages and
+// bytes sent, the share of the total and the rate, at most once per interval
+// so a long upload logs a handful of lines instead of one per message.
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// Report is the state of a transfer.
+type Report struct {
+	Messages      int
+	TotalMessages int
+	Bytes         int64
+	TotalBytes    int64
+	Elapsed       time.Duration
+}
+
+// Percent returns the share done, by bytes if their total is known, else by
+// messages; -1 if neither total is known.
+func (r Report) Percent() float64 {
+	switch {
+	case r.TotalBytes > 0:
+		return 100 * float64(r.Bytes) / float64(r.TotalBytes)
+	case r.TotalMessages > 0:
+		return 100 * float64(r.Messages) / float64(r.TotalMessages)
+	}
+	return -1
+}
+
+// Rate returns bytes per second.
+func (r Report) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+func (r Report) String() string {
+	s := fmt.Sprintf("%d messages, %s", r.Messages, formatBytes(float64(r.Bytes)))
+	if p := r.Percent(); p >= 0 {
+		s = fmt.Sprintf("%.1f%% (%s)", p, s)
+	}
+	return fmt.Sprintf("%s, %s/s", s, formatBytes(r.Rate()))
+}
+
+func formatBytes(n float64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", n/(1<<10))
+	}
+	return fmt.Sprintf("%.0f B", n)
+}
+
+// Tracker counts what is sent and calls its callback at most once per
+// interval. Its methods are safe for concurrent use.
+type Tracker struct {
+	interval time.Duration
+	fn       func(Report)
+
+	mu       sync.Mutex
+	start    time.Time
+	reported time.Time
+	report   Report
+	// pending is set while messages are counted but not reported
+	pending bool
+}
+
+// NewTracker creates a tracker for totalMessages messages of totalBytes
+// bytes; a zero total is unknown. A zero interval reports only at Done.
+func NewTracker(totalMessages int, totalBytes int64, interval time.Duration, fn func(Report)) *Tracker {
+	now := time.Now()
+	return &Tracker{
+		interval: interval,
+		fn:       fn,
+		start:    now,
+		reported: now,
+		report:   Report{TotalMessages: totalMessages, TotalBytes: totalBytes},
+	}
+}
+
+// Add counts one sent message of size bytes.
+func (t *Tracker) Add(size int) {
+	t.mu.Lock()
+	t.report.Messages++
+	t.report.Bytes += int64(size)
+	t.pending = true
+	now := time.Now()
+	if t.interval <= 0 || now.Sub(t.reported) < t.interval {
+		t.mu.Unlock()
+		return
+	}
+	t.reported = now
+	t.pending = false
+	r := t.snapshot(now)
+	t.mu.Unlock()
+
+	t.fn(r)
+}
+
+// Done reports the final state, unless the last Add already did.
+func (t *Tracker) Done() {
+	t.mu.Lock()
+	if !t.pending && t.report.Messages > 0 {
+		t.mu.Unlock()
+		return
+	}
+	t.pending = false
+	r := t.snapshot(time.Now())
+	t.mu.Unlock()
+
+	t.fn(r)
+}
+
+func (t *Tracker) snapshot(now time.Time) Report {
+	r := t.report
+	r.Elapsed = now.Sub(t.start)
+	return r
+}
+
+// Sender wraps a stream and counts every message sent through it.
+type Sender[T any] struct {
+	s streams.Sender[T]
+	t *Tracker
+}
+
+// NewSender counts the messages sent to s in t.
+func NewSender[T any](s streams.Sender[T], t *Tracker) *Sender[T] {
+	return &Sender[T]{s: s, t: t}
+}
+
+// Send sends v and counts it if it was sent.
+func (s *Sender[T]) Send(v *T) error {
+	if err := s.s.Send(v); err != nil {
+		return err
+	}
+	size := 0
+	if m, ok := any(v).(proto.Message); ok {
+		size = proto.Size(m)
+	}
+	s.t.Add(size)
+	return nil
+}
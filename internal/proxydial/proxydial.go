@@ -0,0 +1,167 @@
+// Package proxydial connects clients to a server through a forward proxy,
+// for networks that let nothing out directly. An HTTP proxy is asked for a
+// tunnel with CONNECT, a SOCKS5 proxy with its own handshake; either way
+// the tunnel then carries the usual HTTP/2 connection, TLS included, so the
+// proxy sees only the host and port of the server.
+//
+// grpc-go itself tunnels through the HTTP proxy of $HTTPS_PROXY, but only
+// without a custom dialer and only over HTTP CONNECT; Dialer is for the
+// other cases and for a proxy chosen by a flag.
+package proxydial
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialFunc is a dialer for grpc.WithContextDialer.
+type DialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// Dialer returns a dialer that connects through the proxy at rawURL:
+//
+//	http://[user:password@]host:port     HTTP CONNECT, basic authentication
+//	socks5://[user:password@]host:port   SOCKS5, username/password authentication
+//
+// The host of a SOCKS5 proxy without a port defaults to 1080, of an HTTP
+// proxy to 80. forward connects to the proxy itself, nil for a plain TCP
+// connection.
+func Dialer(rawURL string, forward DialFunc) (DialFunc, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("proxy URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q: no host, expected e.g. http://proxy:3128", rawURL)
+	}
+	if forward == nil {
+		var d net.Dialer
+		forward = func(ctx context.Context, address string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", address)
+		}
+	}
+
+	switch u.Scheme {
+	case "http":
+		return connect(withPort(u.Host, "80"), u.User, forward), nil
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", withPort(u.Host, "1080"), auth, forwardDialer(forward))
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+		}
+		cd := d.(proxy.ContextDialer)
+		return func(ctx context.Context, address string) (net.Conn, error) {
+			conn, err := cd.DialContext(ctx, "tcp", address)
+			if err != nil {
+				return nil, fmt.Errorf("socks5 proxy %s: %w", u.Host, err)
+			}
+			return conn, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("proxy URL %q: unsupported scheme %q, expected http, socks5 or socks5h", u.Redacted(), u.Scheme)
+	}
+}
+
+// Redact returns the proxy URL without its password, for logs.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
+
+func withPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// connect tunnels through an HTTP proxy with CONNECT.
+func connect(proxyAddr string, user *url.Userinfo, forward DialFunc) DialFunc {
+	return func(ctx context.Context, address string) (_ net.Conn, err error) {
+		conn, err := forward(ctx, proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("http proxy %s: %w", proxyAddr, err)
+		}
+		defer func() {
+			if err != nil {
+				conn.Close()
+			}
+		}()
+		// the handshake gives up with ctx: a dead proxy must not hang the
+		// connection attempt
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer func() {
+			if !stop() && err == nil {
+				err = ctx.Err()
+			}
+			conn.SetDeadline(time.Time{})
+		}()
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: address},
+			Host:   address,
+			Header: make(http.Header),
+		}
+		if user != nil {
+			password, _ := user.Password()
+			creds := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+			req.Header.Set("Proxy-Authorization", "Basic "+creds)
+		}
+		if err := req.Write(conn); err != nil {
+			return nil, fmt.Errorf("http proxy %s: send CONNECT: %w", proxyAddr, err)
+		}
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return nil, fmt.Errorf("http proxy %s: read CONNECT response: %w", proxyAddr, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("http proxy %s: CONNECT %s: %s", proxyAddr, address, resp.Status)
+		}
+		if br.Buffered() > 0 {
+			// the server spoke first, in the same segment as the response
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
+	}
+}
+
+// bufferedConn reads what the proxy sent after its response first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// forwardDialer adapts a DialFunc to the dialer of package proxy.
+type forwardDialer DialFunc
+
+func (f forwardDialer) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), address)
+}
+
+func (f forwardDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, address)
+}
@@ -0,0 +1,284 @@
+// Package proxyproto accepts connections that start with a PROXY protocol
+// header, as sent by HAProxy (send-proxy, send-proxy-v2) and nginx
+// (proxy_protocol on), and reports the client behind the proxy as the remote
+// address of the connection. gRPC takes the peer address from the connection,
+// so peer.FromContext, the rate limiter and the logs all see the real client.
+//
+// Both the binary v2 and the text v1 headers are understood. A connection
+// without a valid header is closed: the listener is meant to be reachable
+// only through the proxy.
+//
+// Spec: https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderTimeout bounds how long a new connection may take to send its header.
+const HeaderTimeout = 5 * time.Second
+
+var (
+	sigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	sigV1 = []byte("PROXY ")
+)
+
+// maxV1Len is the longest v1 header allowed by the spec, CRLF included.
+const maxV1Len = 107
+
+// Listener reads the PROXY header of every connection in its own goroutine,
+// so a slow or silent client does not hold up Accept for the others.
+type Listener struct {
+	net.Listener
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewListener starts accepting on l.
+func NewListener(l net.Listener) *Listener {
+	pl := &Listener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+// maxAcceptDelay caps the backoff after temporary Accept errors.
+const maxAcceptDelay = time.Second
+
+// acceptLoop retries temporary errors, like running out of file
+// descriptors, with a growing delay as net/http does: a burst of them must
+// not end the listener and with it the gRPC server. Any other error ends it.
+func (l *Listener) acceptLoop() {
+	var delay time.Duration
+	for {
+		c, err := l.Listener.Accept()
+		if ne, ok := err.(net.Error); ok && ne.Temporary() && !errors.Is(err, net.ErrClosed) {
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			log.Printf("[PROXY] accept error: %v, retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		delay = 0
+		if err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			l.closeOnce.Do(func() { close(l.done) })
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *Listener) handshake(c net.Conn) {
+	pc, err := readHeader(c)
+	if err != nil {
+		log.Printf("[PROXY] dropping connection from %s: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	select {
+	case l.conns <- pc:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection whose header has been read.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err == nil {
+			return nil, net.ErrClosed
+		}
+		return nil, l.err
+	}
+}
+
+// Close stops the listener.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// Conn is a connection with its header consumed. RemoteAddr and LocalAddr
+// report the addresses from the header.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) LocalAddr() net.Addr { return c.local }
+
+// ProxyAddr returns the address of the proxy the connection came through.
+func (c *Conn) ProxyAddr() net.Addr { return c.Conn.RemoteAddr() }
+
+func readHeader(c net.Conn) (*Conn, error) {
+	if err := c.SetReadDeadline(time.Now().Add(HeaderTimeout)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(c)
+	pc := &Conn{Conn: c, r: r, remote: c.RemoteAddr(), local: c.LocalAddr()}
+
+	prefix, err := r.Peek(len(sigV1))
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	switch {
+	case bytes.Equal(prefix, sigV1):
+		err = pc.readV1()
+	case bytes.Equal(prefix, sigV2[:len(sigV1)]):
+		err = pc.readV2()
+	default:
+		err = errors.New("no PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readV1 parses "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func (c *Conn) readV1() error {
+	var line []byte
+	for len(line) < maxV1Len {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read v1 header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("v1 header too long")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// the proxy does not know the client, keep the connection addresses
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed v1 header %q", line)
+	}
+
+	src, err := parseTCPAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseTCPAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func parseTCPAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("malformed address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+const (
+	cmdLocal = 0x0
+	cmdProxy = 0x1
+
+	famTCP4 = 0x11
+	famTCP6 = 0x21
+)
+
+// readV2 parses the binary header: the signature, version and command,
+// family and protocol, a length, then the addresses and optional TLVs.
+func (c *Conn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return fmt.Errorf("read v2 header: %w", err)
+	}
+	if !bytes.Equal(hdr[:12], sigV2) {
+		return errors.New("bad v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return fmt.Errorf("read v2 addresses: %w", err)
+	}
+
+	switch hdr[12] & 0x0f {
+	case cmdLocal:
+		// health checks of the proxy itself
+		return nil
+	case cmdProxy:
+	default:
+		return fmt.Errorf("unsupported command %d", hdr[12]&0x0f)
+	}
+
+	switch hdr[13] {
+	case famTCP4:
+		if len(body) < 12 {
+			return errors.New("short v2 IPv4 addresses")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		c.local = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case famTCP6:
+		if len(body) < 36 {
+			return errors.New("short v2 IPv6 addresses")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		c.local = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	default:
+		// UDP and unix sockets carry no address gRPC could use, keep the
+		// connection addresses
+	}
+	return nil
+}
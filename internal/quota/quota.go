@@ -0,0 +1,168 @@
+// Package quota limits how many calls of a method every user makes per time
+// window, e.g. 10 orders a minute. Unlike ratelimit, which smooths the call
+// rate of a peer, a quota is a budget: once it is used up, every call fails
+// with ResourceExhausted until the window ends. The error carries a
+// google.rpc.QuotaFailure naming the user and the quota, and a RetryInfo
+// with the time left until the window ends, so a client knows when it may
+// come back instead of retrying blindly.
+//
+// The user is the tenant of the credentials the auth interceptor verified,
+// so all the callers of a tenant share its quota, see tenant.Key. Callers
+// without a tenant are users of their own: the subject of their
+// credentials, on any method, or the peer IP for calls without them, see
+// auth.Caller. The quota interceptor goes after auth, or every user is an
+// IP. It is never the tenant of the
+// call context: the client sets it and nothing checks it.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/internal/retry"
+	"github.com/easyp-tech/course-grpc/internal/tenant"
+)
+
+// ID is the quota_id of the violations.
+const ID = "calls-per-user"
+
+type usage struct {
+	start time.Time
+	calls int
+}
+
+// Quotas keeps the usage of every user and method. It is safe for
+// concurrent use.
+type Quotas struct {
+	window time.Duration
+	limits map[string]int
+
+	mu        sync.Mutex
+	used      map[string]*usage
+	lastSweep time.Time
+}
+
+// New creates quotas allowing every user limits[method] calls of a full
+// method name per window. Methods without a limit are not counted.
+func New(window time.Duration, limits map[string]int) *Quotas {
+	return &Quotas{
+		window:    window,
+		limits:    limits,
+		used:      make(map[string]*usage),
+		lastSweep: time.Now(),
+	}
+}
+
+// User returns the quota user of the caller: "tenant:<tenant>",
+// "subject:<subject>" or "ip:<address>", see tenant.Key.
+func User(ctx context.Context) string {
+	return tenant.Key(ctx)
+}
+
+// UnaryServerInterceptor rejects the calls of a user over the quota of the
+// method.
+func (q *Quotas) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := q.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor counts a stream as one call, whatever it sends.
+func (q *Quotas) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := q.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (q *Quotas) check(ctx context.Context, method string) error {
+	limit, ok := q.limits[method]
+	if !ok {
+		return nil
+	}
+	user := User(ctx)
+	now := time.Now()
+
+	q.mu.Lock()
+	q.sweep(now)
+	key := user + "\x00" + method
+	u, ok := q.used[key]
+	if !ok || !now.Before(u.start.Add(q.window)) {
+		u = &usage{start: now}
+		q.used[key] = u
+	}
+	if u.calls < limit {
+		u.calls++
+		q.mu.Unlock()
+		return nil
+	}
+	left := u.start.Add(q.window).Sub(now)
+	q.mu.Unlock()
+
+	metrics.QuotaExceeded.WithLabelValues(method).Inc()
+	logctx.Logger(ctx).Printf("[QUOTA] %s: %s used up %d calls per %v, window ends in %v", method, user, limit, q.window, left.Round(time.Millisecond))
+	retry.SetTrailer(ctx, left)
+	return q.exceeded(method, user, limit, left)
+}
+
+// sweep must be called with q.mu locked. It drops the usage of windows that
+// have ended, so the map does not grow with every user ever seen.
+func (q *Quotas) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < q.window {
+		return
+	}
+	q.lastSweep = now
+
+	for key, u := range q.used {
+		if !now.Before(u.start.Add(q.window)) {
+			delete(q.used, key)
+		}
+	}
+}
+
+func (q *Quotas) exceeded(method, user string, limit int, left time.Duration) error {
+	msg := fmt.Sprintf("quota exceeded: %d calls per %v", limit, q.window)
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:         user,
+			Description:     fmt.Sprintf("%s allows %d calls per user every %v", method, limit, q.window),
+			ApiService:      service,
+			QuotaMetric:     method,
+			QuotaId:         ID,
+			QuotaDimensions: map[string]string{"user": user},
+			QuotaValue:      int64(limit),
+		}}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(left)},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// Violations returns the quota violations of err, nil if it has none.
+func Violations(err error) []*errdetails.QuotaFailure_Violation {
+	for _, d := range status.Convert(err).Details() {
+		if f, ok := d.(*errdetails.QuotaFailure); ok {
+			return f.GetViolations()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/retry"
+)
+
+// UnaryServerInterceptor lets every peer, or every key of WithKey, start
+// rate calls per second with bursts of burst calls, the calls over the limit
+// fail with ResourceExhausted.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := l.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor limits the streams a peer opens, the messages
+// inside a stream are not counted.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (l *Limiter) check(ctx context.Context, method string) error {
+	key := l.Key(ctx)
+	if l.Allow(key) {
+		return nil
+	}
+	logctx.Logger(ctx).Printf("[RATELIMIT] %s: %s exceeded the call rate", method, key)
+
+	// the next token is there after 1/rate seconds
+	delay := time.Duration(float64(time.Second) / l.rate)
+	retry.SetTrailer(ctx, delay)
+	st, err := status.New(codes.ResourceExhausted, "call rate limit exceeded").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "call rate limit exceeded")
+	}
+	return st.Err()
+}
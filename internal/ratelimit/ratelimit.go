@@ -0,0 +1,143 @@
+// Package ratelimit provides a keyed token-bucket limiter used to throttle
+// message intake per peer in the streaming handlers and, through its
+// interceptors, the calls of every peer. WithKey counts the callers by
+// something else than their IP, e.g. by the subject of their credentials.
+// A limiter keeps at most MaxKeys buckets, so callers that make up a new
+// key for every call cannot grow it without bound.
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// idleTTL is how long an untouched bucket is kept before it is swept.
+const idleTTL = 5 * time.Minute
+
+const (
+	// MaxKeys is how many buckets a Limiter keeps at most.
+	MaxKeys = 10000
+	// Overflow is the key of the one bucket shared by the keys over MaxKeys.
+	Overflow = "overflow"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps an independent token bucket for every key.
+type Limiter struct {
+	rate  float64
+	burst float64
+	key   func(context.Context) string
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter that refills rate tokens per second up to burst.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		key:       PeerKey,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// WithKey makes the interceptors and Key count callers under key(ctx)
+// instead of PeerKey and returns l.
+func (l *Limiter) WithKey(key func(context.Context) string) *Limiter {
+	l.key = key
+	return l
+}
+
+// Key returns the key the caller of ctx is limited under.
+func (l *Limiter) Key(ctx context.Context) string {
+	return l.key(ctx)
+}
+
+// Allow reports whether one more event for key fits into its bucket and
+// consumes a token if it does.
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok && len(l.buckets) >= MaxKeys {
+		l.dropFull(now)
+		if len(l.buckets) >= MaxKeys {
+			// keys come from the callers: past the cap the newcomers share
+			// a bucket instead of growing the map
+			key = Overflow
+			b, ok = l.buckets[key]
+		}
+	}
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops buckets of peers that have been quiet for a while so the map
+// does not grow with every client that ever connected.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// dropFull drops the buckets that have refilled to burst: they hold nothing
+// a new bucket would not.
+func (l *Limiter) dropFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// PeerKey returns the limiter key for the caller: the remote IP without the
+// port, so every connection from the same host shares one bucket.
+func PeerKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+// Package reflection registers the server reflection service in the API
+// versions a server should offer and checks which of them a target answers.
+// Current tools (grpcurl 1.8.8+, grpcctl) speak grpc.reflection.v1, older
+// grpcurl builds and many GUI clients only grpc.reflection.v1alpha, so the
+// course servers offer both by default.
+package reflection
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcreflection "google.golang.org/grpc/reflection"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// Versions a server can offer.
+const (
+	Both    = "both"
+	V1      = "v1"
+	V1Alpha = "v1alpha"
+	Off     = "off"
+)
+
+// Modes lists the accepted values of Register.
+var Modes = []string{Both, V1, V1Alpha, Off}
+
+// Register registers reflection on s in the versions of mode.
+func Register(s grpcreflection.GRPCServer, mode string) error {
+	switch mode {
+	case Both:
+		grpcreflection.Register(s)
+	case V1:
+		grpcreflection.RegisterV1(s)
+	case V1Alpha:
+		reflectionv1alpha.RegisterServerReflectionServer(s, grpcreflection.NewServer(grpcreflection.ServerOptions{Services: s}))
+	case Off:
+	default:
+		return fmt.Errorf("unknown reflection mode %q, want one of %v", mode, Modes)
+	}
+	return nil
+}
+
+// ServiceNames returns the reflection services registered for mode.
+func ServiceNames(mode string) []string {
+	var names []string
+	if mode == Both || mode == V1 {
+		names = append(names, reflectionv1.ServerReflection_ServiceDesc.ServiceName)
+	}
+	if mode == Both || mode == V1Alpha {
+		names = append(names, reflectionv1alpha.ServerReflection_ServiceDesc.ServiceName)
+	}
+	return names
+}
+
+// Support tells whether a target answers one reflection version.
+type Support struct {
+	Version string
+	Service string
+	// Supported is false when the target answered Unimplemented.
+	Supported bool
+	// Services is the number of services the target listed.
+	Services int
+	// Err is set when the check itself failed, e.g. the target is down.
+	Err error
+}
+
+// Check lists the services of the target over every reflection version.
+func Check(ctx context.Context, conn *grpc.ClientConn) []Support {
+	return []Support{
+		check(ctx, V1, reflectionv1.ServerReflection_ServiceDesc.ServiceName, func(ctx context.Context) (int, error) {
+			stream, err := reflectionv1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+			if err != nil {
+				return 0, err
+			}
+			if err := stream.Send(&reflectionv1.ServerReflectionRequest{
+				MessageRequest: &reflectionv1.ServerReflectionRequest_ListServices{},
+			}); err != nil {
+				return 0, err
+			}
+			resp, err := stream.Recv()
+			if err != nil {
+				return 0, err
+			}
+			return len(resp.GetListServicesResponse().GetService()), nil
+		}),
+		check(ctx, V1Alpha, reflectionv1alpha.ServerReflection_ServiceDesc.ServiceName, func(ctx context.Context) (int, error) {
+			stream, err := reflectionv1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+			if err != nil {
+				return 0, err
+			}
+			if err := stream.Send(&reflectionv1alpha.ServerReflectionRequest{
+				MessageRequest: &reflectionv1alpha.ServerReflectionRequest_ListServices{},
+			}); err != nil {
+				return 0, err
+			}
+			resp, err := stream.Recv()
+			if err != nil {
+				return 0, err
+			}
+			return len(resp.GetListServicesResponse().GetService()), nil
+		}),
+	}
+}
+
+func check(ctx context.Context, version, service string, list func(context.Context) (int, error)) Support {
+	// the stream ends with the check
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := Support{Version: version, Service: service}
+	n, err := list(ctx)
+	switch {
+	case err == nil:
+		s.Supported, s.Services = true, n
+	case status.Code(err) != codes.Unimplemented:
+		s.Err = err
+	}
+	return s
+}
+
+// Any reports whether any version is supported.
+func Any(supports []Support) bool {
+	return slices.ContainsFunc(supports, func(s Support) bool { return s.Supported })
+}
@@ -0,0 +1,226 @@
+// Package rejoin keeps the state of a bidi stream across reconnects. When a
+// stream starts, the server issues a session token in the response header.
+// A client that loses the stream presents the token in the metadata of the
+// next one and rejoins the session where it left it:
+//
+//   - membership: the conversations the client has written to;
+//   - unread backlog: the responses it has not acknowledged yet, and the
+//     ones journaled for its conversations while it was away.
+//
+// Sessions are kept in memory for a TTL after their stream ends. A token
+// that is unknown or expired starts a new session under a new token, so a
+// client tells a rejoin from a fresh start by comparing the tokens. A token
+// presented while its session is still attached, e.g. by a client that
+// noticed the broken connection before the server did, takes the session
+// over: the old stream is ended with ErrTakenOver.
+package rejoin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// Headers of the token, in the request metadata of a rejoining client and in
+// the response header, and of the size of the backlog delivered on rejoin.
+const (
+	TokenHeader   = "x-session-token"
+	BacklogHeader = "x-session-backlog"
+)
+
+// ErrTakenOver is the cause of the cancellation of a stream whose session
+// was rejoined by another stream.
+var ErrTakenOver = errors.New("session taken over by another stream")
+
+// Session is the state of one client, shared by the streams that attach to
+// it one after another.
+type Session[T any] struct {
+	token  string
+	outbox *streams.Outbox[T]
+
+	mu            sync.Mutex
+	conversations map[string]bool
+	// offset is the last journal offset when the session was left
+	offset uint64
+	// gen counts the attachments, the current stream holds the last one
+	gen      uint64
+	attached bool
+	cancel   context.CancelCauseFunc
+	left     time.Time
+}
+
+// Token returns the token of the session.
+func (s *Session[T]) Token() string {
+	return s.token
+}
+
+// Outbox returns the responses waiting for an ack. It outlives the streams,
+// so a rejoining client gets them again under the same delivery ids.
+func (s *Session[T]) Outbox() *streams.Outbox[T] {
+	return s.outbox
+}
+
+// Join makes the session a member of conversation. The empty conversation is
+// not one.
+func (s *Session[T]) Join(conversation string) {
+	if conversation == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations[conversation] = true
+}
+
+// Member reports whether the session has joined conversation.
+func (s *Session[T]) Member(conversation string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conversations[conversation]
+}
+
+// Conversations returns the conversations of the session, sorted.
+func (s *Session[T]) Conversations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, len(s.conversations))
+	for c := range s.conversations {
+		out = append(out, c)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Offset returns the last journal offset at the time the session was left;
+// the entries after it were journaled while the client was away.
+func (s *Session[T]) Offset() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset
+}
+
+// Attachment is a stream holding a session.
+type Attachment[T any] struct {
+	Session *Session[T]
+	// Rejoined is set when the stream presented the token of a live session.
+	Rejoined bool
+
+	store *Store[T]
+	gen   uint64
+}
+
+// Leave detaches the stream from the session, which is then kept for the TTL
+// of the store. Leaving a session taken over by another stream does nothing.
+func (a *Attachment[T]) Leave() {
+	a.store.leave(a.Session, a.gen)
+}
+
+// Store holds the sessions. It is safe for concurrent use.
+type Store[T any] struct {
+	ttl       time.Duration
+	newOutbox func() *streams.Outbox[T]
+	last      func() uint64
+
+	mu       sync.Mutex
+	sessions map[string]*Session[T]
+}
+
+// NewStore creates a store that keeps a session for ttl after its stream
+// ends. newOutbox creates the outbox of a new session; last returns the
+// last offset of the journal, nil without one.
+func NewStore[T any](ttl time.Duration, newOutbox func() *streams.Outbox[T], last func() uint64) *Store[T] {
+	return &Store[T]{
+		ttl:       ttl,
+		newOutbox: newOutbox,
+		last:      last,
+		sessions:  make(map[string]*Session[T]),
+	}
+}
+
+// Attach attaches the stream with ctx to the session of the token in its
+// metadata or, without a live one, to a new session. The returned context
+// is canceled with ErrTakenOver when another stream takes the session over.
+func (s *Store[T]) Attach(ctx context.Context) (*Attachment[T], context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(TokenHeader); len(v) > 0 {
+			token = v[0]
+		}
+	}
+	sess, rejoined := s.sessions[token]
+	if !rejoined {
+		sess = &Session[T]{
+			token:         newToken(),
+			outbox:        s.newOutbox(),
+			conversations: make(map[string]bool),
+		}
+		s.sessions[sess.token] = sess
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.attached {
+		// the old stream is still running, what it journals from now on is
+		// backlog of the new one
+		sess.cancel(ErrTakenOver)
+		sess.offset = s.lastOffset()
+	}
+	sess.gen++
+	sess.attached = true
+	sess.cancel = cancel
+	return &Attachment[T]{Session: sess, Rejoined: rejoined, store: s, gen: sess.gen}, ctx
+}
+
+func (s *Store[T]) leave(sess *Session[T], gen uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.gen != gen {
+		return
+	}
+	sess.cancel(nil)
+	sess.attached = false
+	sess.offset = s.lastOffset()
+	sess.left = time.Now()
+}
+
+// expire drops the sessions left longer than the TTL ago.
+func (s *Store[T]) expire(now time.Time) {
+	for token, sess := range s.sessions {
+		sess.mu.Lock()
+		expired := !sess.attached && now.Sub(sess.left) > s.ttl
+		sess.mu.Unlock()
+		if expired {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+func (s *Store[T]) lastOffset() uint64 {
+	if s.last == nil {
+		return 0
+	}
+	return s.last()
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,159 @@
+// Package requestid gives every call a correlation id. The client generates
+// it unless the context already carries one, the server takes it from the
+// metadata (or generates it for clients that sent none), echoes it back in
+// the response headers and attaches it to errors as an errdetails.RequestInfo.
+// The id is added to the log fields of the call on both sides.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Key is the metadata key of the request id, in requests and response headers.
+const Key = "x-request-id"
+
+// LogKey is the log field holding the request id.
+const LogKey = "request_id"
+
+type ctxKey struct{}
+
+// NewContext stores id in ctx and adds it to the log fields.
+func NewContext(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, ctxKey{}, id)
+	return logctx.With(ctx, LogKey, id)
+}
+
+// FromContext returns the request id stored in ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok
+}
+
+// FromError returns the request id attached to a status error.
+func FromError(err error) (string, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RequestInfo); ok {
+			return info.GetRequestId(), true
+		}
+	}
+	return "", false
+}
+
+// incoming takes the id sent by the caller or generates one.
+func incoming(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get(Key); len(vs) > 0 && vs[0] != "" {
+		return NewContext(ctx, vs[0]), vs[0]
+	}
+
+	id := uuid.NewString()
+	return NewContext(ctx, id), id
+}
+
+// Start returns a context carrying a request id: the one already in ctx, e.g.
+// of the incoming call of a server that calls downstream, or a new one.
+func Start(ctx context.Context) context.Context {
+	if _, ok := FromContext(ctx); ok {
+		return ctx
+	}
+	return NewContext(ctx, uuid.NewString())
+}
+
+// outgoing makes sure the call carries an id.
+func outgoing(ctx context.Context) context.Context {
+	ctx = Start(ctx)
+	id, _ := FromContext(ctx)
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get(Key)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, Key, id)
+}
+
+// withRequestInfo attaches the id to a status error. Errors that already
+// carry a RequestInfo are left alone.
+func withRequestInfo(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := FromError(err); ok {
+		return err
+	}
+
+	st, detailErr := status.Convert(err).WithDetails(&errdetails.RequestInfo{RequestId: id})
+	if detailErr != nil {
+		return err
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor tags every unary call with its request id.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, id := incoming(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(Key, id))
+
+		resp, err := handler(ctx, req)
+		return resp, withRequestInfo(err, id)
+	}
+}
+
+// StreamServerInterceptor tags every stream with its request id.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := incoming(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs(Key, id))
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		return withRequestInfo(err, id)
+	}
+}
+
+// UnaryClientInterceptor sends a request id with every call.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends a request id with every stream.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// serverStream replaces the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
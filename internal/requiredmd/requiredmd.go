@@ -0,0 +1,106 @@
+// Package requiredmd rejects calls that come without the metadata the rest
+// of the server relies on, e.g. x-request-id for the logs or x-tenant for
+// the quotas. The check happens once, up front: a call missing keys fails
+// with InvalidArgument and a google.rpc.BadRequest detail naming every
+// missing key, instead of some interceptor further down falling back to a
+// default value or failing on the first key it misses.
+package requiredmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Reason is the reason of the field violations.
+const Reason = "MISSING_METADATA"
+
+// Checker is safe for concurrent use.
+type Checker struct {
+	keys   []string
+	exempt map[string]struct{}
+}
+
+// New creates a checker requiring keys on every call. Calls to the exempt
+// services (health, reflection, admin) are not checked: probes and tools do
+// not send application headers.
+func New(keys []string, exempt ...string) *Checker {
+	c := &Checker{keys: keys, exempt: make(map[string]struct{}, len(exempt))}
+	for _, s := range exempt {
+		c.exempt[s] = struct{}{}
+	}
+	return c
+}
+
+// Missing returns the required keys the call has no value for; an empty
+// value counts as missing.
+func (c *Checker) Missing(ctx context.Context) []string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var missing []string
+	for _, key := range c.keys {
+		if vs := md.Get(key); len(vs) == 0 || vs[0] == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// UnaryServerInterceptor rejects unary calls missing required metadata.
+func (c *Checker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := c.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams missing required metadata before
+// the handler reads anything.
+func (c *Checker) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := c.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (c *Checker) check(ctx context.Context, method string) error {
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if _, ok := c.exempt[service]; ok {
+		return nil
+	}
+	missing := c.Missing(ctx)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	logctx.Logger(ctx).Printf("[REQUIRED METADATA] %s: missing %s", method, strings.Join(missing, ", "))
+	return missingError(missing)
+}
+
+func missingError(missing []string) error {
+	msg := fmt.Sprintf("missing required metadata: %s", strings.Join(missing, ", "))
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(missing))
+	for _, key := range missing {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       key,
+			Description: fmt.Sprintf("metadata key %s is required on every call", key),
+			Reason:      Reason,
+		})
+	}
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,227 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/deadline"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Trailers advising a delay, for clients that do not read status details,
+// like those of the REST gateway. RetryInfo takes precedence over them.
+const (
+	// AfterHeader is the delay in milliseconds, set by the servers here.
+	AfterHeader = "retry-after-ms"
+	// afterSecondsHeader is the delay in whole seconds, as HTTP proxies
+	// send it in Retry-After.
+	afterSecondsHeader = "retry-after"
+)
+
+// SetTrailer advises the client of a failing call to wait d before the
+// next one, next to the RetryInfo detail of the error.
+func SetTrailer(ctx context.Context, d time.Duration) {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(AfterHeader, strconv.FormatInt(d.Milliseconds(), 10)))
+}
+
+// metadataDelay returns the delay advised in the trailer md, if any.
+func metadataDelay(md metadata.MD) (time.Duration, bool) {
+	if v := md.Get(AfterHeader); len(v) > 0 {
+		if ms, err := strconv.ParseInt(v[0], 10, 64); err == nil && ms >= 0 {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	if v := md.Get(afterSecondsHeader); len(v) > 0 {
+		if s, err := strconv.ParseInt(v[0], 10, 64); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// Gate holds back the calls to a target that asked its clients to wait.
+// Retrying only the call that was told to wait is not enough: every other
+// call of the client still goes out and is rejected too, and once the
+// delay is over they all retry at once. With a gate a delay advised to one
+// call holds every call to the same target until it is over, and then only
+// one call goes out first. The others wait for its outcome: they go out
+// once it succeeded, or wait out the next delay it was advised.
+//
+// A delay is advised for the whole target, except under a QuotaFailure: a
+// quota is spent per method, and the other methods are not held.
+//
+// A Gate is safe for concurrent use.
+type Gate struct {
+	mu   sync.Mutex
+	held map[string]*hold
+}
+
+// hold is a delay advised by a target: no call before until, and the
+// status that advised it for the calls that can not wait that long. Once
+// it is over, probe is the call that went out first, closed when it ends.
+type hold struct {
+	until time.Time
+	st    *status.Status
+	probe chan struct{}
+}
+
+// NewGate creates a gate holding nothing.
+func NewGate() *Gate {
+	return &Gate{held: make(map[string]*hold)}
+}
+
+// scopes returns the keys of the holds applying to a call of method on
+// target.
+func scopes(target, method string) [2]string {
+	return [2]string{target, target + method}
+}
+
+// record holds the calls err applies to for d, unless they are held longer
+// already.
+func (g *Gate) record(target, method string, err error, d time.Duration) {
+	st := status.Convert(err)
+	key := target
+	for _, detail := range st.Details() {
+		if _, ok := detail.(*errdetails.QuotaFailure); ok {
+			key = target + method
+		}
+	}
+
+	until := time.Now().Add(d)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if h, ok := g.held[key]; ok && h.until.After(until) {
+		return
+	}
+	// the calls waiting for a probe wait for the new delay instead
+	g.held[key] = &hold{until: until, st: st}
+}
+
+// admit lets a call of method on target through, or tells it what to wait
+// for: the time left of a hold with the status that advised it, or the end
+// of the probe of an expired hold. An admitted call that is a probe gets
+// done to call once it has ended and its delay, if any, is recorded.
+func (g *Gate) admit(target, method string) (left time.Duration, st *status.Status, probe <-chan struct{}, done func()) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range scopes(target, method) {
+		h, ok := g.held[key]
+		if !ok || !now.Before(h.until) {
+			continue
+		}
+		if l := h.until.Sub(now); l > left {
+			left, st = l, h.st
+		}
+	}
+	if left > 0 {
+		return left, st, nil, nil
+	}
+
+	// a probe of any scope holds the call; only then the call becomes the
+	// probe of every expired hold, or a probe installed on one scope would
+	// never be closed
+	for _, key := range scopes(target, method) {
+		if h, ok := g.held[key]; ok && h.probe != nil {
+			return 0, nil, h.probe, nil
+		}
+	}
+	var probes []*hold
+	for _, key := range scopes(target, method) {
+		h, ok := g.held[key]
+		if !ok {
+			continue
+		}
+		h.probe = make(chan struct{})
+		probes = append(probes, h)
+	}
+	if len(probes) == 0 {
+		return 0, nil, nil, func() {}
+	}
+	return 0, nil, nil, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, key := range scopes(target, method) {
+			for _, h := range probes {
+				// a hold replaced by a new delay stays
+				if g.held[key] == h {
+					delete(g.held, key)
+				}
+			}
+		}
+		for _, h := range probes {
+			close(h.probe)
+		}
+	}
+}
+
+// wait holds a call until its target lets it through and returns done to
+// call once the call has ended. A call that can not wait, because the hold
+// is longer than maxDelay or than what is left of its deadline, fails at
+// once with the status that advised the hold and a RetryInfo of the time
+// left, without reaching the server.
+func (g *Gate) wait(ctx context.Context, budget deadline.Budget, target, method string, maxDelay time.Duration) (func(), error) {
+	logger := logctx.Logger(ctx)
+	for {
+		left, st, probe, done := g.admit(target, method)
+		switch {
+		case done != nil:
+			return done, nil
+		case probe != nil:
+			select {
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			case <-probe:
+			}
+			continue
+		case left > maxDelay || !budget.Allows(left):
+			logger.Printf("[RETRY] %s: %s asked to wait another %v, failing without a call", method, target, left.Round(time.Millisecond))
+			return nil, heldError(st, left)
+		}
+
+		// the held calls do not all go out at the same moment, and none
+		// before the hold is over
+		left += time.Duration(rand.Float64() * jitter * float64(left))
+		logger.Printf("[RETRY] %s: %s asked to wait, holding the call for %v", method, target, left.Round(time.Millisecond))
+		timer := time.NewTimer(left)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// heldError returns st with its RetryInfo replaced by left, so the caller
+// sees the error the server gave and how much longer to wait.
+func heldError(st *status.Status, left time.Duration) error {
+	msg := fmt.Sprintf("not sent, backing off for %v: %s", left.Round(time.Millisecond), st.Message())
+	held := status.New(st.Code(), msg)
+	var details []protoadapt.MessageV1
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.RetryInfo); ok {
+			continue
+		}
+		if m, ok := d.(protoadapt.MessageV1); ok {
+			details = append(details, m)
+		}
+	}
+	details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(left)})
+	withDetails, err := held.WithDetails(details...)
+	if err != nil {
+		return held.Err()
+	}
+	return withDetails.Err()
+}
@@ -0,0 +1,190 @@
+// Package retry implements an application-level client retry policy driven
+// by the google.rpc.RetryInfo detail the server attaches to its errors, or
+// by its retry-after-ms trailer. Only calls that are safe to repeat are
+// retried, see package idempotency. A Gate makes every call to a target
+// honor a delay it advised, not only the call it was advised to.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/deadline"
+	"github.com/easyp-tech/course-grpc/internal/idempotency"
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+const (
+	// DefaultMaxAttempts is the total number of attempts, the first one included.
+	DefaultMaxAttempts = 3
+	// DefaultMaxDelay is the longest advised delay worth waiting for within a
+	// call. A server asking for more, like a quota that resets in a minute,
+	// gets the error returned to the caller instead of an early retry.
+	DefaultMaxDelay = 5 * time.Second
+	// DefaultMinAttemptBudget is the least amount of deadline worth starting
+	// another attempt with.
+	DefaultMinAttemptBudget = 50 * time.Millisecond
+	// jitter spreads retries of many clients by ±20% of the advised delay.
+	jitter = 0.2
+)
+
+// Policy configures UnaryClientInterceptor.
+type Policy struct {
+	MaxAttempts int
+	MaxDelay    time.Duration
+	// MinAttemptBudget is the floor of the deadline budget below which no
+	// further attempt is started.
+	MinAttemptBudget time.Duration
+	// OnRetry, if set, is called before every retry, e.g. to count them.
+	OnRetry func(ctx context.Context, method string)
+	// Gate, if set, holds every call to a target that advised a delay
+	// until the delay is over, not only the retries of the failed call.
+	Gate *Gate
+}
+
+// DefaultPolicy returns the policy used by the course clients.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:      DefaultMaxAttempts,
+		MaxDelay:         DefaultMaxDelay,
+		MinAttemptBudget: DefaultMinAttemptBudget,
+	}
+}
+
+// Delay returns the retry delay advised by the server in err, if any.
+func Delay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// advisedDelay returns the retry delay advised by the server in err or,
+// without RetryInfo, in the trailer of the failed call.
+func advisedDelay(err error, trailer metadata.MD) (time.Duration, bool) {
+	if d, ok := Delay(err); ok {
+		return d, true
+	}
+	if status.Code(err) != codes.ResourceExhausted && status.Code(err) != codes.Unavailable {
+		return 0, false
+	}
+	return metadataDelay(trailer)
+}
+
+// UnaryClientInterceptor retries calls whose error carries RetryInfo or a
+// retry-after trailer. Errors without them are returned as is: the server
+// did not say retrying is safe. Neither are calls of methods that are not
+// idempotent unless they carry an idempotency key: the failed attempt may
+// have taken effect.
+//
+// With a Gate in p, every attempt first waits out the delay its target
+// advised to any call, and the advised delays are recorded in the gate.
+func UnaryClientInterceptor(p Policy) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		budget := deadline.FromContext(ctx, p.MinAttemptBudget)
+		// a call that is never retried keeps the whole deadline
+		retryable := idempotency.Retryable(ctx, method)
+
+		var err error
+		for attempt := 1; ; attempt++ {
+			done := func() {}
+			if p.Gate != nil {
+				if done, err = p.Gate.wait(ctx, budget, cc.Target(), method, p.MaxDelay); err != nil {
+					return err
+				}
+			}
+			// the first attempt may be the only one: it gets the whole
+			// deadline, the budget is split between the retries once one
+			// has been decided on
+			attemptsLeft := 1
+			if attempt > 1 {
+				attemptsLeft = p.MaxAttempts - attempt + 1
+			}
+			var trailer metadata.MD
+			err = invokeAttempt(ctx, budget, attemptsLeft, method, req, reply, cc, invoker, append(opts, grpc.Trailer(&trailer))...)
+			delay, ok := advisedDelay(err, trailer)
+			if ok && p.Gate != nil {
+				p.Gate.record(cc.Target(), method, err, delay)
+			}
+			done()
+			if err == nil || !ok {
+				return err
+			}
+			if attempt >= p.MaxAttempts {
+				return err
+			}
+			if !retryable {
+				logctx.Logger(ctx).Printf("[RETRY] %s is not idempotent and has no %s, not retrying", method, idempotency.KeyHeader)
+				return err
+			}
+			if delay > p.MaxDelay {
+				logctx.Logger(ctx).Printf("[RETRY] %s: server asks to wait %v, longer than %v, not retrying", method, delay.Round(time.Millisecond), p.MaxDelay)
+				return err
+			}
+			delay = withJitter(delay)
+
+			if !budget.Allows(delay) {
+				logctx.Logger(ctx).Printf("[RETRY] %s: not enough deadline left (%v) to wait %v, giving up", method, budget.Remaining(), delay)
+				return err
+			}
+
+			logctx.Logger(ctx).Printf("[RETRY] %s attempt %d failed (%v), retrying in %v", method, attempt, status.Code(err), delay)
+			if p.OnRetry != nil {
+				p.OnRetry(ctx, method)
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// invokeAttempt runs one attempt with its share of the deadline budget.
+func invokeAttempt(
+	ctx context.Context,
+	budget deadline.Budget,
+	attemptsLeft int,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	attemptCtx, cancel, err := budget.Attempt(ctx, attemptsLeft)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return invoker(attemptCtx, method, req, reply, cc, opts...)
+}
+
+func withJitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
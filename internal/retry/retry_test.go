@@ -0,0 +1,86 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/easyp-tech/course-grpc/internal/idempotency"
+)
+
+// unavailable is an error advising a retry in 1ms.
+func unavailable(t *testing.T) error {
+	t.Helper()
+
+	st, err := status.New(codes.Unavailable, "try again").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+// timeouts calls the interceptor with a 3s deadline and returns the timeout
+// every attempt got. The first fails attempts fail with err.
+func timeouts(t *testing.T, ctx context.Context, fails int, err error) []time.Duration {
+	t.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var got []time.Duration
+	invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		d, _ := ctx.Deadline()
+		got = append(got, time.Until(d).Round(100*time.Millisecond))
+		if len(got) <= fails {
+			return err
+		}
+		return nil
+	}
+	_ = UnaryClientInterceptor(DefaultPolicy())(ctx, "/test.Service/Method", nil, nil, nil, invoker)
+	return got
+}
+
+func TestFirstAttemptKeepsDeadline(t *testing.T) {
+	ctx := idempotency.WithKey(context.Background())
+	got := timeouts(t, ctx, 0, nil)
+	if len(got) != 1 || got[0] != 3*time.Second {
+		t.Errorf("attempt timeouts = %v, want [3s]", got)
+	}
+}
+
+func TestRetriesSplitBudget(t *testing.T) {
+	ctx := idempotency.WithKey(context.Background())
+	got := timeouts(t, ctx, 1, unavailable(t))
+	// the second of three attempts gets half of what is left
+	if len(got) != 2 || got[0] != 3*time.Second || got[1] != 1500*time.Millisecond {
+		t.Errorf("attempt timeouts = %v, want [3s 1.5s]", got)
+	}
+}
+
+func TestNotRetryableKeepsDeadline(t *testing.T) {
+	// no idempotency key and no idempotency level: never retried
+	got := timeouts(t, context.Background(), 1, unavailable(t))
+	if len(got) != 1 || got[0] != 3*time.Second {
+		t.Errorf("attempt timeouts = %v, want [3s]", got)
+	}
+}
+
+func TestNoAdvisedDelayNoRetry(t *testing.T) {
+	ctx := idempotency.WithKey(context.Background())
+	got := timeouts(t, ctx, 1, status.Error(codes.DeadlineExceeded, "slow"))
+	if len(got) != 1 {
+		t.Errorf("attempts = %d, want 1: an error without RetryInfo is not retried", len(got))
+	}
+}
@@ -0,0 +1,178 @@
+// Package servertiming attaches the processing time and the id of the
+// serving instance to the trailers of every call and aggregates them on the
+// client, which shows where requests went and how long the server spent on
+// them compared to the total latency observed by the client.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Trailer keys.
+const (
+	ProcessingTimeKey = "x-processing-time"
+	ServerIDKey       = "x-server-id"
+)
+
+// InstanceID returns an id that is unique per server process: the host name
+// followed by a random suffix.
+func InstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s", host, uuid.NewString()[:8])
+}
+
+func trailer(id string, start time.Time) metadata.MD {
+	// metadata values are printable ASCII: "us" instead of "µs", which
+	// time.ParseDuration reads as well
+	return metadata.Pairs(
+		ProcessingTimeKey, strings.Replace(time.Since(start).String(), "µs", "us", 1),
+		ServerIDKey, id,
+	)
+}
+
+// UnaryServerInterceptor sets the trailers on every unary response, failed
+// ones included.
+func UnaryServerInterceptor(id string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		_ = grpc.SetTrailer(ctx, trailer(id, start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor sets the trailers when a stream handler returns.
+func StreamServerInterceptor(id string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		ss.SetTrailer(trailer(id, start))
+		return err
+	}
+}
+
+type serverStats struct {
+	calls      int
+	processing time.Duration
+}
+
+// Collector aggregates the trailers seen by a client per server instance.
+type Collector struct {
+	mu      sync.Mutex
+	servers map[string]*serverStats
+}
+
+// NewCollector creates an empty Collector.
+func NewCollector() *Collector {
+	return &Collector{servers: make(map[string]*serverStats)}
+}
+
+func (c *Collector) record(md metadata.MD) {
+	ids := md.Get(ServerIDKey)
+	if len(ids) == 0 {
+		return
+	}
+
+	var processing time.Duration
+	if vs := md.Get(ProcessingTimeKey); len(vs) > 0 {
+		processing, _ = time.ParseDuration(vs[0])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st, ok := c.servers[ids[0]]
+	if !ok {
+		st = &serverStats{}
+		c.servers[ids[0]] = st
+	}
+	st.calls++
+	st.processing += processing
+}
+
+// UnaryClientInterceptor records the trailers of every unary call.
+func (c *Collector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		var md metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&md))...)
+		c.record(md)
+		return err
+	}
+}
+
+// StreamClientInterceptor records the trailers of every stream once it ends.
+func (c *Collector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &trailerStream{ClientStream: cs, collector: c, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+// Log prints calls and average processing time per server instance.
+func (c *Collector) Log() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.servers))
+	for id := range c.servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		st := c.servers[id]
+		log.Printf("[SERVER TIMING] %s: %d calls, avg processing %v",
+			id, st.calls, (st.processing / time.Duration(st.calls)).Round(time.Microsecond))
+	}
+}
+
+// trailerStream records trailers when the stream is finished: after the
+// final error for server streams, after the single response otherwise.
+type trailerStream struct {
+	grpc.ClientStream
+
+	collector     *Collector
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *trailerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(func() { s.collector.record(s.Trailer()) })
+	}
+	return err
+}
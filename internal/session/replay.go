@@ -0,0 +1,226 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Options configure Replay.
+type Options struct {
+	// Ignore lists top-level response fields, by proto name, that are left
+	// out of the comparison, e.g. offsets that differ from run to run.
+	Ignore []string
+	// Unordered compares the responses regardless of their order, for
+	// streams that answer in parallel.
+	Unordered bool
+	// Paced keeps the recorded gaps between the sent messages; otherwise
+	// they are sent back to back.
+	Paced bool
+}
+
+// Result is the outcome of one replayed stream.
+type Result struct {
+	Method   string
+	Received []proto.Message
+	Code     string
+	// Diffs describe every difference to the recording, empty if none.
+	Diffs []string
+}
+
+// OK reports whether the responses matched the recording.
+func (r *Result) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+// Replay sends the recorded requests of s over conn and compares the
+// responses with the recorded ones.
+func Replay(ctx context.Context, conn grpc.ClientConnInterface, s *Stream, opts Options, callOpts ...grpc.CallOption) *Result {
+	res := &Result{Method: s.Method}
+
+	reqType, respType, err := messageTypes(s.Method)
+	if err != nil {
+		res.Diffs = append(res.Diffs, err.Error())
+		return res
+	}
+	sent, err := decode(reqType, s.Sent)
+	if err != nil {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("recorded request: %v", err))
+		return res
+	}
+	want, err := decode(respType, s.Received)
+	if err != nil {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("recorded response: %v", err))
+		return res
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Streams the client gave up on, like a subscription that is dropped
+	// after a while, are ended the same way after the recorded time
+	duration := time.Duration(s.DurationMS) * time.Millisecond
+	switch {
+	case duration <= 0:
+	case s.Code == codes.DeadlineExceeded.String():
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, duration)
+		defer cancelTimeout()
+	case s.Code == codes.Canceled.String():
+		timer := time.AfterFunc(duration, cancel)
+		defer timer.Stop()
+	}
+
+	desc := &grpc.StreamDesc{ClientStreams: s.ClientStreams, ServerStreams: s.ServerStreams}
+	cs, err := conn.NewStream(ctx, desc, s.Method, callOpts...)
+	if err != nil {
+		res.Code = status.Code(err).String()
+		res.Diffs = append(res.Diffs, fmt.Sprintf("open stream: %v", err))
+		return res
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		start := time.Now()
+		for i, m := range sent {
+			if opts.Paced {
+				wait := time.Until(start.Add(time.Duration(s.Sent[i].AtMS) * time.Millisecond))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			// io.EOF means the server has ended the stream, RecvMsg
+			// returns its status
+			if err := cs.SendMsg(m); err != nil {
+				return
+			}
+		}
+		_ = cs.CloseSend()
+	}()
+
+	for {
+		m := respType.New().Interface()
+		err := cs.RecvMsg(m)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			res.Code = status.Code(err).String()
+			break
+		}
+		res.Received = append(res.Received, m)
+		if !s.ServerStreams {
+			res.Code = status.Code(nil).String()
+			break
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	if res.Code != s.Code {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("status: want %s, got %s", s.Code, res.Code))
+	}
+	res.Diffs = append(res.Diffs, compare(want, res.Received, opts)...)
+	return res
+}
+
+// decode unmarshals recorded bodies into messages of type mt.
+func decode(mt protoreflect.MessageType, msgs []Message) ([]proto.Message, error) {
+	out := make([]proto.Message, 0, len(msgs))
+	for i, m := range msgs {
+		pm := mt.New().Interface()
+		if err := protojson.Unmarshal(m.Body, pm); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		out = append(out, pm)
+	}
+	return out, nil
+}
+
+// compare describes the differences between the recorded and the replayed
+// responses.
+func compare(want, got []proto.Message, opts Options) []string {
+	want, got = strip(want, opts.Ignore), strip(got, opts.Ignore)
+
+	var diffs []string
+	if len(want) != len(got) {
+		diffs = append(diffs, fmt.Sprintf("responses: want %d, got %d", len(want), len(got)))
+	}
+
+	if opts.Unordered {
+		// match every recorded response with any equal replayed one
+		used := make([]bool, len(got))
+	next:
+		for _, w := range want {
+			for j, g := range got {
+				if !used[j] && proto.Equal(w, g) {
+					used[j] = true
+					continue next
+				}
+			}
+			diffs = append(diffs, "missing response "+format(w))
+		}
+		for j, g := range got {
+			if !used[j] {
+				diffs = append(diffs, "unexpected response "+format(g))
+			}
+		}
+		return diffs
+	}
+
+	for i := 0; i < min(len(want), len(got)); i++ {
+		if !proto.Equal(want[i], got[i]) {
+			diffs = append(diffs, fmt.Sprintf("response %d: want %s, got %s", i+1, format(want[i]), format(got[i])))
+		}
+	}
+	for i := len(got); i < len(want); i++ {
+		diffs = append(diffs, fmt.Sprintf("response %d: missing %s", i+1, format(want[i])))
+	}
+	for i := len(want); i < len(got); i++ {
+		diffs = append(diffs, fmt.Sprintf("response %d: unexpected %s", i+1, format(got[i])))
+	}
+	return diffs
+}
+
+// strip returns copies of msgs with the ignored fields cleared.
+func strip(msgs []proto.Message, ignore []string) []proto.Message {
+	if len(ignore) == 0 {
+		return msgs
+	}
+	out := make([]proto.Message, len(msgs))
+	for i, m := range msgs {
+		c := proto.Clone(m)
+		fields := c.ProtoReflect().Descriptor().Fields()
+		for _, name := range ignore {
+			if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+				c.ProtoReflect().Clear(fd)
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+func format(m proto.Message) string {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	// protojson randomly adds spaces to discourage byte comparisons
+	return strings.Join(strings.Fields(string(b)), " ")
+}
@@ -0,0 +1,236 @@
+// Package session records the streams of a client to a file and replays a
+// recorded session against a server, comparing the responses with the
+// recorded ones. A recording made against a known good server is a golden
+// file for regression tests of the streaming handlers.
+//
+// A session file holds one JSON object per line, one per finished stream:
+//
+//	{"method":"/api.stream.v1.EchoService/EchoServerStream","server_streams":true,
+//	 "sent":[{"at_ms":0,"body":{"message":"hi"}}],"received":[...],"code":"OK"}
+//
+// Message bodies are protojson, so recordings can be read and edited by hand.
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Stream is one recorded stream.
+type Stream struct {
+	Method        string    `json:"method"`
+	ClientStreams bool      `json:"client_streams,omitempty"`
+	ServerStreams bool      `json:"server_streams,omitempty"`
+	Sent          []Message `json:"sent"`
+	Received      []Message `json:"received"`
+	// Code is the final status code, OK for streams that ended normally.
+	Code string `json:"code"`
+	// DurationMS is the time from opening the stream to its end.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// Message is a recorded request or response.
+type Message struct {
+	// AtMS is the time since the stream was opened, in milliseconds.
+	AtMS int64           `json:"at_ms"`
+	Body json.RawMessage `json:"body"`
+}
+
+// Recorder appends finished streams to a session file. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// Create truncates or creates the session file at path.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Close closes the file. Streams finishing afterwards are not recorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+func (r *Recorder) write(s *Stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return
+	}
+	_ = r.enc.Encode(s)
+}
+
+// StreamClientInterceptor records every stream once it has ended.
+func (r *Recorder) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &recordedStream{
+			ClientStream: cs,
+			recorder:     r,
+			start:        time.Now(),
+			stream: Stream{
+				Method:        method,
+				ClientStreams: desc.ClientStreams,
+				ServerStreams: desc.ServerStreams,
+				Sent:          []Message{},
+				Received:      []Message{},
+			},
+		}, nil
+	}
+}
+
+// recordedStream collects the messages and writes the stream when it is
+// finished: after the final error for server streams, after the single
+// response otherwise.
+type recordedStream struct {
+	grpc.ClientStream
+
+	recorder *Recorder
+	start    time.Time
+
+	// SendMsg and RecvMsg may run in different goroutines
+	mu       sync.Mutex
+	stream   Stream
+	finished bool
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.add(&s.stream.Sent, m)
+	}
+	return err
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+
+	s.add(&s.stream.Received, m)
+	if !s.stream.ServerStreams {
+		s.finish(nil)
+	}
+	return nil
+}
+
+func (s *recordedStream) add(to *[]Message, m interface{}) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	body, err := protojson.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*to = append(*to, Message{AtMS: time.Since(s.start).Milliseconds(), Body: body})
+}
+
+func (s *recordedStream) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	if err == io.EOF {
+		err = nil
+	}
+	s.stream.Code = status.Code(err).String()
+	s.stream.DurationMS = time.Since(s.start).Milliseconds()
+	s.recorder.write(&s.stream)
+}
+
+// Load reads the streams of a session file.
+func Load(path string) ([]Stream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var streams []Stream
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var s Stream
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		streams = append(streams, s)
+	}
+	return streams, sc.Err()
+}
+
+// messageTypes returns the request and response types of a full method name
+// like /api.stream.v1.EchoService/EchoServerStream. The generated package of
+// the service must be linked into the binary.
+func messageTypes(method string) (req, resp protoreflect.MessageType, err error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown method %q", method)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a method", name)
+	}
+
+	if req, err = protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName()); err != nil {
+		return nil, nil, err
+	}
+	if resp, err = protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName()); err != nil {
+		return nil, nil, err
+	}
+	return req, resp, nil
+}
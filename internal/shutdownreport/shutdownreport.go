@@ -0,0 +1,221 @@
+// Package shutdownreport writes a machine-readable summary of a run when a
+// server or client exits: how long it ran, how many calls of every method
+// ended with every code, what happened to the streams still open when the
+// shutdown began and how long each component took to stop. The logs tell a
+// person the same; the report lets a test check that a graceful shutdown
+// drained its streams instead of cutting them off.
+//
+// A Recorder is a stats.Handler, so it sees every call whatever the
+// interceptors are. A stream open when the shutdown begins is drained when
+// it ends before the shutdown deadline, with whatever status, farewell's
+// Unavailable included, and aborted when it ends after the deadline, when
+// the server is stopped forcefully, or not at all:
+//
+//	{"command":"server","uptime_ms":5203.1,"rpcs":[{"method":"/api.v2.EchoAPI/Echo","code":"OK","count":3}],
+//	 "streams":{"open_at_shutdown":2,"drained":2,"aborted":0},
+//	 "phases":[{"name":"readiness","duration_ms":1000.4},...]}
+package shutdownreport
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/graceful"
+)
+
+// Stdout is the path that writes the report to standard output.
+const Stdout = "-"
+
+// Report is the summary of a run.
+type Report struct {
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+	UptimeMs  float64   `json:"uptime_ms"`
+	RPCs      []RPCs    `json:"rpcs"`
+	Streams   Streams   `json:"streams"`
+	Phases    []Phase   `json:"phases"`
+	// Error is the error the run ended with, empty for a clean exit.
+	Error string `json:"error,omitempty"`
+}
+
+// RPCs counts the calls of a method that ended with a code.
+type RPCs struct {
+	Method string `json:"method"`
+	Code   string `json:"code"`
+	Count  int    `json:"count"`
+}
+
+// Streams are the streams open when the shutdown began.
+type Streams struct {
+	OpenAtShutdown int `json:"open_at_shutdown"`
+	Drained        int `json:"drained"`
+	Aborted        int `json:"aborted"`
+}
+
+// Phase is the shutdown of one component, see graceful.Phase.
+type Phase struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+var _ stats.Handler = &Recorder{}
+
+// Recorder collects the report. It is safe for concurrent use.
+type Recorder struct {
+	command string
+	start   time.Time
+
+	mu    sync.Mutex
+	calls map[RPCs]int
+	// open are the streams in progress
+	open map[*call]struct{}
+	// shutdown is done when the shutdown deadline passes, nil before the
+	// shutdown
+	shutdown context.Context
+	streams  Streams
+}
+
+// call is an RPC in progress.
+type call struct {
+	method string
+	// active marks a stream open when the shutdown began
+	active bool
+}
+
+type callKey struct{}
+
+// New creates the recorder of command, e.g. "server", started now.
+func New(command string) *Recorder {
+	return &Recorder{command: command, start: time.Now(), calls: make(map[RPCs]int), open: make(map[*call]struct{})}
+}
+
+func (r *Recorder) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, callKey{}, &call{method: info.FullMethodName})
+}
+
+func (r *Recorder) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch s := s.(type) {
+	case *stats.Begin:
+		if s.IsClientStream || s.IsServerStream {
+			r.open[c] = struct{}{}
+		}
+	case *stats.End:
+		r.calls[RPCs{Method: c.method, Code: status.Code(s.Error).String()}]++
+		delete(r.open, c)
+		if !c.active {
+			return
+		}
+		if r.shutdown.Err() != nil {
+			r.streams.Aborted++
+		} else {
+			r.streams.Drained++
+		}
+	}
+}
+
+func (r *Recorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *Recorder) HandleConn(context.Context, stats.ConnStats) {}
+
+// Shutdown marks the streams open now as the ones to drain; ctx is done once
+// the shutdown deadline passes. Register it with graceful.Group.OnShutdown.
+func (r *Recorder) Shutdown(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shutdown != nil {
+		return
+	}
+	r.shutdown = ctx
+	for c := range r.open {
+		c.active = true
+		r.streams.OpenAtShutdown++
+	}
+}
+
+// Report returns the report of the run so far with the shutdown phases of
+// the group and the error the run ended with. The streams to drain that are
+// still open count as aborted.
+func (r *Recorder) Report(phases []graceful.Phase, runErr error) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := Report{
+		Command:   r.command,
+		StartedAt: r.start.UTC(),
+		UptimeMs:  ms(time.Since(r.start)),
+		RPCs:      []RPCs{},
+		Streams:   r.streams,
+		Phases:    []Phase{},
+	}
+	for c := range r.open {
+		if c.active {
+			rep.Streams.Aborted++
+		}
+	}
+	for k, n := range r.calls {
+		k.Count = n
+		rep.RPCs = append(rep.RPCs, k)
+	}
+	slices.SortFunc(rep.RPCs, func(a, b RPCs) int {
+		return cmp.Or(strings.Compare(a.Method, b.Method), strings.Compare(a.Code, b.Code))
+	})
+	for _, p := range phases {
+		phase := Phase{Name: p.Name, DurationMs: ms(p.Duration)}
+		if p.Err != nil {
+			phase.Error = p.Err.Error()
+		}
+		rep.Phases = append(rep.Phases, phase)
+	}
+	if runErr != nil {
+		rep.Error = runErr.Error()
+	}
+	return rep
+}
+
+// Write writes the report as one line of JSON to path, to standard output
+// for Stdout. An empty path writes nothing.
+func (r *Recorder) Write(path string, phases []graceful.Phase, runErr error) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(r.Report(phases, runErr))
+	if err != nil {
+		return fmt.Errorf("shutdown report: %w", err)
+	}
+	data = append(data, '\n')
+	if path == Stdout {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("shutdown report: %w", err)
+	}
+	return nil
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
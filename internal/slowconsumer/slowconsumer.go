@@ -0,0 +1,150 @@
+// Package slowconsumer detects clients that do not read their server
+// stream. Send on a server stream blocks once the client's flow control
+// window is full, so a client that stopped reading holds the handler, and
+// whatever it produces, forever. A Sender notices when one Send takes
+// longer than a threshold and then either keeps the stream but skips the
+// messages the client cannot take, telling it how many it missed once it
+// catches up, or ends the stream with ResourceExhausted.
+package slowconsumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// Policy is what happens to the stream of a slow consumer.
+type Policy int
+
+const (
+	// Summarize skips messages while a Send is blocked and sends a summary
+	// of the skipped ones once it returns.
+	Summarize Policy = iota
+	// Terminate ends the stream with ResourceExhausted.
+	Terminate
+)
+
+func (p Policy) String() string {
+	if p == Terminate {
+		return "terminated"
+	}
+	return "summarized"
+}
+
+// Sender wraps the Sender of a server stream. Like the stream, it must be
+// used from a single goroutine.
+type Sender[T any] struct {
+	ctx       context.Context
+	s         streams.Sender[T]
+	method    string
+	threshold time.Duration
+	policy    Policy
+	summary   func(skipped int) *T
+
+	// pending is the result of a Send still blocked after the threshold
+	pending chan error
+	skipped int
+	slow    bool
+}
+
+// NewSender wraps s, the stream of method opened with ctx. A Send that
+// takes longer than threshold marks the client as slow; with Summarize,
+// summary builds the message that tells the client how many messages it
+// missed, it is unused with Terminate.
+func NewSender[T any](
+	ctx context.Context, s streams.Sender[T], method string, threshold time.Duration, policy Policy, summary func(skipped int) *T,
+) *Sender[T] {
+	return &Sender[T]{ctx: ctx, s: s, method: method, threshold: threshold, policy: policy, summary: summary}
+}
+
+// Send sends m. While an earlier Send of a summarized stream is still
+// blocked, m is skipped and Send returns nil at once.
+func (s *Sender[T]) Send(m *T) error {
+	if s.pending != nil {
+		select {
+		case err := <-s.pending:
+			s.pending = nil
+			if err != nil {
+				return err
+			}
+		default:
+			s.skipped++
+			return nil
+		}
+	}
+
+	if s.skipped > 0 {
+		n := s.skipped
+		s.skipped = 0
+		logctx.Logger(s.ctx).Printf("[SLOW CONSUMER] %s: client caught up, skipped %d messages", s.method, n)
+		if err := s.send(s.summary(n)); err != nil {
+			return err
+		}
+		// the summary itself blocked again, m is the first one skipped
+		if s.pending != nil {
+			s.skipped++
+			return nil
+		}
+	}
+	return s.send(m)
+}
+
+// Flush waits for a blocked Send and sends the summary of the messages
+// skipped since, so the client learns about them before the stream ends.
+// Handlers call it before they return nil.
+func (s *Sender[T]) Flush() error {
+	if s.pending != nil {
+		select {
+		case err := <-s.pending:
+			s.pending = nil
+			if err != nil {
+				return err
+			}
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	if s.skipped == 0 {
+		return nil
+	}
+
+	n := s.skipped
+	s.skipped = 0
+	return s.s.Send(s.summary(n))
+}
+
+// send calls Send of the stream and waits for it at most threshold. A Send
+// still blocked then either stays pending or ends the stream.
+func (s *Sender[T]) send(m *T) error {
+	done := make(chan error, 1)
+	go func() { done <- s.s.Send(m) }()
+
+	t := time.NewTimer(s.threshold)
+	defer t.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+	}
+
+	if !s.slow {
+		s.slow = true
+		metrics.SlowConsumers.WithLabelValues(s.method, s.policy.String()).Inc()
+	}
+	logctx.Logger(s.ctx).Printf("[SLOW CONSUMER] %s: Send blocked for %v, stream %s", s.method, s.threshold, s.policy)
+
+	if s.policy == Terminate {
+		// returning the error ends the stream, which unblocks the Send
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("client reads too slowly: a message waited more than %v to be sent", s.threshold))
+	}
+	s.pending = done
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package sockopt
+
+import "errors"
+
+func reusePort(uintptr) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package sockopt
+
+import "golang.org/x/sys/unix"
+
+func reusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,181 @@
+// Package sockopt applies TCP socket options to the listeners of the course
+// servers and to the connections of the tools that dial them:
+//
+//   - TCP_NODELAY. Go disables Nagle's algorithm on every TCP connection, so
+//     a small gRPC frame leaves at once. With NoDelay off the kernel holds a
+//     small write back while an earlier one is not acknowledged, which shows
+//     up as extra latency of small messages when several calls share a
+//     connection (cmd/bench latency measures it).
+//   - SO_REUSEPORT. Several processes may listen on the same port and the
+//     kernel spreads the new connections between them, e.g. to start a new
+//     server before the old one stops.
+//   - TCP keepalive. Probes find a peer that vanished without closing the
+//     connection, below the HTTP/2 keepalive pings of grpc.
+package sockopt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Options are the socket options of a listener or a dialer.
+type Options struct {
+	// NoDelay keeps Nagle's algorithm off, Go's default.
+	NoDelay bool
+	// ReusePort sets SO_REUSEPORT on the listening socket.
+	ReusePort bool
+	// KeepAlive is the idle time before the first probe and the interval
+	// between probes: 0 for Go's default of 15s, negative disables probes.
+	KeepAlive time.Duration
+	// KeepAliveCount is how many unanswered probes close the connection, 0
+	// for the OS default.
+	KeepAliveCount int
+}
+
+// Default are the options Go uses by itself.
+var Default = Options{NoDelay: true}
+
+func (o Options) String() string {
+	keepAlive := "off"
+	switch {
+	case o.KeepAlive == 0:
+		keepAlive = "default"
+	case o.KeepAlive > 0:
+		keepAlive = o.KeepAlive.String()
+	}
+	if o.KeepAlive >= 0 && o.KeepAliveCount > 0 {
+		keepAlive += fmt.Sprintf(" x%d", o.KeepAliveCount)
+	}
+	return fmt.Sprintf("TCP_NODELAY %t, SO_REUSEPORT %t, keepalive %s", o.NoDelay, o.ReusePort, keepAlive)
+}
+
+func (o Options) keepAliveConfig() net.KeepAliveConfig {
+	if o.KeepAlive < 0 {
+		return net.KeepAliveConfig{Enable: false}
+	}
+	return net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     o.KeepAlive,
+		Interval: o.KeepAlive,
+		Count:    o.KeepAliveCount,
+	}
+}
+
+// ListenConfig returns a net.ListenConfig applying o. Keepalive applies to
+// every accepted connection, SO_REUSEPORT to the listening socket. Go turns
+// TCP_NODELAY on for every accepted connection regardless, so turning it off
+// takes Listen.
+func (o Options) ListenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{KeepAliveConfig: o.keepAliveConfig()}
+	if o.KeepAlive < 0 {
+		lc.KeepAlive = -1
+	}
+	if o.ReusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) { sockErr = reusePort(fd) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc
+}
+
+// Listen announces on the local address with the options applied.
+func Listen(ctx context.Context, network, address string, o Options) (net.Listener, error) {
+	l, err := o.ListenConfig().Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !o.NoDelay {
+		l = &connListener{Listener: l, nagle: true}
+	}
+	return l, nil
+}
+
+// Wrap applies the options of o that belong to accepted connections to a
+// listening socket created elsewhere, e.g. inherited from another process
+// (package handoff): TCP_NODELAY and keepalive. SO_REUSEPORT is set on the
+// socket already or not at all.
+func (o Options) Wrap(l net.Listener) net.Listener {
+	cl := &connListener{Listener: l, nagle: !o.NoDelay}
+	if o.KeepAlive != 0 || o.KeepAliveCount != 0 {
+		cfg := o.keepAliveConfig()
+		cl.keepAlive = &cfg
+	}
+	if !cl.nagle && cl.keepAlive == nil {
+		return l
+	}
+	return cl
+}
+
+// connListener sets socket options on accepted connections: it turns
+// Nagle's algorithm back on and applies the keepalive the listening socket
+// does not. A connection the options cannot be set on is closed and
+// skipped: grpc.Serve stops on any Accept error that is not temporary.
+type connListener struct {
+	net.Listener
+	nagle     bool
+	keepAlive *net.KeepAliveConfig
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err := l.apply(conn); err != nil {
+			log.Printf("[SOCKOPT] dropping connection from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+func (l *connListener) apply(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if l.nagle {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return fmt.Errorf("disable TCP_NODELAY: %w", err)
+		}
+	}
+	if l.keepAlive != nil {
+		if err := tcp.SetKeepAliveConfig(*l.keepAlive); err != nil {
+			return fmt.Errorf("set keepalive: %w", err)
+		}
+	}
+	return nil
+}
+
+// Dialer returns a dial function for grpc.WithContextDialer applying
+// NoDelay and the keepalive of o to the connection. ReusePort does not
+// apply to outgoing connections.
+func (o Options) Dialer() func(ctx context.Context, address string) (net.Conn, error) {
+	d := &net.Dialer{KeepAliveConfig: o.keepAliveConfig()}
+	if o.KeepAlive < 0 {
+		d.KeepAlive = -1
+	}
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok && !o.NoDelay {
+			if err := tcp.SetNoDelay(false); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("disable TCP_NODELAY: %w", err)
+			}
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,249 @@
+// Package ssebridge exposes server-streaming RPCs as text/event-stream
+// endpoints, so curl or a browser EventSource can follow a stream without
+// any gRPC tooling.
+//
+// The request message is built from the query string, one parameter per
+// field (GET /sse/echo?message=hi), or read as JSON from the body of a POST.
+// Every response is sent as a "message" event with a JSON payload
+// (protojson) and a sequential id. The end of the stream is reported as an
+// "end" event, a failed RPC as an "error" event carrying the status code and
+// message.
+package ssebridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+const (
+	// maxRequestSize bounds the JSON body of a POST request.
+	maxRequestSize = 64 * 1024
+	// keepaliveInterval is how often a comment line is sent on an idle
+	// stream so proxies do not time it out.
+	keepaliveInterval = 15 * time.Second
+)
+
+// Bridge serves the event-stream routes. Its Shutdown ends the open streams,
+// which http.Server.Shutdown would otherwise wait for until its deadline.
+type Bridge struct {
+	mux *http.ServeMux
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a bridge without routes.
+func New() *Bridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bridge{
+		mux:    http.NewServeMux(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mux.ServeHTTP(w, r)
+}
+
+// Shutdown cancels the RPCs of all open streams and waits for the handlers
+// to return.
+func (b *Bridge) Shutdown(ctx context.Context) error {
+	b.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handle registers a server-streaming RPC under pattern. open starts the RPC
+// for the decoded request.
+func Handle[Req, Resp any, PReq interface {
+	*Req
+	proto.Message
+}, PResp interface {
+	*Resp
+	proto.Message
+}](b *Bridge, pattern string, open func(ctx context.Context, req PReq) (streams.Receiver[Resp], error)) {
+	b.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		b.wg.Add(1)
+		defer b.wg.Done()
+
+		req := PReq(new(Req))
+		if err := decodeRequest(r, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(b.ctx, cancel)
+		defer stop()
+
+		rs, err := open(ctx, req)
+		if err != nil {
+			writeStatus(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// Recv blocks, so responses are read in their own goroutine and the
+		// handler can send keepalives in between
+		type result struct {
+			resp PResp
+			err  error
+		}
+		results := make(chan result)
+		go func() {
+			for {
+				resp, err := rs.Recv()
+				select {
+				case results <- result{resp: PResp(resp), err: err}:
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+
+		for id := 1; ; {
+			select {
+			case <-ticker.C:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+
+			case res := <-results:
+				switch {
+				case errors.Is(res.err, io.EOF):
+					writeEvent(w, "end", 0, []byte("{}"))
+					flusher.Flush()
+					return
+				case b.ctx.Err() != nil:
+					writeEvent(w, "error", 0, errorPayload("UNAVAILABLE", "server shutting down"))
+					flusher.Flush()
+					return
+				case r.Context().Err() != nil:
+					log.Printf("[SSE] %s: client went away", pattern)
+					return
+				case res.err != nil:
+					st := status.Convert(res.err)
+					log.Printf("[SSE] %s: %v", pattern, res.err)
+					writeEvent(w, "error", 0, errorPayload(st.Code().String(), st.Message()))
+					flusher.Flush()
+					return
+				}
+
+				data, err := protojson.Marshal(res.resp)
+				if err != nil {
+					writeEvent(w, "error", 0, errorPayload("INTERNAL", err.Error()))
+					flusher.Flush()
+					return
+				}
+				if err := writeEvent(w, "message", id, data); err != nil {
+					return
+				}
+				flusher.Flush()
+				id++
+			}
+		}
+	})
+}
+
+// decodeRequest fills req from the query string of a GET or the JSON body of
+// a POST.
+func decodeRequest(r *http.Request, req proto.Message) error {
+	switch r.Method {
+	case http.MethodGet:
+		fields := make(map[string]string)
+		for k, vs := range r.URL.Query() {
+			fields[k] = vs[0]
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		if err := protojson.Unmarshal(data, req); err != nil {
+			return fmt.Errorf("malformed query: %w", err)
+		}
+		return nil
+
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			return err
+		}
+		if err := protojson.Unmarshal(data, req); err != nil {
+			return fmt.Errorf("malformed body: %w", err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+// writeEvent writes one event. id 0 leaves the id field out.
+func writeEvent(w io.Writer, event string, id int, data []byte) error {
+	var err error
+	if id > 0 {
+		_, err = fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", event, id, data)
+	} else {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	}
+	return err
+}
+
+func errorPayload(code, message string) []byte {
+	data, _ := json.Marshal(map[string]string{"code": code, "message": message})
+	return data
+}
+
+// writeStatus answers a request whose RPC could not be started.
+func writeStatus(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = w.Write(errorPayload(st.Code().String(), st.Message()))
+}
@@ -0,0 +1,102 @@
+// Package storeerr turns the errors of a storage into the statuses the
+// handlers return. A store wraps ErrNotFound, ErrExists and ErrConflict
+// into its own errors, and every handler passes what the store returned
+// through Status instead of choosing a code itself, so the same failure
+// gets the same code in every method.
+//
+// Failures of the storage itself become Internal with an error id: the
+// client sees the id, the server log has it next to the real error, and
+// the internals of the store stay on the server.
+package storeerr
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/msgsize"
+)
+
+// Reason is the ErrorInfo reason of failures of the storage.
+const Reason = "STORAGE_ERROR"
+
+var (
+	// ErrNotFound means the record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrExists means a record with the same key was already stored.
+	ErrExists = errors.New("already exists")
+	// ErrConflict means the record changed concurrently and the write was
+	// not applied; retrying from a fresh read may succeed.
+	ErrConflict = errors.New("changed concurrently")
+)
+
+// Code returns the status code of err:
+//
+//	ErrNotFound                NotFound
+//	ErrExists                  AlreadyExists
+//	ErrConflict                Aborted
+//	context.DeadlineExceeded   DeadlineExceeded (also os.ErrDeadlineExceeded)
+//	context.Canceled           Canceled
+//	a status error             its own code
+//	anything else              Internal
+func Code(err error) codes.Code {
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.Is(err, ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrExists):
+		return codes.AlreadyExists
+	case errors.Is(err, ErrConflict):
+		return codes.Aborted
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code()
+	}
+	return codes.Internal
+}
+
+// Status converts an error of the storage into the error a handler
+// returns, nil for nil. The known errors keep their text; an Internal one
+// is logged under a new error id and replaced by a message with the id and
+// an ErrorInfo carrying it.
+func Status(ctx context.Context, err error) error {
+	code := Code(err)
+	switch code {
+	case codes.OK:
+		return nil
+	case codes.Internal:
+		return internal(ctx, err)
+	}
+	if _, ok := status.FromError(err); ok {
+		// already a status, with its details
+		return err
+	}
+	return status.Error(code, err.Error())
+}
+
+func internal(ctx context.Context, err error) error {
+	id := uuid.NewString()
+	logctx.Logger(ctx).Printf("[STORE] error %s: %v", id, err)
+
+	msg := "storage failed, error id " + id
+	st, detailsErr := status.New(codes.Internal, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   Reason,
+		Domain:   msgsize.Domain,
+		Metadata: map[string]string{"error_id": id},
+	})
+	if detailsErr != nil {
+		return status.Error(codes.Internal, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,90 @@
+package storeerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+		// msg is the message of the status, "" to skip the check
+		msg string
+	}{
+		{name: "nil", err: nil, code: codes.OK},
+		{name: "not found", err: fmt.Errorf("order 42: %w", ErrNotFound), code: codes.NotFound, msg: "order 42: not found"},
+		{name: "exists", err: fmt.Errorf("order 42: %w", ErrExists), code: codes.AlreadyExists, msg: "order 42: already exists"},
+		{name: "conflict", err: fmt.Errorf("order 42: %w", ErrConflict), code: codes.Aborted, msg: "order 42: changed concurrently"},
+		{name: "context deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), code: codes.DeadlineExceeded},
+		{name: "file deadline", err: fmt.Errorf("read: %w", os.ErrDeadlineExceeded), code: codes.DeadlineExceeded},
+		{name: "canceled", err: context.Canceled, code: codes.Canceled},
+		{name: "status", err: status.Error(codes.ResourceExhausted, "store is full"), code: codes.ResourceExhausted, msg: "store is full"},
+		{name: "storage failure", err: errors.New("disk on fire"), code: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Code(tt.err); got != tt.code {
+				t.Errorf("Code = %v, want %v", got, tt.code)
+			}
+			err := Status(context.Background(), tt.err)
+			if tt.code == codes.OK {
+				if err != nil {
+					t.Errorf("Status = %v, want nil", err)
+				}
+				return
+			}
+			st := status.Convert(err)
+			if st.Code() != tt.code {
+				t.Errorf("Status code = %v, want %v", st.Code(), tt.code)
+			}
+			if tt.msg != "" && st.Message() != tt.msg {
+				t.Errorf("Status message = %q, want %q", st.Message(), tt.msg)
+			}
+		})
+	}
+}
+
+func TestStatusHidesStorageFailure(t *testing.T) {
+	out := log.Writer()
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(out)
+
+	st := status.Convert(Status(context.Background(), errors.New("disk on fire")))
+	if strings.Contains(st.Message(), "disk") {
+		t.Errorf("message %q tells the client about the store", st.Message())
+	}
+
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	if info == nil || info.GetReason() != Reason {
+		t.Fatalf("details = %v, want an ErrorInfo with reason %s", st.Details(), Reason)
+	}
+	id := info.GetMetadata()["error_id"]
+	if id == "" || !strings.Contains(st.Message(), id) {
+		t.Errorf("message %q does not carry the error id %q", st.Message(), id)
+	}
+	if !strings.Contains(logged.String(), id) || !strings.Contains(logged.String(), "disk on fire") {
+		t.Errorf("log %q does not have the error id next to the error", logged.String())
+	}
+}
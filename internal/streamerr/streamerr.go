@@ -0,0 +1,139 @@
+// Package streamerr classifies the errors that end a streaming handler. A
+// stream ends because the client half-closed it, canceled the call, ran
+// out of its deadline, lost the connection, or because something really
+// failed; each of these is returned to grpc with its own status and logged
+// at its own level, so only real failures show up as errors.
+package streamerr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Kind is the reason a stream ended.
+type Kind int
+
+const (
+	// EOF is a normal end: the client half-closed the stream.
+	EOF Kind = iota
+	// Canceled means the client canceled the call or went away.
+	Canceled
+	// DeadlineExceeded means the deadline of the call expired.
+	DeadlineExceeded
+	// Transport is a broken connection: a reset, a closed pipe or an
+	// RST_STREAM from the peer.
+	Transport
+	// Failure is every other error, a real failure of the handler.
+	Failure
+)
+
+func (k Kind) String() string {
+	switch k {
+	case EOF:
+		return "eof"
+	case Canceled:
+		return "canceled"
+	case DeadlineExceeded:
+		return "deadline exceeded"
+	case Transport:
+		return "transport"
+	}
+	return "failure"
+}
+
+// level is the log level of the kind.
+func (k Kind) level() string {
+	switch k {
+	case EOF, Canceled, DeadlineExceeded:
+		return "info"
+	case Transport:
+		return "warn"
+	}
+	return "error"
+}
+
+// Classify tells why err ended a stream. A nil error counts as EOF.
+func Classify(err error) Kind {
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return EOF
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return Transport
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return Failure
+	}
+	switch st.Code() {
+	case codes.Canceled:
+		return Canceled
+	case codes.DeadlineExceeded:
+		return DeadlineExceeded
+	case codes.Unavailable:
+		// grpc reports a lost connection as Unavailable
+		return Transport
+	case codes.Internal:
+		if strings.Contains(st.Message(), "RST_STREAM") {
+			return Transport
+		}
+	}
+	return Failure
+}
+
+// Status converts err into the error a handler returns: nil for EOF, the
+// matching code for cancellation, deadline and transport errors. Failures
+// keep their status, plain errors become Internal.
+func Status(err error) error {
+	kind := Classify(err)
+	if kind == EOF {
+		return nil
+	}
+
+	code := codes.Internal
+	switch kind {
+	case Canceled:
+		code = codes.Canceled
+	case DeadlineExceeded:
+		code = codes.DeadlineExceeded
+	case Transport:
+		code = codes.Unavailable
+	}
+
+	if st, ok := status.FromError(err); ok {
+		// keep the status with its details unless the code has to change
+		if kind == Failure || st.Code() == code {
+			return err
+		}
+		return status.Error(code, st.Message())
+	}
+	return status.Error(code, err.Error())
+}
+
+// Finish logs the end of the stream of method at the level of err's kind and
+// returns Status(err). Handlers call it with the error that ended the
+// stream:
+//
+//	return streamerr.Finish(ctx, "EchoClientStream", err)
+func Finish(ctx context.Context, method string, err error) error {
+	kind := Classify(err)
+	if kind != EOF {
+		logger := logctx.Logger(logctx.With(ctx, "level", kind.level()))
+		logger.Printf("%s: Stream ended (%s): %v", method, kind, err)
+	}
+	return Status(err)
+}
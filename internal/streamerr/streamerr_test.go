@@ -0,0 +1,79 @@
+package streamerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind Kind
+		// code is the code of Status(err), OK for nil
+		code codes.Code
+	}{
+		{name: "nil", err: nil, kind: EOF, code: codes.OK},
+		{name: "half-close", err: io.EOF, kind: EOF, code: codes.OK},
+		{name: "wrapped half-close", err: fmt.Errorf("recv: %w", io.EOF), kind: EOF, code: codes.OK},
+		{name: "context canceled", err: context.Canceled, kind: Canceled, code: codes.Canceled},
+		{name: "context deadline", err: fmt.Errorf("send: %w", context.DeadlineExceeded), kind: DeadlineExceeded, code: codes.DeadlineExceeded},
+		{name: "status canceled", err: status.Error(codes.Canceled, "client canceled"), kind: Canceled, code: codes.Canceled},
+		{name: "status deadline", err: status.Error(codes.DeadlineExceeded, "too slow"), kind: DeadlineExceeded, code: codes.DeadlineExceeded},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), kind: Transport, code: codes.Unavailable},
+		{name: "broken pipe", err: syscall.EPIPE, kind: Transport, code: codes.Unavailable},
+		{name: "closed connection", err: net.ErrClosed, kind: Transport, code: codes.Unavailable},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, kind: Transport, code: codes.Unavailable},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "transport is closing"), kind: Transport, code: codes.Unavailable},
+		{name: "RST_STREAM", err: status.Error(codes.Internal, "stream terminated by RST_STREAM with error code: CANCEL"), kind: Transport, code: codes.Unavailable},
+		{name: "internal status", err: status.Error(codes.Internal, "boom"), kind: Failure, code: codes.Internal},
+		{name: "failure status", err: status.Error(codes.InvalidArgument, "bad message"), kind: Failure, code: codes.InvalidArgument},
+		{name: "plain error", err: errors.New("boom"), kind: Failure, code: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.kind {
+				t.Errorf("Classify = %v, want %v", got, tt.kind)
+			}
+			err := Status(tt.err)
+			if tt.code == codes.OK {
+				if err != nil {
+					t.Errorf("Status = %v, want nil", err)
+				}
+				return
+			}
+			if got := status.Code(err); got != tt.code {
+				t.Errorf("Status code = %v, want %v", got, tt.code)
+			}
+		})
+	}
+}
+
+func TestStatusKeepsDetails(t *testing.T) {
+	st, err := status.New(codes.FailedPrecondition, "no session").WithDetails(&errdetails.ErrorInfo{Reason: "SESSION_EXPIRED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := status.Convert(Status(st.Err()))
+	if got.Code() != codes.FailedPrecondition || len(got.Details()) != 1 {
+		t.Errorf("Status = %v with %d details, want FailedPrecondition with the ErrorInfo", got.Code(), len(got.Details()))
+	}
+}
+
+func TestStatusKeepsMessage(t *testing.T) {
+	// a transport error reported as Internal changes its code, not its text
+	msg := "stream terminated by RST_STREAM with error code: CANCEL"
+	got := status.Convert(Status(status.Error(codes.Internal, msg)))
+	if got.Message() != msg {
+		t.Errorf("message = %q, want %q", got.Message(), msg)
+	}
+}
@@ -0,0 +1,103 @@
+// Package streamstop lets a stream interceptor end a server or bidi stream
+// whose handler is still running: package farewell when the server drains,
+// package lifetime when a stream outlives its maximum duration.
+//
+// The status of a stream is sent only once the interceptor returns, and a
+// handler blocked in Recv does not watch its context, so Run runs the
+// handler in a goroutine of its own. Once the stream is stopped, the context
+// of the handler is canceled and its further SendMsg and RecvMsg fail
+// without touching the stream. Run then waits, at most Grace, for the
+// handler to return: grpc-go forbids using a stream after its RPC has ended.
+// Only a call already blocked in the stream when it was stopped, e.g. a
+// Recv on a silent client, can outlast the grace; it fails once the RPC
+// ends.
+package streamstop
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/panics"
+)
+
+// Grace is how long Run waits for the handler of a stopped stream.
+const Grace = time.Second
+
+// Run calls handler for ss until it returns or stop is closed. On stop it
+// cancels the context of the handler with cause, waits for the handler and
+// returns stopped; the interceptor then ends the stream with a status of
+// its own. A panic of the handler is raised again by Run as a
+// *panics.Panic carrying the stack of the handler.
+func Run(srv any, ss grpc.ServerStream, handler grpc.StreamHandler, stop <-chan struct{}, cause error) (stopped bool, err error) {
+	ctx, cancel := context.WithCancelCause(ss.Context())
+	defer cancel(nil)
+	s := &serverStream{ServerStream: ss, ctx: ctx}
+
+	done := make(chan error, 1)
+	panicked := make(chan *panics.Panic, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- panics.Recovered(p)
+			}
+		}()
+		done <- handler(srv, s)
+	}()
+
+	select {
+	case err := <-done:
+		return false, err
+	case p := <-panicked:
+		panic(p)
+	case <-stop:
+	}
+
+	s.stopped.Store(true)
+	cancel(cause)
+	timer := time.NewTimer(Grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case p := <-panicked:
+		panic(p)
+	case <-timer.C:
+		logctx.Logger(ctx).Printf("[STREAM STOP] handler still running %v after the stream was stopped: %v", Grace, cause)
+	}
+	return true, nil
+}
+
+// serverStream is the stream of the handler: its own context, and no calls
+// through to the stream once stopped.
+type serverStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	stopped atomic.Bool
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	if s.stopped.Load() {
+		return s.err()
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	if s.stopped.Load() {
+		return s.err()
+	}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func (s *serverStream) err() error {
+	return status.Error(codes.Canceled, context.Cause(s.ctx).Error())
+}
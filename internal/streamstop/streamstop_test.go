@@ -0,0 +1,132 @@
+package streamstop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/easyp-tech/course-grpc/internal/panics"
+)
+
+var errStop = errors.New("stopped by the test")
+
+// fakeStream counts the messages that reach it.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent atomic.Int32
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) SendMsg(any) error {
+	s.sent.Add(1)
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(any) error { return nil }
+
+func newStream(t *testing.T) *fakeStream {
+	t.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &fakeStream{ctx: context.Background()}
+}
+
+func TestRunReturnsHandlerResult(t *testing.T) {
+	ss := newStream(t)
+	want := errors.New("handler failed")
+
+	stopped, err := Run(nil, ss, func(any, grpc.ServerStream) error { return want }, make(chan struct{}), errStop)
+	if stopped || err != want {
+		t.Errorf("Run = %t, %v; want false, %v", stopped, err, want)
+	}
+}
+
+func TestStopWaitsForHandler(t *testing.T) {
+	ss := newStream(t)
+	stop := make(chan struct{})
+	var returned atomic.Bool
+	handler := func(_ any, s grpc.ServerStream) error {
+		close(stop)
+		<-s.Context().Done()
+		if !errors.Is(context.Cause(s.Context()), errStop) {
+			t.Errorf("cause = %v, want errStop", context.Cause(s.Context()))
+		}
+		// a handler finishing its work after the stop does not reach the
+		// stream
+		if err := s.SendMsg("late"); err == nil {
+			t.Error("SendMsg after the stop succeeded")
+		}
+		if err := s.RecvMsg(nil); err == nil {
+			t.Error("RecvMsg after the stop succeeded")
+		}
+		time.Sleep(50 * time.Millisecond)
+		returned.Store(true)
+		return nil
+	}
+
+	stopped, err := Run(nil, ss, handler, stop, errStop)
+	if !stopped || err != nil {
+		t.Fatalf("Run = %t, %v; want true, nil", stopped, err)
+	}
+	if !returned.Load() {
+		t.Error("Run returned before the handler")
+	}
+	if n := ss.sent.Load(); n != 0 {
+		t.Errorf("%d messages reached the stream after the stop", n)
+	}
+}
+
+func TestStopGivesUpAfterGrace(t *testing.T) {
+	ss := newStream(t)
+	stop := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := func(any, grpc.ServerStream) error {
+		close(stop)
+		// ignores its context, like a handler blocked in Recv
+		<-release
+		return nil
+	}
+
+	start := time.Now()
+	if stopped, _ := Run(nil, ss, handler, stop, errStop); !stopped {
+		t.Fatal("Run did not report the stop")
+	}
+	if d := time.Since(start); d < Grace || d > 2*Grace {
+		t.Errorf("Run returned after %v, want about %v", d, Grace)
+	}
+}
+
+func panicking(any, grpc.ServerStream) error {
+	panic("boom")
+}
+
+func TestPanicKeepsHandlerStack(t *testing.T) {
+	ss := newStream(t)
+	defer func() {
+		p, ok := recover().(*panics.Panic)
+		if !ok {
+			t.Fatalf("recovered %T, want *panics.Panic", p)
+		}
+		if p.Value != "boom" {
+			t.Errorf("panic value = %v, want boom", p.Value)
+		}
+		if !bytes.Contains(p.Stack, []byte("streamstop.panicking")) {
+			t.Errorf("stack does not show the handler:\n%s", p.Stack)
+		}
+	}()
+
+	Run(nil, ss, panicking, make(chan struct{}), errStop)
+	t.Fatal("Run returned after a panic of the handler")
+}
@@ -0,0 +1,189 @@
+// Package tenant keeps the tenants of the server apart. The tenant of a call
+// is the one of the credentials the auth interceptor verified (see
+// auth.Identity): a JWT claim, the tenant of an API key or a URI SAN of the
+// client certificate. The rate limiters and the quotas count the calls of a
+// tenant together (see Key), so a noisy tenant uses up its own budget and
+// not the one of the server. The tenant of the call context (package
+// callctx) is set by the client and nothing checks it, so it only goes to
+// the logs: a client would get a new budget with every made-up tenant.
+//
+// A Registry counts the calls of every tenant, for the AdminAPI and for the
+// metrics course_grpc_tenant_calls_total and
+// course_grpc_tenant_rejected_total. Only the first MaxTracked tenants are
+// counted under their own name, the rest under Other, so the registry and
+// the metrics stay bounded whatever tenants the issuers of credentials hand
+// out.
+package tenant
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/auth"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+const (
+	// MaxTracked is how many tenants a Registry counts by name.
+	MaxTracked = 100
+	// Other is the name the tenants over MaxTracked are counted under.
+	Other = "other"
+	// None is the label of the calls without a tenant.
+	None = "none"
+)
+
+// Of returns the tenant of the identity of the call, "" for a call without
+// one.
+func Of(ctx context.Context) string {
+	id, _ := auth.FromContext(ctx)
+	return id.Tenant
+}
+
+// Key returns the key the limits of the caller are counted under:
+// "tenant:<tenant>", or auth.Caller for a call without a tenant.
+func Key(ctx context.Context) string {
+	if t := Of(ctx); t != "" {
+		return "tenant:" + t
+	}
+	return auth.Caller(ctx)
+}
+
+// Stats are the counters of one tenant.
+type Stats struct {
+	// Tenant is "" for the calls without a tenant.
+	Tenant string
+	Calls  uint64
+	// Failed counts the calls that ended with an error, Throttled and
+	// QuotaExceeded included.
+	Failed uint64
+	// Throttled counts ResourceExhausted errors other than quota ones: the
+	// rate limiter, admission and the throttling of streams.
+	Throttled     uint64
+	QuotaExceeded uint64
+	InFlight      int
+	LastCall      time.Time
+}
+
+// Registry counts the calls of every tenant. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{stats: make(map[string]*Stats)}
+}
+
+// Stats returns the counters of the tenants with a prefix, sorted by tenant;
+// an empty prefix returns all of them.
+func (r *Registry) Stats(prefix string) []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []Stats
+	for t, s := range r.stats {
+		if strings.HasPrefix(t, prefix) {
+			out = append(out, *s)
+		}
+	}
+	slices.SortFunc(out, func(a, b Stats) int { return strings.Compare(a.Tenant, b.Tenant) })
+	return out
+}
+
+// start counts a call of the tenant of ctx and returns the name it is
+// counted under.
+func (r *Registry) start(ctx context.Context) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := Of(ctx)
+	s, ok := r.stats[t]
+	if !ok {
+		if len(r.stats) >= MaxTracked {
+			t = Other
+			s, ok = r.stats[t]
+		}
+		if !ok {
+			s = &Stats{Tenant: t}
+			r.stats[t] = s
+		}
+	}
+	s.Calls++
+	s.InFlight++
+	s.LastCall = time.Now()
+	return t
+}
+
+func (r *Registry) end(t string, err error) {
+	code := status.Code(err)
+	reason := ""
+	if code == codes.ResourceExhausted {
+		reason = "throttled"
+		if hasQuotaFailure(err) {
+			reason = "quota"
+		}
+	}
+
+	r.mu.Lock()
+	s := r.stats[t]
+	s.InFlight--
+	if err != nil {
+		s.Failed++
+	}
+	switch reason {
+	case "throttled":
+		s.Throttled++
+	case "quota":
+		s.QuotaExceeded++
+	}
+	r.mu.Unlock()
+
+	label := t
+	if label == "" {
+		label = None
+	}
+	metrics.TenantCalls.WithLabelValues(label, code.String()).Inc()
+	if reason != "" {
+		metrics.TenantRejected.WithLabelValues(label, reason).Inc()
+	}
+}
+
+func hasQuotaFailure(err error) bool {
+	for _, d := range status.Convert(err).Details() {
+		if _, ok := d.(*errdetails.QuotaFailure); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor counts the calls of every tenant. It goes after
+// auth, which finds the tenant, and outside the limits, to count their
+// rejections.
+func (r *Registry) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		t := r.start(ctx)
+		resp, err := handler(ctx, req)
+		r.end(t, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor counts streams as calls.
+func (r *Registry) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t := r.start(ss.Context())
+		err := handler(srv, ss)
+		r.end(t, err)
+		return err
+	}
+}
@@ -0,0 +1,65 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/auth"
+	"github.com/easyp-tech/course-grpc/internal/callctx"
+	callctxpb "github.com/easyp-tech/course-grpc/pkg/api/callctx/v1"
+)
+
+var guard = auth.NewGuard(auth.NewAPIKeys(map[string]auth.Identity{
+	"alice-key": {Subject: "alice", Tenant: "acme"},
+	"bob-key":   {Subject: "bob", Tenant: "acme"},
+	"carol-key": {Subject: "carol"},
+}), nil)
+
+// key returns Key as a handler behind the Guard sees it for a call with
+// token, "" for none, claiming claimed as the tenant of its call context.
+func key(t *testing.T, token, claimed string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(auth.Key, auth.Credentials(token)))
+	}
+	if claimed != "" {
+		ctx = callctx.NewContext(ctx, &callctxpb.CallContext{Tenant: claimed})
+	}
+	var got string
+	_, err := guard.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"},
+		func(ctx context.Context, _ any) (any, error) {
+			got = Key(ctx)
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		claimed string
+		want    string
+	}{
+		{name: "tenant of the credentials", token: "alice-key", want: "tenant:acme"},
+		{name: "callers of a tenant share the key", token: "bob-key", want: "tenant:acme"},
+		{name: "claimed tenant does not count", token: "bob-key", claimed: "other", want: "tenant:acme"},
+		{name: "credentials without a tenant", token: "carol-key", claimed: "acme", want: "subject:carol"},
+		{name: "no credentials", claimed: "acme", want: "ip:unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(t, tt.token, tt.claimed); got != tt.want {
+				t.Errorf("Key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Package timeouts shows where the deadlines of the callers go. For every
+// call with a deadline the server records how much of it was left when the
+// handler finished, and every DeadlineExceeded it returns is logged with its
+// origin:
+//
+//	client      the deadline of the caller ran out
+//	downstream  a call the handler made ran out of its deadline first
+//	server      the server gave up on its own: a timeout of the handler, a
+//	            lifetime of a stream, a budget too small to start a hop
+//
+// A DeadlineExceeded looks the same to the client in all three cases, while
+// the fix differs: a longer deadline, a faster dependency or a different
+// server timeout.
+package timeouts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+// Origins of a DeadlineExceeded.
+const (
+	OriginClient     = "client"
+	OriginDownstream = "downstream"
+	OriginServer     = "server"
+)
+
+// clientSlack is how early a canceled call may end before its deadline and
+// still count as one that ran out of it. A client whose deadline runs out
+// resets the stream, and the reset reaches the server before the copy of
+// the deadline the server keeps runs out: that one starts later, by the
+// latency of the network. The handler then sees Canceled, not
+// DeadlineExceeded.
+const clientSlack = 50 * time.Millisecond
+
+type trackerKey struct{}
+
+// tracker collects what the downstream calls of one incoming call report.
+type tracker struct {
+	mu sync.Mutex
+	// downstream is the first outgoing call that exceeded its deadline, at
+	// the time it failed
+	downstream string
+	at         time.Time
+}
+
+func (t *tracker) exceeded(method string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.downstream == "" {
+		t.downstream, t.at = method, time.Now()
+	}
+}
+
+func (t *tracker) first() (string, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.downstream, t.at
+}
+
+// call is an incoming call being watched.
+type call struct {
+	method   string
+	start    time.Time
+	deadline time.Time
+	bounded  bool
+	tracker  *tracker
+}
+
+func begin(ctx context.Context, method string) (context.Context, *call) {
+	c := &call{method: method, start: time.Now(), tracker: &tracker{}}
+	c.deadline, c.bounded = ctx.Deadline()
+	return context.WithValue(ctx, trackerKey{}, c.tracker), c
+}
+
+// end records the deadline left and, for a DeadlineExceeded, its origin.
+// ctx is the context of the incoming call.
+func (c *call) end(ctx context.Context, err error) {
+	if c.bounded {
+		metrics.DeadlineRemaining.WithLabelValues(c.method).Observe(max(time.Until(c.deadline), 0).Seconds())
+	}
+	code := status.Code(err)
+	clientExpired := c.bounded && ctx.Err() != nil && time.Until(c.deadline) < clientSlack
+	if code != codes.DeadlineExceeded && (code != codes.Canceled || !clientExpired) {
+		return
+	}
+
+	logger := logctx.Logger(ctx)
+	ran := time.Since(c.start).Round(time.Millisecond)
+	origin := OriginServer
+	switch downstream, at := c.tracker.first(); {
+	case clientExpired:
+		origin = OriginClient
+		logger.Printf("[DEADLINE] %s: exceeded the client deadline of %v, the handler ran %v",
+			c.method, c.deadline.Sub(c.start).Round(time.Millisecond), ran)
+	case downstream != "":
+		origin = OriginDownstream
+		logger.Printf("[DEADLINE] %s: downstream call %s exceeded its deadline with %s, the handler ran %v",
+			c.method, downstream, c.describeLeft(c.deadline.Sub(at)), ran)
+	default:
+		logger.Printf("[DEADLINE] %s: the server timed out with %s, the handler ran %v",
+			c.method, c.describeLeft(time.Until(c.deadline)), ran)
+	}
+	metrics.DeadlineExceeded.WithLabelValues(c.method, origin).Inc()
+}
+
+func (c *call) describeLeft(left time.Duration) string {
+	if !c.bounded {
+		return "no client deadline"
+	}
+	return max(left, 0).Round(time.Millisecond).String() + " of the client deadline left"
+}
+
+// UnaryServerInterceptor records the deadline left by unary calls and the
+// origin of their DeadlineExceeded. It goes outside the interceptors that
+// enforce timeouts of their own, to see their errors.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		callCtx, c := begin(ctx, info.FullMethod)
+		resp, err := handler(callCtx, req)
+		c.end(ctx, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		callCtx, c := begin(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: callCtx})
+		c.end(ss.Context(), err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor goes on the connections a server calls other
+// servers over. A DeadlineExceeded of such a call is reported to the
+// incoming call it was made for, which then names it as the origin.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) == codes.DeadlineExceeded {
+			if t, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+				t.exceeded(method)
+			}
+		}
+		return err
+	}
+}
+
+// serverStream replaces the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
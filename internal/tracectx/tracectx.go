@@ -0,0 +1,212 @@
+// Package tracectx propagates W3C trace context (the traceparent and
+// tracestate headers) through gRPC metadata without a full tracing SDK.
+//
+// The server interceptors continue the trace of the caller, or start a new
+// one, as a fresh span. The client interceptors send a child span of the
+// trace found in the context, so a server calling downstream services passes
+// its trace along. The trace id is added to the log fields of the call.
+package tracectx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+// Metadata keys defined by https://www.w3.org/TR/trace-context/.
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+// LogKey is the log field holding the trace id.
+const LogKey = "trace_id"
+
+const sampled = 0x01
+
+// Trace is the trace context of the current span.
+type Trace struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	// State is the vendor specific tracestate, passed along unchanged.
+	State string
+}
+
+// TraceIDString returns the trace id in hex.
+func (t Trace) TraceIDString() string {
+	return hex.EncodeToString(t.TraceID[:])
+}
+
+// Traceparent formats t as a version 00 traceparent header.
+func (t Trace) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", t.TraceID, t.SpanID, t.Flags)
+}
+
+// Parse reads a traceparent header. Headers of future versions are accepted
+// as long as their first four fields are valid.
+func Parse(traceparent string) (Trace, bool) {
+	var t Trace
+
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return t, false
+	}
+	version := parts[0]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return t, false
+	}
+	if _, err := hex.Decode(make([]byte, 1), []byte(version)); err != nil {
+		return t, false
+	}
+	if !decodeLower(t.TraceID[:], parts[1]) || !decodeLower(t.SpanID[:], parts[2]) {
+		return t, false
+	}
+	var flags [1]byte
+	if !decodeLower(flags[:], parts[3]) {
+		return t, false
+	}
+	t.Flags = flags[0]
+
+	if t.TraceID == ([16]byte{}) || t.SpanID == ([8]byte{}) {
+		return t, false
+	}
+	return t, true
+}
+
+// decodeLower decodes exactly len(dst) bytes of lowercase hex.
+func decodeLower(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || s != strings.ToLower(s) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// New starts a new sampled trace.
+func New() Trace {
+	t := Trace{Flags: sampled}
+	_, _ = rand.Read(t.TraceID[:])
+	_, _ = rand.Read(t.SpanID[:])
+	return t
+}
+
+// Child returns a new span of the same trace.
+func (t Trace) Child() Trace {
+	_, _ = rand.Read(t.SpanID[:])
+	return t
+}
+
+type ctxKey struct{}
+
+// NewContext stores t in ctx and adds its trace id to the log fields.
+func NewContext(ctx context.Context, t Trace) context.Context {
+	ctx = context.WithValue(ctx, ctxKey{}, t)
+	return logctx.With(ctx, LogKey, t.TraceIDString())
+}
+
+// FromContext returns the trace stored in ctx.
+func FromContext(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(ctxKey{}).(Trace)
+	return t, ok
+}
+
+// Start returns a context with a child span of the trace in ctx, or with a
+// new trace if there is none.
+func Start(ctx context.Context) context.Context {
+	if t, ok := FromContext(ctx); ok {
+		return NewContext(ctx, t.Child())
+	}
+	return NewContext(ctx, New())
+}
+
+// incoming continues the trace sent by the caller.
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var t Trace
+	if vs := md.Get(TraceparentKey); len(vs) == 1 {
+		if parent, ok := Parse(vs[0]); ok {
+			t = parent.Child()
+			t.State = strings.Join(md.Get(TracestateKey), ",")
+		}
+	}
+	if t.TraceID == ([16]byte{}) {
+		t = New()
+	}
+
+	return NewContext(ctx, t)
+}
+
+// outgoing starts a span for an outgoing call and puts it into the metadata.
+func outgoing(ctx context.Context) context.Context {
+	ctx = Start(ctx)
+	t, _ := FromContext(ctx)
+
+	kv := []string{TraceparentKey, t.Traceparent()}
+	if t.State != "" {
+		kv = append(kv, TracestateKey, t.State)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// UnaryServerInterceptor continues the trace of every unary call.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor continues the trace of every stream.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: incoming(ss.Context())})
+	}
+}
+
+// UnaryClientInterceptor sends traceparent and tracestate with every call.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends traceparent and tracestate with every stream.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// serverStream replaces the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
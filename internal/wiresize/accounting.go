@@ -0,0 +1,161 @@
+package wiresize
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"google.golang.org/grpc/stats"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+	"github.com/easyp-tech/course-grpc/internal/metrics"
+)
+
+// DefaultSoftLimit is three quarters of the receive limit grpc applies by
+// default: a message over it is a call close to failing with
+// ResourceExhausted.
+const DefaultSoftLimit = 3 * 1024 * 1024
+
+// Directions of messages.
+const (
+	Request  = "request"
+	Response = "response"
+)
+
+var _ stats.Handler = &Accounting{}
+
+// Accounting records the size of every message on the wire, compressed and
+// with the gRPC framing, per method and direction, in the histogram
+// course_grpc_wire_message_size_bytes and in a summary printed by Log. A
+// message whose encoded size is over the soft limit is logged and counted
+// in course_grpc_wire_messages_over_soft_limit_total: the size limits of
+// grpc apply to the message before compression, so that is the size
+// compared, even when the wire carries much less. Attached to a client the
+// requests are the messages sent, attached to a server the ones received.
+// It is safe for concurrent use.
+type Accounting struct {
+	softLimit int
+
+	mu      sync.Mutex
+	methods map[string]*sizes
+}
+
+// sizes are the messages of one method.
+type sizes struct {
+	requests, responses direction
+}
+
+type direction struct {
+	count int
+	total int64
+	max   int
+	// largest is the largest encoded size before compression
+	largest int
+	over    int
+}
+
+// NewAccounting creates an Accounting that flags the messages larger than
+// softLimit bytes; 0 flags none.
+func NewAccounting(softLimit int) *Accounting {
+	return &Accounting{softLimit: softLimit, methods: make(map[string]*sizes)}
+}
+
+func (a *Accounting) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (a *Accounting) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(methodKey{}).(string)
+
+	switch p := s.(type) {
+	case *stats.OutPayload:
+		dir := Response
+		if p.Client {
+			dir = Request
+		}
+		a.record(ctx, method, side(p.Client), dir, p.Length, p.WireLength)
+	case *stats.InPayload:
+		dir := Request
+		if p.Client {
+			dir = Response
+		}
+		a.record(ctx, method, side(p.Client), dir, p.Length, p.WireLength)
+	}
+}
+
+func (a *Accounting) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (a *Accounting) HandleConn(context.Context, stats.ConnStats) {}
+
+func (a *Accounting) record(ctx context.Context, method, side, dir string, raw, wire int) {
+	metrics.MessageWireSize.WithLabelValues(side, method, dir).Observe(float64(wire))
+	over := a.softLimit > 0 && raw > a.softLimit
+	if over {
+		metrics.MessagesOverSoftLimit.WithLabelValues(side, method, dir).Inc()
+		logctx.Logger(ctx).Printf("[WIRE %s] %s %s of %d bytes is over the soft limit of %d bytes",
+			side, method, dir, raw, a.softLimit)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.methods[method]
+	if !ok {
+		s = &sizes{}
+		a.methods[method] = s
+	}
+	d := &s.requests
+	if dir == Response {
+		d = &s.responses
+	}
+	d.count++
+	d.total += int64(wire)
+	d.max = max(d.max, wire)
+	d.largest = max(d.largest, raw)
+	if over {
+		d.over++
+	}
+}
+
+// Log prints the count, average and largest size of the requests and
+// responses of every method, and how many were over the soft limit. The
+// largest size before compression is the one to compare with the message
+// size limits.
+func (a *Accounting) Log() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	methods := make([]string, 0, len(a.methods))
+	for m := range a.methods {
+		methods = append(methods, m)
+	}
+	slices.Sort(methods)
+
+	for _, m := range methods {
+		s := a.methods[m]
+		log.Printf("[WIRE SIZE] %s: %s, %s", m, s.requests.describe(Request), s.responses.describe(Response))
+	}
+}
+
+func (d direction) describe(name string) string {
+	if d.count == 0 {
+		return "no " + name + "s"
+	}
+	out := fmt.Sprintf("%d %ss avg %d max %d bytes on the wire, largest %d bytes encoded",
+		d.count, name, d.total/int64(d.count), d.max, d.largest)
+	if d.over > 0 {
+		out += fmt.Sprintf(" (%d over the soft limit)", d.over)
+	}
+	return out
+}
+
+func side(client bool) string {
+	if client {
+		return "client"
+	}
+	return "server"
+}
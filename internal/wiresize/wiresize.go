@@ -0,0 +1,71 @@
+// Package wiresize contains stats handlers that report how big messages are.
+// Logger logs every message before and after compression; Accounting adds
+// the messages up per method and flags the ones over a soft limit, to pick
+// message size limits like grpc.MaxCallRecvMsgSize from what the calls
+// really carry.
+package wiresize
+
+import (
+	"context"
+
+	"google.golang.org/grpc/stats"
+
+	"github.com/easyp-tech/course-grpc/internal/logctx"
+)
+
+type methodKey struct{}
+
+var _ stats.Handler = &Logger{}
+
+// Logger logs the uncompressed and compressed size of every payload sent or
+// received on the connection it is attached to.
+type Logger struct {
+	side string
+}
+
+// NewLogger creates a Logger; side ("client" or "server") prefixes the log lines.
+func NewLogger(side string) *Logger {
+	return &Logger{side: side}
+}
+
+func (l *Logger) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (l *Logger) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(methodKey{}).(string)
+
+	switch p := s.(type) {
+	case *stats.OutHeader:
+		l.logEncoding(ctx, method, "sending", p.Compression)
+	case *stats.InHeader:
+		l.logEncoding(ctx, method, "receiving", p.Compression)
+	case *stats.OutPayload:
+		l.logPayload(ctx, method, "sent", p.Length, p.CompressedLength)
+	case *stats.InPayload:
+		l.logPayload(ctx, method, "received", p.Length, p.CompressedLength)
+	}
+}
+
+func (l *Logger) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (l *Logger) HandleConn(context.Context, stats.ConnStats) {}
+
+// logEncoding reports the message encoding announced in the headers: the one
+// the client picked for its requests and the one the server answers with.
+func (l *Logger) logEncoding(ctx context.Context, method, dir, compression string) {
+	if compression == "" {
+		compression = "identity"
+	}
+	logctx.Logger(ctx).Printf("[WIRE %s] %s %s with encoding %s", l.side, method, dir, compression)
+}
+
+func (l *Logger) logPayload(ctx context.Context, method, dir string, raw, compressed int) {
+	ratio := 100.0
+	if raw > 0 {
+		ratio = float64(compressed) * 100 / float64(raw)
+	}
+	logctx.Logger(ctx).Printf("[WIRE %s] %s %s %d bytes, %d bytes compressed (%.0f%%)", l.side, method, dir, raw, compressed, ratio)
+}
@@ -0,0 +1,329 @@
+// Package wsbridge exposes server-streaming RPCs to browsers over WebSocket.
+//
+// The browser opens a WebSocket and sends the request message as a JSON text
+// frame. The bridge starts the RPC on a gRPC client connection and forwards
+// every response as a JSON text frame (protojson, so field names match the
+// gRPC-Gateway and grpcurl output). When the RPC ends the socket is closed:
+// normally after io.EOF, with 1011 and "CODE: message" as the reason when the
+// RPC fails.
+package wsbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// Limits bound the resources a single browser may hold.
+type Limits struct {
+	// MaxConnections is how many sockets may be open at once, across all
+	// routes of a Bridge; 0 means no limit. Further upgrades are answered
+	// with 503.
+	MaxConnections int
+	// MaxRequestSize is the largest request frame accepted, in bytes.
+	MaxRequestSize int64
+	// MaxMessages closes the socket after this many responses; 0 means no limit.
+	MaxMessages int
+	// MaxDuration closes the socket after this long; 0 means no limit.
+	MaxDuration time.Duration
+	// PingInterval is how often the bridge pings the browser. A socket that
+	// does not answer with a pong within two intervals is closed.
+	PingInterval time.Duration
+	// WriteTimeout bounds every frame written to the browser.
+	WriteTimeout time.Duration
+}
+
+// DefaultLimits returns limits suitable for the course demos.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxConnections: 100,
+		MaxRequestSize: 64 * 1024,
+		MaxMessages:    1000,
+		MaxDuration:    10 * time.Minute,
+		PingInterval:   15 * time.Second,
+		WriteTimeout:   5 * time.Second,
+	}
+}
+
+// Bridge serves the WebSocket routes and tracks the open sockets, which
+// http.Server.Shutdown does not see once they are hijacked.
+type Bridge struct {
+	limits   Limits
+	upgrader websocket.Upgrader
+	mux      *http.ServeMux
+	slots    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a bridge without routes.
+func New(limits Limits) *Bridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		limits: limits,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: 5 * time.Second,
+		},
+		mux:    http.NewServeMux(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if limits.MaxConnections > 0 {
+		b.slots = make(chan struct{}, limits.MaxConnections)
+	}
+	return b
+}
+
+// ServeHTTP implements http.Handler.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mux.ServeHTTP(w, r)
+}
+
+// Shutdown cancels the RPCs of all open sockets and waits for them to close.
+func (b *Bridge) Shutdown(ctx context.Context) error {
+	b.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handle registers a server-streaming RPC under pattern. open starts the RPC
+// for a request decoded from the first frame of the socket.
+func Handle[Req, Resp any, PReq interface {
+	*Req
+	proto.Message
+}, PResp interface {
+	*Resp
+	proto.Message
+}](b *Bridge, pattern string, open func(ctx context.Context, req PReq) (streams.Receiver[Resp], error)) {
+	b.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if b.slots != nil {
+			select {
+			case b.slots <- struct{}{}:
+				defer func() { <-b.slots }()
+			default:
+				http.Error(w, "too many websocket connections", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		conn, err := b.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already answered with an HTTP error
+			log.Printf("[WS] %s: upgrade failed: %v", pattern, err)
+			return
+		}
+
+		b.wg.Add(1)
+		defer b.wg.Done()
+
+		s := &socket{conn: conn, limits: b.limits}
+		s.serve(b.ctx, pattern, func(ctx context.Context, frame []byte) (func() (proto.Message, error), error) {
+			req := PReq(new(Req))
+			if err := protojson.Unmarshal(frame, req); err != nil {
+				return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+			}
+
+			rs, err := open(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return func() (proto.Message, error) {
+				resp, err := rs.Recv()
+				if err != nil {
+					return nil, err
+				}
+				return PResp(resp), nil
+			}, nil
+		})
+	})
+}
+
+var errBadRequest = errors.New("malformed request")
+
+// socket is one upgraded connection.
+type socket struct {
+	conn   *websocket.Conn
+	limits Limits
+}
+
+func (s *socket) serve(
+	bridgeCtx context.Context,
+	route string,
+	open func(ctx context.Context, frame []byte) (func() (proto.Message, error), error),
+) {
+	defer s.conn.Close()
+
+	s.conn.SetReadLimit(s.limits.MaxRequestSize)
+	s.keepalive()
+
+	_, frame, err := s.conn.ReadMessage()
+	if err != nil {
+		log.Printf("[WS] %s: no request received: %v", route, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(bridgeCtx)
+	defer cancel()
+	if s.limits.MaxDuration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, s.limits.MaxDuration)
+		defer cancelTimeout()
+	}
+
+	recv, err := open(ctx, frame)
+	if err != nil {
+		s.fail(route, err)
+		return
+	}
+
+	// the browser sends nothing after the request, but reading is what
+	// processes pongs and notices that it went away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := s.conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	stopPings := s.ping(ctx)
+	defer stopPings()
+
+	sent := 0
+	for {
+		resp, err := recv()
+		if errors.Is(err, io.EOF) {
+			s.close(websocket.CloseNormalClosure, "")
+			return
+		}
+		if err != nil {
+			switch {
+			case bridgeCtx.Err() != nil:
+				s.close(websocket.CloseGoingAway, "server shutting down")
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				s.close(websocket.ClosePolicyViolation, "connection time limit reached")
+			case ctx.Err() != nil:
+				log.Printf("[WS] %s: client went away", route)
+			default:
+				s.fail(route, err)
+			}
+			return
+		}
+
+		data, err := protojson.Marshal(resp)
+		if err != nil {
+			s.fail(route, err)
+			return
+		}
+
+		s.setWriteDeadline()
+		if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Printf("[WS] %s: write failed: %v", route, err)
+			return
+		}
+
+		sent++
+		if s.limits.MaxMessages > 0 && sent >= s.limits.MaxMessages {
+			s.close(websocket.ClosePolicyViolation, "message limit reached")
+			return
+		}
+	}
+}
+
+// keepalive expects a pong within two ping intervals.
+func (s *socket) keepalive() {
+	if s.limits.PingInterval <= 0 {
+		return
+	}
+
+	wait := 2 * s.limits.PingInterval
+	_ = s.conn.SetReadDeadline(time.Now().Add(wait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wait))
+	})
+}
+
+// ping sends pings until ctx is done or the returned func is called.
+func (s *socket) ping(ctx context.Context) func() {
+	if s.limits.PingInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.limits.PingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// WriteControl may run concurrently with WriteMessage
+				if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.limits.WriteTimeout)); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// fail closes the socket with the reason of a failed RPC.
+func (s *socket) fail(route string, err error) {
+	log.Printf("[WS] %s: %v", route, err)
+
+	if errors.Is(err, errBadRequest) {
+		s.close(websocket.CloseInvalidFramePayloadData, "malformed request")
+		return
+	}
+
+	st := status.Convert(err)
+	s.close(websocket.CloseInternalServerErr, fmt.Sprintf("%s: %s", st.Code(), st.Message()))
+}
+
+// close sends a close frame. Reasons longer than a control frame allows are
+// truncated.
+func (s *socket) close(code int, reason string) {
+	const maxReason = 123
+	if len(reason) > maxReason {
+		reason = reason[:maxReason]
+	}
+
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.limits.WriteTimeout))
+}
+
+func (s *socket) setWriteDeadline() {
+	if s.limits.WriteTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.limits.WriteTimeout))
+	}
+}
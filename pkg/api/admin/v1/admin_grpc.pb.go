@@ -0,0 +1,193 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v5.28.2
+// source: api/admin/v1/admin.proto
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AdminAPI_SetMaintenance_FullMethodName = "/api.admin.v1.AdminAPI/SetMaintenance"
+	AdminAPI_GetMaintenance_FullMethodName = "/api.admin.v1.AdminAPI/GetMaintenance"
+	AdminAPI_ListTenants_FullMethodName    = "/api.admin.v1.AdminAPI/ListTenants"
+)
+
+// AdminAPIClient is the client API for AdminAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminAPIClient interface {
+	// В режиме обслуживания health отдает NOT_SERVING, новые вызовы получают
+	// Unavailable с RetryInfo, а уже открытые стримы работают до завершения.
+	SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceStatus, error)
+	// Без побочных эффектов, но не NO_SIDE_EFFECTS: такие ответы кеширует
+	// интерсептор cache, а состояние режима должно быть свежим.
+	GetMaintenance(ctx context.Context, in *GetMaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceStatus, error)
+	// Счетчики вызовов по tenant: сколько вызовов, сколько отклонено лимитами
+	// и квотами. Тоже IDEMPOTENT, чтобы cache не отдавал старые счетчики.
+	ListTenants(ctx context.Context, in *ListTenantsRequest, opts ...grpc.CallOption) (*ListTenantsResponse, error)
+}
+
+type adminAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminAPIClient(cc grpc.ClientConnInterface) AdminAPIClient {
+	return &adminAPIClient{cc}
+}
+
+func (c *adminAPIClient) SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceStatus, error) {
+	out := new(MaintenanceStatus)
+	err := c.cc.Invoke(ctx, AdminAPI_SetMaintenance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminAPIClient) GetMaintenance(ctx context.Context, in *GetMaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceStatus, error) {
+	out := new(MaintenanceStatus)
+	err := c.cc.Invoke(ctx, AdminAPI_GetMaintenance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminAPIClient) ListTenants(ctx context.Context, in *ListTenantsRequest, opts ...grpc.CallOption) (*ListTenantsResponse, error) {
+	out := new(ListTenantsResponse)
+	err := c.cc.Invoke(ctx, AdminAPI_ListTenants_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminAPIServer is the server API for AdminAPI service.
+// All implementations should embed UnimplementedAdminAPIServer
+// for forward compatibility
+type AdminAPIServer interface {
+	// В режиме обслуживания health отдает NOT_SERVING, новые вызовы получают
+	// Unavailable с RetryInfo, а уже открытые стримы работают до завершения.
+	SetMaintenance(context.Context, *SetMaintenanceRequest) (*MaintenanceStatus, error)
+	// Без побочных эффектов, но не NO_SIDE_EFFECTS: такие ответы кеширует
+	// интерсептор cache, а состояние режима должно быть свежим.
+	GetMaintenance(context.Context, *GetMaintenanceRequest) (*MaintenanceStatus, error)
+	// Счетчики вызовов по tenant: сколько вызовов, сколько отклонено лимитами
+	// и квотами. Тоже IDEMPOTENT, чтобы cache не отдавал старые счетчики.
+	ListTenants(context.Context, *ListTenantsRequest) (*ListTenantsResponse, error)
+}
+
+// UnimplementedAdminAPIServer should be embedded to have forward compatible implementations.
+type UnimplementedAdminAPIServer struct {
+}
+
+func (UnimplementedAdminAPIServer) SetMaintenance(context.Context, *SetMaintenanceRequest) (*MaintenanceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMaintenance not implemented")
+}
+func (UnimplementedAdminAPIServer) GetMaintenance(context.Context, *GetMaintenanceRequest) (*MaintenanceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMaintenance not implemented")
+}
+func (UnimplementedAdminAPIServer) ListTenants(context.Context, *ListTenantsRequest) (*ListTenantsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTenants not implemented")
+}
+
+// UnsafeAdminAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminAPIServer will
+// result in compilation errors.
+type UnsafeAdminAPIServer interface {
+	mustEmbedUnimplementedAdminAPIServer()
+}
+
+func RegisterAdminAPIServer(s grpc.ServiceRegistrar, srv AdminAPIServer) {
+	s.RegisterService(&AdminAPI_ServiceDesc, srv)
+}
+
+func _AdminAPI_SetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminAPIServer).SetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminAPI_SetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminAPIServer).SetMaintenance(ctx, req.(*SetMaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminAPI_GetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminAPIServer).GetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminAPI_GetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminAPIServer).GetMaintenance(ctx, req.(*GetMaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminAPI_ListTenants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTenantsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminAPIServer).ListTenants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminAPI_ListTenants_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminAPIServer).ListTenants(ctx, req.(*ListTenantsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminAPI_ServiceDesc is the grpc.ServiceDesc for AdminAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.admin.v1.AdminAPI",
+	HandlerType: (*AdminAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetMaintenance",
+			Handler:    _AdminAPI_SetMaintenance_Handler,
+		},
+		{
+			MethodName: "GetMaintenance",
+			Handler:    _AdminAPI_GetMaintenance_Handler,
+		},
+		{
+			MethodName: "ListTenants",
+			Handler:    _AdminAPI_ListTenants_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/admin/v1/admin.proto",
+}
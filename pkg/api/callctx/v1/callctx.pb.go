@@ -0,0 +1,162 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0
+// 	protoc        v5.28.2
+// source: api/callctx/v1/callctx.proto
+
+package v1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Контекст вызова: клиент сериализует его в бинарный заголовок
+// x-call-context-bin, сервер разбирает в интерсепторе (см. internal/callctx).
+// Заголовки не сжимаются и ограничены по размеру, поэтому сообщение должно
+// оставаться маленьким.
+type CallContext struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// арендатор, от имени которого сделан вызов
+	Tenant string `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// включенные у клиента флаги функциональности
+	Features []string `protobuf:"bytes,2,rep,name=features,proto3" json:"features,omitempty"`
+}
+
+func (x *CallContext) Reset() {
+	*x = CallContext{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_callctx_v1_callctx_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CallContext) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallContext) ProtoMessage() {}
+
+func (x *CallContext) ProtoReflect() protoreflect.Message {
+	mi := &file_api_callctx_v1_callctx_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallContext.ProtoReflect.Descriptor instead.
+func (*CallContext) Descriptor() ([]byte, []int) {
+	return file_api_callctx_v1_callctx_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CallContext) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *CallContext) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+var File_api_callctx_v1_callctx_proto protoreflect.FileDescriptor
+
+var file_api_callctx_v1_callctx_proto_rawDesc = []byte{
+	0x0a, 0x1c, 0x61, 0x70, 0x69, 0x2f, 0x63, 0x61, 0x6c, 0x6c, 0x63, 0x74, 0x78, 0x2f, 0x76, 0x31,
+	0x2f, 0x63, 0x61, 0x6c, 0x6c, 0x63, 0x74, 0x78, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0e,
+	0x61, 0x70, 0x69, 0x2e, 0x63, 0x61, 0x6c, 0x6c, 0x63, 0x74, 0x78, 0x2e, 0x76, 0x31, 0x22, 0x41,
+	0x0a, 0x0b, 0x43, 0x61, 0x6c, 0x6c, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x78, 0x74, 0x12, 0x16, 0x0a,
+	0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74,
+	0x65, 0x6e, 0x61, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x73, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x65, 0x61, 0x73, 0x79, 0x70, 0x2d, 0x74, 0x65, 0x63, 0x68, 0x2f, 0x63, 0x6f, 0x75, 0x72, 0x73,
+	0x65, 0x2d, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x63,
+	0x61, 0x6c, 0x6c, 0x63, 0x74, 0x78, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
+}
+
+var (
+	file_api_callctx_v1_callctx_proto_rawDescOnce sync.Once
+	file_api_callctx_v1_callctx_proto_rawDescData = file_api_callctx_v1_callctx_proto_rawDesc
+)
+
+func file_api_callctx_v1_callctx_proto_rawDescGZIP() []byte {
+	file_api_callctx_v1_callctx_proto_rawDescOnce.Do(func() {
+		file_api_callctx_v1_callctx_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_callctx_v1_callctx_proto_rawDescData)
+	})
+	return file_api_callctx_v1_callctx_proto_rawDescData
+}
+
+var file_api_callctx_v1_callctx_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_api_callctx_v1_callctx_proto_goTypes = []interface{}{
+	(*CallContext)(nil), // 0: api.callctx.v1.CallContext
+}
+var file_api_callctx_v1_callctx_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_api_callctx_v1_callctx_proto_init() }
+func file_api_callctx_v1_callctx_proto_init() {
+	if File_api_callctx_v1_callctx_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_api_callctx_v1_callctx_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CallContext); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_api_callctx_v1_callctx_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_api_callctx_v1_callctx_proto_goTypes,
+		DependencyIndexes: file_api_callctx_v1_callctx_proto_depIdxs,
+		MessageInfos:      file_api_callctx_v1_callctx_proto_msgTypes,
+	}.Build()
+	File_api_callctx_v1_callctx_proto = out.File
+	file_api_callctx_v1_callctx_proto_rawDesc = nil
+	file_api_callctx_v1_callctx_proto_goTypes = nil
+	file_api_callctx_v1_callctx_proto_depIdxs = nil
+}
@@ -19,10 +19,14 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	EchoService_EchoClientStream_FullMethodName             = "/api.stream.v1.EchoService/EchoClientStream"
-	EchoService_EchoServerStream_FullMethodName             = "/api.stream.v1.EchoService/EchoServerStream"
-	EchoService_EchoBidirectionalStreamSync_FullMethodName  = "/api.stream.v1.EchoService/EchoBidirectionalStreamSync"
-	EchoService_EchoBidirectionalStreamAsync_FullMethodName = "/api.stream.v1.EchoService/EchoBidirectionalStreamAsync"
+	EchoService_EchoClientStream_FullMethodName                = "/api.stream.v1.EchoService/EchoClientStream"
+	EchoService_EchoServerStream_FullMethodName                = "/api.stream.v1.EchoService/EchoServerStream"
+	EchoService_EchoBidirectionalStreamSync_FullMethodName     = "/api.stream.v1.EchoService/EchoBidirectionalStreamSync"
+	EchoService_EchoBidirectionalStreamAsync_FullMethodName    = "/api.stream.v1.EchoService/EchoBidirectionalStreamAsync"
+	EchoService_EchoBidirectionalStreamReliable_FullMethodName = "/api.stream.v1.EchoService/EchoBidirectionalStreamReliable"
+	EchoService_EchoReplay_FullMethodName                      = "/api.stream.v1.EchoService/EchoReplay"
+	EchoService_UploadFile_FullMethodName                      = "/api.stream.v1.EchoService/UploadFile"
+	EchoService_EchoPull_FullMethodName                        = "/api.stream.v1.EchoService/EchoPull"
 )
 
 // EchoServiceClient is the client API for EchoService service.
@@ -33,6 +37,19 @@ type EchoServiceClient interface {
 	EchoServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (EchoService_EchoServerStreamClient, error)
 	EchoBidirectionalStreamSync(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoBidirectionalStreamSyncClient, error)
 	EchoBidirectionalStreamAsync(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoBidirectionalStreamAsyncClient, error)
+	// Delivers every response at least once: responses that are not acknowledged
+	// in time are sent again until the client acks them.
+	EchoBidirectionalStreamReliable(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoBidirectionalStreamReliableClient, error)
+	// Replays the journal of echoed messages from an offset and then keeps
+	// following it live, with heartbeats while there is nothing to send.
+	EchoReplay(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (EchoService_EchoReplayClient, error)
+	// Receives a file in chunks and checks its size and checksum. Progress is
+	// acked while the chunks arrive, the stream ends with the final response.
+	UploadFile(ctx context.Context, opts ...grpc.CallOption) (EchoService_UploadFileClient, error)
+	// Server streaming under the flow control of the client: the server sends
+	// only as many responses as the client granted credits and pauses until it
+	// grants more. The requests only carry credits, the responses the data.
+	EchoPull(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoPullClient, error)
 }
 
 type echoServiceClient struct {
@@ -171,6 +188,131 @@ func (x *echoServiceEchoBidirectionalStreamAsyncClient) Recv() (*EchoResponse, e
 	return m, nil
 }
 
+func (c *echoServiceClient) EchoBidirectionalStreamReliable(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoBidirectionalStreamReliableClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoService_ServiceDesc.Streams[4], EchoService_EchoBidirectionalStreamReliable_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServiceEchoBidirectionalStreamReliableClient{stream}
+	return x, nil
+}
+
+type EchoService_EchoBidirectionalStreamReliableClient interface {
+	Send(*EchoRequest) error
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoServiceEchoBidirectionalStreamReliableClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServiceEchoBidirectionalStreamReliableClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoServiceEchoBidirectionalStreamReliableClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoServiceClient) EchoReplay(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (EchoService_EchoReplayClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoService_ServiceDesc.Streams[5], EchoService_EchoReplay_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServiceEchoReplayClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EchoService_EchoReplayClient interface {
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoServiceEchoReplayClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServiceEchoReplayClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoServiceClient) UploadFile(ctx context.Context, opts ...grpc.CallOption) (EchoService_UploadFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoService_ServiceDesc.Streams[6], EchoService_UploadFile_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServiceUploadFileClient{stream}
+	return x, nil
+}
+
+type EchoService_UploadFileClient interface {
+	Send(*FileChunk) error
+	Recv() (*UploadFileResponse, error)
+	grpc.ClientStream
+}
+
+type echoServiceUploadFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServiceUploadFileClient) Send(m *FileChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoServiceUploadFileClient) Recv() (*UploadFileResponse, error) {
+	m := new(UploadFileResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoServiceClient) EchoPull(ctx context.Context, opts ...grpc.CallOption) (EchoService_EchoPullClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoService_ServiceDesc.Streams[7], EchoService_EchoPull_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServiceEchoPullClient{stream}
+	return x, nil
+}
+
+type EchoService_EchoPullClient interface {
+	Send(*PullRequest) error
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoServiceEchoPullClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServiceEchoPullClient) Send(m *PullRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoServiceEchoPullClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // EchoServiceServer is the server API for EchoService service.
 // All implementations should embed UnimplementedEchoServiceServer
 // for forward compatibility
@@ -179,6 +321,19 @@ type EchoServiceServer interface {
 	EchoServerStream(*EchoRequest, EchoService_EchoServerStreamServer) error
 	EchoBidirectionalStreamSync(EchoService_EchoBidirectionalStreamSyncServer) error
 	EchoBidirectionalStreamAsync(EchoService_EchoBidirectionalStreamAsyncServer) error
+	// Delivers every response at least once: responses that are not acknowledged
+	// in time are sent again until the client acks them.
+	EchoBidirectionalStreamReliable(EchoService_EchoBidirectionalStreamReliableServer) error
+	// Replays the journal of echoed messages from an offset and then keeps
+	// following it live, with heartbeats while there is nothing to send.
+	EchoReplay(*ReplayRequest, EchoService_EchoReplayServer) error
+	// Receives a file in chunks and checks its size and checksum. Progress is
+	// acked while the chunks arrive, the stream ends with the final response.
+	UploadFile(EchoService_UploadFileServer) error
+	// Server streaming under the flow control of the client: the server sends
+	// only as many responses as the client granted credits and pauses until it
+	// grants more. The requests only carry credits, the responses the data.
+	EchoPull(EchoService_EchoPullServer) error
 }
 
 // UnimplementedEchoServiceServer should be embedded to have forward compatible implementations.
@@ -197,6 +352,18 @@ func (UnimplementedEchoServiceServer) EchoBidirectionalStreamSync(EchoService_Ec
 func (UnimplementedEchoServiceServer) EchoBidirectionalStreamAsync(EchoService_EchoBidirectionalStreamAsyncServer) error {
 	return status.Errorf(codes.Unimplemented, "method EchoBidirectionalStreamAsync not implemented")
 }
+func (UnimplementedEchoServiceServer) EchoBidirectionalStreamReliable(EchoService_EchoBidirectionalStreamReliableServer) error {
+	return status.Errorf(codes.Unimplemented, "method EchoBidirectionalStreamReliable not implemented")
+}
+func (UnimplementedEchoServiceServer) EchoReplay(*ReplayRequest, EchoService_EchoReplayServer) error {
+	return status.Errorf(codes.Unimplemented, "method EchoReplay not implemented")
+}
+func (UnimplementedEchoServiceServer) UploadFile(EchoService_UploadFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+}
+func (UnimplementedEchoServiceServer) EchoPull(EchoService_EchoPullServer) error {
+	return status.Errorf(codes.Unimplemented, "method EchoPull not implemented")
+}
 
 // UnsafeEchoServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to EchoServiceServer will
@@ -308,6 +475,105 @@ func (x *echoServiceEchoBidirectionalStreamAsyncServer) Recv() (*EchoRequest, er
 	return m, nil
 }
 
+func _EchoService_EchoBidirectionalStreamReliable_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServiceServer).EchoBidirectionalStreamReliable(&echoServiceEchoBidirectionalStreamReliableServer{stream})
+}
+
+type EchoService_EchoBidirectionalStreamReliableServer interface {
+	Send(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoServiceEchoBidirectionalStreamReliableServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServiceEchoBidirectionalStreamReliableServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoServiceEchoBidirectionalStreamReliableServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoService_EchoReplay_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReplayRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EchoServiceServer).EchoReplay(m, &echoServiceEchoReplayServer{stream})
+}
+
+type EchoService_EchoReplayServer interface {
+	Send(*EchoResponse) error
+	grpc.ServerStream
+}
+
+type echoServiceEchoReplayServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServiceEchoReplayServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EchoService_UploadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServiceServer).UploadFile(&echoServiceUploadFileServer{stream})
+}
+
+type EchoService_UploadFileServer interface {
+	Send(*UploadFileResponse) error
+	Recv() (*FileChunk, error)
+	grpc.ServerStream
+}
+
+type echoServiceUploadFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServiceUploadFileServer) Send(m *UploadFileResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoServiceUploadFileServer) Recv() (*FileChunk, error) {
+	m := new(FileChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoService_EchoPull_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServiceServer).EchoPull(&echoServiceEchoPullServer{stream})
+}
+
+type EchoService_EchoPullServer interface {
+	Send(*EchoResponse) error
+	Recv() (*PullRequest, error)
+	grpc.ServerStream
+}
+
+type echoServiceEchoPullServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServiceEchoPullServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoServiceEchoPullServer) Recv() (*PullRequest, error) {
+	m := new(PullRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // EchoService_ServiceDesc is the grpc.ServiceDesc for EchoService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -338,6 +604,29 @@ var EchoService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "EchoBidirectionalStreamReliable",
+			Handler:       _EchoService_EchoBidirectionalStreamReliable_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "EchoReplay",
+			Handler:       _EchoService_EchoReplay_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadFile",
+			Handler:       _EchoService_UploadFile_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "EchoPull",
+			Handler:       _EchoService_EchoPull_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "api/stream/v1/stream.proto",
 }
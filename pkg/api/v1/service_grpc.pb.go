@@ -28,8 +28,14 @@ const (
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type EchoAPIClient interface {
+	// Ответ зависит только от запроса, поэтому сервер кеширует его
+	// (см. internal/cache)
 	HelloWorld(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// IDEMPOTENT и NO_SIDE_EFFECTS клиент повторяет при ошибке с RetryInfo
+	// (см. internal/idempotency)
 	WithError(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// Без idempotency_level: повтор создал бы заказ второй раз, поэтому
+	// клиент повторяет вызов, только если передал заголовок idempotency-key
 	CreateOrder(ctx context.Context, in *CreateOrdersRequest, opts ...grpc.CallOption) (*CreateOrderResponse, error)
 }
 
@@ -72,8 +78,14 @@ func (c *echoAPIClient) CreateOrder(ctx context.Context, in *CreateOrdersRequest
 // All implementations should embed UnimplementedEchoAPIServer
 // for forward compatibility
 type EchoAPIServer interface {
+	// Ответ зависит только от запроса, поэтому сервер кеширует его
+	// (см. internal/cache)
 	HelloWorld(context.Context, *EchoRequest) (*EchoResponse, error)
+	// IDEMPOTENT и NO_SIDE_EFFECTS клиент повторяет при ошибке с RetryInfo
+	// (см. internal/idempotency)
 	WithError(context.Context, *EchoRequest) (*EchoResponse, error)
+	// Без idempotency_level: повтор создал бы заказ второй раз, поэтому
+	// клиент повторяет вызов, только если передал заголовок idempotency-key
 	CreateOrder(context.Context, *CreateOrdersRequest) (*CreateOrderResponse, error)
 }
 
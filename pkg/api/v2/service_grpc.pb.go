@@ -0,0 +1,705 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v5.28.2
+// source: api/v2/service.proto
+
+package v2
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	EchoAPI_Echo_FullMethodName             = "/api.v2.EchoAPI/Echo"
+	EchoAPI_EchoWithError_FullMethodName    = "/api.v2.EchoAPI/EchoWithError"
+	EchoAPI_SlowEcho_FullMethodName         = "/api.v2.EchoAPI/SlowEcho"
+	EchoAPI_EchoWithMetadata_FullMethodName = "/api.v2.EchoAPI/EchoWithMetadata"
+	EchoAPI_ChainedEcho_FullMethodName      = "/api.v2.EchoAPI/ChainedEcho"
+	EchoAPI_BroadcastEcho_FullMethodName    = "/api.v2.EchoAPI/BroadcastEcho"
+	EchoAPI_CreateOrders_FullMethodName     = "/api.v2.EchoAPI/CreateOrders"
+	EchoAPI_ImportOrders_FullMethodName     = "/api.v2.EchoAPI/ImportOrders"
+	EchoAPI_ExportOrders_FullMethodName     = "/api.v2.EchoAPI/ExportOrders"
+	EchoAPI_SyncOrders_FullMethodName       = "/api.v2.EchoAPI/SyncOrders"
+	EchoAPI_CancelOrder_FullMethodName      = "/api.v2.EchoAPI/CancelOrder"
+	EchoAPI_SaveProfile_FullMethodName      = "/api.v2.EchoAPI/SaveProfile"
+	EchoAPI_GetProfile_FullMethodName       = "/api.v2.EchoAPI/GetProfile"
+)
+
+// EchoAPIClient is the client API for EchoAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EchoAPIClient interface {
+	Echo(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	EchoWithError(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// Echo с искусственной задержкой: показывает DeadlineExceeded, отмену
+	// вызова внутри обработчика и настройку таймаутов и повторов клиента.
+	SlowEcho(ctx context.Context, in *SlowEchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// Возвращает полученные сервером заголовки и адрес клиента: видно, что
+	// добавляют или вырезают интерсепторы, прокси и gateway по пути.
+	EchoWithMetadata(ctx context.Context, in *EchoWithMetadataRequest, opts ...grpc.CallOption) (*EchoWithMetadataResponse, error)
+	// Echo через цепочку серверов: каждое звено само вызывает ChainedEcho
+	// следующего с контекстом входящего вызова, поэтому дедлайн и отмена
+	// доходят до конца цепочки. Метаданные передаются дальше только из списка
+	// разрешенных ключей.
+	ChainedEcho(ctx context.Context, in *ChainedEchoRequest, opts ...grpc.CallOption) (*ChainedEchoResponse, error)
+	// Echo на всех серверах из -broadcast-targets одновременно (scatter-gather):
+	// каждый вызов получает свой контекст от входящего, с его дедлайном и
+	// отменой. Ошибка одного сервера попадает в его результат и не проваливает
+	// весь вызов; сам вызов завершается ошибкой, только если серверов нет или
+	// истек его дедлайн.
+	BroadcastEcho(ctx context.Context, in *BroadcastEchoRequest, opts ...grpc.CallOption) (*BroadcastEchoResponse, error)
+	// Повторяется клиентом только с заголовком idempotency-key: сервер
+	// отвечает на повтор результатом первого вызова, а не создает заказы снова.
+	CreateOrders(ctx context.Context, in *CreateOrdersRequest, opts ...grpc.CallOption) (*CreateOrdersResponse, error)
+	// Импорт заказов client стримом: позиция на сообщение, в ответе - итог и
+	// результат каждой позиции с ее ошибкой.
+	ImportOrders(ctx context.Context, opts ...grpc.CallOption) (EchoAPI_ImportOrdersClient, error)
+	// Выгрузка заказов server стримом в порядке создания. В отличие от
+	// постраничного чтения клиент получает все заказы одним вызовом, а после
+	// обрыва продолжает с cursor последнего полученного. С payload_codec
+	// заказы приходят сжатыми пачками.
+	ExportOrders(ctx context.Context, in *ExportOrdersRequest, opts ...grpc.CallOption) (EchoAPI_ExportOrdersClient, error)
+	// Синхронизация заказов bidi стримом: клиент отправляет свои изменения и
+	// получает ответ на каждое, а сервер одновременно присылает изменения
+	// других клиентов. Конфликты разрешаются по версии и времени изменения.
+	SyncOrders(ctx context.Context, opts ...grpc.CallOption) (EchoAPI_SyncOrdersClient, error)
+	// Отменяет заказ. Отменить можно только новый заказ: для оплаченного или
+	// уже отмененного - FailedPrecondition с ErrorInfo (причина
+	// ILLEGAL_STATUS_TRANSITION, в metadata - заказ и его статус). Клиенты
+	// SyncOrders получают отмену событием SYNC_EVENT_CANCELLED.
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error)
+	// Сохраняет профиль как есть, вместе с полями, которых сервер не знает,
+	// и возвращает сохраненный.
+	SaveProfile(ctx context.Context, in *Profile, opts ...grpc.CallOption) (*Profile, error)
+	GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error)
+}
+
+type echoAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEchoAPIClient(cc grpc.ClientConnInterface) EchoAPIClient {
+	return &echoAPIClient{cc}
+}
+
+func (c *echoAPIClient) Echo(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_Echo_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) EchoWithError(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_EchoWithError_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) SlowEcho(ctx context.Context, in *SlowEchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_SlowEcho_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) EchoWithMetadata(ctx context.Context, in *EchoWithMetadataRequest, opts ...grpc.CallOption) (*EchoWithMetadataResponse, error) {
+	out := new(EchoWithMetadataResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_EchoWithMetadata_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) ChainedEcho(ctx context.Context, in *ChainedEchoRequest, opts ...grpc.CallOption) (*ChainedEchoResponse, error) {
+	out := new(ChainedEchoResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_ChainedEcho_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) BroadcastEcho(ctx context.Context, in *BroadcastEchoRequest, opts ...grpc.CallOption) (*BroadcastEchoResponse, error) {
+	out := new(BroadcastEchoResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_BroadcastEcho_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) CreateOrders(ctx context.Context, in *CreateOrdersRequest, opts ...grpc.CallOption) (*CreateOrdersResponse, error) {
+	out := new(CreateOrdersResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_CreateOrders_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) ImportOrders(ctx context.Context, opts ...grpc.CallOption) (EchoAPI_ImportOrdersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoAPI_ServiceDesc.Streams[0], EchoAPI_ImportOrders_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoAPIImportOrdersClient{stream}
+	return x, nil
+}
+
+type EchoAPI_ImportOrdersClient interface {
+	Send(*ImportOrdersRequest) error
+	CloseAndRecv() (*ImportOrdersResponse, error)
+	grpc.ClientStream
+}
+
+type echoAPIImportOrdersClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoAPIImportOrdersClient) Send(m *ImportOrdersRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoAPIImportOrdersClient) CloseAndRecv() (*ImportOrdersResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportOrdersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoAPIClient) ExportOrders(ctx context.Context, in *ExportOrdersRequest, opts ...grpc.CallOption) (EchoAPI_ExportOrdersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoAPI_ServiceDesc.Streams[1], EchoAPI_ExportOrders_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoAPIExportOrdersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EchoAPI_ExportOrdersClient interface {
+	Recv() (*ExportOrdersResponse, error)
+	grpc.ClientStream
+}
+
+type echoAPIExportOrdersClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoAPIExportOrdersClient) Recv() (*ExportOrdersResponse, error) {
+	m := new(ExportOrdersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoAPIClient) SyncOrders(ctx context.Context, opts ...grpc.CallOption) (EchoAPI_SyncOrdersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EchoAPI_ServiceDesc.Streams[2], EchoAPI_SyncOrders_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoAPISyncOrdersClient{stream}
+	return x, nil
+}
+
+type EchoAPI_SyncOrdersClient interface {
+	Send(*SyncOrdersRequest) error
+	Recv() (*SyncOrdersResponse, error)
+	grpc.ClientStream
+}
+
+type echoAPISyncOrdersClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoAPISyncOrdersClient) Send(m *SyncOrdersRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoAPISyncOrdersClient) Recv() (*SyncOrdersResponse, error) {
+	m := new(SyncOrdersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoAPIClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error) {
+	out := new(CancelOrderResponse)
+	err := c.cc.Invoke(ctx, EchoAPI_CancelOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) SaveProfile(ctx context.Context, in *Profile, opts ...grpc.CallOption) (*Profile, error) {
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, EchoAPI_SaveProfile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoAPIClient) GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error) {
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, EchoAPI_GetProfile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EchoAPIServer is the server API for EchoAPI service.
+// All implementations should embed UnimplementedEchoAPIServer
+// for forward compatibility
+type EchoAPIServer interface {
+	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
+	EchoWithError(context.Context, *EchoRequest) (*EchoResponse, error)
+	// Echo с искусственной задержкой: показывает DeadlineExceeded, отмену
+	// вызова внутри обработчика и настройку таймаутов и повторов клиента.
+	SlowEcho(context.Context, *SlowEchoRequest) (*EchoResponse, error)
+	// Возвращает полученные сервером заголовки и адрес клиента: видно, что
+	// добавляют или вырезают интерсепторы, прокси и gateway по пути.
+	EchoWithMetadata(context.Context, *EchoWithMetadataRequest) (*EchoWithMetadataResponse, error)
+	// Echo через цепочку серверов: каждое звено само вызывает ChainedEcho
+	// следующего с контекстом входящего вызова, поэтому дедлайн и отмена
+	// доходят до конца цепочки. Метаданные передаются дальше только из списка
+	// разрешенных ключей.
+	ChainedEcho(context.Context, *ChainedEchoRequest) (*ChainedEchoResponse, error)
+	// Echo на всех серверах из -broadcast-targets одновременно (scatter-gather):
+	// каждый вызов получает свой контекст от входящего, с его дедлайном и
+	// отменой. Ошибка одного сервера попадает в его результат и не проваливает
+	// весь вызов; сам вызов завершается ошибкой, только если серверов нет или
+	// истек его дедлайн.
+	BroadcastEcho(context.Context, *BroadcastEchoRequest) (*BroadcastEchoResponse, error)
+	// Повторяется клиентом только с заголовком idempotency-key: сервер
+	// отвечает на повтор результатом первого вызова, а не создает заказы снова.
+	CreateOrders(context.Context, *CreateOrdersRequest) (*CreateOrdersResponse, error)
+	// Импорт заказов client стримом: позиция на сообщение, в ответе - итог и
+	// результат каждой позиции с ее ошибкой.
+	ImportOrders(EchoAPI_ImportOrdersServer) error
+	// Выгрузка заказов server стримом в порядке создания. В отличие от
+	// постраничного чтения клиент получает все заказы одним вызовом, а после
+	// обрыва продолжает с cursor последнего полученного. С payload_codec
+	// заказы приходят сжатыми пачками.
+	ExportOrders(*ExportOrdersRequest, EchoAPI_ExportOrdersServer) error
+	// Синхронизация заказов bidi стримом: клиент отправляет свои изменения и
+	// получает ответ на каждое, а сервер одновременно присылает изменения
+	// других клиентов. Конфликты разрешаются по версии и времени изменения.
+	SyncOrders(EchoAPI_SyncOrdersServer) error
+	// Отменяет заказ. Отменить можно только новый заказ: для оплаченного или
+	// уже отмененного - FailedPrecondition с ErrorInfo (причина
+	// ILLEGAL_STATUS_TRANSITION, в metadata - заказ и его статус). Клиенты
+	// SyncOrders получают отмену событием SYNC_EVENT_CANCELLED.
+	CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error)
+	// Сохраняет профиль как есть, вместе с полями, которых сервер не знает,
+	// и возвращает сохраненный.
+	SaveProfile(context.Context, *Profile) (*Profile, error)
+	GetProfile(context.Context, *GetProfileRequest) (*Profile, error)
+}
+
+// UnimplementedEchoAPIServer should be embedded to have forward compatible implementations.
+type UnimplementedEchoAPIServer struct {
+}
+
+func (UnimplementedEchoAPIServer) Echo(context.Context, *EchoRequest) (*EchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Echo not implemented")
+}
+func (UnimplementedEchoAPIServer) EchoWithError(context.Context, *EchoRequest) (*EchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EchoWithError not implemented")
+}
+func (UnimplementedEchoAPIServer) SlowEcho(context.Context, *SlowEchoRequest) (*EchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SlowEcho not implemented")
+}
+func (UnimplementedEchoAPIServer) EchoWithMetadata(context.Context, *EchoWithMetadataRequest) (*EchoWithMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EchoWithMetadata not implemented")
+}
+func (UnimplementedEchoAPIServer) ChainedEcho(context.Context, *ChainedEchoRequest) (*ChainedEchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChainedEcho not implemented")
+}
+func (UnimplementedEchoAPIServer) BroadcastEcho(context.Context, *BroadcastEchoRequest) (*BroadcastEchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BroadcastEcho not implemented")
+}
+func (UnimplementedEchoAPIServer) CreateOrders(context.Context, *CreateOrdersRequest) (*CreateOrdersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateOrders not implemented")
+}
+func (UnimplementedEchoAPIServer) ImportOrders(EchoAPI_ImportOrdersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportOrders not implemented")
+}
+func (UnimplementedEchoAPIServer) ExportOrders(*ExportOrdersRequest, EchoAPI_ExportOrdersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportOrders not implemented")
+}
+func (UnimplementedEchoAPIServer) SyncOrders(EchoAPI_SyncOrdersServer) error {
+	return status.Errorf(codes.Unimplemented, "method SyncOrders not implemented")
+}
+func (UnimplementedEchoAPIServer) CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedEchoAPIServer) SaveProfile(context.Context, *Profile) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveProfile not implemented")
+}
+func (UnimplementedEchoAPIServer) GetProfile(context.Context, *GetProfileRequest) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
+}
+
+// UnsafeEchoAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EchoAPIServer will
+// result in compilation errors.
+type UnsafeEchoAPIServer interface {
+	mustEmbedUnimplementedEchoAPIServer()
+}
+
+func RegisterEchoAPIServer(s grpc.ServiceRegistrar, srv EchoAPIServer) {
+	s.RegisterService(&EchoAPI_ServiceDesc, srv)
+}
+
+func _EchoAPI_Echo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).Echo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_Echo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).Echo(ctx, req.(*EchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_EchoWithError_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).EchoWithError(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_EchoWithError_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).EchoWithError(ctx, req.(*EchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_SlowEcho_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SlowEchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).SlowEcho(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_SlowEcho_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).SlowEcho(ctx, req.(*SlowEchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_EchoWithMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EchoWithMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).EchoWithMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_EchoWithMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).EchoWithMetadata(ctx, req.(*EchoWithMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_ChainedEcho_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChainedEchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).ChainedEcho(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_ChainedEcho_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).ChainedEcho(ctx, req.(*ChainedEchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_BroadcastEcho_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BroadcastEchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).BroadcastEcho(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_BroadcastEcho_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).BroadcastEcho(ctx, req.(*BroadcastEchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_CreateOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateOrdersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).CreateOrders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_CreateOrders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).CreateOrders(ctx, req.(*CreateOrdersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_ImportOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoAPIServer).ImportOrders(&echoAPIImportOrdersServer{stream})
+}
+
+type EchoAPI_ImportOrdersServer interface {
+	SendAndClose(*ImportOrdersResponse) error
+	Recv() (*ImportOrdersRequest, error)
+	grpc.ServerStream
+}
+
+type echoAPIImportOrdersServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoAPIImportOrdersServer) SendAndClose(m *ImportOrdersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoAPIImportOrdersServer) Recv() (*ImportOrdersRequest, error) {
+	m := new(ImportOrdersRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoAPI_ExportOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportOrdersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EchoAPIServer).ExportOrders(m, &echoAPIExportOrdersServer{stream})
+}
+
+type EchoAPI_ExportOrdersServer interface {
+	Send(*ExportOrdersResponse) error
+	grpc.ServerStream
+}
+
+type echoAPIExportOrdersServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoAPIExportOrdersServer) Send(m *ExportOrdersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EchoAPI_SyncOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoAPIServer).SyncOrders(&echoAPISyncOrdersServer{stream})
+}
+
+type EchoAPI_SyncOrdersServer interface {
+	Send(*SyncOrdersResponse) error
+	Recv() (*SyncOrdersRequest, error)
+	grpc.ServerStream
+}
+
+type echoAPISyncOrdersServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoAPISyncOrdersServer) Send(m *SyncOrdersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoAPISyncOrdersServer) Recv() (*SyncOrdersRequest, error) {
+	m := new(SyncOrdersRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoAPI_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_SaveProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Profile)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).SaveProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_SaveProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).SaveProfile(ctx, req.(*Profile))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EchoAPI_GetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoAPIServer).GetProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoAPI_GetProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoAPIServer).GetProfile(ctx, req.(*GetProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EchoAPI_ServiceDesc is the grpc.ServiceDesc for EchoAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EchoAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.v2.EchoAPI",
+	HandlerType: (*EchoAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    _EchoAPI_Echo_Handler,
+		},
+		{
+			MethodName: "EchoWithError",
+			Handler:    _EchoAPI_EchoWithError_Handler,
+		},
+		{
+			MethodName: "SlowEcho",
+			Handler:    _EchoAPI_SlowEcho_Handler,
+		},
+		{
+			MethodName: "EchoWithMetadata",
+			Handler:    _EchoAPI_EchoWithMetadata_Handler,
+		},
+		{
+			MethodName: "ChainedEcho",
+			Handler:    _EchoAPI_ChainedEcho_Handler,
+		},
+		{
+			MethodName: "BroadcastEcho",
+			Handler:    _EchoAPI_BroadcastEcho_Handler,
+		},
+		{
+			MethodName: "CreateOrders",
+			Handler:    _EchoAPI_CreateOrders_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _EchoAPI_CancelOrder_Handler,
+		},
+		{
+			MethodName: "SaveProfile",
+			Handler:    _EchoAPI_SaveProfile_Handler,
+		},
+		{
+			MethodName: "GetProfile",
+			Handler:    _EchoAPI_GetProfile_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ImportOrders",
+			Handler:       _EchoAPI_ImportOrders_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExportOrders",
+			Handler:       _EchoAPI_ExportOrders_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SyncOrders",
+			Handler:       _EchoAPI_SyncOrders_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api/v2/service.proto",
+}
@@ -0,0 +1,253 @@
+// Package chunk splits payloads too large for one gRPC message into bounded
+// chunks and puts them back together on the other side of a stream.
+//
+// The first chunk carries the total size if it is known, the last one the
+// SHA-256 of the whole payload, so a payload read from an io.Reader can be
+// sent while it is read. The Assembler checks that the chunks come in order,
+// stay within the size limits, add up to the announced size and match the
+// checksum:
+//
+//	r := chunk.NewReader(file, 64<<10, size)
+//	for {
+//		c, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...send c
+//	}
+//
+//	a := chunk.NewAssembler(w, 64<<10, 100<<20)
+//	for ... {
+//		if err := a.Add(c); err != nil { ... }
+//	}
+//	sum, err := a.Close()
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Errors returned by the Assembler.
+var (
+	ErrOutOfOrder   = errors.New("chunk: chunk out of order")
+	ErrTooLarge     = errors.New("chunk: payload exceeds the size limit")
+	ErrSizeMismatch = errors.New("chunk: payload size does not match the announced size")
+	ErrChecksum     = errors.New("chunk: checksum mismatch")
+	ErrIncomplete   = errors.New("chunk: payload ended before the last chunk")
+	ErrAfterLast    = errors.New("chunk: chunk after the last one")
+)
+
+// Chunk is one piece of a payload.
+type Chunk struct {
+	// Offset of Data in the payload.
+	Offset int64
+	Data   []byte
+	// TotalSize is set on the first chunk, -1 if the size is not known in
+	// advance.
+	TotalSize int64
+	// Last marks the final chunk, which carries the SHA-256 of the whole
+	// payload in Checksum.
+	Last     bool
+	Checksum []byte
+}
+
+// Reader cuts a payload read from an io.Reader into chunks of at most size
+// bytes.
+type Reader struct {
+	r      io.Reader
+	size   int
+	total  int64
+	offset int64
+	sum    hash.Hash
+	buf    []byte
+	// next holds the byte read ahead to find out whether a chunk is the
+	// last one
+	next []byte
+	done bool
+}
+
+// NewReader reads chunks of at most size bytes from r. total is the size of
+// the payload, -1 if unknown.
+func NewReader(r io.Reader, size int, total int64) *Reader {
+	return &Reader{r: r, size: max(size, 1), total: total, sum: sha256.New()}
+}
+
+// Next returns the next chunk, io.EOF after the last one. An empty payload
+// is a single empty last chunk.
+func (r *Reader) Next() (Chunk, error) {
+	if r.done {
+		return Chunk{}, io.EOF
+	}
+
+	// read one byte more than a chunk holds: if it is there, the chunk is
+	// not the last one
+	if cap(r.buf) < r.size+1 {
+		r.buf = make([]byte, r.size+1)
+	}
+	buf := r.buf[:r.size+1]
+	n := copy(buf, r.next)
+	m, err := io.ReadFull(r.r, buf[n:])
+	n += m
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Chunk{}, err
+	}
+
+	c := Chunk{Offset: r.offset, TotalSize: -1}
+	if r.offset == 0 {
+		c.TotalSize = r.total
+	}
+	if n > r.size {
+		c.Data = bytes.Clone(buf[:r.size])
+		r.next = append(r.next[:0], buf[r.size:n]...)
+	} else {
+		c.Data = bytes.Clone(buf[:n])
+		r.next = r.next[:0]
+		r.done = true
+	}
+	r.sum.Write(c.Data)
+	r.offset += int64(len(c.Data))
+
+	if r.done {
+		c.Last = true
+		c.Checksum = r.sum.Sum(nil)
+	}
+	return c, nil
+}
+
+// Split cuts data into chunks of at most size bytes.
+func Split(data []byte, size int) []Chunk {
+	r := NewReader(bytes.NewReader(data), size, int64(len(data)))
+	var chunks []Chunk
+	for {
+		c, err := r.Next()
+		if err != nil {
+			// reading from memory fails only with io.EOF
+			return chunks
+		}
+		chunks = append(chunks, c)
+	}
+}
+
+// SplitMessage marshals m and cuts it into chunks of at most size bytes.
+func SplitMessage(m proto.Message, size int) ([]Chunk, error) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return Split(data, size), nil
+}
+
+// Assembler writes the data of incoming chunks to w and validates them.
+type Assembler struct {
+	w        io.Writer
+	maxChunk int
+	maxSize  int64
+
+	total  int64
+	offset int64
+	chunks int
+	sum    hash.Hash
+	last   bool
+}
+
+// NewAssembler creates an assembler writing to w that accepts chunks of at
+// most maxChunk bytes and payloads of at most maxSize bytes.
+func NewAssembler(w io.Writer, maxChunk int, maxSize int64) *Assembler {
+	return &Assembler{w: w, maxChunk: maxChunk, maxSize: maxSize, total: -1, sum: sha256.New()}
+}
+
+// Add validates c and writes its data. After the last chunk the checksum is
+// verified.
+func (a *Assembler) Add(c Chunk) error {
+	if a.last {
+		return ErrAfterLast
+	}
+	if c.Offset != a.offset {
+		return fmt.Errorf("%w: offset %d, expected %d", ErrOutOfOrder, c.Offset, a.offset)
+	}
+	if len(c.Data) > a.maxChunk {
+		return fmt.Errorf("%w: chunk of %d bytes, at most %d allowed", ErrTooLarge, len(c.Data), a.maxChunk)
+	}
+	if a.chunks == 0 && c.TotalSize >= 0 {
+		if c.TotalSize > a.maxSize {
+			return fmt.Errorf("%w: %d bytes announced, at most %d allowed", ErrTooLarge, c.TotalSize, a.maxSize)
+		}
+		a.total = c.TotalSize
+	}
+
+	size := a.offset + int64(len(c.Data))
+	if size > a.maxSize {
+		return fmt.Errorf("%w: at most %d bytes allowed", ErrTooLarge, a.maxSize)
+	}
+	if a.total >= 0 && size > a.total {
+		return fmt.Errorf("%w: %d bytes received, %d announced", ErrSizeMismatch, size, a.total)
+	}
+
+	if _, err := a.w.Write(c.Data); err != nil {
+		return err
+	}
+	a.sum.Write(c.Data)
+	a.offset = size
+	a.chunks++
+
+	if c.Last {
+		a.last = true
+		if a.total >= 0 && a.offset != a.total {
+			return fmt.Errorf("%w: %d bytes received, %d announced", ErrSizeMismatch, a.offset, a.total)
+		}
+		if !bytes.Equal(a.sum.Sum(nil), c.Checksum) {
+			return ErrChecksum
+		}
+	}
+	return nil
+}
+
+// Size returns the number of bytes received so far.
+func (a *Assembler) Size() int64 {
+	return a.offset
+}
+
+// Chunks returns the number of chunks received so far.
+func (a *Assembler) Chunks() int {
+	return a.chunks
+}
+
+// Close reports whether the payload is complete and returns its checksum.
+func (a *Assembler) Close() ([]byte, error) {
+	if !a.last {
+		return nil, ErrIncomplete
+	}
+	return a.sum.Sum(nil), nil
+}
+
+// Join reassembles chunks in memory, accepting payloads of at most maxSize
+// bytes.
+func Join(chunks []Chunk, maxSize int64) ([]byte, error) {
+	var buf bytes.Buffer
+	a := NewAssembler(&buf, int(min(maxSize, int64(^uint(0)>>1))), maxSize)
+	for _, c := range chunks {
+		if err := a.Add(c); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := a.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JoinMessage reassembles chunks and unmarshals them into m.
+func JoinMessage(chunks []Chunk, maxSize int64, m proto.Message) error {
+	data, err := Join(chunks, maxSize)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
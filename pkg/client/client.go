@@ -0,0 +1,318 @@
+// Package client connects Go programs to the course servers with the
+// defaults the course clients use, instead of repeating the dial options of
+// cmd/client in every program:
+//
+//	echo, err := client.NewEchoClient("localhost:5001")
+//	if err != nil {
+//		return err
+//	}
+//	defer echo.Close()
+//	resp, err := echo.Echo(ctx, &pbv2.EchoRequest{Message: "hello world"})
+//
+// Every connection sends the trace and request ids of the context, retries
+// the unary calls the server asks to retry with RetryInfo (only those safe
+// to repeat, see package idempotency) and holds all calls to a server that
+// asked to back off, pings the server when idle and accepts responses up to
+// 16 MiB. Options add TLS, interceptors and metadata, or change the
+// defaults.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/easyp-tech/course-grpc/internal/chain"
+	"github.com/easyp-tech/course-grpc/internal/headers"
+	"github.com/easyp-tech/course-grpc/internal/idempotency"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/retry"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+)
+
+const (
+	// UserAgent is the user agent of the connections, before the one of
+	// grpc-go.
+	UserAgent = "course-grpc-client/1.0"
+	// MaxRecvMsgSize is the largest response accepted by default.
+	MaxRecvMsgSize = 16 << 20
+)
+
+// DefaultKeepalive pings an idle connection every minute. cmd/server lets
+// clients ping every 30 seconds at most and closes the connection of a
+// client pinging more often with GOAWAY ENHANCE_YOUR_CALM.
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:                time.Minute,
+	Timeout:             20 * time.Second,
+	PermitWithoutStream: true,
+}
+
+type options struct {
+	tls       *tls.Config
+	retry     retry.Policy
+	keepalive keepalive.ClientParameters
+	// outer wrap the whole call, the retries included
+	outer    []grpc.UnaryClientInterceptor
+	unary    []grpc.UnaryClientInterceptor
+	stream   []grpc.StreamClientInterceptor
+	md       metadata.MD
+	forward  []string
+	dialOpts []grpc.DialOption
+	// messageGap is the longest wait for a stream message, 0 waits forever
+	messageGap time.Duration
+}
+
+// Option configures a connection.
+type Option func(*options)
+
+// WithTLS connects over TLS with cfg, e.g. from tlsconfig.Client. Without it
+// the connection is plaintext.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithRetries sets how many attempts a unary call makes at most, the first
+// one included; 1 turns retries off. The default is retry.DefaultMaxAttempts.
+func WithRetries(maxAttempts int) Option {
+	return func(o *options) {
+		o.retry.MaxAttempts = maxAttempts
+	}
+}
+
+// WithRetryHook calls fn before every retry of a unary call, e.g. to count
+// the retries.
+func WithRetryHook(fn func(ctx context.Context, method string)) Option {
+	return func(o *options) {
+		o.retry.OnRetry = fn
+	}
+}
+
+// WithKeepalive replaces DefaultKeepalive; a zero Time turns the pings off.
+func WithKeepalive(params keepalive.ClientParameters) Option {
+	return func(o *options) {
+		o.keepalive = params
+	}
+}
+
+// WithInterceptor adds an interceptor of unary calls and one of streams;
+// either may be nil. They run in the order of the options, inside the
+// retries: every attempt of a call goes through them.
+func WithInterceptor(unary grpc.UnaryClientInterceptor, stream grpc.StreamClientInterceptor) Option {
+	return func(o *options) {
+		if unary != nil {
+			o.unary = append(o.unary, unary)
+		}
+		if stream != nil {
+			o.stream = append(o.stream, stream)
+		}
+	}
+}
+
+// WithCallInterceptor adds an interceptor of unary calls outside the
+// retries: it sees a call once, however many attempts it takes, e.g. to
+// measure its whole time. They run in the order of the options, after the
+// trace and request ids are set.
+func WithCallInterceptor(unary grpc.UnaryClientInterceptor) Option {
+	return func(o *options) {
+		o.outer = append(o.outer, unary)
+	}
+}
+
+// WithMetadata sends md with every call, e.g. the tenant or a token.
+func WithMetadata(md metadata.MD) Option {
+	return func(o *options) {
+		o.md = metadata.Join(o.md, md)
+	}
+}
+
+// WithForwardedMetadata is for servers calling other servers: the incoming
+// metadata of keys, e.g. chain.DefaultAllow, goes on with every call made
+// with the context of an incoming call. Other metadata is never forwarded,
+// see package chain.
+func WithForwardedMetadata(keys ...string) Option {
+	return func(o *options) {
+		o.forward = append(o.forward, keys...)
+	}
+}
+
+// WithDialOptions adds options of grpc.NewClient, like stats handlers or a
+// context dialer. They are applied after the defaults and override them.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOpts = append(o.dialOpts, opts...)
+	}
+}
+
+// Dial creates a connection to target with the defaults and opts. Like
+// grpc.NewClient it does not connect until the first call.
+func Dial(target string, opts ...Option) (*grpc.ClientConn, error) {
+	o := options{
+		retry:     retry.DefaultPolicy(),
+		keepalive: DefaultKeepalive,
+	}
+	o.retry.Gate = retry.NewGate()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// the ids first, then what every attempt shares, then the retries
+	unary := []grpc.UnaryClientInterceptor{
+		tracectx.UnaryClientInterceptor(),
+		requestid.UnaryClientInterceptor(),
+	}
+	unary = append(unary, o.outer...)
+	streams := []grpc.StreamClientInterceptor{
+		tracectx.StreamClientInterceptor(),
+		requestid.StreamClientInterceptor(),
+	}
+	if len(o.forward) > 0 {
+		unary = append(unary, forwardUnary(o.forward))
+		streams = append(streams, forwardStream(o.forward))
+	}
+	if len(o.md) > 0 {
+		unary = append(unary, headers.UnaryClientInterceptor(o.md))
+		streams = append(streams, headers.StreamClientInterceptor(o.md))
+	}
+	if o.retry.MaxAttempts > 1 {
+		unary = append(unary, retry.UnaryClientInterceptor(o.retry))
+	}
+	unary = append(unary, o.unary...)
+	streams = append(streams, o.stream...)
+	// innermost, so the interceptors above never see the heartbeats
+	if o.messageGap > 0 {
+		streams = append(streams, gapInterceptor(o.messageGap))
+	}
+
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUserAgent(UserAgent),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(streams...),
+		grpc.WithKeepaliveParams(o.keepalive),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxRecvMsgSize)),
+	}, o.dialOpts...)
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", target, err)
+	}
+	return conn, nil
+}
+
+func forwardUnary(keys []string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, _ = chain.Outgoing(ctx, keys)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func forwardStream(keys []string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, _ = chain.Outgoing(ctx, keys)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// EchoClient calls the echo methods of EchoAPI v2 and the streams of
+// EchoService over one connection.
+type EchoClient struct {
+	pbv2.EchoAPIClient
+	stream.EchoServiceClient
+
+	conn *grpc.ClientConn
+}
+
+// NewEchoClient connects to the server at target, see Dial.
+func NewEchoClient(target string, opts ...Option) (*EchoClient, error) {
+	conn, err := Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &EchoClient{
+		EchoAPIClient:     pbv2.NewEchoAPIClient(conn),
+		EchoServiceClient: stream.NewEchoServiceClient(conn),
+		conn:              conn,
+	}, nil
+}
+
+// Conn returns the connection of the client.
+func (c *EchoClient) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the connection.
+func (c *EchoClient) Close() error {
+	return c.conn.Close()
+}
+
+// OrderClient calls the order methods of EchoAPI v2. Unlike the generated
+// client it sends every CreateOrders with an idempotency key, unless the
+// context has one already: the server then creates the orders once however
+// many attempts the call takes, and the call is retried.
+type OrderClient struct {
+	api  pbv2.EchoAPIClient
+	conn *grpc.ClientConn
+}
+
+// NewOrderClient connects to the server at target, see Dial.
+func NewOrderClient(target string, opts ...Option) (*OrderClient, error) {
+	conn, err := Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderClient{api: pbv2.NewEchoAPIClient(conn), conn: conn}, nil
+}
+
+// CreateOrders creates the orders of req, see pbv2.EchoAPIClient.
+func (c *OrderClient) CreateOrders(ctx context.Context, req *pbv2.CreateOrdersRequest, opts ...grpc.CallOption) (*pbv2.CreateOrdersResponse, error) {
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(idempotency.KeyHeader)) == 0 {
+		ctx = idempotency.WithKey(ctx)
+	}
+	return c.api.CreateOrders(ctx, req, opts...)
+}
+
+// ImportOrders opens a client stream of orders to import.
+func (c *OrderClient) ImportOrders(ctx context.Context, opts ...grpc.CallOption) (pbv2.EchoAPI_ImportOrdersClient, error) {
+	return c.api.ImportOrders(ctx, opts...)
+}
+
+// ExportOrders opens a server stream of the stored orders. Its responses
+// may carry the orders in compressed batches, exportbatch.Reader reads both
+// forms.
+func (c *OrderClient) ExportOrders(ctx context.Context, req *pbv2.ExportOrdersRequest, opts ...grpc.CallOption) (pbv2.EchoAPI_ExportOrdersClient, error) {
+	return c.api.ExportOrders(ctx, req, opts...)
+}
+
+// SyncOrders opens a bidi stream of order changes.
+func (c *OrderClient) SyncOrders(ctx context.Context, opts ...grpc.CallOption) (pbv2.EchoAPI_SyncOrdersClient, error) {
+	return c.api.SyncOrders(ctx, opts...)
+}
+
+// CancelOrder cancels a new order, see pbv2.EchoAPIClient.
+func (c *OrderClient) CancelOrder(ctx context.Context, req *pbv2.CancelOrderRequest, opts ...grpc.CallOption) (*pbv2.CancelOrderResponse, error) {
+	return c.api.CancelOrder(ctx, req, opts...)
+}
+
+// Conn returns the connection of the client.
+func (c *OrderClient) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the connection.
+func (c *OrderClient) Close() error {
+	return c.conn.Close()
+}
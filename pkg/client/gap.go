@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrStreamStalled ends a stream that got heartbeats but no message within
+	// the gap of WithMessageGap: the server is alive, it has nothing to send
+	// or is still working on it. Its status is DeadlineExceeded; opening the
+	// stream again does not make the server faster.
+	ErrStreamStalled = errors.New("stream stalled: heartbeats but no message")
+	// ErrStreamWedged ends a stream that got neither messages nor heartbeats
+	// within the gap: the server, or the path to it, is stuck. Its status is
+	// Unavailable; a new stream, maybe on a new connection, may get through.
+	ErrStreamWedged = errors.New("stream wedged: no message and no heartbeat")
+)
+
+// Heartbeat is implemented by responses that can be heartbeats, like the
+// EchoResponse of EchoReplay.
+type Heartbeat interface {
+	GetHeartbeat() bool
+}
+
+// WithMessageGap aborts a server or bidi stream that waits longer than gap
+// for a message: a Recv that gets nothing within gap cancels the stream and
+// fails with ErrStreamStalled when heartbeats came meanwhile and with
+// ErrStreamWedged when nothing did. Heartbeats, the responses whose
+// GetHeartbeat returns true, are not returned by Recv: they only tell the
+// two apart. The gap runs only while Recv waits, so a client busy with a
+// response does not abort its own stream.
+func WithMessageGap(gap time.Duration) Option {
+	return func(o *options) {
+		o.messageGap = gap
+	}
+}
+
+// GapError is the error of a stream aborted by WithMessageGap. It matches
+// ErrStreamStalled or ErrStreamWedged with errors.Is.
+type GapError struct {
+	// Gap is how long the stream waited for a message.
+	Gap time.Duration
+	// Heartbeats is how many heartbeats came during the wait.
+	Heartbeats int64
+
+	err error
+}
+
+func (e *GapError) Error() string {
+	if e.Heartbeats > 0 {
+		return fmt.Sprintf("%v for %v (%d heartbeats)", e.err, e.Gap, e.Heartbeats)
+	}
+	return fmt.Sprintf("%v for %v", e.err, e.Gap)
+}
+
+func (e *GapError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus makes status.Code of the error DeadlineExceeded for a stalled
+// stream and Unavailable for a wedged one.
+func (e *GapError) GRPCStatus() *status.Status {
+	if errors.Is(e.err, ErrStreamStalled) {
+		return status.New(codes.DeadlineExceeded, e.Error())
+	}
+	return status.New(codes.Unavailable, e.Error())
+}
+
+func gapInterceptor(gap time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !desc.ServerStreams {
+			// the single response of a client stream comes when the client
+			// is done sending
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithCancelCause(ctx)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel(nil)
+			return nil, err
+		}
+		return &gapStream{ClientStream: cs, ctx: ctx, cancel: cancel, gap: gap}, nil
+	}
+}
+
+// gapStream cancels its stream when a RecvMsg waits longer than gap.
+type gapStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	gap    time.Duration
+
+	heartbeats atomic.Int64
+}
+
+func (s *gapStream) RecvMsg(m any) error {
+	since := s.heartbeats.Load()
+	timer := time.AfterFunc(s.gap, func() {
+		gerr := &GapError{Gap: s.gap, Heartbeats: s.heartbeats.Load() - since, err: ErrStreamWedged}
+		if gerr.Heartbeats > 0 {
+			gerr.err = ErrStreamStalled
+		}
+		s.cancel(gerr)
+	})
+	defer timer.Stop()
+
+	for {
+		err := s.ClientStream.RecvMsg(m)
+		if err != nil {
+			var gerr *GapError
+			if errors.As(context.Cause(s.ctx), &gerr) {
+				return gerr
+			}
+			// the stream is over, the timer is of no use anymore
+			s.cancel(nil)
+			return err
+		}
+		if hb, ok := m.(Heartbeat); ok && hb.GetHeartbeat() {
+			s.heartbeats.Add(1)
+			continue
+		}
+		return nil
+	}
+}
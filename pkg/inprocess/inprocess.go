@@ -0,0 +1,198 @@
+// Package inprocess runs the course services inside the calling program and
+// connects a client to them over an in-memory connection instead of a port.
+// A Go program embeds the services without listening anywhere, and a test
+// calls them through the real gRPC stack, interceptors, codecs and status
+// details included, without picking free ports or waiting for a server to
+// come up.
+//
+//	srv, err := inprocess.New()
+//	if err != nil {
+//		return err
+//	}
+//	defer srv.Close()
+//	resp, err := pbv2.NewEchoAPIClient(srv.Conn()).Echo(ctx, &pbv2.EchoRequest{Message: "hi"})
+//
+// The server runs EchoAPI v1 and v2, EchoService and health, with orders
+// and the journal of EchoReplay in memory, and validates the requests like
+// cmd/server does. The connection is an HTTP/2 connection over
+// bufconn: nothing about the calls changes, only the socket is gone.
+package inprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"buf.build/go/protovalidate"
+	protovalidate_middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/protovalidate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/easyp-tech/course-grpc/internal/chain"
+	"github.com/easyp-tech/course-grpc/internal/echoapi"
+	"github.com/easyp-tech/course-grpc/internal/echostream"
+	"github.com/easyp-tech/course-grpc/internal/journal"
+	"github.com/easyp-tech/course-grpc/internal/orders"
+	"github.com/easyp-tech/course-grpc/internal/panics"
+	"github.com/easyp-tech/course-grpc/internal/ratelimit"
+	"github.com/easyp-tech/course-grpc/internal/requestid"
+	"github.com/easyp-tech/course-grpc/internal/tracectx"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	pb "github.com/easyp-tech/course-grpc/pkg/api/v1"
+	pbv2 "github.com/easyp-tech/course-grpc/pkg/api/v2"
+	"github.com/easyp-tech/course-grpc/pkg/server"
+)
+
+// Target is the target clients pass to grpc.NewClient together with
+// Server.DialOption. Its host is only a name: the dialer ignores it.
+const Target = "passthrough:///inprocess"
+
+// ServerID names the server in the hops of ChainedEcho and is the only
+// target of BroadcastEcho.
+const ServerID = "inprocess"
+
+// bufferSize is the in-memory buffer of each direction of a connection.
+// Like a socket buffer, it only bounds what is in flight: HTTP/2 flow
+// control does the rest.
+const bufferSize = 1 << 20
+
+// chainReserve is what a ChainedEcho hop keeps of the deadline for its call
+// to the next hop, the default of cmd/server.
+const chainReserve = 20 * time.Millisecond
+
+type options struct {
+	serverOpts []grpc.ServerOption
+	dialOpts   []grpc.DialOption
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithServerOptions adds options of the gRPC server. Interceptors added
+// with grpc.ChainUnaryInterceptor and grpc.ChainStreamInterceptor run after
+// the built-in ones: tracing and request ids, panic recovery, validation.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
+
+// WithDialOptions adds options of the connection Conn returns, e.g. client
+// interceptors or default call options.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOpts = append(o.dialOpts, opts...)
+	}
+}
+
+// Server is the course server running in memory.
+type Server struct {
+	listener *bufconn.Listener
+	server   *server.Server
+	conn     *grpc.ClientConn
+	served   chan error
+}
+
+// New starts the services and connects a client to them. Close stops both.
+func New(opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	validator, err := protovalidate.New()
+	if err != nil {
+		return nil, fmt.Errorf("create validator: %w", err)
+	}
+	s := &Server{
+		listener: bufconn.Listen(bufferSize),
+		served:   make(chan error, 1),
+	}
+	// the connection is lazy: ChainedEcho may call through it before the
+	// first call of the embedding program
+	s.conn, err = s.Dial(o.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := journal.Open("")
+	if err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+	usecases := echoapi.NewOrderUsecases(orders.NewStore())
+	s.server, err = server.New(
+		server.WithInterceptor(tracectx.UnaryServerInterceptor(), tracectx.StreamServerInterceptor()),
+		server.WithInterceptor(requestid.UnaryServerInterceptor(), requestid.StreamServerInterceptor()),
+		server.WithInterceptor(panics.UnaryServerInterceptor(), panics.StreamServerInterceptor()),
+		server.WithInterceptor(protovalidate_middleware.UnaryServerInterceptor(validator), protovalidate_middleware.StreamServerInterceptor(validator)),
+		server.WithServerOptions(o.serverOpts...),
+		server.WithService(&pb.EchoAPI_ServiceDesc, echoapi.NewV1(usecases)),
+		server.WithService(&pbv2.EchoAPI_ServiceDesc, echoapi.NewV2(usecases, ServerID).
+			WithChain(pbv2.NewEchoAPIClient(s.conn), chain.DefaultAllow, chainReserve).
+			WithBroadcast([]echoapi.BroadcastTarget{{Name: ServerID, Client: pbv2.NewEchoAPIClient(s.conn)}})),
+		server.WithService(&stream.EchoService_ServiceDesc, echostream.NewAPI(
+			ratelimit.New(echostream.DefaultMsgRate, echostream.DefaultMsgBurst),
+			messages,
+		)),
+		server.WithHealth(health.NewServer()),
+	)
+	if err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		s.served <- s.server.Serve(s.listener)
+	}()
+	return s, nil
+}
+
+// Conn returns the connection to the server. It is closed by Close.
+func (s *Server) Conn() *grpc.ClientConn {
+	return s.conn
+}
+
+// DialOption connects a client created with grpc.NewClient(Target, ...) to
+// the server, for clients that need options of their own.
+func (s *Server) DialOption() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return s.listener.DialContext(ctx)
+	})
+}
+
+// Dial creates another connection to the server with the options of Conn
+// and opts. The caller closes it.
+func (s *Server) Dial(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(Target, append([]grpc.DialOption{
+		s.DialOption(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			tracectx.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			tracectx.StreamClientInterceptor(),
+			requestid.StreamClientInterceptor(),
+		),
+	}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("connect in process: %w", err)
+	}
+	return conn, nil
+}
+
+// Close stops the server, canceling the calls in flight, and closes Conn.
+// Connections made with Dial fail from then on.
+func (s *Server) Close() error {
+	connErr := s.conn.Close()
+	// a done context: the calls in flight are canceled right away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = s.server.Shutdown(ctx)
+	return errors.Join(connErr, <-s.served)
+}
@@ -0,0 +1,224 @@
+// Package server assembles a gRPC server from the building blocks of the
+// course: services, interceptor chains, TLS, health and reflection. A program
+// of its own gets the server cmd/server runs without copying its main, and
+// adds or leaves out what it needs:
+//
+//	srv, err := server.New(
+//		server.WithService(&pbv2.EchoAPI_ServiceDesc, myEchoAPI),
+//		server.WithInterceptor(logUnary, logStream),
+//		server.WithHealth(health.NewServer()),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	l, err := net.Listen("tcp", ":5001")
+//	if err != nil {
+//		return err
+//	}
+//	return srv.Serve(l)
+//
+// Interceptors run in the order of the options that add them, the first one
+// outermost. The mistakes grpc.Server only finds at registration, where it
+// ends the process, like a service registered twice or an implementation of
+// the wrong interface, are errors of New.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/easyp-tech/course-grpc/internal/reflection"
+)
+
+type service struct {
+	desc *grpc.ServiceDesc
+	impl any
+}
+
+type options struct {
+	services   []service
+	unary      []grpc.UnaryServerInterceptor
+	stream     []grpc.StreamServerInterceptor
+	tls        *tls.Config
+	health     *health.Server
+	reflection string
+	serverOpts []grpc.ServerOption
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithService registers impl as the service of desc, like the generated
+// Register...Server functions do: WithService(&pb.EchoAPI_ServiceDesc, impl).
+func WithService(desc *grpc.ServiceDesc, impl any) Option {
+	return func(o *options) {
+		o.services = append(o.services, service{desc: desc, impl: impl})
+	}
+}
+
+// WithInterceptor adds an interceptor of unary calls and one of streams to
+// the chains; either may be nil.
+func WithInterceptor(unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		if unary != nil {
+			o.unary = append(o.unary, unary)
+		}
+		if stream != nil {
+			o.stream = append(o.stream, stream)
+		}
+	}
+}
+
+// WithTLS serves over TLS with cfg; a cfg with ClientAuth set to
+// tls.RequireAndVerifyClientCert asks clients for certificates too. Without
+// it the server speaks plaintext HTTP/2.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithHealth registers hs as the standard health service. The server does
+// not set statuses of its own: the program does, e.g. through package
+// probes. Shutdown marks every service NOT_SERVING first.
+func WithHealth(hs *health.Server) Option {
+	return func(o *options) {
+		o.health = hs
+	}
+}
+
+// WithReflection registers server reflection in the versions of mode, one
+// of reflection.Modes.
+func WithReflection(mode string) Option {
+	return func(o *options) {
+		o.reflection = mode
+	}
+}
+
+// WithServerOptions adds options of the gRPC server, like keepalive
+// parameters, message size limits or stats handlers. Interceptors added
+// with grpc.ChainUnaryInterceptor and grpc.ChainStreamInterceptor run after
+// the ones of WithInterceptor.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
+
+// Server is a gRPC server with its services registered.
+type Server struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+// New creates the server. It does not listen yet, see Serve.
+func New(opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	seen := make(map[string]bool)
+	for _, svc := range o.services {
+		if err := check(svc); err != nil {
+			return nil, err
+		}
+		if seen[svc.desc.ServiceName] {
+			return nil, fmt.Errorf("service %s is registered twice", svc.desc.ServiceName)
+		}
+		seen[svc.desc.ServiceName] = true
+	}
+	if o.health != nil && seen[healthpb.Health_ServiceDesc.ServiceName] {
+		return nil, fmt.Errorf("service %s is registered twice", healthpb.Health_ServiceDesc.ServiceName)
+	}
+
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+	serverOpts := append([]grpc.ServerOption{
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(o.unary...),
+		grpc.ChainStreamInterceptor(o.stream...),
+	}, o.serverOpts...)
+
+	s := &Server{
+		server: grpc.NewServer(serverOpts...),
+		health: o.health,
+	}
+	for _, svc := range o.services {
+		s.server.RegisterService(svc.desc, svc.impl)
+	}
+	if o.health != nil {
+		healthpb.RegisterHealthServer(s.server, o.health)
+	}
+	if o.reflection != "" {
+		if err := reflection.Register(s.server, o.reflection); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// check reports what grpc.Server.RegisterService would end the process for.
+func check(svc service) error {
+	if svc.desc == nil {
+		return errors.New("service without a descriptor")
+	}
+	if svc.impl == nil {
+		return fmt.Errorf("service %s has no implementation", svc.desc.ServiceName)
+	}
+	if svc.desc.HandlerType != nil {
+		want := reflect.TypeOf(svc.desc.HandlerType).Elem()
+		if got := reflect.TypeOf(svc.impl); !got.Implements(want) {
+			return fmt.Errorf("service %s: %v does not implement %v", svc.desc.ServiceName, got, want)
+		}
+	}
+	return nil
+}
+
+// GRPC returns the underlying server, to register services that come with a
+// function of their own, like channelz, or to pass it to graceful.Group.
+func (s *Server) GRPC() *grpc.Server {
+	return s.server
+}
+
+// Serve accepts connections on l until Shutdown. It returns nil after
+// Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.server.Serve(l); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown marks the health services NOT_SERVING, stops accepting
+// connections and waits for the calls in flight. Once ctx is done it stops
+// the server forcefully, canceling the calls left.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return fmt.Errorf("graceful stop: %w", ctx.Err())
+	}
+}
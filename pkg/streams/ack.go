@@ -0,0 +1,156 @@
+package streams
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outbox is the sending half of at-least-once delivery on a stream. Every
+// message gets a delivery id and stays in the outbox until the peer
+// acknowledges it; Due hands out the messages whose ack is overdue so they
+// can be sent again under the same id.
+type Outbox[T any] struct {
+	mu          sync.Mutex
+	timeout     time.Duration
+	maxAttempts int
+	lastID      uint64
+	pending     map[uint64]*pendingDelivery[T]
+}
+
+type pendingDelivery[T any] struct {
+	value    T
+	sentAt   time.Time
+	attempts int
+}
+
+// Delivery is a message due for redelivery.
+type Delivery[T any] struct {
+	ID      uint64
+	Value   T
+	Attempt int
+}
+
+// NewOutbox creates an outbox that redelivers a message when no ack arrives
+// within timeout, at most maxAttempts times in total.
+func NewOutbox[T any](timeout time.Duration, maxAttempts int) *Outbox[T] {
+	return &Outbox[T]{
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		pending:     make(map[uint64]*pendingDelivery[T]),
+	}
+}
+
+// Add records v as sent now and returns its delivery id, which the caller
+// puts into the message before sending it.
+func (o *Outbox[T]) Add(v T) uint64 {
+	return o.AddAt(v, time.Now())
+}
+
+// AddAt is Add with the time of sending read from a clock of the caller, the
+// one it passes to Due.
+func (o *Outbox[T]) AddAt(v T, now time.Time) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lastID++
+	o.pending[o.lastID] = &pendingDelivery[T]{value: v, sentAt: now, attempts: 1}
+	return o.lastID
+}
+
+// Ack removes the acknowledged messages and returns how many of the ids were
+// still pending. Acks for unknown or already acked ids are ignored.
+func (o *Outbox[T]) Ack(ids ...uint64) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for _, id := range ids {
+		if _, ok := o.pending[id]; ok {
+			delete(o.pending, id)
+			n++
+		}
+	}
+	return n
+}
+
+// Due returns the messages whose ack is overdue at now and counts them as
+// sent again. It fails once a message has used up all its attempts.
+func (o *Outbox[T]) Due(now time.Time) ([]Delivery[T], error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []Delivery[T]
+	for id, d := range o.pending {
+		if now.Sub(d.sentAt) < o.timeout {
+			continue
+		}
+		if d.attempts >= o.maxAttempts {
+			return nil, fmt.Errorf("delivery %d not acknowledged after %d attempts", id, d.attempts)
+		}
+
+		d.attempts++
+		d.sentAt = now
+		due = append(due, Delivery[T]{ID: id, Value: d.value, Attempt: d.attempts})
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	return due, nil
+}
+
+// Restart hands out every pending message for a new stream, e.g. one a
+// client reconnected with: they count as sent at now, for the first time on
+// that stream.
+func (o *Outbox[T]) Restart(now time.Time) []Delivery[T] {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	out := make([]Delivery[T], 0, len(o.pending))
+	for id, d := range o.pending {
+		d.attempts = 1
+		d.sentAt = now
+		out = append(out, Delivery[T]{ID: id, Value: d.value, Attempt: 1})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Len returns how many messages wait for an ack.
+func (o *Outbox[T]) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pending)
+}
+
+// Dedup is the receiving half of at-least-once delivery: it remembers the
+// delivery ids seen on a stream so redelivered messages can be dropped.
+type Dedup struct {
+	mu   sync.Mutex
+	seen map[uint64]struct{}
+}
+
+// NewDedup creates an empty Dedup.
+func NewDedup() *Dedup {
+	return &Dedup{seen: make(map[uint64]struct{})}
+}
+
+// Seen records id and reports whether it had been seen before.
+func (d *Dedup) Seen(id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.seen[id] = struct{}{}
+	return false
+}
+
+// Len returns the number of distinct ids seen.
+func (d *Dedup) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.seen)
+}
@@ -0,0 +1,108 @@
+package streams
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchSender buffers values pushed by the caller and writes them to a
+// stream in bursts: as soon as size values are pending, or interval after the
+// first pending value was pushed, whichever comes first.
+//
+// Its methods are safe for concurrent use, writes to the stream are
+// serialized.
+type BatchSender[T any] struct {
+	s        Sender[T]
+	size     int
+	interval time.Duration
+	onFlush  func(n int)
+
+	mu      sync.Mutex
+	pending []*T
+	timer   *time.Timer
+	err     error
+}
+
+// NewBatchSender creates a BatchSender writing to s.
+func NewBatchSender[T any](s Sender[T], size int, interval time.Duration) *BatchSender[T] {
+	return &BatchSender[T]{
+		s:        s,
+		size:     max(size, 1),
+		interval: interval,
+	}
+}
+
+// OnFlush registers fn to be called with the number of values after every
+// successful flush. It must be called before the first Push.
+func (b *BatchSender[T]) OnFlush(fn func(n int)) {
+	b.onFlush = fn
+}
+
+// Push queues v. It returns the error of an earlier failed flush, after
+// which the sender is unusable.
+func (b *BatchSender[T]) Push(v *T) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	b.pending = append(b.pending, v)
+	if len(b.pending) >= b.size {
+		return b.flushLocked()
+	}
+	if len(b.pending) == 1 {
+		b.timer = time.AfterFunc(b.interval, b.flushOnTimer)
+	}
+	return nil
+}
+
+// Flush writes all pending values now.
+func (b *BatchSender[T]) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flushLocked()
+}
+
+// Close flushes the remainder. It does not close the stream itself, the
+// caller still owns CloseSend/CloseAndRecv.
+func (b *BatchSender[T]) Close() error {
+	return b.Flush()
+}
+
+func (b *BatchSender[T]) flushOnTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_ = b.flushLocked()
+}
+
+func (b *BatchSender[T]) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+
+	n := len(b.pending)
+	for _, v := range b.pending {
+		if err := b.s.Send(v); err != nil {
+			b.err = err
+			b.pending = nil
+			return err
+		}
+	}
+	b.pending = b.pending[:0]
+
+	if b.onFlush != nil {
+		b.onFlush(n)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ClientBidi is implemented by the client side of bidi streams.
+type ClientBidi[Req, Resp any] interface {
+	Sender[Req]
+	Receiver[Resp]
+	CloseSend() error
+}
+
+// RunBidi drives the client side of a bidi stream opened with ctx: send
+// writes the requests, recv handles every response, both run concurrently.
+//
+// The send half is closed with CloseSend as soon as send returns, also when
+// ctx is done: the send function passed to send fails once ctx is done, so
+// a sender stops at its next message. The receive half is drained until the
+// server ends the stream, also after recv failed, so the server is never
+// blocked on a client that stopped reading. RunBidi returns once both halves
+// are done with the error of send, else of recv or Recv, or with ctx.Err()
+// if ctx ended the stream.
+//
+//	err := streams.RunBidi(ctx, stream,
+//		func(ctx context.Context, send func(*Req) error) error {
+//			for _, req := range reqs {
+//				if err := send(req); err != nil {
+//					return err
+//				}
+//			}
+//			return nil
+//		},
+//		func(resp *Resp) error {
+//			responses = append(responses, resp)
+//			return nil
+//		})
+func RunBidi[Req, Resp any](
+	ctx context.Context,
+	s ClientBidi[Req, Resp],
+	send func(ctx context.Context, send func(*Req) error) error,
+	recv func(*Resp) error,
+) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// CloseSend must not race with Send, so only this goroutine calls it
+		defer s.CloseSend()
+
+		errs[0] = send(ctx, func(req *Req) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return s.Send(req)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+
+		for {
+			resp, err := s.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				if errs[1] == nil {
+					errs[1] = err
+				}
+				return
+			}
+			if errs[1] == nil {
+				errs[1] = recv(resp)
+			}
+		}
+	}()
+	wg.Wait()
+
+	// a canceled stream fails both halves, report the cause
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Send returns io.EOF when the server ended the stream, the status is
+	// what Recv got
+	if errs[0] != nil && !errors.Is(errs[0], io.EOF) {
+		return errs[0]
+	}
+	return errs[1]
+}
+
+// Pause waits d or until ctx is done, whichever comes first, and returns
+// ctx.Err() in the latter case. Senders use it to pace their messages
+// without delaying cancellation.
+func Pause(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
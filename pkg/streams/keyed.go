@@ -0,0 +1,99 @@
+package streams
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// MapByKey is a parallel Map that keeps per-key ordering: fn runs on up to
+// workers values at once, but values with the same key are always handled by
+// the same worker, so their results leave the stage in arrival order. Values
+// with an empty key have no ordering requirement and are spread round-robin.
+func MapByKey[In, Out any](
+	p *Pipeline,
+	in <-chan In,
+	workers int,
+	key func(In) string,
+	fn func(context.Context, In) (Out, error),
+) <-chan Out {
+	workers = max(workers, 1)
+	out := make(chan Out)
+
+	queues := make([]chan In, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan In, 1)
+
+		wg.Add(1)
+		q := queues[i]
+		p.stage(func() {
+			defer wg.Done()
+			for v := range q {
+				res, err := fn(p.ctx, v)
+				if err != nil {
+					p.Fail(err)
+					return
+				}
+				if !emit(p.ctx, out, res) {
+					return
+				}
+			}
+		})
+	}
+
+	p.addQueue("workers", func() (int, int) {
+		n := 0
+		for _, q := range queues {
+			n += len(q)
+		}
+		return n, len(queues) * cap(queues[0])
+	})
+
+	// dispatcher
+	p.stage(func() {
+		defer func() {
+			for _, q := range queues {
+				close(q)
+			}
+		}()
+
+		next := 0
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+
+				var idx int
+				if k := key(v); k != "" {
+					idx = shard(k, workers)
+				} else {
+					idx = next
+					next = (next + 1) % workers
+				}
+
+				if !emit(p.ctx, queues[idx], v) {
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+
+	// out is closed once every worker has drained its queue
+	p.stage(func() {
+		wg.Wait()
+		close(out)
+	})
+
+	return out
+}
+
+func shard(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
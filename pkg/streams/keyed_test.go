@@ -0,0 +1,186 @@
+package streams_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/easyp-tech/course-grpc/internal/panics"
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/server"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+// workers of MapByKey in the tests. The conversations "a", "b", "c" and "d"
+// land on the four of them: fnv-32a of the keys is 0, 1, 2 and 3 mod 4.
+const workers = 4
+
+// pipeline serves EchoBidirectionalStreamAsync with the stages of build
+// between Recv and Send, the way the handlers of internal/echostream do.
+type pipeline struct {
+	stream.UnimplementedEchoServiceServer
+	build func(p *streams.Pipeline, in <-chan *stream.EchoRequest) <-chan *stream.EchoResponse
+}
+
+func (s *pipeline) EchoBidirectionalStreamAsync(srv stream.EchoService_EchoBidirectionalStreamAsyncServer) error {
+	p, _ := streams.New(srv.Context())
+	in := streams.Recv(p, srv, 0)
+	streams.Send(p, srv, s.build(p, in))
+	return p.Wait()
+}
+
+// serve starts a server of build over bufconn, with panic recovery like
+// cmd/server, and returns a client of it. The logs of the server are
+// dropped.
+func serve(tb testing.TB, build func(p *streams.Pipeline, in <-chan *stream.EchoRequest) <-chan *stream.EchoResponse) stream.EchoServiceClient {
+	tb.Helper()
+
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+
+	srv, err := server.New(
+		server.WithInterceptor(panics.UnaryServerInterceptor(), panics.StreamServerInterceptor()),
+		server.WithService(&stream.EchoService_ServiceDesc, &pipeline{build: build}),
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		conn.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_ = srv.Shutdown(ctx)
+		<-served
+	})
+	return stream.NewEchoServiceClient(conn)
+}
+
+// open opens the stream of client with a test timeout.
+func open(t *testing.T, client stream.EchoServiceClient) stream.EchoService_EchoBidirectionalStreamAsyncClient {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	s, err := client.EchoBidirectionalStreamAsync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMapByKeyKeepsOrderPerKey(t *testing.T) {
+	client := serve(t, func(p *streams.Pipeline, in <-chan *stream.EchoRequest) <-chan *stream.EchoResponse {
+		conversation := func(req *stream.EchoRequest) string { return req.GetConversationId() }
+		return streams.MapByKey(p, in, workers, conversation, func(ctx context.Context, req *stream.EchoRequest) (*stream.EchoResponse, error) {
+			// a random delay lets the workers overtake each other
+			time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+			return &stream.EchoResponse{Message: req.GetMessage(), ConversationId: req.GetConversationId()}, nil
+		})
+	})
+	s := open(t, client)
+
+	const perKey = 25
+	keys := []string{"a", "b", "c", "d"}
+	go func() {
+		for i := range perKey {
+			for _, k := range keys {
+				if err := s.Send(&stream.EchoRequest{Message: fmt.Sprint(i), ConversationId: k}); err != nil {
+					return
+				}
+			}
+		}
+		_ = s.CloseSend()
+	}()
+
+	next := make(map[string]int)
+	for {
+		resp, err := s.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := resp.GetConversationId()
+		if want := fmt.Sprint(next[k]); resp.GetMessage() != want {
+			t.Fatalf("conversation %s: got message %s, want %s", k, resp.GetMessage(), want)
+		}
+		next[k]++
+	}
+	for _, k := range keys {
+		if next[k] != perKey {
+			t.Errorf("conversation %s: %d replies, want %d", k, next[k], perKey)
+		}
+	}
+}
+
+func TestMapByKeyErrorCancelsOtherShards(t *testing.T) {
+	errFailed := status.Error(codes.Aborted, "message failed")
+	started := make(chan struct{}, workers)
+	var canceled atomic.Int32
+	client := serve(t, func(p *streams.Pipeline, in <-chan *stream.EchoRequest) <-chan *stream.EchoResponse {
+		conversation := func(req *stream.EchoRequest) string { return req.GetConversationId() }
+		return streams.MapByKey(p, in, workers, conversation, func(ctx context.Context, req *stream.EchoRequest) (*stream.EchoResponse, error) {
+			if req.GetMessage() == "fail" {
+				return nil, errFailed
+			}
+			// the other shards are busy until the pipeline is canceled
+			started <- struct{}{}
+			<-ctx.Done()
+			canceled.Add(1)
+			return nil, ctx.Err()
+		})
+	})
+	s := open(t, client)
+
+	for _, k := range []string{"b", "c", "d"} {
+		if err := s.Send(&stream.EchoRequest{Message: "wait", ConversationId: k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 3 {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the shards of b, c and d did not start")
+		}
+	}
+	if err := s.Send(&stream.EchoRequest{Message: "fail", ConversationId: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Recv()
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("stream ended with %v, want the Aborted of the failed message", err)
+	}
+	// Recv returns once the handler has returned, after Wait and every
+	// stage of the pipeline
+	if n := canceled.Load(); n != 3 {
+		t.Errorf("%d of the 3 busy shards were canceled", n)
+	}
+}
@@ -0,0 +1,323 @@
+// Package streams pumps gRPC streams through channel-based pipeline stages.
+//
+// A pipeline starts with Recv, which turns any stream with a Recv method into
+// a channel, passes the values through Map, Filter and Batch stages and ends
+// in Send or Each. All stages share one context: the first error returned by
+// any stage cancels it and is reported by Pipeline.Wait.
+//
+//	p, _ := streams.New(srv.Context())
+//	in := streams.Recv(p, srv, 10)
+//	out := streams.Map(p, in, process)
+//	streams.Send(p, srv, out)
+//	return p.Wait()
+//
+// On the client side RunBidi runs the two halves of a bidi stream and closes
+// the send half when they are done or the context is canceled.
+package streams
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Receiver is implemented by client and server streams that receive T.
+type Receiver[T any] interface {
+	Recv() (*T, error)
+}
+
+// Sender is implemented by client and server streams that send T.
+type Sender[T any] interface {
+	Send(*T) error
+}
+
+// Pipeline owns the context and the goroutines of a set of stages.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	err    error
+	queues []queue
+}
+
+// Queue is how full a queue of a pipeline is at one moment.
+type Queue struct {
+	// Name is the kind of stage that owns the queue: "buffer" for Buffer,
+	// "workers" for the queues of the workers of MapByKey.
+	Name string
+	Len  int
+	Cap  int
+}
+
+type queue struct {
+	name  string
+	depth func() (length, capacity int)
+}
+
+// Queues returns how full the queues of the pipeline are, in the order the
+// stages were added.
+func (p *Pipeline) Queues() []Queue {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]Queue, len(p.queues))
+	for i, q := range p.queues {
+		out[i].Name = q.name
+		out[i].Len, out[i].Cap = q.depth()
+	}
+	return out
+}
+
+func (p *Pipeline) addQueue(name string, depth func() (int, int)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queues = append(p.queues, queue{name: name, depth: depth})
+}
+
+// New creates a pipeline bound to ctx. The returned context is cancelled as
+// soon as any stage fails.
+func New(ctx context.Context) (*Pipeline, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pipeline{ctx: ctx, cancel: cancel}, ctx
+}
+
+// Wait blocks until every stage except the Recv pumps has returned and
+// reports the first stage error. When no stage failed but the parent context
+// was cancelled, its error is returned.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+
+	if err == nil {
+		err = p.ctx.Err()
+	}
+	p.cancel()
+	return err
+}
+
+// Fail stops the pipeline with err unless it has already failed.
+func (p *Pipeline) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+func (p *Pipeline) stage(fn func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.recover()
+		fn()
+	}()
+}
+
+// recover turns a panic in a stage goroutine into a codes.Internal pipeline
+// failure. Without it a single bad message would crash the whole process,
+// since interceptors can only recover panics of the handler goroutine.
+func (p *Pipeline) recover() {
+	if r := recover(); r != nil {
+		log.Printf("streams: panic in pipeline stage: %v\n%s", r, debug.Stack())
+		p.Fail(status.Error(codes.Internal, "internal server error"))
+	}
+}
+
+// emit sends v downstream unless the pipeline is cancelled first.
+func emit[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Recv reads r until io.EOF, which closes the returned channel, or any other
+// error, which fails the pipeline. buf is the channel capacity.
+//
+// A blocked Recv can only be interrupted by the stream itself, so the pump is
+// not awaited by Wait: for server streams it ends when the handler returns.
+func Recv[T any](p *Pipeline, r Receiver[T], buf int) <-chan *T {
+	out := make(chan *T, buf)
+	go func() {
+		defer close(out)
+		defer p.recover()
+		for {
+			v, err := r.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				p.Fail(err)
+				return
+			}
+			if !emit(p.ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Map applies fn to every value. An error from fn fails the pipeline.
+func Map[In, Out any](p *Pipeline, in <-chan In, fn func(context.Context, In) (Out, error)) <-chan Out {
+	out := make(chan Out)
+	p.stage(func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				res, err := fn(p.ctx, v)
+				if err != nil {
+					p.Fail(err)
+					return
+				}
+				if !emit(p.ctx, out, res) {
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Filter passes on only the values keep returns true for.
+func Filter[T any](p *Pipeline, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	p.stage(func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if keep(v) && !emit(p.ctx, out, v) {
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Batch groups values into slices of at most size elements. A partial batch
+// is flushed once interval passes since its first element and when in closes.
+func Batch[T any](p *Pipeline, in <-chan T, size int, interval time.Duration) <-chan []T {
+	out := make(chan []T)
+	p.stage(func() {
+		defer close(out)
+
+		var batch []T
+		timer := time.NewTimer(interval)
+		timer.Stop()
+		defer timer.Stop()
+
+		flush := func() bool {
+			timer.Stop()
+			if len(batch) == 0 {
+				return true
+			}
+			ok := emit(p.ctx, out, batch)
+			batch = nil
+			return ok
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					timer.Reset(interval)
+				}
+				batch = append(batch, v)
+				if len(batch) >= size && !flush() {
+					return
+				}
+			case <-timer.C:
+				if !flush() {
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Buffer puts a queue of n values between the stages around it, so a slow
+// consumer does not immediately stall the producer.
+func Buffer[T any](p *Pipeline, in <-chan T, n int) <-chan T {
+	out := make(chan T, n)
+	p.addQueue("buffer", func() (int, int) { return len(out), cap(out) })
+	p.stage(func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if !emit(p.ctx, out, v) {
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Each calls fn for every value; it is the generic pipeline sink.
+func Each[T any](p *Pipeline, in <-chan T, fn func(context.Context, T) error) {
+	p.stage(func() {
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if err := fn(p.ctx, v); err != nil {
+					p.Fail(err)
+					return
+				}
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// Send writes every value to s.
+func Send[T any](p *Pipeline, s Sender[T], in <-chan *T) {
+	Each(p, in, func(_ context.Context, v *T) error {
+		return s.Send(v)
+	})
+}
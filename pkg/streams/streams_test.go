@@ -0,0 +1,45 @@
+package streams_test
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	stream "github.com/easyp-tech/course-grpc/pkg/api/stream/v1"
+	"github.com/easyp-tech/course-grpc/pkg/streams"
+)
+
+func TestPanicInStageEndsStreamWithInternal(t *testing.T) {
+	client := serve(t, func(p *streams.Pipeline, in <-chan *stream.EchoRequest) <-chan *stream.EchoResponse {
+		return streams.Map(p, in, func(_ context.Context, req *stream.EchoRequest) (*stream.EchoResponse, error) {
+			if req.GetMessage() == "panic" {
+				panic("bad message")
+			}
+			return &stream.EchoResponse{Message: req.GetMessage()}, nil
+		})
+	})
+
+	s := open(t, client)
+	if err := s.Send(&stream.EchoRequest{Message: "panic"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Recv()
+	if st := status.Convert(err); st.Code() != codes.Internal || st.Message() != "internal server error" {
+		t.Fatalf("stream ended with %v, want Internal without the panic value", err)
+	}
+
+	// the panic stayed in the stage goroutine: the server still answers
+	s = open(t, client)
+	if err := s.Send(&stream.EchoRequest{Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Recv()
+	if err != nil {
+		t.Fatalf("stream after the panic: %v", err)
+	}
+	if resp.GetMessage() != "hello" {
+		t.Errorf("reply = %q, want hello", resp.GetMessage())
+	}
+}